@@ -0,0 +1,29 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+)
+
+const adminUsage = `usage: forum [flags] admin <command>
+
+commands:
+  grant <email>  give the account that uses email the admin role`
+
+func runAdmin(store *models.Store, args []string) error {
+	if len(args) != 2 || args[0] != "grant" {
+		return errors.New(adminUsage)
+	}
+
+	email := args[1]
+	err := store.Roles.GrantAdmin(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no account uses %s; sign up first", email)
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("%s is now an admin.\n", email)
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"forum/internal/config"
+	"os"
+)
+
+const configUsage = `usage: forum [flags] config <command>
+
+commands:
+  print  show the effective configuration and whether it is valid`
+
+func runConfig(cfg *config.Config, args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		return errors.New(configUsage)
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return errors.New("the configuration is invalid:\n" + err.Error())
+	}
+	return nil
+}
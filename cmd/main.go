@@ -1,59 +1,293 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
 	"fmt"
 	"forum/internal"
+	"forum/internal/config"
+	"forum/internal/database"
+	"forum/internal/handlers"
+	"forum/internal/mail"
+	"forum/internal/migrate"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"forum/ui"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
-
-	_ "github.com/mattn/go-sqlite3"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 )
 
 func main() {
 
-	dsn := "./internal/database/dummy.db"
-	db, err := sql.Open("sqlite3", dsn)
+	cfg, args, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if len(args) > 0 && args[0] == "config" {
+		err = runConfig(cfg, args[1:])
+		if err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		return
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	err = applyConfig(cfg)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	db, err := database.Open(cfg.Dialect(), cfg.Database.URL)
 	if err != nil {
 		log.Fatalf("Failed to open database: %v", err)
 	}
 	defer db.Close()
 
-	err = initializeDatabase(db)
+	migrator, err := newMigrator(db, cfg)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		err = runMigrate(migrator, args[1:])
+		if err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] != "admin" {
+		log.Fatalf("Unknown command %q. Commands: migrate, config, admin.", args[0])
+	}
+
+	err = initializeDatabase(migrator)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
-	mux := internal.Router(db)
+	store := models.NewStore(db)
+	if len(args) > 0 {
+		err = runAdmin(store, args[1:])
+		if err != nil {
+			log.Fatalf("admin: %v", err)
+		}
+		return
+	}
+	store.Events.SetLimit(cfg.Live.MaxConnections)
+	go cleanUp(store, time.Duration(cfg.Session.CleanupInterval), time.Duration(cfg.Login.FailureRetention))
+	go refreshHTML(store)
+
+	mux := internal.Router(store)
 
-	staticPath := "ui/static"
-	fs := http.FileServer(http.Dir(staticPath))
-	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	mux.Handle("/static/", http.StripPrefix("/static/", handlers.Assets))
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	server := &http.Server{
+		Addr:              cfg.Addr(),
+		Handler:           handlers.Sessions(store, handlers.CSRF(mux)),
+		ReadHeaderTimeout: 10 * time.Second,
 	}
-	log.Printf("Starting server on : http://%s:%s", "localhost", port)
-	err = http.ListenAndServe("0.0.0.0:"+port, mux)
-	if err != nil {
+	// Shutdown waits for requests to finish, which event streams never do
+	// on their own; closing the hub ends them.
+	server.RegisterOnShutdown(store.Events.Close)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		log.Printf("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to finish requests in flight: %v", err)
+		}
+	}()
+
+	log.Printf("Starting server on : http://%s:%d", "localhost", cfg.Server.Port)
+	err = server.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Server failed to start: %v", err)
 	}
+	<-stopped
 }
 
-func initializeDatabase(db *sql.DB) error {
-	sqlFile := "./internal/database/init.sql"
-	initSQL, err := os.ReadFile(sqlFile)
+// applyConfig hands the settings to the packages that use them.
+func applyConfig(cfg *config.Config) error {
+	location, err := cfg.Location()
 	if err != nil {
-		return fmt.Errorf("failed to read init.sql: %v", err)
+		return err
+	}
+	models.Timezone = location
+	models.MaxTitleLength = cfg.Forum.MaxTitleLength
+	models.MaxContentLength = cfg.Forum.MaxContentLength
+	models.CommentEditWindow = time.Duration(cfg.Forum.CommentEditWindow)
+	models.SessionLifetime = time.Duration(cfg.Session.Lifetime)
+	models.RememberLifetime = time.Duration(cfg.Session.RememberLifetime)
+	handlers.SecureCookies = cfg.Session.CookieSecure
+	models.MaxLoginFailures = cfg.Login.MaxFailures
+	models.MaxIPLoginFailures = cfg.Login.IPMaxFailures
+	models.LockoutBase = time.Duration(cfg.Login.Lockout)
+	models.MaxLockout = time.Duration(cfg.Login.MaxLockout)
+	models.ResetTokenLifetime = time.Duration(cfg.Mail.ResetLifetime)
+	models.VerifyTokenLifetime = time.Duration(cfg.Mail.VerifyLifetime)
+	models.EmailTokenSecret = []byte(cfg.Mail.TokenSecret)
+	if cfg.Mail.TokenSecret == "" {
+		models.EmailTokenSecret = make([]byte, 32)
+		if _, err := rand.Read(models.EmailTokenSecret); err != nil {
+			return fmt.Errorf("failed to create a mail token secret: %w", err)
+		}
+		log.Printf("mail.token_secret is not set; links in emails stop working when the forum restarts.")
 	}
+	handlers.BaseURL = cfg.PublicURL()
+	handlers.Heartbeat = time.Duration(cfg.Live.Heartbeat)
+	handlers.Mailer = newMailer(cfg.Mail)
+	handlers.Uploads = newBucket(cfg.Uploads)
+	handlers.MaxAttachments = cfg.Uploads.MaxFiles
+	handlers.MaxAttachmentSize = int64(cfg.Uploads.MaxFileMB) << 20
+	handlers.ThumbnailSize = cfg.Uploads.ThumbnailSize
+	handlers.AllowedUploadTypes = map[string]bool{}
+	for _, t := range cfg.Uploads.Types() {
+		handlers.AllowedUploadTypes[t] = true
+	}
+	handlers.Assets, err = handlers.NewStaticFiles(uiFiles(cfg.Server.StaticDir, ui.Static()))
+	if err != nil {
+		return fmt.Errorf("failed to read static files: %w", err)
+	}
+	handlers.Templates, err = handlers.NewTemplateCache(uiFiles(cfg.Server.TemplateDir, ui.Templates()), cfg.Server.ReloadTemplates)
+	if err != nil {
+		return fmt.Errorf("failed to parse templates: %w", err)
+	}
+	return nil
+}
 
-	_, err = db.Exec(string(initSQL))
+func newMailer(cfg config.Mail) mail.Sender {
+	if cfg.Sender == config.SenderSMTP {
+		return &mail.SMTP{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}
+	}
+	return &mail.Log{Dir: cfg.Dir, From: cfg.From}
+}
+
+func newBucket(cfg config.Uploads) storage.Bucket {
+	if cfg.Storage == config.StorageS3 {
+		return &storage.S3{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: cfg.S3PathStyle,
+		}
+	}
+	return storage.Dir(cfg.Dir)
+}
+
+// uiFiles prefers a configured directory over the built-in files.
+func uiFiles(dir string, builtIn fs.FS) fs.FS {
+	if dir == "" {
+		return builtIn
+	}
+	return os.DirFS(dir)
+}
+
+// newMigrator reads the migrations for the configured driver, which live
+// in a directory named after it.
+func newMigrator(db *database.DB, cfg *config.Config) (*migrate.Migrator, error) {
+	if cfg.Database.MigrationsDir != "" {
+		dir := filepath.Join(cfg.Database.MigrationsDir, string(db.Dialect))
+		return &migrate.Migrator{DB: db, FS: os.DirFS(dir)}, nil
+	}
+	migrations, err := database.Migrations(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &migrate.Migrator{DB: db, FS: migrations}, nil
+}
+
+// cleanUp deletes expired sessions and failed logins older than
+// retention every interval. Expired sessions are refused anyway; this
+// only keeps the tables small.
+func cleanUp(store *models.Store, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := store.Sessions.DeleteExpired()
+		if err != nil {
+			log.Printf("Failed to delete expired sessions: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d expired sessions.", n)
+		}
+
+		n, err = store.LoginFailures.DeleteBefore(time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Failed to delete old login failures: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d old login failures.", n)
+		}
+
+		if _, err := store.TwoFactor.DeleteExpiredLogins(); err != nil {
+			log.Printf("Failed to delete expired pending logins: %v", err)
+		}
+	}
+}
+
+// refreshHTML renders again the posts and comments whose cached HTML an
+// older version of the Markdown renderer produced. Until it gets to them
+// they are rendered on every read.
+func refreshHTML(store *models.Store) {
+	posts, err := store.Posts.RefreshHTML()
 	if err != nil {
-		return fmt.Errorf("failed to execute init.sql: %v", err)
+		log.Printf("Failed to refresh post HTML: %v", err)
 	}
+	comments, err := store.Comments.RefreshHTML()
+	if err != nil {
+		log.Printf("Failed to refresh comment HTML: %v", err)
+	}
+	if posts+comments > 0 {
+		log.Printf("Rendered %d posts and %d comments again.", posts, comments)
+	}
+}
 
-	log.Println("Database initialized successfully.")
+func initializeDatabase(migrator *migrate.Migrator) error {
+	err := migrator.Up()
+	if err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	version, err := migrator.Version()
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	log.Printf("Database initialized successfully at schema version %d (%s).", version, migrator.DB.Dialect)
+
+	if migrator.DB.Dialect == database.SQLite {
+		fullText, err := migrator.DB.TableExists("search_index")
+		if err != nil {
+			return fmt.Errorf("failed to check for the search index: %w", err)
+		}
+		if !fullText {
+			log.Printf("This build has no SQLite FTS5 support; search falls back to substring matching. Build with -tags sqlite_fts5 for full-text search.")
+		}
+	}
 	return nil
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"forum/internal/migrate"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+const migrateUsage = `usage: forum [flags] migrate <command>
+
+commands:
+  up            apply all pending migrations
+  down [n]      roll back the newest n migrations (default 1)
+  status        list migrations and whether they are applied
+  to <version>  migrate up or down to the given version (0 rolls back everything)`
+
+func runMigrate(migrator *migrate.Migrator, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+		if err := migrator.Up(); err != nil {
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		} else if len(args) > 2 {
+			return errors.New(migrateUsage)
+		}
+		if err := migrator.Down(steps); err != nil {
+			return err
+		}
+	case "to":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		if err := migrator.To(version); err != nil {
+			return err
+		}
+	case "status":
+		return printMigrationStatus(migrator)
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	version, err := migrator.Version()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema is at version %d.\n", version)
+	return nil
+}
+
+func printMigrationStatus(migrator *migrate.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+	for _, s := range statuses {
+		state := "pending"
+		switch {
+		case s.Skipped:
+			state = "skipped (needs SQLite module " + s.Requires + ")"
+		case s.Applied:
+			state = "applied " + s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		if s.Changed {
+			state += ", FILE CHANGED SINCE APPLIED"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, state)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return migrator.Check()
+}
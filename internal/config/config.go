@@ -0,0 +1,613 @@
+// Package config holds the forum's settings. Each setting starts at its
+// default and is overridden, in order, by a YAML file, by environment
+// variables and by command-line flags.
+//
+// A setting's key is its section and field as written in the file, e.g.
+// server.port. The same key is its flag (-server.port) and, upper-cased
+// with dots turned into underscores and a FORUM_ prefix, its environment
+// variable (FORUM_SERVER_PORT).
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"forum/internal/database"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server   Server   `yaml:"server"`
+	Database Database `yaml:"database"`
+	Session  Session  `yaml:"session"`
+	Login    Login    `yaml:"login"`
+	Mail     Mail     `yaml:"mail"`
+	Uploads  Uploads  `yaml:"uploads"`
+	Live     Live     `yaml:"live"`
+	Forum    Forum    `yaml:"forum"`
+}
+
+type Server struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// StaticDir and TemplateDir replace the files built into the binary
+	// with a directory on disk, e.g. for a custom theme. Empty means the
+	// built-in files.
+	StaticDir   string `yaml:"static_dir"`
+	TemplateDir string `yaml:"template_dir"`
+	// ReloadTemplates parses the templates on every request instead of
+	// once at startup, for working on them without restarts.
+	ReloadTemplates bool `yaml:"reload_templates"`
+	// BaseURL is the address members reach the forum at, used for links
+	// in emails. Empty means http://localhost and the port.
+	BaseURL string `yaml:"base_url"`
+	// ShutdownTimeout is how long a stopping server waits for requests in
+	// flight.
+	ShutdownTimeout Duration `yaml:"shutdown_timeout"`
+}
+
+type Database struct {
+	Driver string `yaml:"driver"`
+	URL    string `yaml:"url"`
+	// MigrationsDir replaces the built-in migrations with a directory
+	// holding one directory per driver. Empty means the built-in ones.
+	MigrationsDir string `yaml:"migrations_dir"`
+}
+
+type Session struct {
+	// Lifetime is how long a session lasts without use; each use extends
+	// it. RememberLifetime applies instead after "remember me".
+	Lifetime         Duration `yaml:"lifetime"`
+	RememberLifetime Duration `yaml:"remember_lifetime"`
+	// CookieSecure marks the session cookie Secure even when TLS ends at
+	// a proxy in front of the forum.
+	CookieSecure bool `yaml:"cookie_secure"`
+	// CleanupInterval is how often expired sessions are deleted.
+	CleanupInterval Duration `yaml:"cleanup_interval"`
+}
+
+// Login throttles password guessing. MaxFailures failed logins with one
+// email within MaxLockout, since the last successful one, lock the email for
+// Lockout, whether or not an account uses it, and every further one doubles
+// that up to MaxLockout. IPMaxFailures failed logins from one address within
+// MaxLockout hold the address back the same way.
+type Login struct {
+	MaxFailures   int      `yaml:"max_failures"`
+	IPMaxFailures int      `yaml:"ip_max_failures"`
+	Lockout       Duration `yaml:"lockout"`
+	MaxLockout    Duration `yaml:"max_lockout"`
+	// FailureRetention is how long failed logins stay in the audit log.
+	FailureRetention Duration `yaml:"failure_retention"`
+}
+
+// Mail sends password resets and address confirmations. The "log" sender
+// writes messages to the log, or to files in Dir, instead of sending them;
+// "smtp" sends them through an SMTP server.
+type Mail struct {
+	Sender       string `yaml:"sender"`
+	From         string `yaml:"from"`
+	Dir          string `yaml:"dir"`
+	SMTPHost     string `yaml:"smtp_host"`
+	SMTPPort     int    `yaml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+	// TokenSecret signs the links in emails. Empty means a random secret
+	// on every start, which ends the links sent before a restart.
+	TokenSecret    string   `yaml:"token_secret"`
+	ResetLifetime  Duration `yaml:"reset_lifetime"`
+	VerifyLifetime Duration `yaml:"verify_lifetime"`
+}
+
+// Mail senders.
+const (
+	SenderLog  = "log"
+	SenderSMTP = "smtp"
+)
+
+// Uploads limits the files attached to posts and says where they are
+// kept: in Dir with the "local" storage, or in an S3-compatible bucket
+// with "s3".
+type Uploads struct {
+	Storage string `yaml:"storage"`
+	Dir     string `yaml:"dir"`
+	// MaxFileMB bounds each file; MaxFiles bounds the files on one post,
+	// and 0 turns uploads off.
+	MaxFileMB int `yaml:"max_file_mb"`
+	MaxFiles  int `yaml:"max_files"`
+	// AllowedTypes is a comma-separated list of media types, checked
+	// against what the content of a file shows it to be.
+	AllowedTypes  string `yaml:"allowed_types"`
+	ThumbnailSize int    `yaml:"thumbnail_size"`
+	S3Endpoint    string `yaml:"s3_endpoint"`
+	S3Region      string `yaml:"s3_region"`
+	S3Bucket      string `yaml:"s3_bucket"`
+	S3AccessKey   string `yaml:"s3_access_key"`
+	S3SecretKey   string `yaml:"s3_secret_key"`
+	// S3PathStyle puts the bucket in the URL path, as MinIO needs.
+	S3PathStyle bool `yaml:"s3_path_style"`
+}
+
+// Upload storages.
+const (
+	StorageLocal = "local"
+	StorageS3    = "s3"
+)
+
+// Types splits AllowedTypes.
+func (u Uploads) Types() []string {
+	var types []string
+	for _, t := range strings.Split(u.AllowedTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, strings.ToLower(t))
+		}
+	}
+	return types
+}
+
+// Live tunes the event streams that update open pages.
+type Live struct {
+	// Heartbeat is how often an idle stream is written to, so proxies do
+	// not close it.
+	Heartbeat Duration `yaml:"heartbeat"`
+	// MaxConnections bounds the open streams; 0 means no bound.
+	MaxConnections int `yaml:"max_connections"`
+}
+
+type Forum struct {
+	MaxTitleLength    int      `yaml:"max_title_length"`
+	CommentEditWindow Duration `yaml:"comment_edit_window"`
+	// MaxContentLength is the most characters a post or comment may hold.
+	MaxContentLength int `yaml:"max_content_length"`
+	// Timezone is an IANA name such as Asia/Tashkent or a fixed offset
+	// such as +05:00. Timestamps are stored and shown in it.
+	Timezone string `yaml:"timezone"`
+}
+
+// Duration is a time.Duration written as "90m" or "24h" in files, flags
+// and the environment.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Host:            "0.0.0.0",
+			Port:            8080,
+			ShutdownTimeout: Duration(10 * time.Second),
+		},
+		Database: Database{
+			Driver: string(database.SQLite),
+			URL:    "./internal/database/dummy.db",
+		},
+		Session: Session{
+			Lifetime:         Duration(24 * time.Hour),
+			RememberLifetime: Duration(30 * 24 * time.Hour),
+			CleanupInterval:  Duration(time.Hour),
+		},
+		Login: Login{
+			MaxFailures:      5,
+			IPMaxFailures:    20,
+			Lockout:          Duration(time.Minute),
+			MaxLockout:       Duration(time.Hour),
+			FailureRetention: Duration(30 * 24 * time.Hour),
+		},
+		Mail: Mail{
+			Sender:         SenderLog,
+			From:           "Forum <forum@localhost>",
+			SMTPPort:       587,
+			ResetLifetime:  Duration(time.Hour),
+			VerifyLifetime: Duration(48 * time.Hour),
+		},
+		Uploads: Uploads{
+			Storage:       StorageLocal,
+			Dir:           "./uploads",
+			MaxFileMB:     5,
+			MaxFiles:      4,
+			AllowedTypes:  "image/jpeg,image/png,image/gif,application/pdf,text/plain",
+			ThumbnailSize: 320,
+			S3Region:      "us-east-1",
+			S3PathStyle:   true,
+		},
+		Live: Live{
+			Heartbeat:      Duration(30 * time.Second),
+			MaxConnections: 10000,
+		},
+		Forum: Forum{
+			MaxTitleLength:    25,
+			MaxContentLength:  50000,
+			CommentEditWindow: Duration(15 * time.Minute),
+			Timezone:          "+05:00",
+		},
+	}
+}
+
+// envAliases are environment variables that predate the FORUM_ names and
+// are still honoured. The FORUM_ name wins when both are set.
+var envAliases = map[string]string{
+	"server.port":     "PORT",
+	"database.driver": "DATABASE_DRIVER",
+	"database.url":    "DATABASE_URL",
+}
+
+// setting is one leaf of Config, addressed by its key.
+type setting struct {
+	key   string
+	value reflect.Value
+}
+
+func (s setting) env() string {
+	return "FORUM_" + strings.ToUpper(strings.ReplaceAll(s.key, ".", "_"))
+}
+
+func (s setting) set(raw string) error {
+	if u, ok := s.value.Addr().Interface().(interface{ UnmarshalText([]byte) error }); ok {
+		return u.UnmarshalText([]byte(raw))
+	}
+	switch s.value.Kind() {
+	case reflect.String:
+		s.value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", raw)
+		}
+		s.value.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", raw)
+		}
+		s.value.SetInt(int64(n))
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+	return nil
+}
+
+func (c *Config) settings() []setting {
+	var settings []setting
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		prefix := sections.Type().Field(i).Tag.Get("yaml")
+		for j := 0; j < section.NumField(); j++ {
+			name := section.Type().Field(j).Tag.Get("yaml")
+			settings = append(settings, setting{key: prefix + "." + name, value: section.Field(j)})
+		}
+	}
+	return settings
+}
+
+// flagValue records a flag's raw text so it can be applied after the file
+// and the environment. Boolean settings may be given as a bare -key.
+type flagValue struct {
+	raw    *string
+	isBool bool
+}
+
+func (f flagValue) String() string {
+	if f.raw == nil {
+		return ""
+	}
+	return *f.raw
+}
+
+func (f flagValue) Set(s string) error {
+	*f.raw = s
+	return nil
+}
+
+func (f flagValue) IsBoolFlag() bool { return f.isBool }
+
+// Load builds the configuration from the defaults, the file named by
+// -config or FORUM_CONFIG, the environment and the flags in args. It
+// returns the arguments left after the flags, i.e. the subcommand. The
+// result is not validated; call Validate.
+func Load(args []string, getenv func(string) string) (*Config, []string, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("forum", flag.ContinueOnError)
+	path := fs.String("config", getenv("FORUM_CONFIG"), "path to a YAML configuration file")
+	flags := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		raw := new(string)
+		flags[s.key] = raw
+		fs.Var(flagValue{raw, s.value.Kind() == reflect.Bool}, s.key, "overrides "+s.key+" (env "+s.env()+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		raw, name := getenv(s.env()), s.env()
+		if alias := envAliases[s.key]; raw == "" && alias != "" {
+			raw, name = getenv(alias), alias
+		}
+		if raw == "" {
+			continue
+		}
+		if err := s.set(raw); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		raw, ok := flags[f.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		for _, s := range settings {
+			if s.key == f.Name {
+				if err := s.set(*raw); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, nil, flagErr
+	}
+	return cfg, fs.Args(), nil
+}
+
+func (c *Config) loadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf(key+": "+format, args...))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		fail("server.port", "%d is not a TCP port", c.Server.Port)
+	}
+	for key, dir := range map[string]string{
+		"server.static_dir":       c.Server.StaticDir,
+		"server.template_dir":     c.Server.TemplateDir,
+		"database.migrations_dir": c.Database.MigrationsDir,
+		"mail.dir":                c.Mail.Dir,
+	} {
+		if info, err := os.Stat(dir); dir != "" && (err != nil || !info.IsDir()) {
+			fail(key, "%q is not a directory", dir)
+		}
+	}
+
+	if u, err := url.Parse(c.Server.BaseURL); c.Server.BaseURL != "" && (err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https") {
+		fail("server.base_url", "%q is not an http or https URL", c.Server.BaseURL)
+	}
+	if c.Server.ShutdownTimeout < 0 {
+		fail("server.shutdown_timeout", "must not be negative")
+	}
+
+	dialect, err := database.ParseDialect(c.Database.Driver)
+	if err != nil {
+		fail("database.driver", "%v", err)
+	}
+	if c.Database.URL == "" || (dialect == database.Postgres && c.Database.URL == Default().Database.URL) {
+		fail("database.url", "is required for the %s driver", c.Database.Driver)
+	}
+
+	if time.Duration(c.Session.Lifetime) < time.Minute {
+		fail("session.lifetime", "must be at least 1m")
+	}
+	if c.Session.RememberLifetime < c.Session.Lifetime {
+		fail("session.remember_lifetime", "cannot be shorter than session.lifetime")
+	}
+	if time.Duration(c.Session.CleanupInterval) < time.Minute {
+		fail("session.cleanup_interval", "must be at least 1m")
+	}
+	if c.Login.MaxFailures < 1 {
+		fail("login.max_failures", "must be at least 1")
+	}
+	if c.Login.IPMaxFailures < 1 {
+		fail("login.ip_max_failures", "must be at least 1")
+	}
+	if time.Duration(c.Login.Lockout) < time.Second {
+		fail("login.lockout", "must be at least 1s")
+	}
+	if c.Login.MaxLockout < c.Login.Lockout {
+		fail("login.max_lockout", "cannot be shorter than login.lockout")
+	}
+	if c.Login.FailureRetention < c.Login.MaxLockout {
+		fail("login.failure_retention", "cannot be shorter than login.max_lockout")
+	}
+	switch c.Mail.Sender {
+	case SenderLog:
+	case SenderSMTP:
+		if c.Mail.SMTPHost == "" {
+			fail("mail.smtp_host", "is required for the smtp sender")
+		}
+	default:
+		fail("mail.sender", "%q is not %s or %s", c.Mail.Sender, SenderLog, SenderSMTP)
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		fail("mail.from", "%q is not an email address", c.Mail.From)
+	}
+	if c.Mail.SMTPPort < 1 || c.Mail.SMTPPort > 65535 {
+		fail("mail.smtp_port", "%d is not a TCP port", c.Mail.SMTPPort)
+	}
+	if c.Mail.TokenSecret != "" && len(c.Mail.TokenSecret) < 32 {
+		fail("mail.token_secret", "must be at least 32 characters")
+	}
+	if time.Duration(c.Mail.ResetLifetime) < time.Minute {
+		fail("mail.reset_lifetime", "must be at least 1m")
+	}
+	if time.Duration(c.Mail.VerifyLifetime) < time.Minute {
+		fail("mail.verify_lifetime", "must be at least 1m")
+	}
+	switch c.Uploads.Storage {
+	case StorageLocal:
+		if c.Uploads.Dir == "" {
+			fail("uploads.dir", "is required for the local storage")
+		}
+	case StorageS3:
+		if u, err := url.Parse(c.Uploads.S3Endpoint); err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+			fail("uploads.s3_endpoint", "%q is not an http or https URL", c.Uploads.S3Endpoint)
+		}
+		for key, value := range map[string]string{
+			"uploads.s3_region":     c.Uploads.S3Region,
+			"uploads.s3_bucket":     c.Uploads.S3Bucket,
+			"uploads.s3_access_key": c.Uploads.S3AccessKey,
+			"uploads.s3_secret_key": c.Uploads.S3SecretKey,
+		} {
+			if value == "" {
+				fail(key, "is required for the s3 storage")
+			}
+		}
+	default:
+		fail("uploads.storage", "%q is not %s or %s", c.Uploads.Storage, StorageLocal, StorageS3)
+	}
+	if c.Uploads.MaxFileMB < 1 || c.Uploads.MaxFileMB > 100 {
+		fail("uploads.max_file_mb", "must be between 1 and 100")
+	}
+	if c.Uploads.MaxFiles < 0 || c.Uploads.MaxFiles > 20 {
+		fail("uploads.max_files", "must be between 0 and 20")
+	}
+	for _, t := range c.Uploads.Types() {
+		if !mediaType.MatchString(t) {
+			fail("uploads.allowed_types", "%q is not a media type", t)
+		}
+	}
+	if c.Uploads.ThumbnailSize < 16 || c.Uploads.ThumbnailSize > 2048 {
+		fail("uploads.thumbnail_size", "must be between 16 and 2048")
+	}
+	if time.Duration(c.Live.Heartbeat) < time.Second {
+		fail("live.heartbeat", "must be at least 1s")
+	}
+	if c.Live.MaxConnections < 0 {
+		fail("live.max_connections", "must not be negative")
+	}
+	if c.Forum.MaxTitleLength < 1 {
+		fail("forum.max_title_length", "must be at least 1")
+	}
+	if c.Forum.MaxContentLength < 1 {
+		fail("forum.max_content_length", "must be at least 1")
+	}
+	if c.Forum.CommentEditWindow < 0 {
+		fail("forum.comment_edit_window", "cannot be negative")
+	}
+	if _, err := c.Location(); err != nil {
+		fail("forum.timezone", "%v", err)
+	}
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
+func (c *Config) Addr() string {
+	return c.Server.Host + ":" + strconv.Itoa(c.Server.Port)
+}
+
+// PublicURL is Server.BaseURL, or the local address when it is empty.
+func (c *Config) PublicURL() string {
+	if c.Server.BaseURL == "" {
+		return "http://localhost:" + strconv.Itoa(c.Server.Port)
+	}
+	return strings.TrimRight(c.Server.BaseURL, "/")
+}
+
+func (c *Config) Dialect() database.Dialect {
+	dialect, _ := database.ParseDialect(c.Database.Driver)
+	return dialect
+}
+
+var mediaType = regexp.MustCompile(`^[a-z0-9][a-z0-9.+-]*/[a-z0-9][a-z0-9.+-]*$`)
+
+var offsetPattern = regexp.MustCompile(`^([+-])(\d{2}):(\d{2})$`)
+
+// Location resolves Forum.Timezone.
+func (c *Config) Location() (*time.Location, error) {
+	match := offsetPattern.FindStringSubmatch(c.Forum.Timezone)
+	if match == nil {
+		return time.LoadLocation(c.Forum.Timezone)
+	}
+	hours, _ := strconv.Atoi(match[2])
+	minutes, _ := strconv.Atoi(match[3])
+	if hours > 14 || minutes > 59 {
+		return nil, fmt.Errorf("invalid offset %q", c.Forum.Timezone)
+	}
+	offset := hours*3600 + minutes*60
+	name := "GMT" + match[1] + strconv.Itoa(hours)
+	if minutes != 0 {
+		name += ":" + match[3]
+	}
+	if match[1] == "-" {
+		offset = -offset
+	}
+	return time.FixedZone(name, offset), nil
+}
+
+// Print writes the effective configuration as YAML, with the database
+// password, the mail secrets and the S3 secret key masked.
+func (c *Config) Print(w io.Writer) error {
+	printed := *c
+	printed.Database.URL = maskPassword(c.Database.URL)
+	for _, secret := range []*string{&printed.Mail.SMTPPassword, &printed.Mail.TokenSecret, &printed.Uploads.S3SecretKey} {
+		if *secret != "" {
+			*secret = "xxxxx"
+		}
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&printed); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+var keywordPassword = regexp.MustCompile(`(password=)(\S+)`)
+
+func maskPassword(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			return u.String()
+		}
+	}
+	return keywordPassword.ReplaceAllString(dsn, "${1}xxxxx")
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "forum.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+server:
+  port: 9000
+  host: 127.0.0.1
+session:
+  lifetime: 2h
+forum:
+  max_title_length: 40
+`), 0o600))
+
+	cfg, args, err := Load(
+		[]string{"-config", path, "-server.port", "9002", "migrate", "up"},
+		env(map[string]string{"FORUM_SERVER_PORT": "9001", "FORUM_SESSION_LIFETIME": "90m", "PORT": "1"}),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"migrate", "up"}, args)
+	assert.Equal(t, 9002, cfg.Server.Port, "flags beat the environment")
+	assert.Equal(t, Duration(90*time.Minute), cfg.Session.Lifetime, "the environment beats the file")
+	assert.Equal(t, 40, cfg.Forum.MaxTitleLength, "the file beats the defaults")
+	assert.Equal(t, "127.0.0.1:9002", cfg.Addr())
+	assert.Equal(t, Default().Forum.Timezone, cfg.Forum.Timezone)
+}
+
+func TestLoadLegacyEnvironment(t *testing.T) {
+	cfg, _, err := Load(nil, env(map[string]string{"PORT": "3000", "DATABASE_DRIVER": "postgres", "DATABASE_URL": "postgres://db/forum"}))
+	require.NoError(t, err)
+	assert.Equal(t, 3000, cfg.Server.Port)
+	assert.Equal(t, "postgres", cfg.Database.Driver)
+	assert.Equal(t, "postgres://db/forum", cfg.Database.URL)
+}
+
+func TestLoadBooleanFlag(t *testing.T) {
+	cfg, args, err := Load([]string{"-server.reload_templates", "migrate"}, env(nil))
+	require.NoError(t, err)
+	assert.True(t, cfg.Server.ReloadTemplates, "a bare boolean flag means true")
+	assert.Equal(t, []string{"migrate"}, args)
+
+	_, _, err = Load(nil, env(map[string]string{"FORUM_SERVER_RELOAD_TEMPLATES": "sometimes"}))
+	assert.ErrorContains(t, err, "FORUM_SERVER_RELOAD_TEMPLATES")
+}
+
+func TestLoadRejectsBadInput(t *testing.T) {
+	_, _, err := Load(nil, env(map[string]string{"FORUM_SERVER_PORT": "eighty"}))
+	assert.ErrorContains(t, err, "FORUM_SERVER_PORT")
+
+	path := filepath.Join(t.TempDir(), "forum.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("server:\n  prot: 80\n"), 0o600))
+	_, _, err = Load([]string{"-config", path}, env(nil))
+	assert.ErrorContains(t, err, "prot", "unknown keys in the file are reported")
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Port = 0
+	cfg.Server.StaticDir = filepath.Join(t.TempDir(), "missing")
+	cfg.Database.Driver = "postgres"
+	cfg.Forum.Timezone = "Mars/Olympus"
+	cfg.Session.Lifetime = Duration(time.Second)
+	cfg.Session.CleanupInterval = 0
+	cfg.Server.BaseURL = "forum.example.com"
+	cfg.Mail.Sender = SenderSMTP
+	cfg.Uploads.Storage = StorageS3
+	cfg.Uploads.AllowedTypes = "image/png, pictures"
+	cfg.Live.Heartbeat = Duration(time.Millisecond)
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	for _, key := range []string{"server.port", "server.static_dir", "database.url", "forum.timezone", "session.lifetime", "session.cleanup_interval", "server.base_url", "mail.smtp_host",
+		"uploads.s3_endpoint", "uploads.s3_bucket", "uploads.allowed_types", "live.heartbeat"} {
+		assert.ErrorContains(t, err, key)
+	}
+}
+
+func TestLocation(t *testing.T) {
+	cfg := Default()
+	location, err := cfg.Location()
+	require.NoError(t, err)
+	_, offset := time.Date(2024, 1, 1, 0, 0, 0, 0, location).Zone()
+	assert.Equal(t, 5*60*60, offset)
+
+	cfg.Forum.Timezone = "Europe/Berlin"
+	location, err = cfg.Location()
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/Berlin", location.String())
+}
+
+func TestPrintMasksPassword(t *testing.T) {
+	cfg := Default()
+	cfg.Database.URL = "postgres://forum:secret@db:5432/forum"
+	cfg.Mail.SMTPPassword = "hunter2"
+	cfg.Mail.TokenSecret = "hunter2"
+	cfg.Uploads.S3SecretKey = "hunter2"
+	var out strings.Builder
+	require.NoError(t, cfg.Print(&out))
+	assert.NotContains(t, out.String(), "forum:secret@")
+	assert.NotContains(t, out.String(), "hunter2")
+	assert.Contains(t, out.String(), "lifetime: 24h0m0s")
+
+	assert.Equal(t, "host=db password=xxxxx", maskPassword("host=db password=secret"))
+}
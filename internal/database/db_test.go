@@ -0,0 +1,13 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebind(t *testing.T) {
+	query := `SELECT * FROM posts WHERE title LIKE ? ESCAPE '\' AND content != '?' AND id IN (?, ?)`
+	assert.Equal(t, query, SQLite.Rebind(query))
+	assert.Equal(t, `SELECT * FROM posts WHERE title LIKE $1 ESCAPE '\' AND content != '?' AND id IN ($2, $3)`, Postgres.Rebind(query))
+}
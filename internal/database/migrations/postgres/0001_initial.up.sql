@@ -0,0 +1,138 @@
+-- The PostgreSQL counterpart of sqlite3/0001_initial.up.sql.
+CREATE TABLE roles (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    is_system BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE role_permissions (
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission TEXT NOT NULL,
+    PRIMARY KEY (role_id, permission)
+);
+
+CREATE TABLE users (
+    id SERIAL PRIMARY KEY,
+    username TEXT NOT NULL UNIQUE,
+    email TEXT NOT NULL UNIQUE,
+    password TEXT NOT NULL,
+    is_banned BOOLEAN DEFAULT FALSE,
+    role_id INTEGER REFERENCES roles(id)
+);
+
+CREATE TABLE posts (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    title TEXT,
+    content TEXT,
+    created TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated TIMESTAMPTZ,
+    deleted TIMESTAMPTZ,
+    deleted_by INTEGER
+);
+
+CREATE TABLE categories (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    slug TEXT,
+    description TEXT NOT NULL DEFAULT '',
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    archived BOOLEAN NOT NULL DEFAULT FALSE,
+    created TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE post_categories (
+    post_id INTEGER REFERENCES posts(id) ON DELETE CASCADE,
+    category_id INTEGER REFERENCES categories(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_categories_slug ON categories (slug);
+
+CREATE INDEX idx_posts_created ON posts (created, id);
+CREATE INDEX idx_posts_user_created ON posts (user_id, created, id);
+CREATE INDEX idx_post_categories_category ON post_categories (category_id, post_id);
+
+CREATE TABLE post_votes (
+    post_id INTEGER REFERENCES posts(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    vote_type INTEGER,
+    PRIMARY KEY (post_id, user_id)
+);
+
+CREATE TABLE comments (
+    id SERIAL PRIMARY KEY,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    parent_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+    depth INTEGER NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    content TEXT NOT NULL,
+    updated TIMESTAMPTZ,
+    deleted TIMESTAMPTZ,
+    removed_by INTEGER REFERENCES users(id),
+    removal_reason TEXT
+);
+
+CREATE INDEX idx_comments_parent ON comments (parent_id);
+CREATE INDEX idx_comments_post_user ON comments (post_id, user_id);
+
+CREATE TABLE sessions (
+    session_id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expiry TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE comment_votes (
+    comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    vote_type INTEGER,
+    PRIMARY KEY (comment_id, user_id)
+);
+
+CREATE TABLE post_revisions (
+    id SERIAL PRIMARY KEY,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    editor_id INTEGER NOT NULL REFERENCES users(id),
+    title TEXT,
+    content TEXT,
+    created TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_post_revisions_post ON post_revisions (post_id);
+
+CREATE TABLE api_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    hint TEXT NOT NULL,
+    scopes TEXT NOT NULL,
+    created TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    last_used TIMESTAMPTZ
+);
+
+CREATE INDEX idx_api_tokens_user ON api_tokens (user_id);
+
+INSERT INTO categories (name, slug, sort_order) VALUES
+    ('Technology', 'technology', 1),
+    ('Entertainment', 'entertainment', 2),
+    ('Sports', 'sports', 3),
+    ('Education', 'education', 4),
+    ('Health', 'health', 5);
+
+INSERT INTO roles (name, description, is_system) VALUES
+    ('user', 'Regular member', TRUE),
+    ('moderator', 'Keeps discussions on topic', TRUE),
+    ('admin', 'Full access to the forum', TRUE);
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.permission FROM roles r, (VALUES
+    ('users.view'), ('users.ban'), ('roles.manage'), ('categories.manage'),
+    ('posts.edit'), ('posts.delete'), ('comments.delete')
+) AS p (permission) WHERE r.name = 'admin';
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.permission FROM roles r, (VALUES
+    ('users.view'), ('users.ban'), ('posts.edit'), ('posts.delete'), ('comments.delete')
+) AS p (permission) WHERE r.name = 'moderator';
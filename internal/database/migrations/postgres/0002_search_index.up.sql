@@ -0,0 +1,4 @@
+-- Search on PostgreSQL uses case-insensitive substring matching, which
+-- needs no extra objects. The migration keeps version numbers in step
+-- with the SQLite migrations.
+SELECT 1;
@@ -0,0 +1,19 @@
+-- Sessions are keyed by a hash of their token and carry what the profile
+-- page shows about them. Existing sessions cannot be converted, so
+-- everyone signs in again.
+DROP TABLE sessions;
+
+CREATE TABLE sessions (
+    id SERIAL PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created TIMESTAMPTZ NOT NULL,
+    last_seen TIMESTAMPTZ NOT NULL,
+    expiry TIMESTAMPTZ NOT NULL,
+    remember BOOLEAN NOT NULL DEFAULT FALSE,
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_sessions_user ON sessions (user_id);
+CREATE INDEX idx_sessions_expiry ON sessions (expiry);
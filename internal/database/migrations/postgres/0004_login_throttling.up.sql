@@ -0,0 +1,16 @@
+-- Failed logins lock an account for a while; see models.UserModel.Authenticate.
+ALTER TABLE users ADD COLUMN failed_logins INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until TIMESTAMPTZ;
+
+-- Every failed login, for throttling by address and for the admin audit.
+CREATE TABLE login_failures (
+    id SERIAL PRIMARY KEY,
+    email TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL,
+    created TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_login_failures_ip ON login_failures (ip, created);
+CREATE INDEX idx_login_failures_created ON login_failures (created);
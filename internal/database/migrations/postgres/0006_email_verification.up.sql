@@ -0,0 +1,4 @@
+-- New accounts confirm their email address before they can post. Accounts
+-- from before verification existed are trusted as they are.
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE users SET email_verified = TRUE;
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN created;
+ALTER TABLE users DROP COLUMN avatar_key;
+ALTER TABLE users DROP COLUMN bio;
@@ -0,0 +1,8 @@
+-- Public profiles show a bio, an avatar and when a member joined. Members
+-- from before join dates were kept are dated by their first post, or else
+-- their first comment; those with neither stay undated.
+ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN avatar_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN created TIMESTAMPTZ;
+UPDATE users SET created = (SELECT MIN(created) FROM posts WHERE posts.user_id = users.id);
+UPDATE users SET created = (SELECT MIN(created) FROM comments WHERE comments.user_id = users.id) WHERE created IS NULL;
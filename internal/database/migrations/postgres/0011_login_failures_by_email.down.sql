@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN failed_logins INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until TIMESTAMPTZ;
+
+DROP INDEX IF EXISTS idx_login_failures_email;
+ALTER TABLE login_failures DROP COLUMN cleared;
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS post_revisions;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS comment_votes;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS post_votes;
+DROP TABLE IF EXISTS post_categories;
+DROP TABLE IF EXISTS categories;
+DROP TABLE IF EXISTS posts;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS roles;
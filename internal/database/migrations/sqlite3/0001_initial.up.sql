@@ -0,0 +1,168 @@
+-- The schema as it stood when migrations were introduced. Statements use
+-- IF NOT EXISTS so databases created by the old init.sql are adopted as-is.
+CREATE TABLE IF NOT EXISTS posts (
+                       id INTEGER PRIMARY KEY AUTOINCREMENT,
+                       user_id INTEGER NOT NULL,
+                       title TEXT,
+                       content TEXT,
+                       created DATETIME DEFAULT CURRENT_TIMESTAMP,
+                       updated DATETIME,
+                       deleted DATETIME,
+                       deleted_by INTEGER,
+                       FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+
+CREATE TABLE IF NOT EXISTS users (
+                                     id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                     username TEXT NOT NULL UNIQUE,
+                                     email TEXT NOT NULL UNIQUE,
+                                     password TEXT NOT NULL,
+                                     is_banned BOOLEAN DEFAULT FALSE,
+                                     role_id INTEGER REFERENCES roles(id)
+);
+
+CREATE TABLE IF NOT EXISTS categories (
+                                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                          name TEXT NOT NULL,
+                                          slug TEXT,
+                                          description TEXT NOT NULL DEFAULT '',
+                                          sort_order INTEGER NOT NULL DEFAULT 0,
+                                          archived BOOLEAN NOT NULL DEFAULT FALSE,
+                                          created DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS post_categories (
+                                               post_id INTEGER,
+                                               category_id INTEGER,
+                                               FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+                                               FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_slug ON categories (slug);
+
+CREATE INDEX IF NOT EXISTS idx_posts_created ON posts (created, id);
+CREATE INDEX IF NOT EXISTS idx_posts_user_created ON posts (user_id, created, id);
+CREATE INDEX IF NOT EXISTS idx_post_categories_category ON post_categories (category_id, post_id);
+
+INSERT INTO categories (name, slug, sort_order)
+SELECT name, slug, sort_order FROM (
+    SELECT 'Technology' AS name, 'technology' AS slug, 1 AS sort_order
+    UNION ALL SELECT 'Entertainment', 'entertainment', 2
+    UNION ALL SELECT 'Sports', 'sports', 3
+    UNION ALL SELECT 'Education', 'education', 4
+    UNION ALL SELECT 'Health', 'health', 5
+) WHERE NOT EXISTS (SELECT 1 FROM categories);
+
+CREATE TABLE IF NOT EXISTS post_votes (
+                                          post_id INTEGER,
+                                          user_id INTEGER,
+                                          vote_type INTEGER,
+                                          PRIMARY KEY (post_id, user_id),
+                                          FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+                                          FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+                                        id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                        post_id INTEGER NOT NULL,
+                                        parent_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+                                        depth INTEGER NOT NULL DEFAULT 0,
+                                        user_id INTEGER NOT NULL,
+                                        created DATETIME DEFAULT CURRENT_TIMESTAMP,
+                                        content TEXT NOT NULL,
+                                        updated DATETIME,
+                                        deleted DATETIME,
+                                        removed_by INTEGER REFERENCES users(id),
+                                        removal_reason TEXT,
+                                        FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+                                        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_comments_parent ON comments (parent_id);
+CREATE INDEX IF NOT EXISTS idx_comments_post_user ON comments (post_id, user_id);
+
+CREATE TABLE IF NOT EXISTS sessions (
+                                        session_id TEXT PRIMARY KEY,
+                                        user_id INTEGER NOT NULL,
+                                        expiry DATETIME NOT NULL,
+                                        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS comment_votes (
+                                             comment_id INTEGER,
+                                             user_id INTEGER,
+                                             vote_type INTEGER,
+                                             PRIMARY KEY (comment_id, user_id),
+                                             FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE,
+                                             FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+                                     id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                     name TEXT NOT NULL UNIQUE,
+                                     description TEXT NOT NULL DEFAULT '',
+                                     is_system BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+                                                role_id INTEGER NOT NULL,
+                                                permission TEXT NOT NULL,
+                                                PRIMARY KEY (role_id, permission),
+                                                FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+);
+
+INSERT INTO roles (name, description, is_system)
+SELECT 'user', 'Regular member', TRUE WHERE NOT EXISTS (SELECT 1 FROM roles WHERE name = 'user');
+INSERT INTO roles (name, description, is_system)
+SELECT 'moderator', 'Keeps discussions on topic', TRUE WHERE NOT EXISTS (SELECT 1 FROM roles WHERE name = 'moderator');
+INSERT INTO roles (name, description, is_system)
+SELECT 'admin', 'Full access to the forum', TRUE WHERE NOT EXISTS (SELECT 1 FROM roles WHERE name = 'admin');
+
+INSERT OR IGNORE INTO role_permissions (role_id, permission)
+SELECT r.id, p.permission FROM roles r, (
+    SELECT 'users.view' AS permission
+    UNION ALL SELECT 'users.ban'
+    UNION ALL SELECT 'roles.manage'
+    UNION ALL SELECT 'categories.manage'
+    UNION ALL SELECT 'posts.edit'
+    UNION ALL SELECT 'posts.delete'
+    UNION ALL SELECT 'comments.delete'
+) p WHERE r.name = 'admin';
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.permission FROM roles r, (
+    SELECT 'users.view' AS permission
+    UNION ALL SELECT 'users.ban'
+    UNION ALL SELECT 'posts.edit'
+    UNION ALL SELECT 'posts.delete'
+    UNION ALL SELECT 'comments.delete'
+) p WHERE r.name = 'moderator'
+  AND NOT EXISTS (SELECT 1 FROM role_permissions WHERE role_id = r.id);
+
+CREATE TABLE IF NOT EXISTS post_revisions (
+                                              id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                              post_id INTEGER NOT NULL,
+                                              editor_id INTEGER NOT NULL,
+                                              title TEXT,
+                                              content TEXT,
+                                              created DATETIME DEFAULT CURRENT_TIMESTAMP,
+                                              FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+                                              FOREIGN KEY (editor_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_revisions_post ON post_revisions (post_id);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+                                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                          user_id INTEGER NOT NULL,
+                                          name TEXT NOT NULL,
+                                          token_hash TEXT NOT NULL UNIQUE,
+                                          hint TEXT NOT NULL,
+                                          scopes TEXT NOT NULL,
+                                          created DATETIME DEFAULT CURRENT_TIMESTAMP,
+                                          last_used DATETIME,
+                                          FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens (user_id);
@@ -0,0 +1,50 @@
+-- requires: fts5
+-- Full-text index over post titles, post content and comments. Posts use
+-- rowid = id * 2 and comments rowid = id * 2 + 1 so triggers can find their
+-- row without scanning. Skipped when SQLite is built without FTS5.
+CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+    title,
+    content,
+    post_id UNINDEXED,
+    comment_id UNINDEXED,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS posts_search_insert AFTER INSERT ON posts BEGIN
+    INSERT INTO search_index (rowid, title, content, post_id, comment_id)
+    VALUES (new.id * 2, new.title, new.content, new.id, NULL);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_search_update AFTER UPDATE OF title, content, deleted ON posts BEGIN
+    DELETE FROM search_index WHERE rowid = old.id * 2;
+    INSERT INTO search_index (rowid, title, content, post_id, comment_id)
+    SELECT new.id * 2, new.title, new.content, new.id, NULL WHERE new.deleted IS NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_search_delete AFTER DELETE ON posts BEGIN
+    DELETE FROM search_index WHERE rowid = old.id * 2;
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_search_insert AFTER INSERT ON comments BEGIN
+    INSERT INTO search_index (rowid, title, content, post_id, comment_id)
+    VALUES (new.id * 2 + 1, '', new.content, new.post_id, new.id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_search_update AFTER UPDATE OF content, deleted ON comments BEGIN
+    DELETE FROM search_index WHERE rowid = old.id * 2 + 1;
+    INSERT INTO search_index (rowid, title, content, post_id, comment_id)
+    SELECT new.id * 2 + 1, '', new.content, new.post_id, new.id WHERE new.deleted IS NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_search_delete AFTER DELETE ON comments BEGIN
+    DELETE FROM search_index WHERE rowid = old.id * 2 + 1;
+END;
+
+-- Index existing content the first time the table is created.
+INSERT INTO search_index (rowid, title, content, post_id, comment_id)
+SELECT id * 2, title, content, id, NULL FROM posts
+WHERE deleted IS NULL AND NOT EXISTS (SELECT 1 FROM search_index);
+
+INSERT INTO search_index (rowid, title, content, post_id, comment_id)
+SELECT id * 2 + 1, '', content, post_id, id FROM comments
+WHERE deleted IS NULL AND NOT EXISTS (SELECT 1 FROM search_index WHERE comment_id IS NOT NULL);
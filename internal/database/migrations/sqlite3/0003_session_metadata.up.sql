@@ -0,0 +1,21 @@
+-- Sessions are keyed by a hash of their token and carry what the profile
+-- page shows about them. Existing sessions cannot be converted, so
+-- everyone signs in again. Dropping the table also drops the one session
+-- per user index older databases have.
+DROP TABLE sessions;
+
+CREATE TABLE sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_hash TEXT NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    expiry DATETIME NOT NULL,
+    remember BOOLEAN NOT NULL DEFAULT FALSE,
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_sessions_user ON sessions (user_id);
+CREATE INDEX idx_sessions_expiry ON sessions (expiry);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS pending_logins;
+DROP TABLE IF EXISTS recovery_codes;
+ALTER TABLE users DROP COLUMN totp_last_step;
+ALTER TABLE users DROP COLUMN totp_secret;
@@ -0,0 +1,4 @@
+ALTER TABLE comments DROP COLUMN html_version;
+ALTER TABLE comments DROP COLUMN content_html;
+ALTER TABLE posts DROP COLUMN html_version;
+ALTER TABLE posts DROP COLUMN content_html;
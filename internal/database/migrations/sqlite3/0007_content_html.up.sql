@@ -0,0 +1,7 @@
+-- Posts and comments keep their Markdown rendered as HTML. Rows rendered
+-- by an older renderer, like all rows from before this migration, carry an
+-- older html_version and are rendered again.
+ALTER TABLE posts ADD COLUMN content_html TEXT NOT NULL DEFAULT '';
+ALTER TABLE posts ADD COLUMN html_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE comments ADD COLUMN content_html TEXT NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN html_version INTEGER NOT NULL DEFAULT 0;
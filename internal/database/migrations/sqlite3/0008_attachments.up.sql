@@ -0,0 +1,17 @@
+-- Files attached to posts. The bytes live in blob storage under
+-- storage_key; images also have a thumbnail under thumbnail_key.
+CREATE TABLE IF NOT EXISTS attachments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    filename TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    storage_key TEXT NOT NULL UNIQUE,
+    thumbnail_key TEXT NOT NULL DEFAULT '',
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_post ON attachments (post_id);
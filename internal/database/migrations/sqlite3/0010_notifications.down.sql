@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS notification_mutes;
+DROP TABLE IF EXISTS notification_actors;
+DROP TABLE IF EXISTS notifications;
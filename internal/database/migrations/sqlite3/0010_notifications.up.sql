@@ -0,0 +1,30 @@
+-- Notifications tell members about comments on their posts, replies to
+-- their comments, mentions and likes. comment_id is 0 for a like of a
+-- post. Likes of the same thing are gathered into one unread notification,
+-- whose actors are listed in notification_actors so nobody counts twice.
+CREATE TABLE notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    actor_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    actors INTEGER NOT NULL DEFAULT 1,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    comment_id INTEGER NOT NULL DEFAULT 0,
+    created DATETIME NOT NULL,
+    read_at DATETIME
+);
+
+CREATE INDEX idx_notifications_user ON notifications (user_id, created);
+
+CREATE TABLE notification_actors (
+    notification_id INTEGER NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (notification_id, user_id)
+);
+
+-- A row turns one type of notification off for a member.
+CREATE TABLE notification_mutes (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    PRIMARY KEY (user_id, type)
+);
@@ -0,0 +1,9 @@
+-- Lockouts are counted per email in login_failures, whether or not an
+-- account uses the email, so they cannot tell which emails have accounts;
+-- see models.UserModel.Authenticate. A successful login clears the
+-- failures before it, which stay for the admin audit.
+ALTER TABLE login_failures ADD COLUMN cleared BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS idx_login_failures_email ON login_failures (email, created);
+
+ALTER TABLE users DROP COLUMN locked_until;
+ALTER TABLE users DROP COLUMN failed_logins;
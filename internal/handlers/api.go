@@ -0,0 +1,650 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiList is the envelope of every list response. Cursors are only set on
+// paginated lists and are passed back as ?after= or ?before=.
+type apiList struct {
+	Data       any    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+type apiVoteResult struct {
+	Likes    int `json:"likes"`
+	Dislikes int `json:"dislikes"`
+	UserVote int `json:"user_vote"`
+}
+
+// apiPublicUser is what anyone may see of a member.
+type apiPublicUser struct {
+	ID       int        `json:"id"`
+	Username string     `json:"username"`
+	Role     string     `json:"role"`
+	Bio      string     `json:"bio"`
+	Created  *time.Time `json:"created,omitempty"`
+}
+
+const maxAPIBodyBytes = 1 << 20
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writeJSON: Failed to encode response: %v", err)
+	}
+}
+
+// WriteAPIError is the JSON counterpart of RenderError.
+func WriteAPIError(w http.ResponseWriter, code int, description string) {
+	writeJSON(w, code, ErrorData{
+		Code:        code,
+		Status:      http.StatusText(code),
+		Description: description,
+	})
+}
+
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIBodyBytes)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		WriteAPIError(w, http.StatusBadRequest, "The request body must be a valid JSON object: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func apiAllowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	WriteAPIError(w, http.StatusMethodNotAllowed, "This endpoint does not support the HTTP method used.")
+	return false
+}
+
+// apiCaller identifies who is calling and checks that they may use scope.
+// An Authorization: Bearer token takes precedence over the session cookie;
+// a session carries every scope. Anonymous callers get 0. When ok is false
+// the error response has already been written.
+func apiCaller(w http.ResponseWriter, r *http.Request, store *models.Store, scope string) (int, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		userModel := store.Users
+		userID, err := userModel.GetSessionUserIDFromRequest(r)
+		if err != nil {
+			return 0, true
+		}
+		return userID, true
+	}
+
+	token, found := strings.CutPrefix(header, "Bearer ")
+	if !found {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="forum"`)
+		WriteAPIError(w, http.StatusUnauthorized, "The Authorization header must use the Bearer scheme.")
+		return 0, false
+	}
+
+	tokenModel := store.Tokens
+	userID, scopes, err := tokenModel.Authenticate(strings.TrimSpace(token))
+	if errors.Is(err, models.ErrInvalidToken) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="forum", error="invalid_token"`)
+		WriteAPIError(w, http.StatusUnauthorized, "The API token is invalid or has been revoked.")
+		return 0, false
+	} else if err != nil {
+		log.Printf("apiCaller: Failed to check API token: %v", err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to check the API token.")
+		return 0, false
+	}
+	if !scopes.Has(scope) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="forum", error="insufficient_scope", scope="`+scope+`"`)
+		WriteAPIError(w, http.StatusForbidden, "This API token does not have the "+scope+" scope.")
+		return 0, false
+	}
+	return userID, true
+}
+
+func apiRequireUser(w http.ResponseWriter, r *http.Request, store *models.Store, scope string) (int, bool) {
+	userID, ok := apiCaller(w, r, store, scope)
+	if !ok {
+		return 0, false
+	}
+	if userID == 0 {
+		WriteAPIError(w, http.StatusUnauthorized, "Authentication required.")
+		return 0, false
+	}
+	return userID, true
+}
+
+// apiRequirePoster is apiRequireUser for requests that post content, which
+// needs a confirmed email address.
+func apiRequirePoster(w http.ResponseWriter, r *http.Request, store *models.Store) (int, bool) {
+	userID, ok := apiRequireUser(w, r, store, models.ScopeWrite)
+	if !ok {
+		return 0, false
+	}
+	if !apiEmailVerified(w, store, userID) {
+		return 0, false
+	}
+	return userID, true
+}
+
+func apiEmailVerified(w http.ResponseWriter, store *models.Store, userID int) bool {
+	unverified, err := emailUnverified(store, userID)
+	if err != nil {
+		log.Printf("apiEmailVerified: Failed to fetch user ID %d: %v", userID, err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to check your account.")
+		return false
+	}
+	if unverified {
+		WriteAPIError(w, http.StatusForbidden, unverifiedMessage)
+		return false
+	}
+	return true
+}
+
+func apiPathID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil || id < 1 {
+		WriteAPIError(w, http.StatusBadRequest, "The "+name+" in the URL must be a positive number.")
+		return 0, false
+	}
+	return id, true
+}
+
+// apiLoadPost fetches a visible post, writing the error response itself when
+// the post is missing or deleted.
+func apiLoadPost(w http.ResponseWriter, store *models.Store, postID int) (*models.Post, bool) {
+	postModel := store.Posts
+	post, err := postModel.Get(postID)
+	if err == sql.ErrNoRows {
+		WriteAPIError(w, http.StatusNotFound, "The post with the specified ID does not exist.")
+		return nil, false
+	} else if err != nil {
+		log.Printf("apiLoadPost: Failed to retrieve post %d: %v", postID, err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to retrieve the post.")
+		return nil, false
+	}
+	if post.Deleted {
+		WriteAPIError(w, http.StatusGone, "This post has been deleted.")
+		return nil, false
+	}
+	return post, true
+}
+
+func apiPostDetails(store *models.Store, post *models.Post, viewerID int) error {
+	postModel := store.Posts
+	commentModel := store.Comments
+
+	var err error
+	if post.Username, err = postModel.GetUsername(post.UserID); err != nil {
+		return err
+	}
+	if post.Categories, err = postModel.GetCategories(post.ID); err != nil {
+		return err
+	}
+	if post.Likes, post.Dislikes, err = postModel.GetLikesAndDislikes(post.ID); err != nil {
+		return err
+	}
+	if post.CommentCount, err = commentModel.CountByPostID(post.ID); err != nil {
+		return err
+	}
+	if post.Attachments, err = store.Attachments.ByPostID(post.ID); err != nil {
+		return err
+	}
+	if viewerID > 0 {
+		if post.UserVote, err = postModel.GetUserVote(post.ID, viewerID); err != nil {
+			return err
+		}
+		if post.UserCommented, err = commentModel.HasUserCommented(post.ID, viewerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// APIPosts serves GET (list) and POST (create) on /api/v1/posts. Lists take
+// the same after/before/limit parameters as the HTML listings and can be
+// narrowed with ?category={slug} or ?user_id={id}.
+func APIPosts(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !apiAllowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		apiCreatePost(w, r, store)
+		return
+	}
+
+	pageReq, ok := pageRequestFromQuery(r)
+	if !ok {
+		WriteAPIError(w, http.StatusBadRequest, "The paging parameters are invalid.")
+		return
+	}
+
+	viewerID, ok := apiCaller(w, r, store, models.ScopeRead)
+	if !ok {
+		return
+	}
+	postModel := store.Posts
+	query := r.URL.Query()
+
+	var page *models.PostPage
+	var err error
+	switch {
+	case query.Get("category") != "":
+		categoryModel := store.Categories
+		category, catErr := categoryModel.GetBySlug(query.Get("category"))
+		if catErr == sql.ErrNoRows {
+			WriteAPIError(w, http.StatusNotFound, "The category does not exist.")
+			return
+		} else if catErr != nil {
+			log.Printf("APIPosts: Failed to retrieve category: %v", catErr)
+			WriteAPIError(w, http.StatusInternalServerError, "Failed to retrieve the category.")
+			return
+		}
+		page, err = postModel.GetByCategoryID(category.ID, viewerID, pageReq)
+	case query.Get("user_id") != "":
+		authorID, convErr := strconv.Atoi(query.Get("user_id"))
+		if convErr != nil || authorID < 1 {
+			WriteAPIError(w, http.StatusBadRequest, "user_id must be a positive number.")
+			return
+		}
+		page, err = postModel.GetByUserID(authorID, viewerID, pageReq)
+	default:
+		page, err = postModel.Latest(viewerID, pageReq)
+	}
+	if errors.Is(err, models.ErrInvalidCursor) {
+		WriteAPIError(w, http.StatusBadRequest, "The page cursor is invalid.")
+		return
+	} else if err != nil {
+		log.Printf("APIPosts: Failed to list posts: %v", err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to list posts.")
+		return
+	}
+
+	posts := page.Posts
+	if posts == nil {
+		posts = []*models.Post{}
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: posts, NextCursor: page.NextCursor, PrevCursor: page.PrevCursor})
+}
+
+func apiCreatePost(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	userID, ok := apiRequirePoster(w, r, store)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Title       string `json:"title"`
+		Content     string `json:"content"`
+		CategoryIDs []int  `json:"category_ids"`
+	}
+	if !decodeJSONBody(w, r, &input) {
+		return
+	}
+
+	categoryIDs, code, problem := checkNewPost(store, input.Title, input.Content, input.CategoryIDs)
+	if problem != "" {
+		WriteAPIError(w, code, problem)
+		return
+	}
+
+	postModel := store.Posts
+	postID, err := postModel.InsertWithUserIDAndCategories(input.Title, input.Content, userID, categoryIDs)
+	if err != nil {
+		log.Printf("apiCreatePost: Failed to create post: %v", err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to create the post.")
+		return
+	}
+
+	post, err := postModel.Get(postID)
+	if err == nil {
+		err = apiPostDetails(store, post, userID)
+	}
+	if err != nil {
+		log.Printf("apiCreatePost: Failed to load created post %d: %v", postID, err)
+		WriteAPIError(w, http.StatusInternalServerError, "The post was created but could not be loaded.")
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/posts/"+strconv.Itoa(postID))
+	writeJSON(w, http.StatusCreated, post)
+}
+
+func APIPost(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !apiAllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	viewerID, ok := apiCaller(w, r, store, models.ScopeRead)
+	if !ok {
+		return
+	}
+	postID, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	post, ok := apiLoadPost(w, store, postID)
+	if !ok {
+		return
+	}
+
+	if err := apiPostDetails(store, post, viewerID); err != nil {
+		log.Printf("APIPost: Failed to load post %d: %v", postID, err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to load the post.")
+		return
+	}
+	writeJSON(w, http.StatusOK, post)
+}
+
+// APIPostComments serves GET (the whole thread, nested under replies) and
+// POST (a new comment, or a reply when parent_id is set).
+func APIPostComments(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !apiAllowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	scope := models.ScopeRead
+	if r.Method == http.MethodPost {
+		scope = models.ScopeWrite
+	}
+	viewerID, ok := apiCaller(w, r, store, scope)
+	if !ok {
+		return
+	}
+	postID, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	if _, ok := apiLoadPost(w, store, postID); !ok {
+		return
+	}
+
+	commentModel := store.Comments
+	if r.Method == http.MethodGet {
+		comments, err := commentModel.GetThreadByPostID(postID, viewerID)
+		if err != nil {
+			log.Printf("APIPostComments: Failed to retrieve comments for post %d: %v", postID, err)
+			WriteAPIError(w, http.StatusInternalServerError, "Failed to retrieve comments.")
+			return
+		}
+		prepareComments(comments, viewerID, viewerID > 0 && HasPermission(store, viewerID, models.PermDeleteComments), false, time.Now())
+		if comments == nil {
+			comments = []*models.Comment{}
+		}
+		writeJSON(w, http.StatusOK, apiList{Data: comments})
+		return
+	}
+
+	userID := viewerID
+	if userID == 0 {
+		WriteAPIError(w, http.StatusUnauthorized, "Authentication required.")
+		return
+	}
+	if !apiEmailVerified(w, store, userID) {
+		return
+	}
+
+	var input struct {
+		Content  string `json:"content"`
+		ParentID int    `json:"parent_id"`
+	}
+	if !decodeJSONBody(w, r, &input) {
+		return
+	}
+	if IsBlankOrInvisibleText(input.Content) {
+		WriteAPIError(w, http.StatusBadRequest, "Content cannot be empty or consist only of invisible characters.")
+		return
+	}
+	if msg, ok := contentTooLong(input.Content); ok {
+		WriteAPIError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	var commentID int
+	var err error
+	if input.ParentID > 0 {
+		commentID, err = commentModel.InsertReply(postID, input.ParentID, userID, input.Content)
+	} else {
+		commentID, err = commentModel.Insert(postID, userID, input.Content)
+	}
+	switch {
+	case errors.Is(err, models.ErrParentNotFound):
+		WriteAPIError(w, http.StatusNotFound, "The comment you are replying to does not exist on this post.")
+		return
+	case errors.Is(err, models.ErrCommentGone):
+		WriteAPIError(w, http.StatusGone, "The comment you are replying to has been deleted.")
+		return
+	case errors.Is(err, models.ErrCommentTooDeep):
+		WriteAPIError(w, http.StatusBadRequest, "Replies cannot be nested more than "+strconv.Itoa(models.MaxCommentDepth)+" levels deep.")
+		return
+	case err != nil:
+		log.Printf("APIPostComments: Failed to add comment to post %d: %v", postID, err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to add the comment.")
+		return
+	}
+
+	comment, err := commentModel.Get(commentID)
+	if err != nil {
+		log.Printf("APIPostComments: Failed to load comment %d: %v", commentID, err)
+		WriteAPIError(w, http.StatusInternalServerError, "The comment was added but could not be loaded.")
+		return
+	}
+	comment.Username, _ = store.Posts.GetUsername(userID)
+	writeJSON(w, http.StatusCreated, comment)
+}
+
+func apiReadVote(w http.ResponseWriter, r *http.Request) (int, bool) {
+	var input struct {
+		Vote int `json:"vote"`
+	}
+	if !decodeJSONBody(w, r, &input) {
+		return 0, false
+	}
+	if input.Vote != 1 && input.Vote != -1 {
+		WriteAPIError(w, http.StatusBadRequest, "vote must be 1 (like) or -1 (dislike).")
+		return 0, false
+	}
+	return input.Vote, true
+}
+
+// APIPostVote toggles a vote the same way the HTML buttons do: repeating a
+// vote removes it.
+func APIPostVote(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !apiAllowMethods(w, r, http.MethodPost) {
+		return
+	}
+	userID, ok := apiRequireUser(w, r, store, models.ScopeVote)
+	if !ok {
+		return
+	}
+	postID, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	vote, ok := apiReadVote(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := apiLoadPost(w, store, postID); !ok {
+		return
+	}
+
+	postModel := store.Posts
+	result := apiVoteResult{}
+	err := postModel.ToggleVote(postID, userID, vote)
+	if err == nil {
+		result.Likes, result.Dislikes, err = postModel.GetLikesAndDislikes(postID)
+	}
+	if err == nil {
+		result.UserVote, err = postModel.GetUserVote(postID, userID)
+	}
+	if err != nil {
+		log.Printf("APIPostVote: Failed to vote on post %d: %v", postID, err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to process your vote.")
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func APICommentVote(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !apiAllowMethods(w, r, http.MethodPost) {
+		return
+	}
+	userID, ok := apiRequireUser(w, r, store, models.ScopeVote)
+	if !ok {
+		return
+	}
+	commentID, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	vote, ok := apiReadVote(w, r)
+	if !ok {
+		return
+	}
+
+	commentModel := store.Comments
+	comment, err := commentModel.Get(commentID)
+	if err == sql.ErrNoRows {
+		WriteAPIError(w, http.StatusNotFound, "The comment with the specified ID does not exist.")
+		return
+	} else if err != nil {
+		log.Printf("APICommentVote: Failed to retrieve comment %d: %v", commentID, err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to retrieve the comment.")
+		return
+	}
+	if comment.Gone() {
+		WriteAPIError(w, http.StatusGone, "This comment has been deleted.")
+		return
+	}
+
+	result := apiVoteResult{}
+	err = commentModel.ToggleVote(commentID, userID, vote)
+	if err == nil {
+		result.Likes, result.Dislikes, err = commentModel.GetLikesAndDislikes(commentID)
+	}
+	if err == nil {
+		result.UserVote, err = commentModel.GetUserVote(commentID, userID)
+	}
+	if err != nil {
+		log.Printf("APICommentVote: Failed to vote on comment %d: %v", commentID, err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to process your vote.")
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func APICategories(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !apiAllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if _, ok := apiCaller(w, r, store, models.ScopeRead); !ok {
+		return
+	}
+	categoryModel := store.Categories
+	categories, err := categoryModel.Active()
+	if err != nil {
+		log.Printf("APICategories: Failed to list categories: %v", err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to list categories.")
+		return
+	}
+	if categories == nil {
+		categories = []*models.Category{}
+	}
+	writeJSON(w, http.StatusOK, apiList{Data: categories})
+}
+
+// APIMe describes the signed-in user, including their permissions.
+func APIMe(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !apiAllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	userID, ok := apiRequireUser(w, r, store, models.ScopeRead)
+	if !ok {
+		return
+	}
+
+	userModel := store.Users
+	user, err := userModel.Get(userID)
+	if err != nil {
+		log.Printf("APIMe: Failed to retrieve user %d: %v", userID, err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to retrieve your account.")
+		return
+	}
+
+	roleModel := store.Roles
+	permissionSet, err := roleModel.UserPermissions(userID)
+	if err != nil {
+		log.Printf("APIMe: Failed to retrieve permissions for user %d: %v", userID, err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to retrieve your permissions.")
+		return
+	}
+	permissions := []string{}
+	for permission := range permissionSet {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+
+	writeJSON(w, http.StatusOK, struct {
+		*models.User
+		Permissions []string `json:"permissions"`
+	}{user, permissions})
+}
+
+// APIUser is the public view of a member; the email address is left out.
+// Whether the member is banned or has confirmed their address is only shown
+// to callers who may ban users.
+func APIUser(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !apiAllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	viewerID, ok := apiCaller(w, r, store, models.ScopeRead)
+	if !ok {
+		return
+	}
+	userID, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	userModel := store.Users
+	user, err := userModel.Get(userID)
+	if err == sql.ErrNoRows {
+		WriteAPIError(w, http.StatusNotFound, "The user with the specified ID does not exist.")
+		return
+	} else if err != nil {
+		log.Printf("APIUser: Failed to retrieve user %d: %v", userID, err)
+		WriteAPIError(w, http.StatusInternalServerError, "Failed to retrieve the user.")
+		return
+	}
+	user.Email = ""
+	if viewerID > 0 && HasPermission(store, viewerID, models.PermBanUsers) {
+		writeJSON(w, http.StatusOK, user)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiPublicUser{
+		ID:       user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		Bio:      user.Bio,
+		Created:  user.Created,
+	})
+}
+
+func APINotFound(w http.ResponseWriter, r *http.Request) {
+	WriteAPIError(w, http.StatusNotFound, "The requested API endpoint does not exist.")
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"encoding/json"
+	"forum/internal/database"
+	"forum/internal/migrate"
+	"forum/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStore returns a store on a freshly migrated SQLite database.
+func newTestStore(t *testing.T) *models.Store {
+	t.Helper()
+	db, err := database.Open(database.SQLite, filepath.Join(t.TempDir(), "forum.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	migrations, err := database.Migrations(database.SQLite)
+	require.NoError(t, err)
+	require.NoError(t, (&migrate.Migrator{DB: db, FS: migrations}).Up())
+	return models.NewStore(db)
+}
+
+// createTestUser signs up username with a verified email and returns its ID.
+func createTestUser(t *testing.T, store *models.Store, username string) int {
+	t.Helper()
+	email := strings.ToLower(username) + "@example.com"
+	require.NoError(t, store.Users.Create(username, email, "password123"))
+	user, err := store.Users.GetByEmail(email)
+	require.NoError(t, err)
+	require.NoError(t, store.Users.VerifyEmail(user.ID, email))
+	return user.ID
+}
+
+// assignRole gives userID the built-in role called name.
+func assignRole(t *testing.T, store *models.Store, userID int, name string) {
+	t.Helper()
+	roles, err := store.Roles.All()
+	require.NoError(t, err)
+	for _, role := range roles {
+		if role.Name == name {
+			require.NoError(t, store.Roles.AssignToUser(userID, role.ID))
+			return
+		}
+	}
+	t.Fatalf("no role called %q", name)
+}
+
+// formRequest builds a form POST signed in as userID.
+func formRequest(t *testing.T, store *models.Store, userID int, target string, form url.Values) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	token, err := store.Sessions.Create(userID, false, "192.0.2.1", "test")
+	require.NoError(t, err)
+	r.AddCookie(&http.Cookie{Name: "session_id", Value: token})
+	return r
+}
+
+// apiRequest serves one request to an API handler. The path values are
+// given as name, value pairs because the handlers are called without the
+// router.
+func apiRequest(handler func(http.ResponseWriter, *http.Request, *models.Store), store *models.Store, method, target, token, body string, pathValues ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(pathValues); i += 2 {
+		r.SetPathValue(pathValues[i], pathValues[i+1])
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, r, store)
+	return rec
+}
+
+func TestAPIRemovedComment(t *testing.T) {
+	store := newTestStore(t)
+	author := createTestUser(t, store, "Author")
+	moderator := createTestUser(t, store, "Moderator")
+	postID, err := store.Posts.InsertWithUserIDAndCategories("A post", "Some text", author, []int{1})
+	require.NoError(t, err)
+	commentID, err := store.Comments.Insert(postID, author, "Something **rude**")
+	require.NoError(t, err)
+	require.NoError(t, store.Comments.Remove(commentID, moderator, "Rude"))
+
+	rec := apiRequest(APIPostComments, store, http.MethodGet, "/api/v1/posts/"+strconv.Itoa(postID)+"/comments", "", "", "id", strconv.Itoa(postID))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "rude")
+	assert.NotContains(t, rec.Body.String(), "Rude")
+
+	var list struct {
+		Data []map[string]any `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Data, 1)
+	assert.Equal(t, true, list.Data[0]["removed"])
+	assert.Equal(t, "", list.Data[0]["content"])
+	assert.Equal(t, "", list.Data[0]["content_html"])
+}
+
+func TestAPIPosts(t *testing.T) {
+	store := newTestStore(t)
+	author := createTestUser(t, store, "Author")
+	token, err := store.Tokens.Create(author, "test", []string{models.ScopeRead, models.ScopeWrite, models.ScopeVote})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := store.Posts.InsertWithUserIDAndCategories("Post "+strconv.Itoa(i), "Text", author, []int{1})
+		require.NoError(t, err)
+	}
+
+	rec := apiRequest(APIPosts, store, http.MethodGet, "/api/v1/posts?limit=2", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+	var list struct {
+		Data       []map[string]any `json:"data"`
+		NextCursor string           `json:"next_cursor"`
+		PrevCursor string           `json:"prev_cursor"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Data, 2)
+	assert.Equal(t, "Post 2", list.Data[0]["title"])
+	for _, key := range []string{"id", "content", "content_html", "created", "user_id", "username", "likes", "dislikes", "categories", "comment_count"} {
+		assert.Contains(t, list.Data[0], key)
+	}
+	assert.NotEmpty(t, list.NextCursor)
+
+	rec = apiRequest(APIPosts, store, http.MethodGet, "/api/v1/posts?after="+url.QueryEscape(list.NextCursor), "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	list.Data = nil
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Data, 1)
+	assert.Equal(t, "Post 0", list.Data[0]["title"])
+
+	// Creating a post answers 201 with its location and the post itself.
+	rec = apiRequest(APIPosts, store, http.MethodPost, "/api/v1/posts", token, `{"title":"New","content":"Body","category_ids":[1]}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var post models.Post
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &post))
+	assert.Equal(t, "New", post.Title)
+	assert.Equal(t, "Author", post.Username)
+	assert.Equal(t, "/api/v1/posts/"+strconv.Itoa(post.ID), rec.Header().Get("Location"))
+
+	rec = apiRequest(APIPost, store, http.MethodGet, "/api/v1/posts/"+strconv.Itoa(post.ID), "", "", "id", strconv.Itoa(post.ID))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"title":"New"`)
+}
+
+func TestAPIErrors(t *testing.T) {
+	store := newTestStore(t)
+	author := createTestUser(t, store, "Author")
+	token, err := store.Tokens.Create(author, "test", []string{models.ScopeRead, models.ScopeWrite, models.ScopeVote})
+	require.NoError(t, err)
+	postID, err := store.Posts.InsertWithUserIDAndCategories("A post", "Text", author, []int{1})
+	require.NoError(t, err)
+	deletedID, err := store.Posts.InsertWithUserIDAndCategories("Gone", "Text", author, []int{1})
+	require.NoError(t, err)
+	require.NoError(t, store.Posts.Delete(deletedID, author))
+
+	tests := []struct {
+		name    string
+		handler func(http.ResponseWriter, *http.Request, *models.Store)
+		method  string
+		target  string
+		token   string
+		body    string
+		id      string
+		code    int
+	}{
+		{"missing post", APIPost, http.MethodGet, "/api/v1/posts/9999", "", "", "9999", http.StatusNotFound},
+		{"deleted post", APIPost, http.MethodGet, "/api/v1/posts/" + strconv.Itoa(deletedID), "", "", strconv.Itoa(deletedID), http.StatusGone},
+		{"bad id", APIPost, http.MethodGet, "/api/v1/posts/abc", "", "", "abc", http.StatusBadRequest},
+		{"wrong method", APIPost, http.MethodDelete, "/api/v1/posts/" + strconv.Itoa(postID), "", "", strconv.Itoa(postID), http.StatusMethodNotAllowed},
+		{"anonymous post", APIPosts, http.MethodPost, "/api/v1/posts", "", `{"title":"T","content":"C","category_ids":[1]}`, "", http.StatusUnauthorized},
+		{"invalid token", APIPosts, http.MethodGet, "/api/v1/posts", "not-a-token", "", "", http.StatusUnauthorized},
+		{"unknown field", APIPosts, http.MethodPost, "/api/v1/posts", token, `{"title":"T","content":"C","tags":[]}`, "", http.StatusBadRequest},
+		{"bad vote", APIPostVote, http.MethodPost, "/api/v1/posts/" + strconv.Itoa(postID) + "/vote", token, `{"vote":2}`, strconv.Itoa(postID), http.StatusBadRequest},
+		{"bad limit", APIPosts, http.MethodGet, "/api/v1/posts?limit=0", "", "", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := apiRequest(tt.handler, store, tt.method, tt.target, tt.token, tt.body, "id", tt.id)
+			require.Equal(t, tt.code, rec.Code, rec.Body.String())
+			var body ErrorData
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, tt.code, body.Code)
+			assert.Equal(t, http.StatusText(tt.code), body.Status)
+			assert.NotEmpty(t, body.Description)
+		})
+	}
+
+	rec := apiRequest(APIPost, store, http.MethodDelete, "/api/v1/posts/1", "", "", "id", "1")
+	assert.Equal(t, "GET", rec.Header().Get("Allow"))
+	rec = apiRequest(APIPosts, store, http.MethodGet, "/api/v1/posts", "not-a-token", "")
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	store := newTestStore(t)
+	author := createTestUser(t, store, "Author")
+	readOnly, err := store.Tokens.Create(author, "reader", []string{models.ScopeRead})
+	require.NoError(t, err)
+	writer, err := store.Tokens.Create(author, "writer", []string{models.ScopeRead, models.ScopeWrite, models.ScopeVote})
+	require.NoError(t, err)
+	postID, err := store.Posts.InsertWithUserIDAndCategories("A post", "Text", author, []int{1})
+	require.NoError(t, err)
+	commentID, err := store.Comments.Insert(postID, author, "A comment")
+	require.NoError(t, err)
+	post, comment := strconv.Itoa(postID), strconv.Itoa(commentID)
+
+	requests := []struct {
+		name    string
+		handler func(http.ResponseWriter, *http.Request, *models.Store)
+		target  string
+		body    string
+		id      string
+		scope   string
+		code    int
+	}{
+		{"post vote", APIPostVote, "/api/v1/posts/" + post + "/vote", `{"vote":1}`, post, models.ScopeVote, http.StatusOK},
+		{"comment vote", APICommentVote, "/api/v1/comments/" + comment + "/vote", `{"vote":1}`, comment, models.ScopeVote, http.StatusOK},
+		{"comment", APIPostComments, "/api/v1/posts/" + post + "/comments", `{"content":"Hello"}`, post, models.ScopeWrite, http.StatusCreated},
+		{"post", APIPosts, "/api/v1/posts", `{"title":"T","content":"C","category_ids":[1]}`, "", models.ScopeWrite, http.StatusCreated},
+	}
+	for _, tt := range requests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := apiRequest(tt.handler, store, http.MethodPost, tt.target, readOnly, tt.body, "id", tt.id)
+			require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+			assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `scope="`+tt.scope+`"`)
+
+			rec = apiRequest(tt.handler, store, http.MethodPost, tt.target, writer, tt.body, "id", tt.id)
+			assert.Equal(t, tt.code, rec.Code, rec.Body.String())
+		})
+	}
+
+	// The read-only token changed nothing.
+	likes, _, err := store.Posts.GetLikesAndDislikes(postID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, likes)
+	count, err := store.Comments.CountByPostID(postID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	rec := apiRequest(APIPost, store, http.MethodGet, "/api/v1/posts/"+post, readOnly, "", "id", post)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestAPIUser(t *testing.T) {
+	store := newTestStore(t)
+	member := createTestUser(t, store, "Member")
+	moderator := createTestUser(t, store, "Moderator")
+	assignRole(t, store, moderator, models.RoleModerator)
+	modToken, err := store.Tokens.Create(moderator, "test", []string{models.ScopeRead})
+	require.NoError(t, err)
+	memberToken, err := store.Tokens.Create(member, "test", []string{models.ScopeRead})
+	require.NoError(t, err)
+
+	get := func(token string) map[string]any {
+		rec := apiRequest(APIUser, store, http.MethodGet, "/api/v1/users/"+strconv.Itoa(member), token, "", "id", strconv.Itoa(member))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var user map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &user))
+		assert.Equal(t, "Member", user["username"])
+		assert.NotContains(t, user, "email")
+		return user
+	}
+	for _, token := range []string{"", memberToken} {
+		user := get(token)
+		assert.NotContains(t, user, "is_banned")
+		assert.NotContains(t, user, "email_verified")
+	}
+	user := get(modToken)
+	assert.Equal(t, false, user["is_banned"])
+	assert.Equal(t, true, user["email_verified"])
+}
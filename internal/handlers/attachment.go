@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"forum/internal/media"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// Uploads keeps the files attached to posts.
+	Uploads storage.Bucket = storage.Dir("./uploads")
+	// MaxAttachments bounds the files on one post; 0 turns uploads off.
+	MaxAttachments          = 4
+	MaxAttachmentSize int64 = 5 << 20
+	// AllowedUploadTypes holds the media types files may have, as their
+	// content shows rather than as the browser claims.
+	AllowedUploadTypes = map[string]bool{
+		"image/jpeg":      true,
+		"image/png":       true,
+		"image/gif":       true,
+		"application/pdf": true,
+		"text/plain":      true,
+	}
+	ThumbnailSize = 320
+)
+
+// maxUploadMemory is how much of a multipart form is kept in memory;
+// larger files wait in temporary files.
+const maxUploadMemory = 8 << 20
+
+const maxFilenameLength = 100
+
+// upload is a checked file waiting to be stored with its post.
+type upload struct {
+	attachment models.Attachment
+	data       []byte
+	thumbnail  []byte
+}
+
+// maxMultipartBytes bounds a multipart body, which can hold a post's
+// attachments, or an avatar, plus a megabyte for the text fields.
+func maxMultipartBytes() int64 {
+	return int64(max(MaxAttachments, 1))*MaxAttachmentSize + 1<<20
+}
+
+// parseMultipartForm reads a form that is multipart when files are
+// attached, and does nothing more once the form has been read. On failure
+// it returns the status code and message to show.
+func parseMultipartForm(w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.MultipartForm != nil {
+		return 0, ""
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxMultipartBytes())
+	err := r.ParseMultipartForm(maxUploadMemory)
+	if err == nil || errors.Is(err, http.ErrNotMultipart) {
+		return 0, ""
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, "The attached files are too large."
+	}
+	return http.StatusBadRequest, "The form could not be read."
+}
+
+// readUploads checks the files sent in the attachments field. Images are
+// re-encoded, which strips their metadata, and get a thumbnail. On failure
+// it returns the status code and message to show.
+func readUploads(r *http.Request) ([]*upload, int, string) {
+	if r.MultipartForm == nil {
+		return nil, 0, ""
+	}
+	var uploads []*upload
+	for _, fh := range r.MultipartForm.File["attachments"] {
+		// Browsers send an empty part when no file was chosen.
+		if fh.Filename == "" && fh.Size == 0 {
+			continue
+		}
+		if MaxAttachments == 0 {
+			return nil, http.StatusBadRequest, "Attachments are turned off."
+		}
+		if len(uploads) == MaxAttachments {
+			return nil, http.StatusBadRequest, "A post can have at most " + pluralize(MaxAttachments, "attachment", "attachments") + "."
+		}
+		name := cleanFilename(fh.Filename)
+		if fh.Size > MaxAttachmentSize {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Sprintf("%s is larger than %s.", name, formatFileSize(MaxAttachmentSize))
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			log.Printf("readUploads: Failed to open %q: %v", fh.Filename, err)
+			return nil, http.StatusInternalServerError, "Failed to read the attached files."
+		}
+		data, err := io.ReadAll(io.LimitReader(f, MaxAttachmentSize+1))
+		f.Close()
+		if err != nil {
+			log.Printf("readUploads: Failed to read %q: %v", fh.Filename, err)
+			return nil, http.StatusInternalServerError, "Failed to read the attached files."
+		}
+		if int64(len(data)) > MaxAttachmentSize {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Sprintf("%s is larger than %s.", name, formatFileSize(MaxAttachmentSize))
+		}
+
+		contentType := media.ContentType(data)
+		if !AllowedUploadTypes[contentType] {
+			return nil, http.StatusUnsupportedMediaType, fmt.Sprintf("%s is a file type that cannot be attached.", name)
+		}
+		u := &upload{
+			attachment: models.Attachment{Filename: name, ContentType: contentType},
+			data:       data,
+		}
+		if media.IsImage(contentType) {
+			img, err := media.Process(data, contentType, ThumbnailSize)
+			switch {
+			case errors.Is(err, media.ErrImageTooLarge):
+				return nil, http.StatusRequestEntityTooLarge, fmt.Sprintf("%s has too many pixels.", name)
+			case errors.Is(err, media.ErrNotImage):
+				return nil, http.StatusBadRequest, fmt.Sprintf("%s is not a readable image.", name)
+			case err != nil:
+				log.Printf("readUploads: Failed to process %q: %v", fh.Filename, err)
+				return nil, http.StatusInternalServerError, "Failed to process the attached images."
+			}
+			u.data, u.thumbnail = img.Data, img.Thumbnail
+			u.attachment.Width, u.attachment.Height = img.Width, img.Height
+		}
+		u.attachment.Size = int64(len(u.data))
+		uploads = append(uploads, u)
+	}
+	return uploads, 0, ""
+}
+
+// cleanFilename keeps the last path element of a name a browser sent,
+// without control characters and cut to a sensible length.
+func cleanFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > maxFilenameLength {
+		name = string([]rune(name)[:maxFilenameLength])
+	}
+	if name == "" || name == "." || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// saveUploads stores the files and records them as attachments of the
+// post. Files of an attachment that cannot be recorded are removed again.
+func saveUploads(store *models.Store, postID, userID int, uploads []*upload) error {
+	for _, u := range uploads {
+		name, err := randomKey()
+		if err != nil {
+			return err
+		}
+		a := &u.attachment
+		a.PostID, a.UserID = postID, userID
+		a.StorageKey = "attachments/" + name
+		if err := Uploads.Put(a.StorageKey, u.data, a.ContentType); err != nil {
+			return fmt.Errorf("store %s: %w", a.StorageKey, err)
+		}
+		if u.thumbnail != nil {
+			a.ThumbnailKey = "thumbnails/" + name
+			if err := Uploads.Put(a.ThumbnailKey, u.thumbnail, a.ThumbnailType()); err != nil {
+				Uploads.Delete(a.StorageKey)
+				return fmt.Errorf("store %s: %w", a.ThumbnailKey, err)
+			}
+		}
+		if _, err := store.Attachments.Insert(a); err != nil {
+			Uploads.Delete(a.StorageKey)
+			if a.ThumbnailKey != "" {
+				Uploads.Delete(a.ThumbnailKey)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func randomKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ServeAttachment serves /attachments/{id} and, for images,
+// /attachments/{id}/thumbnail. Attachments of deleted posts are gone.
+func ServeAttachment(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use GET.")
+		return
+	}
+	idStr, thumbnail := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/attachments/"), "/thumbnail")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id < 1 || strconv.Itoa(id) != idStr {
+		RenderError(w, http.StatusNotFound, "The attachment does not exist.")
+		return
+	}
+
+	a, err := store.Attachments.Get(id)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The attachment does not exist.")
+		return
+	} else if err != nil {
+		log.Printf("ServeAttachment: Failed to retrieve attachment %d: %v", id, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the attachment.")
+		return
+	}
+	post, err := store.Posts.Get(a.PostID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("ServeAttachment: Failed to retrieve post %d: %v", a.PostID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the attachment.")
+		return
+	}
+	if err == sql.ErrNoRows || post.Deleted {
+		RenderError(w, http.StatusNotFound, "The attachment was removed with its post.")
+		return
+	}
+
+	key, contentType := a.StorageKey, a.ContentType
+	if thumbnail {
+		if !a.IsImage() {
+			RenderError(w, http.StatusNotFound, "The attachment has no thumbnail.")
+			return
+		}
+		key, contentType = a.ThumbnailKey, a.ThumbnailType()
+	}
+	body, err := Uploads.Get(key)
+	if errors.Is(err, storage.ErrNotFound) {
+		log.Printf("ServeAttachment: %s of attachment %d is missing", key, id)
+		RenderError(w, http.StatusNotFound, "The attachment file is missing.")
+		return
+	} else if err != nil {
+		log.Printf("ServeAttachment: Failed to read %s: %v", key, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the attachment.")
+		return
+	}
+	defer body.Close()
+
+	// Only re-encoded images are shown in the browser; anything else is
+	// downloaded, and nothing served here may run scripts.
+	disposition := "attachment"
+	if a.IsImage() {
+		disposition = "inline"
+	}
+	if header := mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename}); header != "" {
+		disposition = header
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("ServeAttachment: Failed to send %s: %v", key, err)
+	}
+}
+
+// formatFileSize describes a size in bytes as people read it, e.g. "2.4 MB".
+func formatFileSize(size int64) string {
+	switch {
+	case size < 1<<10:
+		return fmt.Sprintf("%d B", size)
+	case size < 1<<20:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	}
+}
+
+// acceptTypes lists the allowed media types for the accept attribute of
+// the file input.
+func acceptTypes() string {
+	types := make([]string, 0, len(AllowedUploadTypes))
+	for t := range AllowedUploadTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return strings.Join(types, ",")
+}
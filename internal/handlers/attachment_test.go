@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func multipartRequest(t *testing.T, files map[string][]byte) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, data := range files {
+		part, err := form.CreateFormFile("attachments", name)
+		require.NoError(t, err)
+		part.Write(data)
+	}
+	require.NoError(t, form.Close())
+	r := httptest.NewRequest(http.MethodPost, "/forum/create", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	_, problem := parseMultipartForm(httptest.NewRecorder(), r)
+	require.Empty(t, problem)
+	return r
+}
+
+func TestReadUploads(t *testing.T) {
+	var img bytes.Buffer
+	require.NoError(t, png.Encode(&img, image.NewGray(image.Rect(0, 0, 30, 20))))
+
+	uploads, _, problem := readUploads(multipartRequest(t, map[string][]byte{`C:\Users\me\shot.png`: img.Bytes()}))
+	require.Empty(t, problem)
+	require.Len(t, uploads, 1)
+	a := uploads[0].attachment
+	assert.Equal(t, "shot.png", a.Filename)
+	assert.Equal(t, "image/png", a.ContentType)
+	assert.Equal(t, [2]int{30, 20}, [2]int{a.Width, a.Height})
+	assert.NotEmpty(t, uploads[0].thumbnail)
+
+	// The name says text, the content says HTML.
+	_, code, _ := readUploads(multipartRequest(t, map[string][]byte{"notes.txt": []byte("<html><script>alert(1)</script>")}))
+	assert.Equal(t, http.StatusUnsupportedMediaType, code)
+
+	_, code, _ = readUploads(multipartRequest(t, map[string][]byte{"fake.png": []byte("\x89PNG\r\n\x1a\nnot really")}))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	many := map[string][]byte{}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		many[name] = []byte("notes")
+	}
+	_, code, _ = readUploads(multipartRequest(t, many))
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestCleanFilename(t *testing.T) {
+	assert.Equal(t, "report.pdf", cleanFilename("../../report.pdf"))
+	assert.Equal(t, "ab.txt", cleanFilename("a\x00\u202eb.txt"))
+	assert.Equal(t, "file", cleanFilename(".."))
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func CategoryPosts(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	slug := strings.Trim(strings.TrimPrefix(r.URL.Path, "/forum/c/"), "/")
+	if slug == "" || !models.ValidSlug(slug) {
+		RenderError(w, http.StatusNotFound, "The category you are looking for does not exist.")
+		return
+	}
+
+	categoryModel := store.Categories
+	category, err := categoryModel.GetBySlug(slug)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The category you are looking for does not exist.")
+		return
+	} else if err != nil {
+		log.Printf("CategoryPosts: Failed to retrieve category %q: %v", slug, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to connect to the database. Please try again later.")
+		return
+	}
+
+	renderPostList(w, r, store, category.ID)
+}
+
+func sidebarCategories(store *models.Store) []*models.Category {
+	categoryModel := store.Categories
+	categories, err := categoryModel.Active()
+	if err != nil {
+		log.Printf("sidebarCategories: Failed to load categories: %v", err)
+		return nil
+	}
+	return categories
+}
+
+type categoryForm struct {
+	name        string
+	slug        string
+	description string
+	sortOrder   int
+}
+
+func parseCategoryForm(r *http.Request) (categoryForm, string) {
+	form := categoryForm{
+		name:        strings.TrimSpace(r.FormValue("name")),
+		slug:        strings.TrimSpace(strings.ToLower(r.FormValue("slug"))),
+		description: strings.TrimSpace(r.FormValue("description")),
+	}
+
+	if IsBlankOrInvisibleText(form.name) {
+		return form, "The category name cannot be empty or contain invisible characters."
+	}
+	if len(form.name) > 40 {
+		return form, "The category name must be at most 40 characters long."
+	}
+	if len(form.description) > 300 {
+		return form, "The category description must be at most 300 characters long."
+	}
+	if form.slug == "" {
+		form.slug = models.Slugify(form.name)
+	}
+	if !models.ValidSlug(form.slug) {
+		return form, "The slug may only contain lowercase letters, digits and single dashes."
+	}
+
+	if sortOrder := strings.TrimSpace(r.FormValue("sort_order")); sortOrder != "" {
+		n, err := strconv.Atoi(sortOrder)
+		if err != nil {
+			return form, "The sort order must be a whole number."
+		}
+		form.sortOrder = n
+	}
+
+	return form, ""
+}
+
+func categoryWriteError(w http.ResponseWriter, handler string, err error) {
+	switch {
+	case errors.Is(err, models.ErrDuplicateSlug):
+		RenderError(w, http.StatusConflict, "Another category already uses this slug. Please choose a different one.")
+	case errors.Is(err, models.ErrInvalidSlug):
+		RenderError(w, http.StatusBadRequest, "The slug may only contain lowercase letters, digits and single dashes.")
+	case errors.Is(err, sql.ErrNoRows):
+		RenderError(w, http.StatusNotFound, "The requested category does not exist.")
+	default:
+		log.Printf("%s: Failed to save category: %v", handler, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to save the category. Please try again.")
+	}
+}
+
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return false
+	}
+	return true
+}
+
+func CreateCategory(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	form, problem := parseCategoryForm(r)
+	if problem != "" {
+		RenderError(w, http.StatusBadRequest, problem)
+		return
+	}
+
+	categoryModel := store.Categories
+	if _, err := categoryModel.Insert(form.name, form.slug, form.description, form.sortOrder); err != nil {
+		categoryWriteError(w, "CreateCategory", err)
+		return
+	}
+
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
+
+func UpdateCategory(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	categoryID, err := strconv.Atoi(r.FormValue("categoryID"))
+	if err != nil || categoryID < 1 {
+		RenderError(w, http.StatusBadRequest, "Invalid category ID.")
+		return
+	}
+
+	form, problem := parseCategoryForm(r)
+	if problem != "" {
+		RenderError(w, http.StatusBadRequest, problem)
+		return
+	}
+
+	categoryModel := store.Categories
+	if err := categoryModel.Update(categoryID, form.name, form.slug, form.description, form.sortOrder); err != nil {
+		categoryWriteError(w, "UpdateCategory", err)
+		return
+	}
+
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
+
+func ToggleCategoryArchive(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	categoryID, err := strconv.Atoi(r.FormValue("categoryID"))
+	if err != nil || categoryID < 1 {
+		RenderError(w, http.StatusBadRequest, "Invalid category ID.")
+		return
+	}
+
+	categoryModel := store.Categories
+	category, err := categoryModel.Get(categoryID)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The requested category does not exist.")
+		return
+	} else if err != nil {
+		log.Printf("ToggleCategoryArchive: Failed to retrieve category %d: %v", categoryID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	if err := categoryModel.SetArchived(categoryID, !category.Archived); err != nil {
+		categoryWriteError(w, "ToggleCategoryArchive", err)
+		return
+	}
+
+	log.Printf("ToggleCategoryArchive: Set archived=%t for category ID %d", !category.Archived, categoryID)
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"forum/ui"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useTemplates loads the built-in templates for the length of a test.
+func useTemplates(t *testing.T) {
+	t.Helper()
+	cache, err := NewTemplateCache(ui.Templates(), false)
+	require.NoError(t, err)
+	saved := Templates
+	Templates = cache
+	t.Cleanup(func() { Templates = saved })
+}
+
+func TestCategoryPosts(t *testing.T) {
+	useTemplates(t)
+	store := newTestStore(t)
+	author := createTestUser(t, store, "Author")
+	rust, err := store.Categories.Insert("Rust", "rust", "Systems programming", 10)
+	require.NoError(t, err)
+	_, err = store.Posts.InsertWithUserIDAndCategories("Borrow checker tips", "Lifetimes", author, []int{rust})
+	require.NoError(t, err)
+	_, err = store.Posts.InsertWithUserIDAndCategories("Game night", "Board games", author, []int{2})
+	require.NoError(t, err)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		CategoryPosts(rec, httptest.NewRequest(http.MethodGet, path, nil), store)
+		return rec
+	}
+
+	rec := get("/forum/c/rust")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Borrow checker tips")
+	assert.NotContains(t, rec.Body.String(), "Game night")
+	assert.Contains(t, rec.Body.String(), `filterByCategory('rust')`)
+
+	assert.Equal(t, http.StatusOK, get("/forum/c/rust/").Code)
+	assert.Equal(t, http.StatusNotFound, get("/forum/c/go").Code)
+	assert.Equal(t, http.StatusNotFound, get("/forum/c/Rust").Code)
+	assert.Equal(t, http.StatusNotFound, get("/forum/c/").Code)
+
+	// An archived category leaves the sidebar, but its posts stay
+	// reachable.
+	require.NoError(t, store.Categories.SetArchived(rust, true))
+	rec = get("/forum/c/rust")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Borrow checker tips")
+	assert.NotContains(t, rec.Body.String(), `filterByCategory('rust')`)
+	assert.Contains(t, rec.Body.String(), `filterByCategory('technology')`)
+
+	active, err := store.Categories.FilterActive([]int{1, rust})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, active, "new posts cannot be filed under an archived category")
+}
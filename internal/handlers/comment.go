@@ -2,18 +2,30 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"forum/internal/models"
+	"log"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
-func AddComment(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	userModel := &models.UserModel{DB: db}
+func AddComment(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	userModel := store.Users
 	userID, err := userModel.GetSessionUserIDFromRequest(r)
 	if err != nil {
 		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to add a comment.")
 		return
 	}
+	if !requireVerifiedEmail(w, store, userID) {
+		return
+	}
 
 	idStr := r.URL.Path[len("/post/") : len(r.URL.Path)-len("/comment")]
 	postID, err := strconv.Atoi(idStr)
@@ -22,17 +34,272 @@ func AddComment(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	content := r.FormValue("content")
-	if IsBlankOrInvisible(content) {
+	postModel := store.Posts
+	post, err := postModel.Get(postID)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The post with the specified ID does not exist. Please check the ID.")
+		return
+	} else if err != nil {
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the post for commenting.")
+		return
+	}
+	if post.Deleted {
+		RenderError(w, http.StatusGone, "This post has been deleted and no longer accepts comments.")
+		return
+	}
+
+	content := r.PostFormValue("content")
+	if IsBlankOrInvisibleText(content) {
 		RenderError(w, http.StatusBadRequest, "Content cannot consist only of invisible characters.")
 		return
 	}
+	if msg, ok := contentTooLong(content); ok {
+		RenderError(w, http.StatusBadRequest, msg)
+		return
+	}
 
-	commentModel := &models.CommentModel{DB: db}
-	if err := commentModel.Insert(postID, userID, content); err != nil {
+	commentModel := store.Comments
+	if _, err := commentModel.Insert(postID, userID, content); err != nil {
 		RenderError(w, http.StatusInternalServerError, "Failed to add the comment due to an internal error.")
 		return
 	}
 
 	http.Redirect(w, r, "/post/"+idStr, http.StatusSeeOther)
 }
+
+func AddReply(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	userModel := store.Users
+	userID, err := userModel.GetSessionUserIDFromRequest(r)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to reply to a comment.")
+		return
+	}
+	if !requireVerifiedEmail(w, store, userID) {
+		return
+	}
+
+	postID, err := postIDFromPath(r.URL.Path, "/reply")
+	if err != nil {
+		RenderError(w, http.StatusBadRequest, "Invalid post ID. The ID must be a valid number.")
+		return
+	}
+
+	parentID, err := strconv.Atoi(r.FormValue("parentID"))
+	if err != nil || parentID < 1 {
+		RenderError(w, http.StatusBadRequest, "Invalid comment ID. The ID must be a valid number.")
+		return
+	}
+
+	postModel := store.Posts
+	post, err := postModel.Get(postID)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The post with the specified ID does not exist. Please check the ID.")
+		return
+	} else if err != nil {
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the post for commenting.")
+		return
+	}
+	if post.Deleted {
+		RenderError(w, http.StatusGone, "This post has been deleted and no longer accepts comments.")
+		return
+	}
+
+	content := r.FormValue("content")
+	if IsBlankOrInvisibleText(content) {
+		RenderError(w, http.StatusBadRequest, "Content cannot consist only of invisible characters.")
+		return
+	}
+	if msg, ok := contentTooLong(content); ok {
+		RenderError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	commentModel := store.Comments
+	replyID, err := commentModel.InsertReply(postID, parentID, userID, content)
+	if errors.Is(err, models.ErrParentNotFound) {
+		RenderError(w, http.StatusNotFound, "The comment you are replying to does not exist on this post.")
+		return
+	} else if errors.Is(err, models.ErrCommentGone) {
+		RenderError(w, http.StatusGone, "The comment you are replying to has been deleted.")
+		return
+	} else if errors.Is(err, models.ErrCommentTooDeep) {
+		RenderError(w, http.StatusBadRequest, "This discussion is nested too deeply. Please reply further up the thread.")
+		return
+	} else if err != nil {
+		log.Printf("AddReply: Failed to add reply to comment %d: %v", parentID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to add the reply due to an internal error.")
+		return
+	}
+
+	commentRedirect(w, r, &models.Comment{ID: replyID, PostID: postID})
+}
+
+// tokenComment hands the recursive comment template the page's CSRF token
+// along with each comment.
+type tokenComment struct {
+	*models.Comment
+	CSRFToken string
+}
+
+// withCSRFToken is the template func that pairs a comment with the token.
+func withCSRFToken(c *models.Comment, token string) tokenComment {
+	return tokenComment{c, token}
+}
+
+// prepareComments sets the per-viewer action flags on a comment tree and
+// hides removed content from everyone but its author and moderators.
+func prepareComments(comments []*models.Comment, viewerID int, canModerate, canReply bool, now time.Time) {
+	for _, c := range comments {
+		isAuthor := viewerID > 0 && c.UserID == viewerID
+		c.CanReply = canReply && !c.Gone() && c.Depth < models.MaxCommentDepth
+		c.CanEdit = isAuthor && c.Editable(now)
+		c.CanDelete = isAuthor && !c.Gone()
+		c.CanRemove = canModerate && !c.Gone()
+		if c.Removed && !isAuthor && !canModerate {
+			c.Content = ""
+			c.ContentHTML = ""
+			c.RemovalReason = ""
+		}
+		prepareComments(c.Replies, viewerID, canModerate, canReply, now)
+	}
+}
+
+func commentIDFromPath(path, suffix string) (int, error) {
+	idStr := strings.TrimSuffix(strings.TrimPrefix(path, "/comment/"), suffix)
+	if !regexp.MustCompile(`^[1-9]\d{0,17}$`).MatchString(idStr) {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.Atoi(idStr)
+}
+
+func loadCommentForAction(w http.ResponseWriter, r *http.Request, store *models.Store, suffix, handler string) (*models.Comment, int, bool) {
+	if !requirePost(w, r) {
+		return nil, 0, false
+	}
+
+	userModel := store.Users
+	userID, err := userModel.GetSessionUserIDFromRequest(r)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to manage comments.")
+		return nil, 0, false
+	}
+
+	commentID, err := commentIDFromPath(r.URL.Path, suffix)
+	if err != nil {
+		RenderError(w, http.StatusBadRequest, "Invalid comment ID. The ID must be a valid number.")
+		return nil, 0, false
+	}
+
+	commentModel := store.Comments
+	comment, err := commentModel.Get(commentID)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The comment with the specified ID does not exist.")
+		return nil, 0, false
+	} else if err != nil {
+		log.Printf("%s: Failed to retrieve comment %d: %v", handler, commentID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the comment.")
+		return nil, 0, false
+	}
+	if comment.Gone() {
+		RenderError(w, http.StatusGone, "This comment has already been deleted or removed.")
+		return nil, 0, false
+	}
+
+	return comment, userID, true
+}
+
+func commentRedirect(w http.ResponseWriter, r *http.Request, comment *models.Comment) {
+	http.Redirect(w, r, "/post/"+strconv.Itoa(comment.PostID)+"#comment-"+strconv.Itoa(comment.ID), http.StatusSeeOther)
+}
+
+func EditComment(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	comment, userID, ok := loadCommentForAction(w, r, store, "/edit", "EditComment")
+	if !ok {
+		return
+	}
+
+	if comment.UserID != userID {
+		RenderError(w, http.StatusForbidden, "You can only edit your own comments.")
+		return
+	}
+
+	content := r.FormValue("content")
+	if IsBlankOrInvisibleText(content) {
+		RenderError(w, http.StatusBadRequest, "Content cannot consist only of invisible characters.")
+		return
+	}
+	if msg, ok := contentTooLong(content); ok {
+		RenderError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	commentModel := store.Comments
+	err := commentModel.Update(comment.ID, content)
+	if errors.Is(err, models.ErrEditWindowClosed) {
+		RenderError(w, http.StatusForbidden, "Comments can only be edited within "+models.CommentEditWindow.String()+" of posting.")
+		return
+	} else if err != nil {
+		log.Printf("EditComment: Failed to update comment %d: %v", comment.ID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to save your changes due to an internal error.")
+		return
+	}
+
+	commentRedirect(w, r, comment)
+}
+
+func DeleteComment(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	comment, userID, ok := loadCommentForAction(w, r, store, "/delete", "DeleteComment")
+	if !ok {
+		return
+	}
+
+	if comment.UserID != userID {
+		RenderError(w, http.StatusForbidden, "You can only delete your own comments.")
+		return
+	}
+
+	commentModel := store.Comments
+	if err := commentModel.Delete(comment.ID); err != nil {
+		log.Printf("DeleteComment: Failed to delete comment %d: %v", comment.ID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to delete the comment due to an internal error.")
+		return
+	}
+
+	commentRedirect(w, r, comment)
+}
+
+func RemoveComment(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	comment, userID, ok := loadCommentForAction(w, r, store, "/remove", "RemoveComment")
+	if !ok {
+		return
+	}
+
+	if !HasPermission(store, userID, models.PermDeleteComments) {
+		RenderError(w, http.StatusForbidden, "You do not have permission to remove comments.")
+		return
+	}
+
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if IsBlankOrInvisibleText(reason) {
+		RenderError(w, http.StatusBadRequest, "Please give a reason for removing this comment.")
+		return
+	}
+	if len(reason) > 300 {
+		RenderError(w, http.StatusBadRequest, "The removal reason must be at most 300 characters long.")
+		return
+	}
+
+	commentModel := store.Comments
+	if err := commentModel.Remove(comment.ID, userID, reason); err != nil {
+		log.Printf("RemoveComment: Failed to remove comment %d: %v", comment.ID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to remove the comment due to an internal error.")
+		return
+	}
+
+	log.Printf("RemoveComment: User ID %d removed comment %d", userID, comment.ID)
+	commentRedirect(w, r, comment)
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"forum/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddComment(t *testing.T) {
+	store := newTestStore(t)
+	userID := createTestUser(t, store, "Alice")
+	postID, err := store.Posts.InsertWithUserIDAndCategories("A post", "Some text", userID, []int{1})
+	require.NoError(t, err)
+	target := "/post/" + strconv.Itoa(postID) + "/comment"
+
+	// A link or an image on another site must not comment for the visitor.
+	r := formRequest(t, store, userID, target+"?content=Spam", nil)
+	r.Method = http.MethodGet
+	rec := httptest.NewRecorder()
+	AddComment(rec, r, store)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	// Nor does content in the query string of a POST count.
+	rec = httptest.NewRecorder()
+	AddComment(rec, formRequest(t, store, userID, target+"?content=Spam", nil), store)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	count, err := store.Comments.CountByPostID(postID)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	rec = httptest.NewRecorder()
+	AddComment(rec, formRequest(t, store, userID, target, url.Values{"content": {"Hello"}}), store)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	count, err = store.Comments.CountByPostID(postID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestContentLength(t *testing.T) {
+	store := newTestStore(t)
+	userID := createTestUser(t, store, "Alice")
+	postID, err := store.Posts.InsertWithUserIDAndCategories("A post", "Some text", userID, []int{1})
+	require.NoError(t, err)
+	target := "/post/" + strconv.Itoa(postID) + "/comment"
+
+	// The limit counts characters, not bytes.
+	longest := strings.Repeat("é", models.MaxContentLength)
+	rec := httptest.NewRecorder()
+	AddComment(rec, formRequest(t, store, userID, target, url.Values{"content": {longest}}), store)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+
+	rec = httptest.NewRecorder()
+	AddComment(rec, formRequest(t, store, userID, target, url.Values{"content": {longest + "é"}}), store)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	count, err := store.Comments.CountByPostID(postID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	rec = httptest.NewRecorder()
+	Preview(rec, formRequest(t, store, userID, "/preview", url.Values{"content": {longest + "é"}}), store)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+func TestAddReplyDepth(t *testing.T) {
+	store := newTestStore(t)
+	userID := createTestUser(t, store, "Alice")
+	postID, err := store.Posts.InsertWithUserIDAndCategories("A post", "Some text", userID, []int{1})
+	require.NoError(t, err)
+	parentID, err := store.Comments.Insert(postID, userID, "Top")
+	require.NoError(t, err)
+	for depth := 1; depth <= models.MaxCommentDepth; depth++ {
+		parentID, err = store.Comments.InsertReply(postID, parentID, userID, "Deeper")
+		require.NoError(t, err)
+	}
+
+	reply := func(parentID int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		form := url.Values{"parentID": {strconv.Itoa(parentID)}, "content": {"One more"}}
+		AddReply(rec, formRequest(t, store, userID, "/post/"+strconv.Itoa(postID)+"/reply", form), store)
+		return rec
+	}
+	assert.Equal(t, http.StatusBadRequest, reply(parentID).Code, "a reply past MaxCommentDepth is refused")
+	assert.Equal(t, http.StatusNotFound, reply(parentID+100).Code)
+
+	comments, err := store.Comments.GetByPostID(postID, 0)
+	require.NoError(t, err)
+	assert.Len(t, comments, models.MaxCommentDepth+1)
+
+	// A reply lands on the reply itself, not on the comment it answers.
+	rec := reply(comments[0].ID)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	comments, err = store.Comments.GetByPostID(postID, 0)
+	require.NoError(t, err)
+	replyID := 0
+	for _, c := range comments {
+		replyID = max(replyID, c.ID)
+	}
+	assert.Equal(t, "/post/"+strconv.Itoa(postID)+"#comment-"+strconv.Itoa(replyID), rec.Header().Get("Location"))
+}
+
+func TestCommentDeleteAndRemove(t *testing.T) {
+	store := newTestStore(t)
+	author := createTestUser(t, store, "Author")
+	other := createTestUser(t, store, "Other")
+	moderator := createTestUser(t, store, "Moderator")
+	roles, err := store.Roles.All()
+	require.NoError(t, err)
+	for _, role := range roles {
+		if role.Name == models.RoleModerator {
+			require.NoError(t, store.Roles.AssignToUser(moderator, role.ID))
+		}
+	}
+	postID, err := store.Posts.InsertWithUserIDAndCategories("A post", "Some text", author, []int{1})
+	require.NoError(t, err)
+	first, err := store.Comments.Insert(postID, author, "Mine")
+	require.NoError(t, err)
+	second, err := store.Comments.Insert(postID, author, "Also mine")
+	require.NoError(t, err)
+
+	act := func(handler func(http.ResponseWriter, *http.Request, *models.Store), userID, commentID int, action string) int {
+		rec := httptest.NewRecorder()
+		target := "/comment/" + strconv.Itoa(commentID) + "/" + action
+		handler(rec, formRequest(t, store, userID, target, url.Values{"reason": {"Off topic"}}), store)
+		return rec.Code
+	}
+
+	// Only the author deletes, and only moderators remove.
+	assert.Equal(t, http.StatusForbidden, act(DeleteComment, moderator, first, "delete"))
+	assert.Equal(t, http.StatusForbidden, act(RemoveComment, other, first, "remove"))
+	assert.Equal(t, http.StatusForbidden, act(RemoveComment, author, first, "remove"))
+
+	assert.Equal(t, http.StatusSeeOther, act(DeleteComment, author, first, "delete"))
+	assert.Equal(t, http.StatusGone, act(RemoveComment, moderator, first, "remove"))
+	assert.Equal(t, http.StatusSeeOther, act(RemoveComment, moderator, second, "remove"))
+	assert.Equal(t, http.StatusGone, act(DeleteComment, author, second, "delete"))
+
+	c, err := store.Comments.Get(first)
+	require.NoError(t, err)
+	assert.True(t, c.Deleted)
+	c, err = store.Comments.Get(second)
+	require.NoError(t, err)
+	assert.True(t, c.Removed)
+	assert.Equal(t, "Off topic", c.RemovalReason)
+}
+
+func TestPrepareComments(t *testing.T) {
+	now := time.Now()
+	thread := func() []*models.Comment {
+		return []*models.Comment{
+			{ID: 1, UserID: 1, Created: now.Add(-time.Minute), Content: "Fresh", Replies: []*models.Comment{
+				{ID: 2, UserID: 2, Depth: 1, Created: now.Add(-time.Minute), Content: "Rude", ContentHTML: "<p>Rude</p>", Removed: true, RemovalReason: "Be nice"},
+			}},
+			{ID: 3, UserID: 1, Created: now.Add(-models.CommentEditWindow - time.Minute), Content: "Old"},
+		}
+	}
+
+	// The author may edit inside the window and delete, but not remove.
+	comments := thread()
+	prepareComments(comments, 1, false, true, now)
+	assert.True(t, comments[0].CanEdit)
+	assert.True(t, comments[0].CanDelete)
+	assert.False(t, comments[0].CanRemove)
+	assert.False(t, comments[1].CanEdit, "the edit window has closed")
+	assert.True(t, comments[1].CanDelete)
+	removed := comments[0].Replies[0]
+	assert.Empty(t, removed.Content)
+	assert.Empty(t, removed.ContentHTML)
+	assert.Empty(t, removed.RemovalReason)
+	assert.False(t, removed.CanReply)
+
+	// The removed comment's author still sees it and why.
+	comments = thread()
+	prepareComments(comments, 2, false, true, now)
+	removed = comments[0].Replies[0]
+	assert.Equal(t, "Rude", removed.Content)
+	assert.Equal(t, "Be nice", removed.RemovalReason)
+	assert.False(t, removed.CanDelete)
+
+	// Moderators see it too and may remove what is left, but edit nothing.
+	comments = thread()
+	prepareComments(comments, 3, true, true, now)
+	assert.Equal(t, "Rude", comments[0].Replies[0].Content)
+	assert.True(t, comments[0].CanRemove)
+	assert.False(t, comments[0].Replies[0].CanRemove)
+	assert.False(t, comments[0].CanEdit)
+	assert.False(t, comments[0].CanDelete)
+}
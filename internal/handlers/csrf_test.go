@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRF(t *testing.T) {
+	var seen string
+	handler := CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = csrfToken(r)
+	}))
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+	post := func(form url.Values, cookies ...*http.Cookie) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/forum/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		return r
+	}
+
+	// An anonymous visitor gets a random token in a cookie and must echo it.
+	rec := serve(httptest.NewRequest(http.MethodGet, "/forum/login", nil))
+	require.Len(t, rec.Result().Cookies(), 1)
+	cookie := rec.Result().Cookies()[0]
+	assert.Equal(t, csrfCookieName, cookie.Name)
+	assert.Equal(t, cookie.Value, seen)
+
+	assert.Equal(t, http.StatusOK, serve(post(url.Values{"csrf_token": {cookie.Value}}, cookie)).Code)
+	assert.Equal(t, http.StatusForbidden, serve(post(url.Values{}, cookie)).Code)
+	assert.Equal(t, http.StatusForbidden, serve(post(url.Values{"csrf_token": {cookie.Value}})).Code, "the token must match the cookie")
+
+	// A signed-in visitor's token follows the session, not the cookie.
+	session := &http.Cookie{Name: "session_id", Value: "session-one"}
+	serve(func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(session)
+		return r
+	}())
+	sessionToken := seen
+	assert.NotEqual(t, cookie.Value, sessionToken)
+	assert.Equal(t, http.StatusForbidden, serve(post(url.Values{"csrf_token": {cookie.Value}}, cookie, session)).Code)
+
+	r := post(url.Values{}, session)
+	r.Header.Set(csrfHeaderName, sessionToken)
+	assert.Equal(t, http.StatusOK, serve(r).Code, "fetch calls send the token in a header")
+
+	other := &http.Cookie{Name: "session_id", Value: "session-two"}
+	assert.Equal(t, http.StatusForbidden, serve(post(url.Values{"csrf_token": {sessionToken}}, other)).Code)
+
+	// API calls with a token in the Authorization header are not checked.
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/posts", nil)
+	r.Header.Set("Authorization", "Bearer fat_abc")
+	assert.Equal(t, http.StatusOK, serve(r).Code)
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/posts", nil)
+	r.AddCookie(session)
+	rec = serve(r)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "application/json")
+}
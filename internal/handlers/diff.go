@@ -0,0 +1,95 @@
+package handlers
+
+import "strings"
+
+type DiffLine struct {
+	Kind string
+	Text string
+}
+
+const (
+	diffSame    = "same"
+	diffAdded   = "added"
+	diffRemoved = "removed"
+)
+
+// maxDiffCells bounds the table diffLines fills for the lines between the
+// common start and end of two texts. Past it the changed lines are shown
+// as removed and added wholesale.
+const maxDiffCells = 1 << 20
+
+// diffLines compares two texts line by line using the longest common
+// subsequence, which is plenty for forum posts of a few hundred lines.
+func diffLines(before, after string) []DiffLine {
+	a := strings.Split(strings.ReplaceAll(before, "\r\n", "\n"), "\n")
+	b := strings.Split(strings.ReplaceAll(after, "\r\n", "\n"), "\n")
+
+	var lines []DiffLine
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		lines = append(lines, DiffLine{diffSame, a[0]})
+		a, b = a[1:], b[1:]
+	}
+	var tail []DiffLine
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		tail = append(tail, DiffLine{diffSame, a[len(a)-1]})
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			lines = append(lines, DiffLine{diffRemoved, line})
+		}
+		for _, line := range b {
+			lines = append(lines, DiffLine{diffAdded, line})
+		}
+	} else {
+		lines = appendLCSDiff(lines, a, b)
+	}
+
+	for k := len(tail) - 1; k >= 0; k-- {
+		lines = append(lines, tail[k])
+	}
+	return lines
+}
+
+// appendLCSDiff appends the line diff of a and b to lines.
+func appendLCSDiff(lines []DiffLine, a, b []string) []DiffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, DiffLine{diffSame, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, DiffLine{diffRemoved, a[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{diffAdded, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, DiffLine{diffRemoved, a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, DiffLine{diffAdded, b[j]})
+	}
+	return lines
+}
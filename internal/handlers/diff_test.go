@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffLines(t *testing.T) {
+	assert.Equal(t, []DiffLine{
+		{diffSame, "a"},
+		{diffRemoved, "b"},
+		{diffAdded, "B"},
+		{diffSame, "c"},
+		{diffAdded, "d"},
+		{diffSame, "e"},
+	}, diffLines("a\nb\nc\ne", "a\r\nB\r\nc\r\nd\r\ne"))
+
+	assert.Equal(t, []DiffLine{{diffSame, "a"}, {diffSame, "b"}}, diffLines("a\nb", "a\nb"))
+
+	// Past maxDiffCells the changed lines are replaced wholesale, while the
+	// common start and end are still shown as they are.
+	n := 2000
+	before := "start\n" + strings.Repeat("x\n", n) + "end"
+	after := "start\n" + strings.Repeat("y\n", n) + "end"
+	lines := diffLines(before, after)
+	assert.Len(t, lines, 2*n+2)
+	assert.Equal(t, DiffLine{diffSame, "start"}, lines[0])
+	assert.Equal(t, DiffLine{diffRemoved, "x"}, lines[1])
+	assert.Equal(t, DiffLine{diffAdded, "y"}, lines[n+1])
+	assert.Equal(t, DiffLine{diffSame, "end"}, lines[2*n+1])
+}
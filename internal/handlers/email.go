@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum/internal/mail"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// Mailer sends the forum's emails.
+	Mailer mail.Sender = &mail.Log{From: "forum@localhost"}
+	// BaseURL is the forum's public address, which links in emails point
+	// to. It is configured rather than taken from the request, so a forged
+	// Host header cannot send reset links elsewhere.
+	BaseURL = "http://localhost:8080"
+)
+
+// emailSentData fills email_sent.html, the page telling the user to look
+// in their inbox.
+type emailSentData struct {
+	Layout
+	Title   string
+	Message string
+}
+
+// sendLink emails to a link to path carrying a token for purpose. body
+// holds a %s where the link goes.
+func sendLink(store *models.Store, purpose models.EmailTokenPurpose, userID int, to, path, subject, body string) error {
+	token, err := store.EmailTokens.New(purpose, userID, to)
+	if err != nil {
+		return err
+	}
+	link := BaseURL + path + "?token=" + url.QueryEscape(token)
+	return Mailer.Send(mail.Message{To: to, Subject: subject, Body: fmt.Sprintf(body, link)})
+}
+
+func sendVerification(store *models.Store, user *models.User) error {
+	return sendLink(store, models.TokenVerifyEmail, user.ID, user.Email, "/forum/verify-email",
+		"Confirm your email address",
+		"Hello "+user.Username+",\n\n"+
+			"Open this link to confirm your email address. You can post once it is confirmed.\n\n%s\n\n"+
+			"The link works for "+lifetimeText(models.VerifyTokenLifetime)+". If you did not sign up, ignore this email.\n")
+}
+
+func lifetimeText(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		return pluralize(int(d/time.Hour), "hour", "hours")
+	}
+	return pluralize(int(d/time.Minute), "minute", "minutes")
+}
+
+func renderEmailSent(w http.ResponseWriter, r *http.Request, store *models.Store, username, title, message string) {
+	data := emailSentData{Layout: newLayout(r, store, username, username != ""), Title: title, Message: message}
+	if err := render(w, "email_sent.html", data); err != nil {
+		log.Printf("renderEmailSent: Failed to render template: %v", err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+// renderLinkError explains why a link from an email was refused.
+func renderLinkError(w http.ResponseWriter, err error, handler string) {
+	switch {
+	case errors.Is(err, models.ErrLinkExpired):
+		RenderError(w, http.StatusGone, "This link has expired. Please ask for a new one.")
+	case errors.Is(err, models.ErrInvalidLink):
+		RenderError(w, http.StatusBadRequest, "This link is invalid or has already been used.")
+	default:
+		log.Printf("%s: Failed to check link: %v", handler, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+// newPasswordProblem describes what is wrong with a new password, or
+// returns "" when it is acceptable.
+func newPasswordProblem(password, confirmPassword string) string {
+	switch {
+	case IsBlankOrInvisible(password):
+		return "The new password cannot contain invisible characters."
+	case strings.Contains(password, " "):
+		return "The new password cannot contain spaces."
+	case len(password) < 8:
+		return "The new password must be at least 8 characters long."
+	case password != confirmPassword:
+		return "The 'New Password' and 'Confirm Password' fields must match."
+	}
+	return ""
+}
+
+// ForgotPassword emails a password reset link. The answer is the same
+// whether or not an account uses the address.
+func ForgotPassword(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method == http.MethodGet {
+		err := render(w, "forgot_password.html", newLayout(r, store, "", false))
+		if err != nil {
+			log.Printf("ForgotPassword: Failed to render template: %v", err)
+			RenderError(w, http.StatusInternalServerError, "The password reset page could not be displayed.")
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method not supported. Use GET or POST.")
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	user, err := store.Users.GetByEmail(email)
+	if err == nil && !user.IsBanned {
+		err = sendLink(store, models.TokenResetPassword, user.ID, user.Email, "/forum/reset-password",
+			"Reset your password",
+			"Hello "+user.Username+",\n\n"+
+				"Someone asked to reset the password of your forum account. Open this link to choose a new one:\n\n%s\n\n"+
+				"The link works for "+lifetimeText(models.ResetTokenLifetime)+". If you did not ask for it, ignore this email; your password stays the same.\n")
+		if err != nil {
+			log.Printf("ForgotPassword: Failed to send reset link to user ID %d: %v", user.ID, err)
+		}
+	} else if err != nil && err != sql.ErrNoRows {
+		log.Printf("ForgotPassword: Failed to look up email: %v", err)
+	}
+
+	renderEmailSent(w, r, store, "", "Check your email",
+		"If an account uses "+email+", we have sent it a link to reset the password.")
+}
+
+type resetPasswordData struct {
+	Layout
+	Token string
+}
+
+// ResetPassword sets a new password from a reset link and logs the
+// account out everywhere.
+func ResetPassword(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method not supported. Use GET or POST.")
+		return
+	}
+
+	tokenValue := r.FormValue("token")
+	token, err := store.EmailTokens.Check(models.TokenResetPassword, tokenValue)
+	if err != nil {
+		renderLinkError(w, err, "ResetPassword")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		data := resetPasswordData{Layout: newLayout(r, store, "", false), Token: tokenValue}
+		if err := render(w, "reset_password.html", data); err != nil {
+			log.Printf("ResetPassword: Failed to render template: %v", err)
+			RenderError(w, http.StatusInternalServerError, "The password reset page could not be displayed.")
+		}
+		return
+	}
+
+	newPassword := r.FormValue("new-password")
+	if problem := newPasswordProblem(newPassword, r.FormValue("confirm-password")); problem != "" {
+		RenderError(w, http.StatusBadRequest, problem)
+		return
+	}
+
+	err = store.Users.SetPassword(token.UserID, newPassword)
+	if err != nil {
+		log.Printf("ResetPassword: Failed to set password for user ID %d: %v", token.UserID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to update the password. Please try again.")
+		return
+	}
+	if err := store.Sessions.RevokeAll(token.UserID); err != nil {
+		log.Printf("ResetPassword: Failed to end sessions for user ID %d: %v", token.UserID, err)
+	}
+	if err := store.LoginFailures.Clear(token.Email); err != nil {
+		log.Printf("ResetPassword: Failed to clear failed logins for user ID %d: %v", token.UserID, err)
+	}
+	// Opening the link proved the address too.
+	if err := store.Users.VerifyEmail(token.UserID, token.Email); err != nil {
+		log.Printf("ResetPassword: Failed to confirm email for user ID %d: %v", token.UserID, err)
+	}
+
+	log.Printf("ResetPassword: User ID %d reset their password", token.UserID)
+	clearSessionCookie(w, r)
+	http.Redirect(w, r, "/forum/login", http.StatusSeeOther)
+}
+
+func VerifyEmail(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use GET.")
+		return
+	}
+
+	token, err := store.EmailTokens.Check(models.TokenVerifyEmail, r.FormValue("token"))
+	if err != nil {
+		renderLinkError(w, err, "VerifyEmail")
+		return
+	}
+
+	err = store.Users.VerifyEmail(token.UserID, token.Email)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusBadRequest, "This link is invalid or has already been used.")
+		return
+	} else if err != nil {
+		log.Printf("VerifyEmail: Failed to confirm email for user ID %d: %v", token.UserID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
+
+func ResendVerification(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+
+	userID, err := GetSessionUserID(r, store)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to confirm your email address.")
+		return
+	}
+	user, err := store.Users.Get(userID)
+	if err != nil {
+		log.Printf("ResendVerification: Failed to fetch user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if user.EmailVerified {
+		http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+		return
+	}
+
+	if err := sendVerification(store, user); err != nil {
+		log.Printf("ResendVerification: Failed to send link to user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to send the email. Please try again later.")
+		return
+	}
+	renderEmailSent(w, r, store, user.Username, "Check your email",
+		"We have sent a new confirmation link to "+user.Email+".")
+}
+
+// ChangeEmail sends a confirmation link to the new address; the change
+// happens once it is opened. The old address is told about the request.
+func ChangeEmail(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+
+	userID, err := GetSessionUserID(r, store)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to change your email address.")
+		return
+	}
+	user, err := store.Users.Get(userID)
+	if err != nil {
+		log.Printf("ChangeEmail: Failed to fetch user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	err = store.Users.CheckPassword(userID, r.FormValue("password"))
+	if errors.Is(err, models.ErrWrongPassword) {
+		RenderError(w, http.StatusUnauthorized, "The password you entered is incorrect.")
+		return
+	} else if err != nil {
+		log.Printf("ChangeEmail: Failed to check password for user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	newEmail := strings.TrimSpace(r.FormValue("new-email"))
+	if !isValidEmail(newEmail) {
+		RenderError(w, http.StatusBadRequest, "Incorrect email. Check the entered data.")
+		return
+	}
+	if newEmail == user.Email {
+		RenderError(w, http.StatusBadRequest, "This is already your email address.")
+		return
+	}
+	if exists, _ := store.Users.EmailExists(newEmail); exists {
+		RenderError(w, http.StatusConflict, "The email you entered is already registered. Please use another email.")
+		return
+	}
+
+	err = sendLink(store, models.TokenChangeEmail, userID, newEmail, "/forum/confirm-email",
+		"Confirm your new email address",
+		"Hello "+user.Username+",\n\n"+
+			"Open this link to use this address for your forum account:\n\n%s\n\n"+
+			"The link works for "+lifetimeText(models.VerifyTokenLifetime)+". If you did not ask for it, ignore this email.\n")
+	if err != nil {
+		log.Printf("ChangeEmail: Failed to send link to user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to send the email. Please try again later.")
+		return
+	}
+	err = Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Your email address is being changed",
+		Body: "Hello " + user.Username + ",\n\n" +
+			"Someone asked to change the email address of your forum account to " + newEmail + ". " +
+			"It changes once the link sent there is opened.\n\n" +
+			"If this was not you, change your password now.\n",
+	})
+	if err != nil {
+		log.Printf("ChangeEmail: Failed to notify the old address of user ID %d: %v", userID, err)
+	}
+
+	renderEmailSent(w, r, store, user.Username, "Confirm your new address",
+		"We have sent a link to "+newEmail+". Your email address changes once you open it.")
+}
+
+func ConfirmEmail(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use GET.")
+		return
+	}
+
+	token, err := store.EmailTokens.Check(models.TokenChangeEmail, r.FormValue("token"))
+	if err != nil {
+		renderLinkError(w, err, "ConfirmEmail")
+		return
+	}
+	if exists, _ := store.Users.EmailExists(token.Email); exists {
+		RenderError(w, http.StatusConflict, "Another account has started using this email address.")
+		return
+	}
+
+	err = store.Users.SetEmail(token.UserID, token.Email)
+	if err != nil {
+		log.Printf("ConfirmEmail: Failed to change email for user ID %d: %v", token.UserID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to change the email address. Please try again.")
+		return
+	}
+
+	log.Printf("ConfirmEmail: User ID %d changed their email address", token.UserID)
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
+
+// emailUnverified reports whether the user still has to confirm their
+// address before posting.
+func emailUnverified(store *models.Store, userID int) (bool, error) {
+	user, err := store.Users.Get(userID)
+	if err != nil {
+		return false, err
+	}
+	return !user.EmailVerified, nil
+}
+
+const unverifiedMessage = "Please confirm your email address before posting. You can have the link sent again from your profile."
+
+// requireVerifiedEmail renders an error page and returns false when the
+// user may not post yet.
+func requireVerifiedEmail(w http.ResponseWriter, store *models.Store, userID int) bool {
+	unverified, err := emailUnverified(store, userID)
+	if err != nil {
+		log.Printf("requireVerifiedEmail: Failed to fetch user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return false
+	}
+	if unverified {
+		RenderError(w, http.StatusForbidden, unverifiedMessage)
+		return false
+	}
+	return true
+}
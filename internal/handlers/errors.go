@@ -1,50 +1,36 @@
 package handlers
 
 import (
-	"database/sql"
 	"forum/internal/models"
-	"html/template"
 	"log"
 	"net/http"
 )
 
 type ErrorData struct {
-	Code        int
-	Status      string
-	Description string
+	// Layout stays empty; it lets error pages share the header.
+	Layout      `json:"-"`
+	Code        int    `json:"code"`
+	Status      string `json:"status"`
+	Description string `json:"description"`
 }
 
 func RenderError(w http.ResponseWriter, code int, description string) {
-	w.WriteHeader(code)
 	data := ErrorData{
 		Code:        code,
 		Status:      http.StatusText(code),
 		Description: description,
 	}
 
-	files := []string{
-		"./ui/templates/error.html",
-		"./ui/templates/header.html",
-		"./ui/templates/footer.html",
-	}
-
-	ts, err := template.ParseFiles(files...)
+	err := Templates.Render(w, code, "error.html", data)
 	if err != nil {
 		log.Printf("Error rendering error page: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-
-	err = ts.Execute(w, data)
-	if err != nil {
-		log.Printf("Error executing error template: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		http.Error(w, description, code)
 	}
 }
 
-func AuthorizeAndHandle(db *sql.DB, handlerFunc func(w http.ResponseWriter, r *http.Request, userID int)) http.HandlerFunc {
+func AuthorizeAndHandle(store *models.Store, handlerFunc func(w http.ResponseWriter, r *http.Request, userID int), permissions ...string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userModel := &models.UserModel{DB: db}
+		userModel := store.Users
 		userID, err := userModel.GetSessionUserIDFromRequest(r)
 		if err != nil {
 			RenderError(w, http.StatusUnauthorized, "Access denied. Please log in to view this page.")
@@ -57,6 +43,45 @@ func AuthorizeAndHandle(db *sql.DB, handlerFunc func(w http.ResponseWriter, r *h
 			return
 		}
 
+		for _, permission := range permissions {
+			if !HasPermission(store, userID, permission) {
+				RenderError(w, http.StatusForbidden, "You do not have permission to view this page.")
+				return
+			}
+		}
+
+		handlerFunc(w, r, userID)
+	}
+}
+
+// RequirePermission is the AuthorizeAndHandle counterpart for privileged
+// actions: it accepts any method and only lets through users whose role
+// grants the permission.
+func RequirePermission(store *models.Store, permission string, handlerFunc func(w http.ResponseWriter, r *http.Request, userID int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userModel := store.Users
+		userID, err := userModel.GetSessionUserIDFromRequest(r)
+		if err != nil {
+			RenderError(w, http.StatusUnauthorized, "Access denied. Please log in to perform this action.")
+			return
+		}
+
+		if !HasPermission(store, userID, permission) {
+			log.Printf("RequirePermission: User ID %d lacks permission %q for %s", userID, permission, r.URL.Path)
+			RenderError(w, http.StatusForbidden, "You do not have permission to perform this action.")
+			return
+		}
+
 		handlerFunc(w, r, userID)
 	}
 }
+
+func HasPermission(store *models.Store, userID int, permission string) bool {
+	roleModel := store.Roles
+	allowed, err := roleModel.HasPermission(userID, permission)
+	if err != nil {
+		log.Printf("HasPermission: Failed to check %q for user ID %d: %v", permission, userID, err)
+		return false
+	}
+	return allowed
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/live"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Heartbeat is how often an idle event stream gets a comment line, so
+	// proxies keep it open and dead clients are noticed.
+	Heartbeat = 30 * time.Second
+	// eventWriteTimeout bounds each write to an event stream; a client that
+	// stops reading is dropped.
+	eventWriteTimeout = 10 * time.Second
+)
+
+// reconnectDelay is how long browsers wait before reopening a stream, in
+// milliseconds.
+const reconnectDelay = 5000
+
+// PostEvents streams /events/post/{id}: new comments and vote counts of
+// one post.
+func PostEvents(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id < 1 || strconv.Itoa(id) != idStr {
+		RenderError(w, http.StatusNotFound, "The post does not exist.")
+		return
+	}
+	post, err := store.Posts.Get(id)
+	if err == sql.ErrNoRows || err == nil && post.Deleted {
+		RenderError(w, http.StatusNotFound, "The post does not exist.")
+		return
+	} else if err != nil {
+		log.Printf("PostEvents: Failed to retrieve post %d: %v", id, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the post.")
+		return
+	}
+	streamEvents(w, r, store, models.PostTopic(id))
+}
+
+// FeedEvents streams /events/feed, and /events/c/{slug} for a category:
+// new posts and the counts of the posts listed.
+func FeedEvents(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	slug := r.PathValue("slug")
+	if slug == "" {
+		streamEvents(w, r, store, models.FeedTopic)
+		return
+	}
+	if !models.ValidSlug(slug) {
+		RenderError(w, http.StatusNotFound, "The category does not exist.")
+		return
+	}
+	category, err := store.Categories.GetBySlug(slug)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The category does not exist.")
+		return
+	} else if err != nil {
+		log.Printf("FeedEvents: Failed to retrieve category %q: %v", slug, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the category.")
+		return
+	}
+	streamEvents(w, r, store, models.CategoryTopic(category.ID))
+}
+
+// streamEvents sends the events of topic as Server-Sent Events until the
+// client goes away or the server shuts down.
+func streamEvents(w http.ResponseWriter, r *http.Request, store *models.Store, topic string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use GET.")
+		return
+	}
+	sub, err := store.Events.Subscribe(topic)
+	switch {
+	case errors.Is(err, live.ErrTooManySubscribers), errors.Is(err, live.ErrClosed):
+		w.Header().Set("Retry-After", strconv.Itoa(reconnectDelay/1000))
+		RenderError(w, http.StatusServiceUnavailable, "Live updates are not available right now.")
+		return
+	case err != nil:
+		log.Printf("streamEvents: Failed to subscribe to %s: %v", topic, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to start live updates.")
+		return
+	}
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keeps nginx and similar proxies from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(message string) bool {
+		rc.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		if _, err := w.Write([]byte(message)); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	if !send("retry: " + strconv.Itoa(reconnectDelay) + "\n\n") {
+		return
+	}
+
+	heartbeat := time.NewTicker(Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				// The hub dropped us or is shutting down; the browser
+				// reconnects if the server is still there.
+				return
+			}
+			if !send(formatEvent(event)) {
+				return
+			}
+		case <-heartbeat.C:
+			if !send(": ping\n\n") {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// formatEvent writes an event in the text/event-stream format. Data is
+// JSON, which never holds a raw newline, but each line is prefixed anyway.
+func formatEvent(event live.Event) string {
+	var b strings.Builder
+	b.WriteString("event: " + event.Name + "\n")
+	for _, line := range strings.Split(string(event.Data), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"bufio"
+	"forum/internal/live"
+	"forum/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamEvents(t *testing.T) {
+	store := &models.Store{Events: live.NewHub()}
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		streamEvents(w, r, store, models.PostTopic(1))
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	lines := bufio.NewReader(resp.Body)
+	readMessage := func() string {
+		var message strings.Builder
+		for {
+			line, err := lines.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\n" {
+				return message.String()
+			}
+			message.WriteString(line)
+		}
+	}
+	assert.Equal(t, "retry: 5000\n", readMessage())
+
+	store.Events.Publish(models.EventVotes, models.VotesEvent{PostID: 1, Likes: 2}, models.PostTopic(1))
+	assert.Equal(t, "event: votes\ndata: {\"post_id\":1,\"likes\":2,\"dislikes\":0}\n", readMessage())
+
+	// Shutting the hub down ends the stream.
+	store.Events.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream did not end when the hub closed")
+	}
+	assert.Zero(t, store.Events.Subscribers())
+}
+
+func TestStreamEventsLimit(t *testing.T) {
+	store := &models.Store{Events: live.NewHub()}
+	store.Events.SetLimit(1)
+	sub, err := store.Events.Subscribe(models.FeedTopic)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	rec := httptest.NewRecorder()
+	streamEvents(rec, httptest.NewRequest(http.MethodGet, "/events/feed", nil), store, models.FeedTopic)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "5", rec.Header().Get("Retry-After"))
+}
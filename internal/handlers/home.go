@@ -1,31 +1,80 @@
 package handlers
 
 import (
-	"database/sql"
+	"errors"
 	"forum/internal/models"
-	"html/template"
+	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 )
 
 type TemplateData struct {
-	Posts            []*models.Post
-	Username         string
-	LoggedIn         bool
-	ActiveCategoryID int
-	FilterMyPosts    bool
-	FilterLikedPosts bool
-	FilterComments   bool
+	Layout
+	Posts        []*models.Post
+	NewerPageURL string
+	OlderPageURL string
+	// EventsURL streams changes to the posts listed. NewPostsNotice
+	// offers to show posts published since the page was loaded.
+	EventsURL      string
+	NewPostsNotice bool
 }
 
-func Home(w http.ResponseWriter, r *http.Request, postModel *models.PostModel, commentModel *models.CommentModel, db *sql.DB) {
-	userModel := &models.UserModel{DB: db}
+func Home(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	categoryID := 0
+	if categoryIDStr := r.URL.Query().Get("categoryID"); categoryIDStr != "" {
+		var err error
+		categoryID, err = strconv.Atoi(categoryIDStr)
+		if err != nil {
+			RenderError(w, http.StatusBadRequest, "The category ID provided is invalid. Please check your input.")
+			return
+		}
+	}
+	renderPostList(w, r, store, categoryID)
+}
+
+// pageRequestFromQuery reads the shared paging parameters: after/before
+// cursors and an optional limit.
+func pageRequestFromQuery(r *http.Request) (models.PageRequest, bool) {
+	query := r.URL.Query()
+	page := models.PageRequest{
+		After:  query.Get("after"),
+		Before: query.Get("before"),
+	}
+	if page.After != "" && page.Before != "" {
+		return page, false
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > models.MaxPageSize {
+			return page, false
+		}
+		page.Limit = n
+	}
+	return page, true
+}
+
+// pageURL links to the same listing with the paging cursor replaced.
+func pageURL(r *http.Request, key, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	query := r.URL.Query()
+	query.Del("after")
+	query.Del("before")
+	query.Set(key, cursor)
+	return r.URL.Path + "?" + query.Encode()
+}
+
+func renderPostList(w http.ResponseWriter, r *http.Request, store *models.Store, categoryID int) {
+	postModel := store.Posts
+	userModel := store.Users
 	userID, err := userModel.GetSessionUserIDFromRequest(r)
 	loggedIn := err == nil
 
 	var username string
 	if loggedIn {
-		err = db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username)
+		username, err = store.Posts.GetUsername(userID)
 		if err != nil {
 			RenderError(w, http.StatusInternalServerError, "Failed to connect to the database. Please try again later.")
 			return
@@ -41,7 +90,13 @@ func Home(w http.ResponseWriter, r *http.Request, postModel *models.PostModel, c
 	filterLikedPosts := r.URL.Query().Get("likedPosts") == "1" && loggedIn
 	filterComments := r.URL.Query().Get("commentedPosts") == "1" && loggedIn
 
-	var posts []*models.Post
+	pageReq, ok := pageRequestFromQuery(r)
+	if !ok {
+		RenderError(w, http.StatusBadRequest, "The paging parameters are invalid. Please check your input.")
+		return
+	}
+
+	var page *models.PostPage
 	activeCategoryID := 0
 
 	defer func() {
@@ -50,89 +105,52 @@ func Home(w http.ResponseWriter, r *http.Request, postModel *models.PostModel, c
 		}
 	}()
 
-	if filterComments {
-		posts, err = postModel.GetPostsWithUserComments(userID)
-		if err != nil {
-			RenderError(w, http.StatusInternalServerError, "Failed to connect to the database. Please try again later.")
-			return
-		}
-	} else if filterLikedPosts {
-		posts, err = postModel.GetLikedPostsByUserID(userID)
-		if err != nil {
-			RenderError(w, http.StatusInternalServerError, "Failed to connect to the database. Please try again later.")
-			return
-		}
-	} else if filterMyPosts {
-		posts, err = postModel.GetByUserID(userID)
-		if err != nil {
-			RenderError(w, http.StatusInternalServerError, "Failed to connect to the database. Please try again later.")
-			return
-		}
-	} else {
-		categoryIDStr := r.URL.Query().Get("categoryID")
-		if categoryIDStr != "" {
-			categoryID, convErr := strconv.Atoi(categoryIDStr)
-			if convErr == nil {
-				posts, err = postModel.GetByCategoryID(categoryID, userID)
-				activeCategoryID = categoryID
-				if err != nil {
-					RenderError(w, http.StatusInternalServerError, "Failed to connect to the database. Please try again later.")
-					return
-				}
-			} else {
-				RenderError(w, http.StatusBadRequest, "The category ID provided is invalid. Please check your input.")
-				return
-			}
-		} else {
-			posts, err = postModel.Latest(userID)
-			if err != nil {
-				RenderError(w, http.StatusInternalServerError, "Failed to connect to the database. Please try again later.")
-				return
-			}
-		}
-	}
-
-	for _, post := range posts {
-		if loggedIn {
-			post.UserCommented, err = commentModel.HasUserCommented(post.ID, userID)
-			if err != nil {
-				RenderError(w, http.StatusInternalServerError, "Failed to connect to the database. Please try again later.")
-				return
-			}
-		}
-
-		post.CommentCount, err = commentModel.CountByPostID(post.ID)
-		if err != nil {
-			RenderError(w, http.StatusInternalServerError, "Failed to connect to the database. Please try again later.")
-			return
-		}
-	}
-
-	files := []string{
-		"./ui/templates/home.html",
-		"./ui/templates/header.html",
-		"./ui/templates/footer.html",
-		"./ui/templates/left_sidebar.html",
-		"./ui/templates/right_sidebar.html",
+	switch {
+	case filterComments:
+		page, err = postModel.GetPostsWithUserComments(userID, pageReq)
+	case filterLikedPosts:
+		page, err = postModel.GetLikedPostsByUserID(userID, pageReq)
+	case filterMyPosts:
+		page, err = postModel.GetByUserID(userID, userID, pageReq)
+	case categoryID != 0:
+		page, err = postModel.GetByCategoryID(categoryID, userID, pageReq)
+		activeCategoryID = categoryID
+	default:
+		page, err = postModel.Latest(userID, pageReq)
 	}
-
-	ts, err := template.New("home.html").ParseFiles(files...)
-	if err != nil {
-		RenderError(w, http.StatusInternalServerError, "The server failed to load the required template files. Please try again later.")
+	if errors.Is(err, models.ErrInvalidCursor) {
+		RenderError(w, http.StatusBadRequest, "The page cursor is invalid. Please start again from the first page.")
+		return
+	} else if err != nil {
+		RenderError(w, http.StatusInternalServerError, "Failed to connect to the database. Please try again later.")
 		return
 	}
+	posts := page.Posts
 
 	data := TemplateData{
-		Posts:            posts,
-		Username:         username,
-		LoggedIn:         loggedIn,
-		ActiveCategoryID: activeCategoryID,
-		FilterMyPosts:    filterMyPosts,
-		FilterLikedPosts: filterLikedPosts,
-		FilterComments:   filterComments,
+		Layout:       newLayout(r, store, username, loggedIn),
+		Posts:        posts,
+		NewerPageURL: pageURL(r, "before", page.PrevCursor),
+		OlderPageURL: pageURL(r, "after", page.NextCursor),
+	}
+
+	data.ActiveCategoryID = activeCategoryID
+	data.FilterMyPosts = filterMyPosts
+	data.FilterLikedPosts = filterLikedPosts
+	data.FilterComments = filterComments
+	data.EventsURL = "/events/feed"
+	if categoryID != 0 {
+		data.EventsURL = ""
+		for _, category := range data.Categories {
+			if category.ID == categoryID {
+				data.EventsURL = "/events/c/" + url.PathEscape(category.Slug)
+			}
+		}
 	}
+	data.NewPostsNotice = !filterMyPosts && !filterLikedPosts && !filterComments && pageReq.After == "" && pageReq.Before == ""
 
-	if err := ts.Execute(w, data); err != nil {
-		http.Error(w, "Failed to render the page. The server encountered a technical issue.", http.StatusInternalServerError)
+	if err := render(w, "home.html", data); err != nil {
+		log.Printf("Home: Failed to render template: %v", err)
+		RenderError(w, http.StatusInternalServerError, "Failed to render the page. The server encountered a technical issue.")
 	}
 }
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"database/sql"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// notificationsShown is how many notifications the notifications page
+// lists, newest first.
+const notificationsShown = 50
+
+type notificationsData struct {
+	Layout
+	Notifications []*models.Notification
+}
+
+// unreadNotifications counts the signed-in member's unread notifications
+// for the header. A failure only hides the count.
+func unreadNotifications(r *http.Request, store *models.Store, loggedIn bool) int {
+	if !loggedIn {
+		return 0
+	}
+	userID, err := store.Users.GetSessionUserIDFromRequest(r)
+	if err != nil {
+		return 0
+	}
+	count, err := store.Notifications.UnreadCount(userID)
+	if err != nil {
+		log.Printf("unreadNotifications: Failed to count notifications of user ID %d: %v", userID, err)
+		return 0
+	}
+	return count
+}
+
+// Notifications serves /forum/notifications, the signed-in member's
+// latest notifications.
+func Notifications(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use GET.")
+		return
+	}
+	userID, err := GetSessionUserID(r, store)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to see your notifications.")
+		return
+	}
+	username, err := store.Posts.GetUsername(userID)
+	if err != nil {
+		log.Printf("Notifications: Failed to retrieve username for user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve user data. Please try again later.")
+		return
+	}
+
+	notifications, err := store.Notifications.ByUserID(userID, notificationsShown)
+	if err != nil {
+		log.Printf("Notifications: Failed to retrieve notifications of user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve your notifications.")
+		return
+	}
+
+	data := notificationsData{
+		Layout:        newLayout(r, store, username, true),
+		Notifications: notifications,
+	}
+	if err := render(w, "notifications.html", data); err != nil {
+		log.Printf("Notifications: Failed to render template: %v", err)
+		RenderError(w, http.StatusInternalServerError, "Failed to render your notifications.")
+	}
+}
+
+// MarkNotificationRead marks one notification read. With open set it then
+// goes to what the notification is about.
+func MarkNotificationRead(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+	userID, err := GetSessionUserID(r, store)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to manage your notifications.")
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil || id < 1 {
+		RenderError(w, http.StatusBadRequest, "Invalid notification ID.")
+		return
+	}
+
+	err = store.Notifications.MarkRead(userID, id)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The notification does not exist.")
+		return
+	} else if err != nil {
+		log.Printf("MarkNotificationRead: Failed to mark notification %d of user ID %d read: %v", id, userID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to mark the notification read. Please try again.")
+		return
+	}
+
+	if r.FormValue("open") != "" {
+		notifications, err := store.Notifications.ByUserID(userID, notificationsShown)
+		if err != nil {
+			log.Printf("MarkNotificationRead: Failed to retrieve notifications of user ID %d: %v", userID, err)
+		}
+		for _, n := range notifications {
+			if n.ID == id {
+				http.Redirect(w, r, n.URL(), http.StatusSeeOther)
+				return
+			}
+		}
+	}
+	http.Redirect(w, r, "/forum/notifications", http.StatusSeeOther)
+}
+
+func MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+	userID, err := GetSessionUserID(r, store)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to manage your notifications.")
+		return
+	}
+
+	if err := store.Notifications.MarkAllRead(userID); err != nil {
+		log.Printf("MarkAllNotificationsRead: Failed to mark notifications of user ID %d read: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to mark your notifications read. Please try again.")
+		return
+	}
+	http.Redirect(w, r, "/forum/notifications", http.StatusSeeOther)
+}
+
+// UpdateNotificationPreferences turns off every type of notification whose
+// box was left unchecked on the profile page.
+func UpdateNotificationPreferences(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+	userID, err := GetSessionUserID(r, store)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to change your notification settings.")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		RenderError(w, http.StatusBadRequest, "The form could not be read.")
+		return
+	}
+
+	enabled := map[models.NotificationType]bool{}
+	for _, t := range r.PostForm["notify"] {
+		if !models.IsKnownNotificationType(models.NotificationType(t)) {
+			RenderError(w, http.StatusBadRequest, "Unknown notification type.")
+			return
+		}
+		enabled[models.NotificationType(t)] = true
+	}
+	var muted []models.NotificationType
+	for _, kind := range models.NotificationKinds {
+		if !enabled[kind.Type] {
+			muted = append(muted, kind.Type)
+		}
+	}
+
+	if err := store.Notifications.SetMuted(userID, muted); err != nil {
+		log.Printf("UpdateNotificationPreferences: Failed to save settings of user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to save your notification settings. Please try again.")
+		return
+	}
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
@@ -2,16 +2,18 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
+	"forum/internal/markdown"
 	"forum/internal/models"
-	"html/template"
 	"log"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
-func PostView(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func PostView(w http.ResponseWriter, r *http.Request, store *models.Store) {
 	idStr := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/post/"))
 
 	if idStr == "" {
@@ -49,7 +51,7 @@ func PostView(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	postModel := &models.PostModel{DB: db}
+	postModel := store.Posts
 	post, err := postModel.Get(id)
 	if err == sql.ErrNoRows {
 		RenderError(w, http.StatusNotFound, "The post with the specified ID does not exist. Please check the ID.")
@@ -60,18 +62,18 @@ func PostView(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	err = db.QueryRow("SELECT username FROM users WHERE id = ?", post.UserID).Scan(&post.Username)
+	post.Username, err = store.Posts.GetUsername(post.UserID)
 	if err != nil {
 		log.Printf("PostView: Failed to retrieve the author's username: %v", err)
 		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the post author's username.")
 		return
 	}
 
-	userModel := &models.UserModel{DB: db}
+	userModel := store.Users
 	userID, _ := userModel.GetSessionUserIDFromRequest(r)
 	var loggedInUsername string
 	if userID > 0 {
-		err := db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&loggedInUsername)
+		loggedInUsername, err = store.Posts.GetUsername(userID)
 		if err != nil {
 			log.Printf("PostView: Failed to retrieve logged-in user's username: %v", err)
 			RenderError(w, http.StatusInternalServerError, "Failed to retrieve user data. Please try again later.")
@@ -79,13 +81,15 @@ func PostView(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		}
 	}
 
-	commentModel := &models.CommentModel{DB: db}
-	comments, err := commentModel.GetByPostID(id, userID)
+	commentModel := store.Comments
+	comments, err := commentModel.GetThreadByPostID(id, userID)
 	if err != nil {
 		log.Printf("PostView: Failed to retrieve comments: %v", err)
 		RenderError(w, http.StatusInternalServerError, "Failed to retrieve comments for the post.")
 		return
 	}
+	canModerate := userID > 0 && HasPermission(store, userID, models.PermDeleteComments)
+	prepareComments(comments, userID, canModerate, userID > 0 && !post.Deleted, time.Now())
 
 	post.Likes, post.Dislikes, err = postModel.GetLikesAndDislikes(post.ID)
 	if err != nil {
@@ -97,124 +101,82 @@ func PostView(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		post.UserVote, _ = postModel.GetUserVote(post.ID, userID)
 	}
 
-	rows, err := db.Query(`
-        SELECT c.name 
-        FROM categories c
-        JOIN post_categories pc ON c.id = pc.category_id
-        WHERE pc.post_id = ?`, post.ID)
+	post.Categories, err = postModel.GetCategories(post.ID)
 	if err != nil {
 		log.Printf("PostView: Failed to retrieve categories: %v", err)
 		RenderError(w, http.StatusInternalServerError, "Failed to retrieve categories for the post.")
 		return
 	}
-	defer rows.Close()
-
-	var categories []string
-	for rows.Next() {
-		var category string
-		if err := rows.Scan(&category); err != nil {
-			log.Printf("PostView: Failed to scan category: %v", err)
-			RenderError(w, http.StatusInternalServerError, "Failed to retrieve categories for the post.")
+	if !post.Deleted {
+		post.Attachments, err = store.Attachments.ByPostID(post.ID)
+		if err != nil {
+			log.Printf("PostView: Failed to retrieve attachments: %v", err)
+			RenderError(w, http.StatusInternalServerError, "Failed to retrieve attachments for the post.")
 			return
 		}
-		categories = append(categories, category)
 	}
-	post.Categories = categories
+
+	canEdit := !post.Deleted && userID > 0 && userID == post.UserID
+	canDelete := !post.Deleted && userID > 0 && (userID == post.UserID || HasPermission(store, userID, models.PermDeletePosts))
 
 	data := struct {
-		Post             *models.Post
-		Comments         []*models.Comment
-		LoggedIn         bool
-		Username         string
-		ActiveCategoryID int
-		FilterMyPosts    bool
-		FilterLikedPosts bool
-		FilterComments   bool
+		Layout
+		Post      *models.Post
+		Comments  []*models.Comment
+		CanEdit   bool
+		CanDelete bool
 	}{
-		Post:             post,
-		Comments:         comments,
-		LoggedIn:         userID > 0,
-		Username:         loggedInUsername,
-		ActiveCategoryID: 0,
-		FilterMyPosts:    false,
-		FilterLikedPosts: false,
-		FilterComments:   false,
+		Layout:    newLayout(r, store, loggedInUsername, userID > 0),
+		Post:      post,
+		Comments:  comments,
+		CanEdit:   canEdit,
+		CanDelete: canDelete,
 	}
 
-	files := []string{
-		"./ui/templates/view.html",
-		"./ui/templates/header.html",
-		"./ui/templates/footer.html",
-		"./ui/templates/left_sidebar.html",
-		"./ui/templates/right_sidebar.html",
-	}
-
-	ts, err := template.New("view.html").ParseFiles(files...)
-	if err != nil {
-		log.Printf("PostView: Failed to load templates: %v", err)
-		RenderError(w, http.StatusInternalServerError, "Failed to load templates for the post view.")
-		return
-	}
-
-	if err := ts.Execute(w, data); err != nil {
+	if err := render(w, "view.html", data); err != nil {
 		log.Printf("PostView: Failed to render template: %v", err)
 		RenderError(w, http.StatusInternalServerError, "Failed to render the post view.")
 	}
 }
 
-func PostCreateForm(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	userModel := &models.UserModel{DB: db}
+func PostCreateForm(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	userModel := store.Users
 	userID, err := userModel.GetSessionUserIDFromRequest(r)
 	if err != nil {
 		RenderError(w, http.StatusUnauthorized, "Only authorized users can create posts. Please log in.")
 		return
 	}
+	if !requireVerifiedEmail(w, store, userID) {
+		return
+	}
 
 	var username string
-	err = db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username)
+	username, err = store.Posts.GetUsername(userID)
 	if err != nil {
 		RenderError(w, http.StatusInternalServerError, "Failed to retrieve username for the session.")
 		return
 	}
 
 	data := struct {
-		LoggedIn         bool
-		Username         string
-		FilterMyPosts    bool
-		FilterLikedPosts bool
-		FilterComments   bool
-		ActiveCategoryID int
+		Layout
+		MaxAttachments int
+		MaxFileSize    string
+		AcceptTypes    string
 	}{
-		LoggedIn:         true,
-		Username:         username,
-		FilterMyPosts:    false,
-		FilterLikedPosts: false,
-		FilterComments:   false,
-		ActiveCategoryID: 0,
-	}
-
-	files := []string{
-		"./ui/templates/create.html",
-		"./ui/templates/header.html",
-		"./ui/templates/footer.html",
-		"./ui/templates/left_sidebar.html",
-		"./ui/templates/right_sidebar.html",
+		Layout:         newLayout(r, store, username, true),
+		MaxAttachments: MaxAttachments,
+		MaxFileSize:    formatFileSize(MaxAttachmentSize),
+		AcceptTypes:    acceptTypes(),
 	}
-
-	ts, err := template.ParseFiles(files...)
-	if err != nil {
-		RenderError(w, http.StatusInternalServerError, "Failed to load templates for the post creation form.")
-		return
-	}
-
-	err = ts.Execute(w, data)
+	err = render(w, "create.html", data)
 	if err != nil {
+		log.Printf("PostCreateForm: Failed to render template: %v", err)
 		RenderError(w, http.StatusInternalServerError, "Failed to render the post creation form.")
 	}
 }
 
-func PostCreate(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	userModel := &models.UserModel{DB: db}
+func PostCreate(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	userModel := store.Users
 	userID, err := userModel.GetSessionUserIDFromRequest(r)
 	if err != nil {
 		RenderError(w, http.StatusUnauthorized, "Only authorized users can create posts. Please log in.")
@@ -226,13 +188,16 @@ func PostCreate(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
 		return
 	}
+	if !requireVerifiedEmail(w, store, userID) {
+		return
+	}
+	if code, problem := parseMultipartForm(w, r); problem != "" {
+		RenderError(w, code, problem)
+		return
+	}
 
 	title := r.FormValue("title")
 	content := r.FormValue("content")
-	if IsBlankOrInvisible(title) || IsBlankOrInvisible(content) {
-		RenderError(w, http.StatusBadRequest, "Title and content cannot contain invisible characters.")
-		return
-	}
 
 	var categoryIDs []int
 	for _, categoryIDStr := range r.Form["categories"] {
@@ -242,12 +207,353 @@ func PostCreate(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		}
 	}
 
-	postModel := &models.PostModel{DB: db}
+	categoryIDs, code, problem := checkNewPost(store, title, content, categoryIDs)
+	if problem != "" {
+		RenderError(w, code, problem)
+		return
+	}
+	uploads, code, problem := readUploads(r)
+	if problem != "" {
+		RenderError(w, code, problem)
+		return
+	}
+
+	postModel := store.Posts
 	postID, err := postModel.InsertWithUserIDAndCategories(title, content, userID, categoryIDs)
 	if err != nil {
 		RenderError(w, http.StatusInternalServerError, "Failed to create the post due to an internal error.")
 		return
 	}
+	if err := saveUploads(store, postID, userID, uploads); err != nil {
+		log.Printf("PostCreate: Failed to save attachments of post %d: %v", postID, err)
+		RenderError(w, http.StatusInternalServerError, "The post was created, but its attachments could not be saved.")
+		return
+	}
+
+	http.Redirect(w, r, "/post/"+strconv.Itoa(postID), http.StatusSeeOther)
+}
+
+// checkNewPost validates a new post and narrows categoryIDs to active
+// categories. On failure it returns the status code and message to show.
+func checkNewPost(store *models.Store, title, content string, categoryIDs []int) ([]int, int, string) {
+	if IsBlankOrInvisibleText(title) || IsBlankOrInvisibleText(content) {
+		return nil, http.StatusBadRequest, "Title and content cannot contain invisible characters."
+	}
+	if msg, ok := contentTooLong(content); ok {
+		return nil, http.StatusBadRequest, msg
+	}
+
+	categoryModel := store.Categories
+	categoryIDs, err := categoryModel.FilterActive(categoryIDs)
+	if err != nil {
+		log.Printf("checkNewPost: Failed to verify categories: %v", err)
+		return nil, http.StatusInternalServerError, "Failed to verify the selected categories."
+	}
+	if len(categoryIDs) == 0 {
+		return nil, http.StatusBadRequest, "Please select at least one existing category for your post."
+	}
+	return categoryIDs, 0, ""
+}
+
+func postIDFromPath(path, suffix string) (int, error) {
+	idStr := strings.TrimSuffix(strings.TrimPrefix(path, "/post/"), suffix)
+	if !regexp.MustCompile(`^[1-9]\d{0,17}$`).MatchString(idStr) {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.Atoi(idStr)
+}
+
+func PostEdit(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	userModel := store.Users
+	userID, err := userModel.GetSessionUserIDFromRequest(r)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Only authorized users can edit posts. Please log in.")
+		return
+	}
+
+	postID, err := postIDFromPath(r.URL.Path, "/edit")
+	if err != nil {
+		RenderError(w, http.StatusBadRequest, "Invalid post ID. The ID must be a valid number.")
+		return
+	}
+
+	postModel := store.Posts
+	post, err := postModel.Get(postID)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The post with the specified ID does not exist. Please check the ID.")
+		return
+	} else if err != nil {
+		log.Printf("PostEdit: Failed to retrieve post %d: %v", postID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the post.")
+		return
+	}
+
+	if post.UserID != userID {
+		RenderError(w, http.StatusForbidden, "You can only edit your own posts.")
+		return
+	}
+	if post.Deleted {
+		RenderError(w, http.StatusGone, "This post has been deleted and can no longer be edited.")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var username string
+		username, err = store.Posts.GetUsername(userID)
+		if err != nil {
+			RenderError(w, http.StatusInternalServerError, "Failed to retrieve username for the session.")
+			return
+		}
+
+		data := struct {
+			Layout
+			Post *models.Post
+		}{
+			Layout: newLayout(r, store, username, true),
+			Post:   post,
+		}
+
+		if err := render(w, "edit.html", data); err != nil {
+			log.Printf("PostEdit: Failed to render template: %v", err)
+			RenderError(w, http.StatusInternalServerError, "Failed to render the post edit form.")
+		}
+	case http.MethodPost:
+		title := r.FormValue("title")
+		content := r.FormValue("content")
+		if IsBlankOrInvisibleText(title) || IsBlankOrInvisibleText(content) {
+			RenderError(w, http.StatusBadRequest, "Title and content cannot contain invisible characters.")
+			return
+		}
+		if msg, ok := contentTooLong(content); ok {
+			RenderError(w, http.StatusBadRequest, msg)
+			return
+		}
+
+		if title == post.Title && content == post.Content {
+			http.Redirect(w, r, "/post/"+strconv.Itoa(postID), http.StatusSeeOther)
+			return
+		}
+
+		if err := postModel.Update(postID, userID, title, content); err != nil {
+			log.Printf("PostEdit: Failed to update post %d: %v", postID, err)
+			RenderError(w, http.StatusInternalServerError, "Failed to save your changes due to an internal error.")
+			return
+		}
+
+		http.Redirect(w, r, "/post/"+strconv.Itoa(postID), http.StatusSeeOther)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method not allowed. Use GET or POST.")
+	}
+}
+
+func PostDelete(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	userModel := store.Users
+	userID, err := userModel.GetSessionUserIDFromRequest(r)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Only authorized users can delete posts. Please log in.")
+		return
+	}
+
+	postID, err := postIDFromPath(r.URL.Path, "/delete")
+	if err != nil {
+		RenderError(w, http.StatusBadRequest, "Invalid post ID. The ID must be a valid number.")
+		return
+	}
+
+	postModel := store.Posts
+	post, err := postModel.Get(postID)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The post with the specified ID does not exist. Please check the ID.")
+		return
+	} else if err != nil {
+		log.Printf("PostDelete: Failed to retrieve post %d: %v", postID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the post.")
+		return
+	}
+
+	if post.UserID != userID && !HasPermission(store, userID, models.PermDeletePosts) {
+		RenderError(w, http.StatusForbidden, "You can only delete your own posts.")
+		return
+	}
+	if post.Deleted {
+		RenderError(w, http.StatusGone, "This post has already been deleted.")
+		return
+	}
+
+	if err := postModel.Delete(postID, userID); err != nil {
+		log.Printf("PostDelete: Failed to delete post %d: %v", postID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to delete the post due to an internal error.")
+		return
+	}
+
+	log.Printf("PostDelete: User ID %d deleted post %d", userID, postID)
+	http.Redirect(w, r, "/post/"+strconv.Itoa(postID), http.StatusSeeOther)
+}
+
+type revisionView struct {
+	*models.PostRevision
+	TitleChanged bool
+	NextTitle    string
+	Diff         []DiffLine
+}
+
+func PostRevisions(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		RenderError(w, http.StatusMethodNotAllowed, "This resource does not support the HTTP method used.")
+		return
+	}
+
+	postID, err := postIDFromPath(r.URL.Path, "/revisions")
+	if err != nil {
+		RenderError(w, http.StatusBadRequest, "Invalid post ID. The ID must be a valid number.")
+		return
+	}
+
+	postModel := store.Posts
+	post, err := postModel.Get(postID)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The post with the specified ID does not exist. Please check the ID.")
+		return
+	} else if err != nil {
+		log.Printf("PostRevisions: Failed to retrieve post %d: %v", postID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the post.")
+		return
+	}
+	if post.Deleted {
+		RenderError(w, http.StatusGone, "This post has been deleted.")
+		return
+	}
+
+	revisionModel := store.Revisions
+	revisions, err := revisionModel.ByPostID(postID)
+	if err != nil {
+		log.Printf("PostRevisions: Failed to retrieve revisions for post %d: %v", postID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the post history.")
+		return
+	}
+
+	// Revisions are newest first; each one is diffed against the version
+	// that replaced it, starting from the live post.
+	views := make([]revisionView, 0, len(revisions))
+	nextTitle, nextContent := post.Title, post.Content
+	for _, rev := range revisions {
+		views = append(views, revisionView{
+			PostRevision: rev,
+			TitleChanged: rev.Title != nextTitle,
+			NextTitle:    nextTitle,
+			Diff:         diffLines(rev.Content, nextContent),
+		})
+		nextTitle, nextContent = rev.Title, rev.Content
+	}
+
+	userModel := store.Users
+	userID, _ := userModel.GetSessionUserIDFromRequest(r)
+	var username string
+	if userID > 0 {
+		if username, err = store.Posts.GetUsername(userID); err != nil {
+			log.Printf("PostRevisions: Failed to retrieve logged-in user's username: %v", err)
+			RenderError(w, http.StatusInternalServerError, "Failed to retrieve user data. Please try again later.")
+			return
+		}
+	}
+
+	data := struct {
+		Layout
+		Post       *models.Post
+		Revisions  []revisionView
+		CanRestore bool
+	}{
+		Layout:     newLayout(r, store, username, userID > 0),
+		Post:       post,
+		Revisions:  views,
+		CanRestore: userID > 0 && HasPermission(store, userID, models.PermEditPosts),
+	}
+
+	if err := render(w, "revisions.html", data); err != nil {
+		log.Printf("PostRevisions: Failed to render template: %v", err)
+		RenderError(w, http.StatusInternalServerError, "Failed to render the post history.")
+	}
+}
+
+func PostRestoreRevision(w http.ResponseWriter, r *http.Request, store *models.Store, userID int) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	postID, err := postIDFromPath(r.URL.Path, "/restore")
+	if err != nil {
+		RenderError(w, http.StatusBadRequest, "Invalid post ID. The ID must be a valid number.")
+		return
+	}
 
+	revisionID, err := strconv.Atoi(r.FormValue("revisionID"))
+	if err != nil || revisionID < 1 {
+		RenderError(w, http.StatusBadRequest, "Invalid revision ID.")
+		return
+	}
+
+	revisionModel := store.Revisions
+	revision, err := revisionModel.Get(postID, revisionID)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The requested revision does not exist for this post.")
+		return
+	} else if err != nil {
+		log.Printf("PostRestoreRevision: Failed to retrieve revision %d: %v", revisionID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the revision.")
+		return
+	}
+
+	postModel := store.Posts
+	err = postModel.Update(postID, userID, revision.Title, revision.Content)
+	if errors.Is(err, models.ErrPostDeleted) {
+		RenderError(w, http.StatusGone, "This post has been deleted and can no longer be edited.")
+		return
+	} else if err != nil {
+		log.Printf("PostRestoreRevision: Failed to restore revision %d of post %d: %v", revisionID, postID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to restore the revision due to an internal error.")
+		return
+	}
+
+	log.Printf("PostRestoreRevision: User ID %d restored revision %d of post %d", userID, revisionID, postID)
 	http.Redirect(w, r, "/post/"+strconv.Itoa(postID), http.StatusSeeOther)
 }
+
+// maxPreviewBytes bounds the text a preview request may send.
+const maxPreviewBytes = 1 << 20
+
+// Preview renders the Markdown in the content field the way a post or
+// comment would show it, for the preview next to the editors. It answers
+// with an HTML fragment.
+func Preview(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+	if _, err := GetSessionUserID(r, store); err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to preview.")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPreviewBytes)
+	if err := r.ParseForm(); err != nil {
+		RenderError(w, http.StatusRequestEntityTooLarge, "The text is too long to preview.")
+		return
+	}
+	content := r.PostForm.Get("content")
+	if msg, ok := contentTooLong(content); ok {
+		RenderError(w, http.StatusRequestEntityTooLarge, msg)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(markdown.Render(content)))
+}
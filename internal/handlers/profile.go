@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/media"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxBioLength = 500
+	// avatarSize is the side of stored avatars and identicons, in pixels.
+	avatarSize = 256
+	// recentActivityLimit is how many posts and comments a public profile
+	// lists.
+	recentActivityLimit = 5
+)
+
+// profileURL is the address of a member's public profile.
+func profileURL(username string) string {
+	return "/forum/u/" + url.PathEscape(username)
+}
+
+func avatarURL(userID int) string {
+	return "/avatars/" + strconv.Itoa(userID)
+}
+
+type publicProfileData struct {
+	Layout
+	Member   *models.User
+	Stats    *models.UserStats
+	Posts    []*models.Post
+	Comments []*models.Comment
+	IsOwn    bool
+}
+
+// PublicProfile serves /forum/u/{username}, which anyone may see.
+func PublicProfile(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	escaped := strings.TrimPrefix(r.URL.EscapedPath(), "/forum/u/")
+	username, err := url.PathUnescape(escaped)
+	if err != nil || username == "" || strings.Contains(escaped, "/") {
+		RenderError(w, http.StatusNotFound, "The member you are looking for does not exist.")
+		return
+	}
+
+	userModel := store.Users
+	member, err := userModel.GetByUsername(username)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The member you are looking for does not exist.")
+		return
+	} else if err != nil {
+		log.Printf("PublicProfile: Failed to retrieve user %q: %v", username, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the member's profile.")
+		return
+	}
+	member.Email = ""
+
+	viewerID, _ := userModel.GetSessionUserIDFromRequest(r)
+	var viewerName string
+	if viewerID > 0 {
+		viewerName, err = store.Posts.GetUsername(viewerID)
+		if err != nil {
+			log.Printf("PublicProfile: Failed to retrieve logged-in user's username: %v", err)
+			RenderError(w, http.StatusInternalServerError, "Failed to retrieve user data. Please try again later.")
+			return
+		}
+	}
+
+	stats, err := userModel.Stats(member.ID)
+	if err != nil {
+		log.Printf("PublicProfile: Failed to count activity for user ID %d: %v", member.ID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the member's profile.")
+		return
+	}
+	page, err := store.Posts.GetByUserID(member.ID, viewerID, models.PageRequest{Limit: recentActivityLimit})
+	if err != nil {
+		log.Printf("PublicProfile: Failed to retrieve posts of user ID %d: %v", member.ID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the member's posts.")
+		return
+	}
+	comments, err := store.Comments.RecentByUserID(member.ID, recentActivityLimit)
+	if err != nil {
+		log.Printf("PublicProfile: Failed to retrieve comments of user ID %d: %v", member.ID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the member's comments.")
+		return
+	}
+
+	data := publicProfileData{
+		Layout:   newLayout(r, store, viewerName, viewerID > 0),
+		Member:   member,
+		Stats:    stats,
+		Posts:    page.Posts,
+		Comments: comments,
+		IsOwn:    viewerID == member.ID,
+	}
+	if err := render(w, "user.html", data); err != nil {
+		log.Printf("PublicProfile: Failed to render template: %v", err)
+		RenderError(w, http.StatusInternalServerError, "Failed to render the member's profile.")
+	}
+}
+
+// ServeAvatar serves /avatars/{id}: the member's uploaded avatar, or an
+// identicon drawn from their ID.
+func ServeAvatar(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/avatars/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id < 1 || strconv.Itoa(id) != idStr {
+		RenderError(w, http.StatusNotFound, "The avatar does not exist.")
+		return
+	}
+	user, err := store.Users.Get(id)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The avatar does not exist.")
+		return
+	} else if err != nil {
+		log.Printf("ServeAvatar: Failed to retrieve user %d: %v", id, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to retrieve the avatar.")
+		return
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if user.AvatarKey != "" {
+		body, err := Uploads.Get(user.AvatarKey)
+		if err == nil {
+			defer body.Close()
+			w.Header().Set("Content-Type", "image/png")
+			if _, err := io.Copy(w, body); err != nil {
+				log.Printf("ServeAvatar: Failed to send %s: %v", user.AvatarKey, err)
+			}
+			return
+		}
+		// A missing file falls back to the identicon.
+		log.Printf("ServeAvatar: Failed to read %s: %v", user.AvatarKey, err)
+	}
+
+	data, err := media.Identicon(strconv.Itoa(user.ID), avatarSize)
+	if err != nil {
+		log.Printf("ServeAvatar: Failed to draw identicon for user %d: %v", id, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to draw the avatar.")
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(data)
+}
+
+// UpdateAvatar replaces the signed-in member's avatar with an uploaded
+// image, or with remove set goes back to the identicon.
+func UpdateAvatar(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+	userID, err := GetSessionUserID(r, store)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to change your avatar.")
+		return
+	}
+	if code, problem := parseMultipartForm(w, r); problem != "" {
+		RenderError(w, code, problem)
+		return
+	}
+	user, err := store.Users.Get(userID)
+	if err != nil {
+		log.Printf("UpdateAvatar: Failed to retrieve user %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to update the avatar. Please try again.")
+		return
+	}
+
+	var key string
+	if r.FormValue("remove") == "" {
+		file, _, err := r.FormFile("avatar")
+		if err != nil {
+			RenderError(w, http.StatusBadRequest, "Choose an image to upload.")
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(file, MaxAttachmentSize+1))
+		file.Close()
+		if err != nil {
+			log.Printf("UpdateAvatar: Failed to read the upload: %v", err)
+			RenderError(w, http.StatusInternalServerError, "Failed to read the image.")
+			return
+		}
+		if int64(len(data)) > MaxAttachmentSize {
+			RenderError(w, http.StatusRequestEntityTooLarge, "The image is larger than "+formatFileSize(MaxAttachmentSize)+".")
+			return
+		}
+		contentType := media.ContentType(data)
+		if !media.IsImage(contentType) {
+			RenderError(w, http.StatusUnsupportedMediaType, "Avatars must be JPEG, PNG or GIF images.")
+			return
+		}
+		avatar, err := media.Avatar(data, contentType, avatarSize)
+		switch {
+		case errors.Is(err, media.ErrImageTooLarge):
+			RenderError(w, http.StatusRequestEntityTooLarge, "The image has too many pixels.")
+			return
+		case errors.Is(err, media.ErrNotImage):
+			RenderError(w, http.StatusBadRequest, "The image could not be read.")
+			return
+		case err != nil:
+			log.Printf("UpdateAvatar: Failed to process the image: %v", err)
+			RenderError(w, http.StatusInternalServerError, "Failed to process the image.")
+			return
+		}
+
+		name, err := randomKey()
+		if err == nil {
+			key = "avatars/" + name
+			err = Uploads.Put(key, avatar, "image/png")
+		}
+		if err != nil {
+			log.Printf("UpdateAvatar: Failed to store the avatar of user %d: %v", userID, err)
+			RenderError(w, http.StatusInternalServerError, "Failed to save the avatar. Please try again.")
+			return
+		}
+	}
+
+	if err := store.Users.SetAvatar(userID, key); err != nil {
+		log.Printf("UpdateAvatar: Failed to record the avatar of user %d: %v", userID, err)
+		if key != "" {
+			Uploads.Delete(key)
+		}
+		RenderError(w, http.StatusInternalServerError, "Failed to update the avatar. Please try again.")
+		return
+	}
+	if user.AvatarKey != "" {
+		if err := Uploads.Delete(user.AvatarKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("UpdateAvatar: Failed to delete the old avatar %s: %v", user.AvatarKey, err)
+		}
+	}
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
+
+// UpdateBio sets the signed-in member's bio; an empty one removes it.
+func UpdateBio(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+	userID, err := GetSessionUserID(r, store)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to change your bio.")
+		return
+	}
+
+	bio := strings.TrimSpace(strings.ReplaceAll(r.FormValue("bio"), "\r\n", "\n"))
+	if utf8.RuneCountInString(bio) > maxBioLength {
+		RenderError(w, http.StatusBadRequest, "The bio can be at most "+strconv.Itoa(maxBioLength)+" characters long.")
+		return
+	}
+	if bio != "" && IsBlankOrInvisibleText(bio) {
+		RenderError(w, http.StatusBadRequest, "The bio cannot contain invisible characters.")
+		return
+	}
+
+	if err := store.Users.SetBio(userID, bio); err != nil {
+		log.Printf("UpdateBio: Failed to update the bio of user %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to update the bio. Please try again.")
+		return
+	}
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func roleWriteError(w http.ResponseWriter, handler string, err error) {
+	switch {
+	case errors.Is(err, models.ErrDuplicateRole):
+		RenderError(w, http.StatusConflict, "A role with this name already exists.")
+	case errors.Is(err, models.ErrInvalidRole):
+		RenderError(w, http.StatusBadRequest, "Role names may only contain lowercase letters, digits and single dashes.")
+	case errors.Is(err, models.ErrUnknownPermission):
+		RenderError(w, http.StatusBadRequest, "One of the selected permissions does not exist.")
+	case errors.Is(err, models.ErrSystemRole):
+		RenderError(w, http.StatusForbidden, "Built-in roles cannot be changed this way.")
+	case errors.Is(err, sql.ErrNoRows):
+		RenderError(w, http.StatusNotFound, "The requested role or user does not exist.")
+	default:
+		log.Printf("%s: Failed to save role: %v", handler, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to save the role. Please try again.")
+	}
+}
+
+func CreateRole(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !requirePost(w, r) {
+		return
+	}
+	r.ParseForm()
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	description := strings.TrimSpace(r.FormValue("description"))
+	if len(description) > 200 {
+		RenderError(w, http.StatusBadRequest, "The role description must be at most 200 characters long.")
+		return
+	}
+
+	roleModel := store.Roles
+	if _, err := roleModel.Insert(name, description, r.Form["permissions"]); err != nil {
+		roleWriteError(w, "CreateRole", err)
+		return
+	}
+
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
+
+func UpdateRolePermissions(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !requirePost(w, r) {
+		return
+	}
+	r.ParseForm()
+
+	roleID, err := strconv.Atoi(r.FormValue("roleID"))
+	if err != nil || roleID < 1 {
+		RenderError(w, http.StatusBadRequest, "Invalid role ID.")
+		return
+	}
+
+	roleModel := store.Roles
+	if err := roleModel.SetPermissions(roleID, r.Form["permissions"]); err != nil {
+		roleWriteError(w, "UpdateRolePermissions", err)
+		return
+	}
+
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
+
+func DeleteRole(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	roleID, err := strconv.Atoi(r.FormValue("roleID"))
+	if err != nil || roleID < 1 {
+		RenderError(w, http.StatusBadRequest, "Invalid role ID.")
+		return
+	}
+
+	roleModel := store.Roles
+	if err := roleModel.Delete(roleID); err != nil {
+		roleWriteError(w, "DeleteRole", err)
+		return
+	}
+
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
+
+func AssignUserRole(w http.ResponseWriter, r *http.Request, store *models.Store, actorID int) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.FormValue("userID"))
+	if err != nil || userID < 1 {
+		RenderError(w, http.StatusBadRequest, "Invalid user ID.")
+		return
+	}
+
+	roleID, err := strconv.Atoi(r.FormValue("roleID"))
+	if err != nil || roleID < 1 {
+		RenderError(w, http.StatusBadRequest, "Invalid role ID.")
+		return
+	}
+
+	if userID == actorID {
+		RenderError(w, http.StatusForbidden, "You cannot change your own role.")
+		return
+	}
+
+	roleModel := store.Roles
+	if err := roleModel.AssignToUser(userID, roleID); err != nil {
+		roleWriteError(w, "AssignUserRole", err)
+		return
+	}
+
+	log.Printf("AssignUserRole: User ID %d assigned role ID %d to user ID %d", actorID, roleID, userID)
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
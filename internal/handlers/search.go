@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"forum/internal/models"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const searchPageSize = 20
+
+type searchResultView struct {
+	*models.SearchResult
+	Highlighted template.HTML
+}
+
+// highlight escapes a snippet and turns the model's match markers into
+// <mark> tags.
+func highlight(snippet string) template.HTML {
+	escaped := template.HTMLEscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, models.HighlightStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, models.HighlightEnd, "</mark>")
+	return template.HTML(escaped)
+}
+
+func searchPageURL(query, sort string, page int) string {
+	values := url.Values{}
+	values.Set("q", query)
+	values.Set("sort", sort)
+	values.Set("page", strconv.Itoa(page))
+	return "/forum/search?" + values.Encode()
+}
+
+func Search(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodGet {
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use GET.")
+		return
+	}
+
+	userModel := store.Users
+	userID, err := userModel.GetSessionUserIDFromRequest(r)
+	loggedIn := err == nil
+
+	var username string
+	if loggedIn {
+		if username, err = store.Posts.GetUsername(userID); err != nil {
+			log.Printf("Search: Failed to retrieve logged-in user's username: %v", err)
+			RenderError(w, http.StatusInternalServerError, "Failed to retrieve user data. Please try again later.")
+			return
+		}
+	}
+
+	rawQuery := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len(rawQuery) > 200 {
+		RenderError(w, http.StatusBadRequest, "The search query must be at most 200 characters long.")
+		return
+	}
+
+	sort := r.URL.Query().Get("sort")
+	if sort != models.SortRecent {
+		sort = models.SortRelevance
+	}
+
+	page := 1
+	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+		page, err = strconv.Atoi(pageStr)
+		if err != nil || page < 1 || page > 1000 {
+			RenderError(w, http.StatusBadRequest, "The page number is invalid.")
+			return
+		}
+	}
+
+	var results []searchResultView
+	var hasMore bool
+	var problem string
+	if rawQuery != "" {
+		query, err := models.ParseSearchQuery(rawQuery)
+		switch {
+		case errors.Is(err, models.ErrEmptySearch):
+			problem = "Enter a word, a \"quoted phrase\" or a filter to search for."
+		case errors.Is(err, models.ErrInvalidSearch):
+			problem = "Dates must be written as from:YYYY-MM-DD and to:YYYY-MM-DD, with from before to."
+		default:
+			query.Sort = sort
+			searchModel := store.Search
+			found, more, err := searchModel.Search(query, searchPageSize, (page-1)*searchPageSize)
+			if err != nil {
+				log.Printf("Search: Failed to search for %q: %v", rawQuery, err)
+				RenderError(w, http.StatusInternalServerError, "Search failed due to an internal error. Please try again later.")
+				return
+			}
+			hasMore = more
+			for _, result := range found {
+				results = append(results, searchResultView{result, highlight(result.Snippet)})
+			}
+		}
+	}
+
+	data := struct {
+		Layout
+		Query        string
+		Sort         string
+		Results      []searchResultView
+		Problem      string
+		Searched     bool
+		NewerPageURL string
+		OlderPageURL string
+		RelevanceURL string
+		RecentURL    string
+	}{
+		Layout:       newLayout(r, store, username, loggedIn),
+		Query:        rawQuery,
+		Sort:         sort,
+		Results:      results,
+		Problem:      problem,
+		Searched:     rawQuery != "",
+		RelevanceURL: searchPageURL(rawQuery, models.SortRelevance, 1),
+		RecentURL:    searchPageURL(rawQuery, models.SortRecent, 1),
+	}
+	if page > 1 {
+		data.NewerPageURL = searchPageURL(rawQuery, sort, page-1)
+	}
+	if hasMore {
+		data.OlderPageURL = searchPageURL(rawQuery, sort, page+1)
+	}
+
+	if err := render(w, "search.html", data); err != nil {
+		log.Printf("Search: Failed to render template: %v", err)
+		RenderError(w, http.StatusInternalServerError, "Failed to render the search page.")
+	}
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/models"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sessionCookieName = "session_id"
+
+// SecureCookies marks the session cookie Secure even on plain HTTP, for
+// forums that sit behind a proxy ending TLS.
+var SecureCookies bool
+
+// sessionView is a session as the profile page lists it.
+type sessionView struct {
+	*models.Session
+	Device  string
+	Current bool
+}
+
+// setSessionCookie hands the browser a session token. Without "remember
+// me" the cookie lasts until the browser closes; the server-side expiry
+// still applies.
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, remember bool) {
+	cookie := &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   SecureCookies || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if remember {
+		cookie.Expires = time.Now().Add(models.RememberLifetime)
+	}
+	http.SetCookie(w, cookie)
+}
+
+func clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   SecureCookies || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// startSession signs the user in. A login always starts a new session, so
+// a token planted before it is worthless afterwards.
+func startSession(w http.ResponseWriter, r *http.Request, store *models.Store, userID int, remember bool) error {
+	if token := sessionToken(r); token != "" {
+		if err := store.Sessions.Delete(token); err != nil {
+			log.Printf("startSession: Failed to delete the previous session: %v", err)
+		}
+	}
+
+	token, err := store.Sessions.Create(userID, remember, clientIP(r), r.UserAgent())
+	if err != nil {
+		return err
+	}
+	setSessionCookie(w, r, token, remember)
+	return nil
+}
+
+func sessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Sessions keeps a signed-in visitor's session alive: each request slides
+// its expiry and records the address and browser it came from. A cookie
+// whose session is gone is removed.
+func Sessions(store *models.Store, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := sessionToken(r)
+		if token == "" || strings.HasPrefix(r.URL.Path, "/static/") || usesAPIToken(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, touched, err := store.Sessions.Touch(token, clientIP(r), r.UserAgent())
+		switch {
+		case errors.Is(err, models.ErrNoSession):
+			clearSessionCookie(w, r)
+		case err != nil:
+			log.Printf("Sessions: Failed to refresh session: %v", err)
+		case touched && session.Remember:
+			setSessionCookie(w, r, token, true)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deviceLabel names the browser and system a user agent string belongs
+// to, such as "Firefox on Linux".
+func deviceLabel(userAgent string) string {
+	var browser string
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	case strings.HasPrefix(userAgent, "curl/"):
+		browser = "curl"
+	default:
+		browser = "Unknown browser"
+	}
+
+	switch {
+	case strings.Contains(userAgent, "Android"):
+		return browser + " on Android"
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		return browser + " on iOS"
+	case strings.Contains(userAgent, "Windows"):
+		return browser + " on Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		return browser + " on macOS"
+	case strings.Contains(userAgent, "Linux"):
+		return browser + " on Linux"
+	}
+	return browser
+}
+
+// userSessions lists the user's sessions for the profile page, marking
+// the one the request came with.
+func userSessions(r *http.Request, store *models.Store, userID int) ([]sessionView, error) {
+	sessions, err := store.Sessions.ByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	current, _ := store.Sessions.Get(sessionToken(r))
+
+	views := make([]sessionView, len(sessions))
+	for i, s := range sessions {
+		views[i] = sessionView{
+			Session: s,
+			Device:  deviceLabel(s.UserAgent),
+			Current: current != nil && current.ID == s.ID,
+		}
+	}
+	return views, nil
+}
+
+func RevokeSession(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+
+	current, err := store.Sessions.Get(sessionToken(r))
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to manage your sessions.")
+		return
+	}
+
+	sessionID, err := strconv.Atoi(r.FormValue("sessionID"))
+	if err != nil || sessionID < 1 {
+		RenderError(w, http.StatusBadRequest, "Invalid session ID.")
+		return
+	}
+
+	err = store.Sessions.Revoke(current.UserID, sessionID)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The session does not exist or has already ended.")
+		return
+	} else if err != nil {
+		log.Printf("RevokeSession: Failed to revoke session %d for user ID %d: %v", sessionID, current.UserID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to end the session. Please try again.")
+		return
+	}
+
+	if sessionID == current.ID {
+		clearSessionCookie(w, r)
+		http.Redirect(w, r, "/forum/login", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
+
+// RevokeAllSessions logs the user out everywhere, including here.
+func RevokeAllSessions(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+
+	userID, err := GetSessionUserID(r, store)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to manage your sessions.")
+		return
+	}
+
+	err = store.Sessions.RevokeAll(userID)
+	if err != nil {
+		log.Printf("RevokeAllSessions: Failed to revoke sessions for user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to log out your sessions. Please try again.")
+		return
+	}
+
+	log.Printf("RevokeAllSessions: User ID %d logged out everywhere", userID)
+	clearSessionCookie(w, r)
+	http.Redirect(w, r, "/forum/login", http.StatusSeeOther)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceLabel(t *testing.T) {
+	assert.Equal(t, "Firefox on Linux", deviceLabel("Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"))
+	assert.Equal(t, "Chrome on Android", deviceLabel("Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Mobile Safari/537.36"))
+	assert.Equal(t, "Safari on iOS", deviceLabel("Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1"))
+	assert.Equal(t, "Edge on Windows", deviceLabel("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36 Edg/126.0"))
+	assert.Equal(t, "curl", deviceLabel("curl/8.5.0"))
+	assert.Equal(t, "Unknown browser", deviceLabel(""))
+}
+
+func TestSessionCookie(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	rec := httptest.NewRecorder()
+	setSessionCookie(rec, r, "abc", false)
+	require.Len(t, rec.Result().Cookies(), 1)
+	cookie := rec.Result().Cookies()[0]
+	assert.True(t, cookie.HttpOnly)
+	assert.False(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	assert.True(t, cookie.Expires.IsZero(), "without remember me the cookie ends with the browser")
+
+	SecureCookies = true
+	defer func() { SecureCookies = false }()
+	rec = httptest.NewRecorder()
+	setSessionCookie(rec, r, "abc", true)
+	cookie = rec.Result().Cookies()[0]
+	assert.True(t, cookie.Secure)
+	assert.False(t, cookie.Expires.IsZero())
+}
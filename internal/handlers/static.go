@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Assets serves /static/. main sets it before serving.
+var Assets *StaticFiles
+
+// StaticFiles serves a file system from memory. Every file is reachable
+// under its own name and under a name carrying a hash of its content,
+// e.g. css/styles.1a2b3c4d5e.css. Pages link to the hashed name through
+// the asset template func, so browsers may keep it for good; a changed
+// file gets a new name.
+type StaticFiles struct {
+	byName   map[string]*staticFile
+	byHashed map[string]*staticFile
+}
+
+type staticFile struct {
+	name    string
+	hashed  string
+	etag    string
+	content []byte
+}
+
+// NewStaticFiles reads every file in fsys. Files are not read again, so
+// changes need a restart.
+func NewStaticFiles(fsys fs.FS) (*StaticFiles, error) {
+	s := &StaticFiles{byName: map[string]*staticFile{}, byHashed: map[string]*staticFile{}}
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:])[:10]
+		ext := path.Ext(name)
+		f := &staticFile{
+			name:    name,
+			hashed:  strings.TrimSuffix(name, ext) + "." + hash + ext,
+			etag:    `"` + hash + `"`,
+			content: content,
+		}
+		s.byName[f.name] = f
+		s.byHashed[f.hashed] = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// URL is the path pages should use for a static file.
+func (s *StaticFiles) URL(name string) string {
+	if s != nil {
+		if f, ok := s.byName[name]; ok {
+			return "/static/" + f.hashed
+		}
+	}
+	return "/static/" + name
+}
+
+// ServeHTTP expects the /static/ prefix to be stripped already.
+func (s *StaticFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		RenderError(w, http.StatusMethodNotAllowed, "Static files can only be fetched with GET.")
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	f, ok := s.byHashed[name]
+	if ok {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else if f, ok = s.byName[name]; ok {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		RenderError(w, http.StatusNotFound, "The requested file does not exist.")
+		return
+	}
+
+	w.Header().Set("ETag", f.etag)
+	http.ServeContent(w, r, f.name, time.Time{}, bytes.NewReader(f.content))
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticFiles(t *testing.T) {
+	assets, err := NewStaticFiles(fstest.MapFS{"css/site.css": {Data: []byte("body { color: red; }")}})
+	require.NoError(t, err)
+
+	url := assets.URL("css/site.css")
+	assert.Regexp(t, regexp.MustCompile(`^/static/css/site\.[0-9a-f]{10}\.css$`), url)
+	assert.Equal(t, "/static/css/missing.css", assets.URL("css/missing.css"))
+
+	handler := http.StripPrefix("/static/", assets)
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(url, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "body { color: red; }", rec.Body.String())
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/css")
+	assert.Contains(t, rec.Header().Get("Cache-Control"), "immutable")
+	etag := rec.Header().Get("ETag")
+	assert.True(t, strings.HasPrefix(etag, `"`))
+
+	rec = get("/static/css/site.css", etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+
+	assert.Equal(t, http.StatusNotFound, get("/static/css/missing.css", "").Code)
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"forum/internal/markdown"
+	"forum/internal/models"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Layout is the data layout.html and the sidebars read. Page data embeds
+// it instead of repeating the fields.
+type Layout struct {
+	LoggedIn         bool
+	Username         string
+	Categories       []*models.Category
+	ActiveCategoryID int
+	FilterMyPosts    bool
+	FilterLikedPosts bool
+	FilterComments   bool
+	// CSRFToken goes into every form that changes something.
+	CSRFToken string
+	// UnreadNotifications is the count on the header's bell.
+	UnreadNotifications int
+}
+
+// newLayout fills the sidebar for a page that highlights nothing.
+func newLayout(r *http.Request, store *models.Store, username string, loggedIn bool) Layout {
+	return Layout{
+		LoggedIn:            loggedIn,
+		Username:            username,
+		Categories:          sidebarCategories(store),
+		CSRFToken:           csrfToken(r),
+		UnreadNotifications: unreadNotifications(r, store, loggedIn),
+	}
+}
+
+// Templates holds the parsed pages. main sets it before serving.
+var Templates *TemplateCache
+
+// partials are parsed into every page. A page that defines "content" is
+// rendered through layout.html; any other page is rendered on its own.
+var partials = []string{"layout.html", "header.html", "footer.html", "left_sidebar.html", "right_sidebar.html"}
+
+var ErrTemplatesNotLoaded = errors.New("templates are not loaded")
+
+// TemplateCache parses every page in its file system once. With reload
+// set it parses them again on each render, so edits show up without a
+// restart.
+type TemplateCache struct {
+	fsys   fs.FS
+	reload bool
+	pages  map[string]*template.Template
+}
+
+func NewTemplateCache(fsys fs.FS, reload bool) (*TemplateCache, error) {
+	c := &TemplateCache{fsys: fsys, reload: reload}
+	pages, err := c.parse()
+	if err != nil {
+		return nil, err
+	}
+	c.pages = pages
+	return c, nil
+}
+
+func (c *TemplateCache) parse() (map[string]*template.Template, error) {
+	names, err := fs.Glob(c.fsys, "*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	pages := map[string]*template.Template{}
+	for _, name := range names {
+		if isPartial(name) {
+			continue
+		}
+		ts, err := template.New(name).Funcs(templateFuncs).ParseFS(c.fsys, partials...)
+		if err != nil {
+			return nil, err
+		}
+		if ts, err = ts.ParseFS(c.fsys, name); err != nil {
+			return nil, err
+		}
+		pages[name] = ts
+	}
+	return pages, nil
+}
+
+func isPartial(name string) bool {
+	for _, partial := range partials {
+		if name == partial {
+			return true
+		}
+	}
+	return false
+}
+
+// Render executes a page into a buffer first, so a failing template
+// leaves w untouched and the caller can still send an error page.
+func (c *TemplateCache) Render(w http.ResponseWriter, status int, name string, data any) error {
+	if c == nil {
+		return ErrTemplatesNotLoaded
+	}
+	pages := c.pages
+	if c.reload {
+		var err error
+		if pages, err = c.parse(); err != nil {
+			return err
+		}
+	}
+
+	ts, ok := pages[name]
+	if !ok {
+		return fmt.Errorf("template %s does not exist", name)
+	}
+	entry := name
+	if ts.Lookup("content") != nil {
+		entry = "layout"
+	}
+
+	var buf bytes.Buffer
+	if err := ts.ExecuteTemplate(&buf, entry, data); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func render(w http.ResponseWriter, name string, data any) error {
+	return Templates.Render(w, http.StatusOK, name, data)
+}
+
+var templateFuncs = template.FuncMap{
+	"asset":         func(name string) string { return Assets.URL(name) },
+	"withCSRFToken": withCSRFToken,
+	"timeAgo":       func(t time.Time) string { return relativeTime(t, time.Now()) },
+	"pluralize":     pluralize,
+	"markdown":      renderMarkdown,
+	"deviceLabel":   deviceLabel,
+	"fileSize":      formatFileSize,
+	"profileURL":    profileURL,
+	"avatarURL":     avatarURL,
+}
+
+// relativeTime describes t as seen from now, e.g. "5 minutes ago". Times
+// older than a month are shown as a date.
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return pluralize(int(d/time.Minute), "minute", "minutes") + " ago"
+	case d < 24*time.Hour:
+		return pluralize(int(d/time.Hour), "hour", "hours") + " ago"
+	case d < 30*24*time.Hour:
+		return pluralize(int(d/(24*time.Hour)), "day", "days") + " ago"
+	}
+	return t.Format("02 Jan 2006")
+}
+
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return strconv.Itoa(n) + " " + plural
+}
+
+// renderMarkdown renders s with the Markdown the forum uses for posts and
+// comments.
+func renderMarkdown(s string) template.HTML {
+	return template.HTML(markdown.Render(s))
+}
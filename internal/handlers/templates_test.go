@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"forum/ui"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateCacheRendersPages(t *testing.T) {
+	cache, err := NewTemplateCache(ui.Templates(), false)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, cache.Render(rec, http.StatusOK, "login.html", Layout{}))
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "<title>Login - Forum</title>")
+	assert.Contains(t, rec.Body.String(), `class="left-sidebar"`)
+	assert.Contains(t, rec.Body.String(), "/forum/login")
+
+	rec = httptest.NewRecorder()
+	require.NoError(t, cache.Render(rec, http.StatusNotFound, "error.html", ErrorData{Code: 404, Status: "Not Found"}))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Error 404: Not Found")
+	assert.NotContains(t, rec.Body.String(), `class="left-sidebar"`)
+}
+
+func TestTemplateCacheFailsWithoutWriting(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layout.html":        {Data: []byte(`{{define "layout"}}{{template "content" .}}{{end}}`)},
+		"header.html":        {Data: []byte(`{{define "header"}}{{end}}`)},
+		"footer.html":        {Data: []byte(`{{define "footer"}}{{end}}`)},
+		"left_sidebar.html":  {},
+		"right_sidebar.html": {},
+		"page.html":          {Data: []byte(`{{define "content"}}partial output {{.Missing}}{{end}}`)},
+	}
+	cache, err := NewTemplateCache(fsys, true)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	assert.Error(t, cache.Render(rec, http.StatusOK, "page.html", Layout{}))
+	assert.Empty(t, rec.Body.String())
+
+	fsys["page.html"].Data = []byte(`{{define "content"}}reloaded{{end}}`)
+	rec = httptest.NewRecorder()
+	require.NoError(t, cache.Render(rec, http.StatusOK, "page.html", Layout{}))
+	assert.Equal(t, "reloaded", rec.Body.String())
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "just now", relativeTime(now.Add(-30*time.Second), now))
+	assert.Equal(t, "1 minute ago", relativeTime(now.Add(-time.Minute), now))
+	assert.Equal(t, "5 hours ago", relativeTime(now.Add(-5*time.Hour), now))
+	assert.Equal(t, "2 days ago", relativeTime(now.Add(-50*time.Hour), now))
+	assert.Equal(t, "01 Jan 2024", relativeTime(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), now))
+}
+
+func TestMarkdown(t *testing.T) {
+	assert.Equal(t, "<p>one<br>two</p><p>three</p>", string(renderMarkdown("one\r\ntwo\n\nthree")))
+	assert.Equal(t, "<p><strong>bold</strong> and <em>it</em> <code>a*b*c</code></p>", string(renderMarkdown("**bold** and *it* `a*b*c`")))
+	assert.Equal(t, "<p>&lt;script&gt; <code>&lt;b&gt;</code></p>", string(renderMarkdown("<script> `<b>`")))
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxTokenNameLength = 50
+
+// CreateAPIToken issues a token for the signed-in user and shows its
+// plaintext once; only a hash is stored.
+func CreateAPIToken(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+
+	userID, err := GetSessionUserID(r, store)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to create API tokens.")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		RenderError(w, http.StatusBadRequest, "Failed to parse the form.")
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if IsBlankOrInvisible(name) || utf8.RuneCountInString(name) > maxTokenNameLength {
+		RenderError(w, http.StatusBadRequest, "The token name must be 1 to "+strconv.Itoa(maxTokenNameLength)+" visible characters long.")
+		return
+	}
+
+	tokenModel := store.Tokens
+	token, err := tokenModel.Create(userID, name, r.Form["scopes"])
+	switch {
+	case errors.Is(err, models.ErrNoScopes):
+		RenderError(w, http.StatusBadRequest, "Choose at least one scope for the token.")
+		return
+	case errors.Is(err, models.ErrUnknownScope):
+		RenderError(w, http.StatusBadRequest, "One of the selected scopes does not exist.")
+		return
+	case errors.Is(err, models.ErrTooManyTokens):
+		RenderError(w, http.StatusConflict, "You already have "+strconv.Itoa(models.MaxTokensPerUser)+" API tokens. Revoke one before creating another.")
+		return
+	case err != nil:
+		log.Printf("CreateAPIToken: Failed to create token for user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to create the API token. Please try again.")
+		return
+	}
+
+	data := struct {
+		Layout
+		Name  string
+		Token string
+	}{
+		Layout: newLayout(r, store, "", true),
+		Name:   name,
+		Token:  token,
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := render(w, "token_created.html", data); err != nil {
+		log.Printf("CreateAPIToken: Failed to render template: %v", err)
+		RenderError(w, http.StatusInternalServerError, "The token was created but the page could not be shown. Revoke it and try again.")
+	}
+}
+
+func RevokeAPIToken(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+
+	userID, err := GetSessionUserID(r, store)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to revoke API tokens.")
+		return
+	}
+
+	tokenID, err := strconv.Atoi(r.FormValue("tokenID"))
+	if err != nil || tokenID < 1 {
+		RenderError(w, http.StatusBadRequest, "Invalid token ID.")
+		return
+	}
+
+	tokenModel := store.Tokens
+	err = tokenModel.Revoke(userID, tokenID)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The API token does not exist.")
+		return
+	} else if err != nil {
+		log.Printf("RevokeAPIToken: Failed to revoke token %d for user ID %d: %v", tokenID, userID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to revoke the API token. Please try again.")
+		return
+	}
+
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
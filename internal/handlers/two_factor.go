@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/models"
+	"forum/internal/qrcode"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pendingLoginCookieName = "pending_login"
+	pendingLoginPath       = "/forum/login/2fa"
+	// totpIssuer names the forum in authenticator apps.
+	totpIssuer = "Forum"
+)
+
+type twoFactorSetupData struct {
+	Layout
+	Secret string
+	URI    string
+	QRCode template.HTML
+	Error  string
+}
+
+type recoveryCodesData struct {
+	Layout
+	Codes []string
+}
+
+// startTwoFactorLogin parks a login that passed the password check until
+// the user enters a code.
+func startTwoFactorLogin(w http.ResponseWriter, r *http.Request, store *models.Store, userID int, remember bool) {
+	token, err := store.TwoFactor.StartLogin(userID, remember)
+	if err != nil {
+		log.Printf("Login: Failed to start two-factor login for user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to authenticate user.")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingLoginCookieName,
+		Value:    token,
+		Path:     pendingLoginPath,
+		MaxAge:   int((5 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   SecureCookies || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, pendingLoginPath, http.StatusSeeOther)
+}
+
+func clearPendingLoginCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingLoginCookieName,
+		Value:    "",
+		Path:     pendingLoginPath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   SecureCookies || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// LoginTwoFactor is the second login step for accounts with two-factor
+// authentication: a code from the authenticator app or a recovery code.
+func LoginTwoFactor(store *models.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST")
+			RenderError(w, http.StatusMethodNotAllowed, "Method not allowed. Use GET or POST.")
+			return
+		}
+
+		cookie, err := r.Cookie(pendingLoginCookieName)
+		if err != nil {
+			http.Redirect(w, r, "/forum/login", http.StatusSeeOther)
+			return
+		}
+		token := cookie.Value
+		pending, err := store.TwoFactor.PendingLogin(token)
+		if errors.Is(err, models.ErrNoPendingLogin) {
+			clearPendingLoginCookie(w, r)
+			RenderError(w, http.StatusUnauthorized, "Your login has expired or had too many wrong codes. Please log in again.")
+			return
+		} else if err != nil {
+			log.Printf("LoginTwoFactor: Failed to load pending login: %v", err)
+			RenderError(w, http.StatusInternalServerError, "Failed to authenticate user.")
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			err := render(w, "login_2fa.html", newLayout(r, store, "", false))
+			if err != nil {
+				log.Printf("LoginTwoFactor: Failed to render template: %v", err)
+				RenderError(w, http.StatusInternalServerError, "Failed to render the login page.")
+			}
+			return
+		}
+
+		ip := clientIP(r)
+		until, err := store.LoginFailures.IPLockedUntil(ip)
+		if err != nil {
+			log.Printf("LoginTwoFactor: Failed to check failed logins from %s: %v", ip, err)
+		} else if time.Now().Before(until) {
+			renderLockout(w, until)
+			return
+		}
+
+		err = store.TwoFactor.Verify(pending.UserID, r.FormValue("code"))
+		if errors.Is(err, models.ErrInvalidCode) {
+			if err := store.TwoFactor.FailLogin(token); err != nil {
+				log.Printf("LoginTwoFactor: Failed to count a wrong code: %v", err)
+			}
+			if user, err := store.Users.Get(pending.UserID); err != nil {
+				log.Printf("LoginTwoFactor: Failed to count a wrong code for user ID %d: %v", pending.UserID, err)
+			} else {
+				recordLoginFailure(r, store, user.Email, models.ErrInvalidCode)
+			}
+			RenderError(w, http.StatusUnauthorized, "Invalid authentication code.")
+			return
+		} else if err != nil {
+			log.Printf("LoginTwoFactor: Failed to verify code for user ID %d: %v", pending.UserID, err)
+			RenderError(w, http.StatusInternalServerError, "Failed to authenticate user.")
+			return
+		}
+
+		if err := store.TwoFactor.FinishLogin(token); err != nil {
+			log.Printf("LoginTwoFactor: Failed to finish pending login: %v", err)
+		}
+		if user, err := store.Users.Get(pending.UserID); err != nil {
+			log.Printf("LoginTwoFactor: Failed to clear failed logins for user ID %d: %v", pending.UserID, err)
+		} else if err := store.LoginFailures.Clear(user.Email); err != nil {
+			log.Printf("LoginTwoFactor: Failed to clear failed logins for user ID %d: %v", pending.UserID, err)
+		}
+		clearPendingLoginCookie(w, r)
+
+		err = startSession(w, r, store, pending.UserID, pending.Remember)
+		if err != nil {
+			log.Printf("LoginTwoFactor: Failed to create session for user ID %d: %v", pending.UserID, err)
+			RenderError(w, http.StatusInternalServerError, "Failed to create user session.")
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+// TwoFactorSetup shows a new secret as a QR code and turns two-factor
+// authentication on once the user enters a code from their app. The
+// secret travels with the form until then, so abandoning the page leaves
+// the account unchanged.
+func TwoFactorSetup(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method not allowed. Use GET or POST.")
+		return
+	}
+
+	userID, err := GetSessionUserID(r, store)
+	if err != nil {
+		http.Redirect(w, r, "/forum/login", http.StatusSeeOther)
+		return
+	}
+	user, err := store.Users.Get(userID)
+	if err != nil {
+		log.Printf("TwoFactorSetup: Failed to fetch user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	enabled, err := store.TwoFactor.Enabled(userID)
+	if err != nil {
+		log.Printf("TwoFactorSetup: Failed to check two-factor authentication for user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if enabled {
+		http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+		return
+	}
+
+	data := twoFactorSetupData{Layout: newLayout(r, store, user.Username, true)}
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method == http.MethodPost {
+		data.Secret = strings.TrimSpace(r.FormValue("secret"))
+		codes, err := store.TwoFactor.Enable(userID, data.Secret, r.FormValue("code"))
+		if err == nil {
+			log.Printf("TwoFactorSetup: User ID %d enabled two-factor authentication", userID)
+			showRecoveryCodes(w, r, store, user.Username, codes)
+			return
+		} else if !errors.Is(err, models.ErrInvalidCode) {
+			log.Printf("TwoFactorSetup: Failed to enable two-factor authentication for user ID %d: %v", userID, err)
+			RenderError(w, http.StatusInternalServerError, "Failed to turn on two-factor authentication. Please try again.")
+			return
+		}
+		data.Error = "That code did not match. Check the time on your phone and try the newest code."
+	} else {
+		data.Secret, err = models.NewTOTPSecret()
+		if err != nil {
+			log.Printf("TwoFactorSetup: Failed to create a secret: %v", err)
+			RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+	}
+
+	data.URI = models.TOTPURI(totpIssuer, user.Email, data.Secret)
+	code, err := qrcode.Encode(data.URI)
+	if err != nil {
+		log.Printf("TwoFactorSetup: Failed to draw the QR code: %v", err)
+	} else {
+		data.QRCode = template.HTML(code.SVG())
+	}
+
+	status := http.StatusOK
+	if data.Error != "" {
+		status = http.StatusBadRequest
+	}
+	if err := Templates.Render(w, status, "two_factor_setup.html", data); err != nil {
+		log.Printf("TwoFactorSetup: Failed to render template: %v", err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+func showRecoveryCodes(w http.ResponseWriter, r *http.Request, store *models.Store, username string, codes []string) {
+	w.Header().Set("Cache-Control", "no-store")
+	data := recoveryCodesData{Layout: newLayout(r, store, username, true), Codes: codes}
+	if err := render(w, "recovery_codes.html", data); err != nil {
+		log.Printf("showRecoveryCodes: Failed to render template: %v", err)
+		RenderError(w, http.StatusInternalServerError, "Your recovery codes were created but could not be shown. Create new ones from your profile.")
+	}
+}
+
+// passwordConfirmed reads the signed-in user and checks the password they
+// typed again, as changes to two-factor authentication require.
+func passwordConfirmed(w http.ResponseWriter, r *http.Request, store *models.Store) (*models.User, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return nil, false
+	}
+
+	userID, err := GetSessionUserID(r, store)
+	if err != nil {
+		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to change two-factor authentication.")
+		return nil, false
+	}
+
+	err = store.Users.CheckPassword(userID, r.FormValue("password"))
+	if errors.Is(err, models.ErrWrongPassword) {
+		RenderError(w, http.StatusUnauthorized, "The password you entered is incorrect.")
+		return nil, false
+	} else if err != nil {
+		log.Printf("passwordConfirmed: Failed to check password for user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return nil, false
+	}
+
+	user, err := store.Users.Get(userID)
+	if err != nil {
+		log.Printf("passwordConfirmed: Failed to fetch user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return nil, false
+	}
+	return user, true
+}
+
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	user, ok := passwordConfirmed(w, r, store)
+	if !ok {
+		return
+	}
+
+	err := store.TwoFactor.Disable(user.ID)
+	if err != nil {
+		log.Printf("DisableTwoFactor: Failed to disable two-factor authentication for user ID %d: %v", user.ID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to turn off two-factor authentication. Please try again.")
+		return
+	}
+
+	log.Printf("DisableTwoFactor: User ID %d disabled two-factor authentication", user.ID)
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
+}
+
+// NewRecoveryCodes replaces the user's recovery codes and shows the new
+// ones once.
+func NewRecoveryCodes(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	user, ok := passwordConfirmed(w, r, store)
+	if !ok {
+		return
+	}
+
+	enabled, err := store.TwoFactor.Enabled(user.ID)
+	if err == nil && !enabled {
+		RenderError(w, http.StatusConflict, "Turn on two-factor authentication first.")
+		return
+	}
+	var codes []string
+	if err == nil {
+		codes, err = store.TwoFactor.NewRecoveryCodes(user.ID)
+	}
+	if err != nil {
+		log.Printf("NewRecoveryCodes: Failed to create recovery codes for user ID %d: %v", user.ID, err)
+		RenderError(w, http.StatusInternalServerError, "Failed to create new recovery codes. Please try again.")
+		return
+	}
+	showRecoveryCodes(w, r, store, user.Username, codes)
+}
+
+// ResetTwoFactor turns off two-factor authentication for a member who lost
+// their phone and their recovery codes. Their sessions are ended too, since
+// whoever has their password could be behind one.
+func ResetTwoFactor(w http.ResponseWriter, r *http.Request, store *models.Store, actorID int) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+
+	userID, err := strconv.Atoi(r.FormValue("userID"))
+	if err != nil || userID < 1 {
+		RenderError(w, http.StatusBadRequest, "User ID is required. Please provide a valid ID.")
+		return
+	}
+
+	// Staff could otherwise take over each other's accounts, so only
+	// members who manage roles reset anyone whose role grants permissions.
+	permissions, err := store.Roles.UserPermissions(userID)
+	if err != nil {
+		log.Printf("ResetTwoFactor: Failed to load the permissions of user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if len(permissions) > 0 && !HasPermission(store, actorID, models.PermManageRoles) {
+		RenderError(w, http.StatusForbidden, "Only administrators can reset the two-factor authentication of staff members.")
+		return
+	}
+
+	err = store.TwoFactor.Disable(userID)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The requested user does not exist. Please verify the ID and try again.")
+		return
+	} else if err != nil {
+		log.Printf("ResetTwoFactor: Failed to reset two-factor authentication for user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err := store.Sessions.RevokeAll(userID); err != nil {
+		log.Printf("ResetTwoFactor: Failed to revoke sessions for user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	log.Printf("ResetTwoFactor: User ID %d reset two-factor authentication for user ID %d", actorID, userID)
+	http.Redirect(w, r, "/forum/profile#manage-users", http.StatusSeeOther)
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"forum/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResetTwoFactor(t *testing.T) {
+	store := newTestStore(t)
+	member := createTestUser(t, store, "Member")
+	moderator := createTestUser(t, store, "Moderator")
+	admin := createTestUser(t, store, "Admin")
+	otherModerator := createTestUser(t, store, "Helper")
+	otherAdmin := createTestUser(t, store, "Other")
+	assignRole(t, store, moderator, models.RoleModerator)
+	assignRole(t, store, otherModerator, models.RoleModerator)
+	assignRole(t, store, admin, models.RoleAdmin)
+	assignRole(t, store, otherAdmin, models.RoleAdmin)
+	for _, id := range []int{member, otherModerator, otherAdmin} {
+		_, err := store.Users.(*models.UserModel).DB.Exec(`UPDATE users SET totp_secret = 'JBSWY3DPEHPK3PXP' WHERE id = ?`, id)
+		require.NoError(t, err)
+	}
+	session, err := store.Sessions.Create(member, false, "192.0.2.1", "test")
+	require.NoError(t, err)
+
+	reset := func(actorID, userID int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		form := url.Values{"userID": {strconv.Itoa(userID)}}
+		ResetTwoFactor(rec, formRequest(t, store, actorID, "/forum/admin/users/reset-2fa", form), store, actorID)
+		return rec
+	}
+
+	// A moderator can help a member back in, who is then logged out
+	// everywhere.
+	rec := reset(moderator, member)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/forum/profile#manage-users", rec.Header().Get("Location"))
+	enabled, err := store.TwoFactor.Enabled(member)
+	require.NoError(t, err)
+	assert.False(t, enabled)
+	_, err = store.Sessions.UserID(session)
+	assert.Error(t, err, "the member's sessions are revoked")
+
+	// Staff can only be reset by an administrator.
+	assert.Equal(t, http.StatusForbidden, reset(moderator, otherModerator).Code)
+	enabled, err = store.TwoFactor.Enabled(otherModerator)
+	require.NoError(t, err)
+	assert.True(t, enabled)
+	assert.Equal(t, http.StatusSeeOther, reset(admin, otherModerator).Code)
+
+	assert.Equal(t, http.StatusForbidden, reset(moderator, otherAdmin).Code)
+	enabled, err = store.TwoFactor.Enabled(otherAdmin)
+	require.NoError(t, err)
+	assert.True(t, enabled)
+	assert.Equal(t, http.StatusSeeOther, reset(admin, otherAdmin).Code)
+
+	assert.Equal(t, http.StatusNotFound, reset(admin, 9999).Code)
+}
@@ -2,118 +2,121 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"forum/internal/models"
-	"golang.org/x/crypto/bcrypt"
-	"html/template"
 	"log"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
-type AdminUser struct {
-	ID                    int
-	Username              string
-	Email                 string
-	PostCount             int
-	CommentCount          int
-	LikedPosts            int
-	DislikedPosts         int
-	LikeDislikeRatioPosts float64
-	IsBanned              bool
-}
+// maxLoginFailuresShown caps the failed logins listed for admins.
+const maxLoginFailuresShown = 50
 
 type ProfileData struct {
+	Layout
 	ID                    int
-	Username              string
 	Email                 string
+	EmailVerified         bool
+	Bio                   string
 	PostCount             int
 	CommentCount          int
 	LikedPosts            int
 	DislikedPosts         int
 	LikeDislikeRatioPosts float64
-	IsAdmin               bool
-	LoggedIn              bool
-	FilterMyPosts         bool
-	FilterLikedPosts      bool
-	FilterComments        bool
-	ActiveCategoryID      int
-	Users                 []AdminUser
+	RoleName              string
+	Permissions           models.PermissionSet
+	Users                 []*models.Member
+	ManagedCategories     []*models.Category
+	Roles                 []*models.Role
+	AllPermissions        []models.Permission
+	APITokens             []*models.APIToken
+	AllScopes             []models.Permission
+	Sessions              []sessionView
+	LoginFailures         []*models.LoginFailure
+	TwoFactor             bool
+	RecoveryCodesLeft     int
+	NotificationKinds     []models.NotificationKind
+	MutedNotifications    map[models.NotificationType]bool
 }
 
-func Login(db *sql.DB) http.HandlerFunc {
+func Login(store *models.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		files := []string{
-			"./ui/templates/login.html",
-			"./ui/templates/header.html",
-			"./ui/templates/footer.html",
-			"./ui/templates/left_sidebar.html",
-			"./ui/templates/right_sidebar.html",
-		}
-
-		ts, err := template.ParseFiles(files...)
-		if err != nil {
-			RenderError(w, http.StatusInternalServerError, "Failed to load login page templates.")
-			return
-		}
-
 		if r.Method == http.MethodPost {
 			email := r.FormValue("email")
 			password := r.FormValue("password")
+			ip := clientIP(r)
 
-			var hashedPassword string
-			var isBanned bool
-
-			err := db.QueryRow("SELECT password, is_banned FROM users WHERE email = ?", email).Scan(&hashedPassword, &isBanned)
+			until, err := store.LoginFailures.IPLockedUntil(ip)
 			if err != nil {
-				if err == sql.ErrNoRows {
-					RenderError(w, http.StatusUnauthorized, "The email does not exist in the database.")
-					return
-				}
-				RenderError(w, http.StatusInternalServerError, "Failed to query user information.")
+				log.Printf("Login: Failed to check failed logins from %s: %v", ip, err)
+			} else if time.Now().Before(until) {
+				renderLockout(w, until)
 				return
 			}
 
-			if isBanned {
-				RenderError(w, http.StatusForbidden, "The account is banned. Please contact support.")
+			// Every failure gets the same answer, and emails lock the same
+			// way whether or not an account uses them, so the form cannot
+			// be used to find out which emails have accounts. Attempts
+			// during a lockout are not recorded, or each would extend it.
+			until, err = store.LoginFailures.EmailLockedUntil(email)
+			if err != nil {
+				log.Printf("Login: Failed to check failed logins with %q: %v", email, err)
+			} else if time.Now().Before(until) {
+				renderLockout(w, until)
 				return
 			}
 
-			err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-			if err != nil {
-				RenderError(w, http.StatusUnauthorized, "The entered password is incorrect.")
+			userID, err := store.Users.Authenticate(email, password)
+			switch {
+			case errors.Is(err, models.ErrUnknownEmail), errors.Is(err, models.ErrWrongPassword):
+				recordLoginFailure(r, store, email, err)
+				RenderError(w, http.StatusUnauthorized, "Invalid email or password.")
+				return
+			case errors.Is(err, models.ErrUserBanned):
+				recordLoginFailure(r, store, email, err)
+				RenderError(w, http.StatusForbidden, "The account is banned. Please contact support.")
+				return
+			case err != nil:
+				log.Printf("Login: Failed to authenticate: %v", err)
+				RenderError(w, http.StatusInternalServerError, "Failed to authenticate user.")
 				return
 			}
 
-			userModel := &models.UserModel{DB: db}
-			userID, err := userModel.Authenticate(email, password)
+			remember := r.FormValue("remember") != ""
+			enabled, err := store.TwoFactor.Enabled(userID)
 			if err != nil {
+				log.Printf("Login: Failed to check two-factor authentication for user ID %d: %v", userID, err)
 				RenderError(w, http.StatusInternalServerError, "Failed to authenticate user.")
 				return
 			}
+			// With two-factor authentication the failures are only
+			// cleared once the second step succeeds too.
+			if enabled {
+				startTwoFactorLogin(w, r, store, userID, remember)
+				return
+			}
+			if err := store.LoginFailures.Clear(email); err != nil {
+				log.Printf("Login: Failed to clear failed logins for user ID %d: %v", userID, err)
+			}
 
-			sessionID, err := userModel.CreateSession(userID)
+			err = startSession(w, r, store, userID, remember)
 			if err != nil {
+				log.Printf("Login: Failed to create session for user ID %d: %v", userID, err)
 				RenderError(w, http.StatusInternalServerError, "Failed to create user session.")
 				return
 			}
 
-			http.SetCookie(w, &http.Cookie{
-				Name:     "session_id",
-				Value:    sessionID,
-				Expires:  time.Now().Add(time.Hour),
-				HttpOnly: true,
-				Path:     "/",
-			})
-
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
 
 		if r.Method == http.MethodGet {
-			err := ts.Execute(w, nil)
+			err := render(w, "login.html", newLayout(r, store, "", false))
 			if err != nil {
+				log.Printf("Login: Failed to render template: %v", err)
 				RenderError(w, http.StatusInternalServerError, "Failed to render the login page.")
 			}
 			return
@@ -124,38 +127,31 @@ func Login(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func isValidEmail(email string) bool {
-	re := regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
-	return re.MatchString(email)
-}
-
-func emailExists(db *sql.DB, email string) bool {
-	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)", email).Scan(&exists)
+func recordLoginFailure(r *http.Request, store *models.Store, email string, reason error) {
+	err := store.LoginFailures.Record(email, clientIP(r), r.UserAgent(), reason.Error())
 	if err != nil {
-		return false
+		log.Printf("Login: Failed to record a failed login: %v", err)
 	}
-	return exists
 }
 
-func SignUp(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	files := []string{
-		"./ui/templates/signup.html",
-		"./ui/templates/header.html",
-		"./ui/templates/footer.html",
-		"./ui/templates/left_sidebar.html",
-		"./ui/templates/right_sidebar.html",
-	}
+// renderLockout turns a login away until a lockout ends.
+func renderLockout(w http.ResponseWriter, until time.Time) {
+	wait := time.Until(until)
+	minutes := int((wait + time.Minute - 1) / time.Minute)
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	RenderError(w, http.StatusTooManyRequests, "Too many failed login attempts. Try again in "+pluralize(max(minutes, 1), "minute", "minutes")+".")
+}
 
-	ts, err := template.ParseFiles(files...)
-	if err != nil {
-		RenderError(w, http.StatusInternalServerError, "Failed to load templates for registration page.")
-		return
-	}
+func isValidEmail(email string) bool {
+	re := regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
+	return re.MatchString(email)
+}
 
+func SignUp(w http.ResponseWriter, r *http.Request, store *models.Store) {
 	if r.Method == http.MethodGet {
-		err = ts.Execute(w, nil)
+		err := render(w, "signup.html", newLayout(r, store, "", false))
 		if err != nil {
+			log.Printf("SignUp: Failed to render template: %v", err)
 			RenderError(w, http.StatusInternalServerError, "The registration page could not be displayed.")
 		}
 		return
@@ -183,7 +179,7 @@ func SignUp(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 			return
 		}
 
-		if emailExists(db, email) {
+		if exists, _ := store.Users.EmailExists(email); exists {
 			RenderError(w, http.StatusConflict, "The email you entered is already registered. Please use another email.")
 			return
 		}
@@ -208,16 +204,18 @@ func SignUp(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 			return
 		}
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		err := store.Users.Create(username, email, password)
 		if err != nil {
-			RenderError(w, http.StatusInternalServerError, "Failed to process password. Try again.")
+			RenderError(w, http.StatusInternalServerError, "Failed to create user due to internal server error.")
 			return
 		}
 
-		_, err = db.Exec("INSERT INTO users (username, email, password) VALUES (?, ?, ?)", username, email, string(hashedPassword))
+		user, err := store.Users.GetByEmail(email)
+		if err == nil {
+			err = sendVerification(store, user)
+		}
 		if err != nil {
-			RenderError(w, http.StatusInternalServerError, "Failed to create user due to internal server error.")
-			return
+			log.Printf("SignUp: Failed to send the confirmation link to %s: %v", email, err)
 		}
 
 		http.Redirect(w, r, "/forum/login", http.StatusSeeOther)
@@ -228,33 +226,32 @@ func SignUp(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	RenderError(w, http.StatusMethodNotAllowed, "Method not supported. Use GET or POST.")
 }
 
-func Logout(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
+// Logout only answers POST so that another site cannot log a visitor out
+// with a link or an image.
+func Logout(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	token := sessionToken(r)
+	if token == "" {
 		http.Redirect(w, r, "/forum/login", http.StatusSeeOther)
 		return
 	}
 
-	userModel := &models.UserModel{DB: db}
-	err = userModel.DeleteSession(cookie.Value)
+	err := store.Sessions.Delete(token)
 	if err != nil {
 		RenderError(w, http.StatusInternalServerError, "Unable to log out due to a server issue. Please try again later.")
 
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    "",
-		Expires:  time.Unix(0, 0),
-		HttpOnly: true,
-		Path:     "/",
-	})
+	clearSessionCookie(w, r)
 	http.Redirect(w, r, "/forum/login", http.StatusSeeOther)
 }
 
-func UserProfile(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	userModel := &models.UserModel{DB: db}
+func UserProfile(w http.ResponseWriter, r *http.Request, store *models.Store) {
+	userModel := store.Users
 
 	userID, err := userModel.GetSessionUserIDFromRequest(r)
 	if err != nil || userID == 0 {
@@ -263,115 +260,122 @@ func UserProfile(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	var username, email string
-	err = db.QueryRow("SELECT username, email FROM users WHERE id = ?", userID).Scan(&username, &email)
+	user, err := userModel.Get(userID)
 	if err != nil {
 		log.Printf("UserProfile: Failed to fetch user data for ID %d. Error: %v", userID, err)
 		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
 
-	isAdmin := email == "admin@gmail.com" && username == "Admin"
+	roleModel := store.Roles
+	permissions, err := roleModel.UserPermissions(userID)
+	if err != nil {
+		log.Printf("UserProfile: Failed to fetch permissions for user ID %d. Error: %v", userID, err)
+		permissions = models.PermissionSet{}
+	}
 
-	var postCount, commentCount, likedPosts, dislikedPosts int
-	var likeDislikeRatioPosts float64
+	var roleName string
+	if role, err := roleModel.UserRole(userID); err == nil {
+		roleName = role.Name
+	} else {
+		log.Printf("UserProfile: Failed to fetch role for user ID %d. Error: %v", userID, err)
+	}
 
-	err = db.QueryRow("SELECT COUNT(*) FROM posts WHERE user_id = ?", userID).Scan(&postCount)
+	stats, err := userModel.Stats(userID)
 	if err != nil {
-		log.Printf("UserProfile: Failed to count posts for user ID %d. Error: %v", userID, err)
-		postCount = 0
+		log.Printf("UserProfile: Failed to count activity for user ID %d. Error: %v", userID, err)
+		stats = &models.UserStats{}
 	}
 
-	err = db.QueryRow("SELECT COUNT(*) FROM comments WHERE user_id = ?", userID).Scan(&commentCount)
-	if err != nil {
-		log.Printf("UserProfile: Failed to count comments for user ID %d. Error: %v", userID, err)
-		commentCount = 0
+	var users []*models.Member
+	if permissions.Has(models.PermViewUsers) {
+		users, err = userModel.Members()
+		if err != nil {
+			log.Printf("UserProfile: Failed to fetch user list for admin. Error: %v", err)
+		}
+	}
+
+	var loginFailures []*models.LoginFailure
+	if permissions.Has(models.PermViewUsers) {
+		loginFailures, err = store.LoginFailures.Recent(maxLoginFailuresShown)
+		if err != nil {
+			log.Printf("UserProfile: Failed to fetch failed logins for admin. Error: %v", err)
+		}
+	}
+
+	var managedCategories []*models.Category
+	if permissions.Has(models.PermManageCategories) {
+		categoryModel := store.Categories
+		managedCategories, err = categoryModel.All()
+		if err != nil {
+			log.Printf("UserProfile: Failed to fetch categories for admin. Error: %v", err)
+		}
 	}
 
-	err = db.QueryRow("SELECT COUNT(*) FROM post_votes WHERE user_id = ? AND vote_type = 1", userID).Scan(&likedPosts)
+	var roles []*models.Role
+	if permissions.Has(models.PermManageRoles) {
+		roles, err = roleModel.All()
+		if err != nil {
+			log.Printf("UserProfile: Failed to fetch roles for admin. Error: %v", err)
+		}
+	}
+
+	tokenModel := store.Tokens
+	apiTokens, err := tokenModel.ByUserID(userID)
 	if err != nil {
-		log.Printf("UserProfile: Failed to count liked posts for user ID %d. Error: %v", userID, err)
-		likedPosts = 0
+		log.Printf("UserProfile: Failed to fetch API tokens for user ID %d. Error: %v", userID, err)
 	}
 
-	err = db.QueryRow("SELECT COUNT(*) FROM post_votes WHERE user_id = ? AND vote_type = -1", userID).Scan(&dislikedPosts)
+	sessions, err := userSessions(r, store, userID)
 	if err != nil {
-		log.Printf("UserProfile: Failed to count disliked posts for user ID %d. Error: %v", userID, err)
-		dislikedPosts = 0
+		log.Printf("UserProfile: Failed to fetch sessions for user ID %d. Error: %v", userID, err)
 	}
 
-	err = db.QueryRow("SELECT COALESCE(AVG(vote_type), 0) FROM post_votes WHERE user_id = ?", userID).Scan(&likeDislikeRatioPosts)
+	twoFactor, err := store.TwoFactor.Enabled(userID)
 	if err != nil {
-		log.Printf("UserProfile: Failed to calculate like/dislike ratio for posts for user ID %d. Error: %v", userID, err)
-		likeDislikeRatioPosts = 0
-	}
-
-	var users []AdminUser
-	if isAdmin {
-		rows, err := db.Query(`
-			SELECT 
-				u.id, u.username, u.email, u.is_banned,
-				(SELECT COUNT(*) FROM posts WHERE user_id = u.id) AS post_count,
-				(SELECT COUNT(*) FROM comments WHERE user_id = u.id) AS comment_count,
-				(SELECT COUNT(*) FROM post_votes WHERE user_id = u.id AND vote_type = 1) AS liked_posts,
-				(SELECT COUNT(*) FROM post_votes WHERE user_id = u.id AND vote_type = -1) AS disliked_posts,
-				COALESCE((SELECT AVG(vote_type) FROM post_votes WHERE user_id = u.id), 0) AS like_dislike_ratio_posts
-			FROM users u
-		`)
+		log.Printf("UserProfile: Failed to check two-factor authentication for user ID %d. Error: %v", userID, err)
+	}
+	var recoveryCodesLeft int
+	if twoFactor {
+		recoveryCodesLeft, err = store.TwoFactor.RecoveryCodesLeft(userID)
 		if err != nil {
-			log.Printf("UserProfile: Failed to fetch user list for admin. Error: %v", err)
-		} else {
-			defer rows.Close()
-			for rows.Next() {
-				var user AdminUser
-				if err := rows.Scan(
-					&user.ID, &user.Username, &user.Email, &user.IsBanned,
-					&user.PostCount, &user.CommentCount,
-					&user.LikedPosts, &user.DislikedPosts,
-					&user.LikeDislikeRatioPosts,
-				); err != nil {
-					log.Printf("UserProfile: Error scanning user data: %v", err)
-					continue
-				}
-				users = append(users, user)
-			}
+			log.Printf("UserProfile: Failed to count recovery codes for user ID %d. Error: %v", userID, err)
 		}
 	}
 
+	mutedNotifications, err := store.Notifications.Muted(userID)
+	if err != nil {
+		log.Printf("UserProfile: Failed to fetch notification settings for user ID %d. Error: %v", userID, err)
+	}
+
 	data := ProfileData{
+		Layout:                newLayout(r, store, user.Username, true),
 		ID:                    userID,
-		Username:              username,
-		Email:                 email,
-		PostCount:             postCount,
-		CommentCount:          commentCount,
-		LikedPosts:            likedPosts,
-		DislikedPosts:         dislikedPosts,
-		LikeDislikeRatioPosts: likeDislikeRatioPosts,
-		IsAdmin:               isAdmin,
-		LoggedIn:              true,
-		FilterMyPosts:         false,
-		FilterLikedPosts:      false,
-		FilterComments:        false,
-		ActiveCategoryID:      0,
+		Email:                 user.Email,
+		EmailVerified:         user.EmailVerified,
+		Bio:                   user.Bio,
+		PostCount:             stats.PostCount,
+		CommentCount:          stats.CommentCount,
+		LikedPosts:            stats.LikedPosts,
+		DislikedPosts:         stats.DislikedPosts,
+		LikeDislikeRatioPosts: stats.LikeDislikeRatioPosts,
+		RoleName:              roleName,
+		Permissions:           permissions,
 		Users:                 users,
+		ManagedCategories:     managedCategories,
+		Roles:                 roles,
+		AllPermissions:        models.AllPermissions,
+		APITokens:             apiTokens,
+		AllScopes:             models.AllScopes,
+		Sessions:              sessions,
+		LoginFailures:         loginFailures,
+		TwoFactor:             twoFactor,
+		RecoveryCodesLeft:     recoveryCodesLeft,
+		NotificationKinds:     models.NotificationKinds,
+		MutedNotifications:    mutedNotifications,
 	}
 
-	files := []string{
-		"./ui/templates/profile.html",
-		"./ui/templates/header.html",
-		"./ui/templates/footer.html",
-		"./ui/templates/left_sidebar.html",
-		"./ui/templates/right_sidebar.html",
-	}
-
-	ts, err := template.ParseFiles(files...)
-	if err != nil {
-		log.Printf("UserProfile: Failed to parse templates for user ID %d. Error: %v", userID, err)
-		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
-		return
-	}
-
-	err = ts.Execute(w, data)
+	err = render(w, "profile.html", data)
 	if err != nil {
 		log.Printf("UserProfile: Failed to execute template for user ID %d. Error: %v", userID, err)
 		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
@@ -381,51 +385,87 @@ func UserProfile(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	log.Printf("UserProfile: Successfully rendered profile page for user ID %d.", userID)
 }
 
-func GetSessionUserID(r *http.Request, db *sql.DB) (int, error) {
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-		return 0, err
-	}
-	userModel := &models.UserModel{DB: db}
-	return userModel.GetSessionUserID(cookie.Value)
+func GetSessionUserID(r *http.Request, store *models.Store) (int, error) {
+	return store.Users.GetSessionUserIDFromRequest(r)
 }
 
-func ToggleBanStatus(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func ToggleBanStatus(w http.ResponseWriter, r *http.Request, store *models.Store, actorID int) {
 	if r.Method != http.MethodPost {
 		RenderError(w, http.StatusMethodNotAllowed, "This HTTP method is not allowed for the requested resource.")
 		return
 	}
 
-	userID := r.URL.Query().Get("userID")
-	if userID == "" {
+	userID, err := strconv.Atoi(r.URL.Query().Get("userID"))
+	if err != nil || userID < 1 {
 		RenderError(w, http.StatusBadRequest, "User ID is required. Please provide a valid ID.")
 		return
 	}
 
-	var isBanned bool
-	err := db.QueryRow("SELECT is_banned FROM users WHERE id = ?", userID).Scan(&isBanned)
+	if userID == actorID {
+		RenderError(w, http.StatusForbidden, "You cannot ban your own account.")
+		return
+	}
+
+	if HasPermission(store, userID, models.PermManageRoles) {
+		RenderError(w, http.StatusForbidden, "Administrators cannot be banned. Change their role first.")
+		return
+	}
+
+	newStatus, err := store.Users.ToggleBan(userID)
 	if err == sql.ErrNoRows {
 		RenderError(w, http.StatusNotFound, "The requested user does not exist. Please verify the ID and try again.")
 		return
 	} else if err != nil {
-		log.Printf("ToggleBanStatus: Error fetching user status: %v", err)
+		log.Printf("ToggleBanStatus: Error updating ban status: %v", err)
 		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
 
-	newStatus := !isBanned
-	_, err = db.Exec("UPDATE users SET is_banned = ? WHERE id = ?", newStatus, userID)
-	if err != nil {
-		log.Printf("ToggleBanStatus: Error updating ban status: %v", err)
+	if newStatus {
+		err = store.Sessions.RevokeAll(userID)
+		if err != nil {
+			log.Printf("ToggleBanStatus: Failed to log out banned user ID %d: %v", userID, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Printf("ToggleBanStatus: User ID %d updated ban status for user ID %d to %t", actorID, userID, newStatus)
+}
+
+// UnlockUser lifts a lockout caused by failed logins before it runs out.
+func UnlockUser(w http.ResponseWriter, r *http.Request, store *models.Store, actorID int) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
+		return
+	}
+
+	userID, err := strconv.Atoi(r.FormValue("userID"))
+	if err != nil || userID < 1 {
+		RenderError(w, http.StatusBadRequest, "User ID is required. Please provide a valid ID.")
+		return
+	}
+
+	user, err := store.Users.Get(userID)
+	if err == sql.ErrNoRows {
+		RenderError(w, http.StatusNotFound, "The requested user does not exist. Please verify the ID and try again.")
+		return
+	} else if err != nil {
+		log.Printf("UnlockUser: Failed to retrieve user ID %d: %v", userID, err)
+		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if err := store.LoginFailures.Clear(user.Email); err != nil {
+		log.Printf("UnlockUser: Failed to unlock user ID %d: %v", userID, err)
 		RenderError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	log.Printf("ToggleBanStatus: Updated ban status for user ID %s to %t", userID, newStatus)
+	log.Printf("UnlockUser: User ID %d unlocked user ID %d", actorID, userID)
+	http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
 }
 
-func ChangePassword(db *sql.DB) http.HandlerFunc {
+func ChangePassword(store *models.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.Header().Set("Allow", "POST")
@@ -433,7 +473,7 @@ func ChangePassword(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		userID, err := GetSessionUserID(r, db)
+		userID, err := GetSessionUserID(r, store)
 		if err != nil {
 			RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to change your password.")
 			return
@@ -443,56 +483,50 @@ func ChangePassword(db *sql.DB) http.HandlerFunc {
 		newPassword := r.FormValue("new-password")
 		confirmPassword := r.FormValue("confirm-password")
 
-		var hashedPassword string
-		err = db.QueryRow("SELECT password FROM users WHERE id = ?", userID).Scan(&hashedPassword)
-		if err != nil {
-			RenderError(w, http.StatusInternalServerError, "Failed to retrieve your current password.")
-			return
-		}
-
-		err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(currentPassword))
-		if err != nil {
+		err = store.Users.CheckPassword(userID, currentPassword)
+		if errors.Is(err, models.ErrWrongPassword) {
 			RenderError(w, http.StatusUnauthorized, "The current password you entered is incorrect.")
 			return
-		}
-
-		if IsBlankOrInvisible(newPassword) {
-			RenderError(w, http.StatusBadRequest, "The new password cannot contain invisible characters.")
-			return
-		}
-
-		if strings.Contains(newPassword, " ") {
-			RenderError(w, http.StatusBadRequest, "The new password cannot contain spaces.")
+		} else if err != nil {
+			RenderError(w, http.StatusInternalServerError, "Failed to retrieve your current password.")
 			return
 		}
 
-		if len(newPassword) < 8 {
-			RenderError(w, http.StatusBadRequest, "The new password must be at least 8 characters long.")
+		if problem := newPasswordProblem(newPassword, confirmPassword); problem != "" {
+			RenderError(w, http.StatusBadRequest, problem)
 			return
 		}
 
-		if newPassword != confirmPassword {
-			RenderError(w, http.StatusBadRequest, "The 'New Password' and 'Confirm Password' fields must match.")
+		session, err := store.Sessions.Get(sessionToken(r))
+		if err != nil {
+			RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to change your password.")
 			return
 		}
 
-		newHashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+		err = store.Users.SetPassword(userID, newPassword)
 		if err != nil {
-			RenderError(w, http.StatusInternalServerError, "Failed to process the new password.")
+			RenderError(w, http.StatusInternalServerError, "Failed to update the password. Please try again.")
 			return
 		}
 
-		_, err = db.Exec("UPDATE users SET password = ? WHERE id = ?", newHashedPassword, userID)
+		// Whoever knew the old password may hold a session: end every
+		// other one and give this one a new token.
+		token, err := store.Sessions.Rotate(sessionToken(r))
+		if err == nil {
+			err = store.Sessions.RevokeOthers(userID, token)
+		}
 		if err != nil {
-			RenderError(w, http.StatusInternalServerError, "Failed to update the password. Please try again.")
+			log.Printf("ChangePassword: Failed to renew sessions for user ID %d: %v", userID, err)
+			RenderError(w, http.StatusInternalServerError, "Your password was changed, but other sessions could not be ended. Log out everywhere from your profile.")
 			return
 		}
+		setSessionCookie(w, r, token, session.Remember)
 
 		http.Redirect(w, r, "/forum/profile", http.StatusSeeOther)
 	}
 }
 
-func ChangeName(db *sql.DB) http.HandlerFunc {
+func ChangeName(store *models.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.Header().Set("Allow", "POST")
@@ -500,7 +534,7 @@ func ChangeName(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		userID, err := GetSessionUserID(r, db)
+		userID, err := GetSessionUserID(r, store)
 		if err != nil {
 			RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to change your name.")
 			return
@@ -512,7 +546,7 @@ func ChangeName(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		_, err = db.Exec("UPDATE users SET username = ? WHERE id = ?", newName, userID)
+		err = store.Users.SetUsername(userID, newName)
 		if err != nil {
 			RenderError(w, http.StatusInternalServerError, "Failed to update the name. Please try again.")
 			return
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"forum/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginLocksUnknownEmails(t *testing.T) {
+	store := newTestStore(t)
+	createTestUser(t, store, "Alice")
+	createTestUser(t, store, "Bob")
+	login := Login(store)
+	attempt := func(email, password string) int {
+		form := url.Values{"email": {email}, "password": {password}}
+		r := httptest.NewRequest(http.MethodPost, "/forum/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		login(rec, r)
+		return rec.Code
+	}
+
+	// An email with an account and one without answer alike, locks included.
+	for _, email := range []string{"alice@example.com", "nobody@example.com"} {
+		for i := 0; i < models.MaxLoginFailures; i++ {
+			assert.Equal(t, http.StatusUnauthorized, attempt(email, "wrong-password"), email)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, attempt(email, "password123"), email)
+	}
+	assert.Equal(t, http.StatusSeeOther, attempt("bob@example.com", "password123"), "other emails are not held back")
+}
+
+func TestLoginLockoutDoesNotGrow(t *testing.T) {
+	store := newTestStore(t)
+	createTestUser(t, store, "Alice")
+	login := Login(store)
+	attempt := func(password string) int {
+		form := url.Values{"email": {"alice@example.com"}, "password": {password}}
+		r := httptest.NewRequest(http.MethodPost, "/forum/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		login(rec, r)
+		return rec.Code
+	}
+
+	for i := 0; i < models.MaxLoginFailures; i++ {
+		require.Equal(t, http.StatusUnauthorized, attempt("wrong-password"))
+	}
+	until, err := store.LoginFailures.EmailLockedUntil("alice@example.com")
+	require.NoError(t, err)
+	require.False(t, until.IsZero())
+
+	// Retrying during the lockout neither extends nor doubles it.
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusTooManyRequests, attempt("wrong-password"))
+		assert.Equal(t, http.StatusTooManyRequests, attempt("password123"))
+	}
+	after, err := store.LoginFailures.EmailLockedUntil("alice@example.com")
+	require.NoError(t, err)
+	assert.True(t, until.Equal(after), "the lockout moved from %v to %v", until, after)
+}
+
+func TestLogout(t *testing.T) {
+	store := newTestStore(t)
+	userID := createTestUser(t, store, "Alice")
+
+	r := formRequest(t, store, userID, "/forum/logout", nil)
+	token, err := r.Cookie("session_id")
+	require.NoError(t, err)
+	r.Method = http.MethodGet
+	rec := httptest.NewRecorder()
+	Logout(rec, r, store)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	_, err = store.Sessions.UserID(token.Value)
+	assert.NoError(t, err, "a GET leaves the session alone")
+
+	r.Method = http.MethodPost
+	rec = httptest.NewRecorder()
+	Logout(rec, r, store)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	_, err = store.Sessions.UserID(token.Value)
+	assert.Error(t, err)
+}
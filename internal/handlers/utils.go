@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"forum/internal/models"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 func IsBlankOrInvisible(s string) bool {
@@ -37,3 +40,31 @@ func isInvisibleRune(r rune) bool {
 	}
 	return false
 }
+
+// IsBlankOrInvisibleText is the variant of IsBlankOrInvisible for free text
+// such as titles and post bodies, where ordinary spaces and line breaks are
+// expected.
+func IsBlankOrInvisibleText(s string) bool {
+	if len(strings.TrimSpace(s)) == 0 {
+		return true
+	}
+
+	for _, r := range s {
+		if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+			continue
+		}
+		if isInvisibleRune(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// contentTooLong reports whether a post or comment is longer than
+// models.MaxContentLength, and if so the message to show.
+func contentTooLong(content string) (string, bool) {
+	if utf8.RuneCountInString(content) <= models.MaxContentLength {
+		return "", false
+	}
+	return "Posts and comments can be at most " + strconv.Itoa(models.MaxContentLength) + " characters long.", true
+}
@@ -7,14 +7,14 @@ import (
 	"strconv"
 )
 
-func ToggleVote(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func ToggleVote(w http.ResponseWriter, r *http.Request, store *models.Store) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", "POST")
 		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
 		return
 	}
 
-	userModel := &models.UserModel{DB: db}
+	userModel := store.Users
 	userID, err := userModel.GetSessionUserIDFromRequest(r)
 	if err != nil || userID == 0 {
 		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to vote.")
@@ -33,9 +33,9 @@ func ToggleVote(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	postModel := &models.PostModel{DB: db}
+	postModel := store.Posts
 
-	_, err = postModel.Get(postID)
+	post, err := postModel.Get(postID)
 	if err == sql.ErrNoRows {
 		RenderError(w, http.StatusNotFound, "The post with the specified ID does not exist. Please check the ID.")
 		return
@@ -43,24 +43,36 @@ func ToggleVote(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		RenderError(w, http.StatusInternalServerError, "Failed to retrieve post for voting.")
 		return
 	}
+	if post.Deleted {
+		RenderError(w, http.StatusGone, "This post has been deleted and can no longer be voted on.")
+		return
+	}
 
+	// The new counts let the page update without reloading.
+	result := apiVoteResult{}
 	err = postModel.ToggleVote(postID, userID, voteType)
+	if err == nil {
+		result.Likes, result.Dislikes, err = postModel.GetLikesAndDislikes(postID)
+	}
+	if err == nil {
+		result.UserVote, err = postModel.GetUserVote(postID, userID)
+	}
 	if err != nil {
 		RenderError(w, http.StatusInternalServerError, "Failed to process your vote.")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	writeJSON(w, http.StatusOK, result)
 }
 
-func ToggleCommentVote(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func ToggleCommentVote(w http.ResponseWriter, r *http.Request, store *models.Store) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", "POST")
 		RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed. Use POST.")
 		return
 	}
 
-	userModel := &models.UserModel{DB: db}
+	userModel := store.Users
 	userID, err := userModel.GetSessionUserIDFromRequest(r)
 	if err != nil || userID == 0 {
 		RenderError(w, http.StatusUnauthorized, "Unauthorized. Please log in to vote.")
@@ -79,9 +91,9 @@ func ToggleCommentVote(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	commentModel := &models.CommentModel{DB: db}
+	commentModel := store.Comments
 
-	_, err = db.Exec("SELECT id FROM comments WHERE id = ?", commentID)
+	comment, err := commentModel.Get(commentID)
 	if err == sql.ErrNoRows {
 		RenderError(w, http.StatusNotFound, "The comment with the specified ID does not exist.")
 		return
@@ -89,12 +101,23 @@ func ToggleCommentVote(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		RenderError(w, http.StatusInternalServerError, "Failed to retrieve comment for voting.")
 		return
 	}
+	if comment.Gone() {
+		RenderError(w, http.StatusGone, "This comment has been deleted and can no longer be voted on.")
+		return
+	}
 
+	result := apiVoteResult{}
 	err = commentModel.ToggleVote(commentID, userID, voteType)
+	if err == nil {
+		result.Likes, result.Dislikes, err = commentModel.GetLikesAndDislikes(commentID)
+	}
+	if err == nil {
+		result.UserVote, err = commentModel.GetUserVote(commentID, userID)
+	}
 	if err != nil {
 		RenderError(w, http.StatusInternalServerError, "Failed to process your vote.")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	writeJSON(w, http.StatusOK, result)
 }
@@ -0,0 +1,144 @@
+// Package live passes events from the code that changes the forum to the
+// pages that show it, which receive them as Server-Sent Events.
+package live
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+)
+
+// Event is one message for the subscribers of a topic. Data is JSON.
+type Event struct {
+	Name string
+	Data []byte
+}
+
+var (
+	ErrTooManySubscribers = errors.New("live: too many subscribers")
+	ErrClosed             = errors.New("live: hub is closed")
+)
+
+// bufferSize is how many events a subscriber may fall behind before it is
+// dropped. Browsers reconnect on their own.
+const bufferSize = 32
+
+// Hub fans events out to the subscribers of each topic. A nil *Hub
+// publishes nothing, so code that publishes works without one.
+type Hub struct {
+	mu     sync.Mutex
+	topics map[string]map[*Subscription]struct{}
+	count  int
+	limit  int
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{topics: map[string]map[*Subscription]struct{}{}}
+}
+
+// SetLimit bounds the subscribers across all topics; 0 means no bound.
+func (h *Hub) SetLimit(limit int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.limit = limit
+}
+
+// Subscription receives the events of one topic until it is closed, falls
+// too far behind or the hub shuts down, which all close Events.
+type Subscription struct {
+	hub    *Hub
+	topic  string
+	events chan Event
+}
+
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close stops the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+func (h *Hub) Subscribe(topic string) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, ErrClosed
+	}
+	if h.limit > 0 && h.count >= h.limit {
+		return nil, ErrTooManySubscribers
+	}
+	s := &Subscription{hub: h, topic: topic, events: make(chan Event, bufferSize)}
+	if h.topics[topic] == nil {
+		h.topics[topic] = map[*Subscription]struct{}{}
+	}
+	h.topics[topic][s] = struct{}{}
+	h.count++
+	return s, nil
+}
+
+// remove takes s out of the hub and closes its channel, unless that
+// already happened. The caller holds h.mu.
+func (h *Hub) remove(s *Subscription) {
+	subscribers := h.topics[s.topic]
+	if _, ok := subscribers[s]; !ok {
+		return
+	}
+	delete(subscribers, s)
+	if len(subscribers) == 0 {
+		delete(h.topics, s.topic)
+	}
+	h.count--
+	close(s.events)
+}
+
+// Publish sends an event with data encoded as JSON to every subscriber of
+// each topic. It never waits for a subscriber.
+func (h *Hub) Publish(name string, data any, topics ...string) {
+	if h == nil {
+		return
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("live: Failed to encode %s event: %v", name, err)
+		return
+	}
+	event := Event{Name: name, Data: encoded}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, topic := range topics {
+		for s := range h.topics[topic] {
+			select {
+			case s.events <- event:
+			default:
+				h.remove(s)
+			}
+		}
+	}
+}
+
+// Subscribers counts the open subscriptions.
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// Close ends every subscription and refuses new ones, so that open
+// streams finish when the server shuts down.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subscribers := range h.topics {
+		for s := range subscribers {
+			h.remove(s)
+		}
+	}
+}
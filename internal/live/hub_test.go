@@ -0,0 +1,50 @@
+package live
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	a, err := hub.Subscribe("post:1")
+	require.NoError(t, err)
+	b, err := hub.Subscribe("post:2")
+	require.NoError(t, err)
+
+	hub.Publish("votes", map[string]int{"likes": 3}, "post:1", "feed")
+	event := <-a.Events()
+	assert.Equal(t, "votes", event.Name)
+	assert.JSONEq(t, `{"likes": 3}`, string(event.Data))
+	assert.Empty(t, b.Events())
+
+	a.Close()
+	a.Close()
+	_, open := <-a.Events()
+	assert.False(t, open)
+	assert.Equal(t, 1, hub.Subscribers())
+
+	// A subscriber that falls behind is dropped rather than waited for.
+	for i := 0; i <= bufferSize; i++ {
+		hub.Publish("comment", i, "post:2")
+	}
+	assert.Len(t, b.Events(), bufferSize)
+	assert.Zero(t, hub.Subscribers())
+
+	hub.SetLimit(1)
+	c, err := hub.Subscribe("feed")
+	require.NoError(t, err)
+	_, err = hub.Subscribe("feed")
+	assert.ErrorIs(t, err, ErrTooManySubscribers)
+
+	hub.Close()
+	_, open = <-c.Events()
+	assert.False(t, open)
+	_, err = hub.Subscribe("feed")
+	assert.ErrorIs(t, err, ErrClosed)
+
+	var none *Hub
+	none.Publish("votes", nil, "feed")
+}
@@ -0,0 +1,40 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageBytes(t *testing.T) {
+	msg := Message{To: "alice@example.com", Subject: "Confirm your email – Forum", Body: "Hello,\nopen this link."}
+	content, err := msg.Bytes("forum@example.com")
+	require.NoError(t, err)
+	text := string(content)
+	assert.Contains(t, text, "From: forum@example.com\r\n")
+	assert.Contains(t, text, "To: alice@example.com\r\n")
+	assert.Contains(t, text, "Subject: =?utf-8?q?")
+	assert.True(t, strings.HasSuffix(text, "\r\n\r\nHello,\r\nopen this link."))
+
+	msg.To = "alice@example.com\r\nBcc: everyone@example.com"
+	_, err = msg.Bytes("forum@example.com")
+	assert.ErrorIs(t, err, ErrHeaderInjection)
+}
+
+func TestLogWritesFiles(t *testing.T) {
+	dir := t.TempDir()
+	sender := &Log{Dir: dir, From: "forum@example.com"}
+	require.NoError(t, sender.Send(Message{To: "alice@example.com", Subject: "One", Body: "1"}))
+	require.NoError(t, sender.Send(Message{To: "bob@example.com", Subject: "Two", Body: "2"}))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	content, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "To: alice@example.com")
+}
@@ -0,0 +1,157 @@
+package markdown
+
+import "strings"
+
+// language describes just enough of a programming language to colour its
+// keywords, strings, comments and numbers.
+type language struct {
+	keywords     map[string]bool
+	ignoreCase   bool
+	lineComments []string
+	blockComment [2]string
+	quotes       string
+}
+
+var languages = map[string]*language{}
+
+func register(names string, l *language, keywords string) {
+	l.keywords = make(map[string]bool)
+	for _, keyword := range strings.Fields(keywords) {
+		if l.ignoreCase {
+			keyword = strings.ToLower(keyword)
+		}
+		l.keywords[keyword] = true
+	}
+	for _, name := range strings.Fields(names) {
+		languages[name] = l
+	}
+}
+
+func init() {
+	register("go golang", &language{lineComments: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: "\"'`"},
+		`break case chan const continue default defer else fallthrough for func go goto if import interface
+		map package range return select struct switch type var true false nil iota`)
+	register("js javascript jsx ts typescript tsx", &language{lineComments: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: "\"'`"},
+		`async await break case catch class const continue debugger default delete do else enum export extends
+		finally for from function if implements import in instanceof interface let new of return static super
+		switch this throw try type typeof var void while with yield true false null undefined`)
+	register("py python", &language{lineComments: []string{"#"}, quotes: `"'`},
+		`and as assert async await break class continue def del elif else except finally for from global if
+		import in is lambda nonlocal not or pass raise return try while with yield True False None`)
+	register("sql sqlite postgresql", &language{ignoreCase: true, lineComments: []string{"--"}, blockComment: [2]string{"/*", "*/"}, quotes: "'"},
+		`add all alter and as asc begin between by case check commit create default delete desc distinct drop
+		else end exists foreign from group having in index inner insert into is join key left like limit not
+		null offset on or order outer primary references returning right rollback select set table then union
+		unique update values when where with`)
+	register("sh bash shell zsh console", &language{lineComments: []string{"#"}, quotes: `"'`},
+		`if then else elif fi for while until do done case esac function return in local export exit`)
+	register("c h cpp c++ cs csharp java kotlin", &language{lineComments: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: `"'`},
+		`abstract auto bool boolean break case catch char class const continue default delete do double else
+		enum extends extern final float for goto if implements import int interface long namespace new null
+		nullptr package private protected public return short signed sizeof static struct switch template
+		this throw try typedef union unsigned using virtual void volatile while true false`)
+	register("rs rust", &language{lineComments: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: `"`},
+		`as async await break const continue crate dyn else enum extern false fn for if impl in let loop match
+		mod move mut pub ref return self Self static struct super trait true type unsafe use where while`)
+	register("json", &language{quotes: `"`}, `true false null`)
+	register("yaml yml", &language{lineComments: []string{"#"}, quotes: `"'`}, `true false null yes no`)
+}
+
+// highlight escapes code and, for a known language, wraps its tokens in
+// spans with hl-keyword, hl-string, hl-comment or hl-number classes.
+func highlight(name, code string) string {
+	l := languages[strings.ToLower(name)]
+	if l == nil {
+		return escape(code)
+	}
+
+	var b strings.Builder
+	span := func(class, text string) {
+		b.WriteString(`<span class="hl-` + class + `">` + escape(text) + "</span>")
+	}
+	for i := 0; i < len(code); {
+		rest := code[i:]
+		if l.lineCommentAt(code, i) {
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			span("comment", rest[:end])
+			i += end
+			continue
+		}
+		if open, close := l.blockComment[0], l.blockComment[1]; open != "" && strings.HasPrefix(rest, open) {
+			end := len(rest)
+			if j := strings.Index(rest[len(open):], close); j >= 0 {
+				end = len(open) + j + len(close)
+			}
+			span("comment", rest[:end])
+			i += end
+			continue
+		}
+
+		c := code[i]
+		j := i + 1
+		switch {
+		case strings.IndexByte(l.quotes, c) >= 0:
+			// Strings end at the closing quote, or at the line's end for
+			// all but backquoted ones.
+			for ; j < len(code) && code[j] != c; j++ {
+				if c != '`' && code[j] == '\n' {
+					break
+				}
+				if c != '`' && code[j] == '\\' && j+1 < len(code) && code[j+1] != '\n' {
+					j++
+				}
+			}
+			if j < len(code) && code[j] == c {
+				j++
+			}
+			span("string", code[i:j])
+		case isDigit(c) && (i == 0 || !isWordByte(code[i-1])):
+			for j < len(code) && (isWordByte(code[j]) || code[j] == '.' && j+1 < len(code) && isDigit(code[j+1])) {
+				j++
+			}
+			span("number", code[i:j])
+		case isWordByte(c):
+			for j < len(code) && isWordByte(code[j]) {
+				j++
+			}
+			word := code[i:j]
+			if l.ignoreCase {
+				word = strings.ToLower(word)
+			}
+			if l.keywords[word] {
+				span("keyword", code[i:j])
+			} else {
+				b.WriteString(code[i:j])
+			}
+		default:
+			b.WriteString(escape(code[i:j]))
+		}
+		i = j
+	}
+	return b.String()
+}
+
+func (l *language) lineCommentAt(code string, i int) bool {
+	for _, prefix := range l.lineComments {
+		if !strings.HasPrefix(code[i:], prefix) {
+			continue
+		}
+		// In shell and YAML a # inside a word, as in $#, starts no comment.
+		if prefix == "#" && i > 0 && code[i-1] != ' ' && code[i-1] != '\n' {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
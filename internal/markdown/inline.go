@@ -0,0 +1,528 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	uriAutolink   = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^\s<>]*)>`)
+	emailAutolink = regexp.MustCompile("^<([A-Za-z0-9.!#$%&'*+/=?^_`{|}~-]+@[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?(?:\\.[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*)>")
+	bareURL       = regexp.MustCompile(`^(?i:https?://|www\.)[^\s<]*`)
+)
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&#34;")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// node is a piece of a paragraph: finished HTML, or a run of emphasis
+// delimiters waiting to be paired.
+type node struct {
+	html string
+
+	delim             byte
+	count, length     int
+	canOpen, canClose bool
+	open, close       string
+}
+
+// bracket is a [ or ![ that a later ] may close into a link or an image.
+type bracket struct {
+	// node is the index of the text node holding the bracket, and pos
+	// where the bracket starts in the source.
+	node, pos int
+	image     bool
+}
+
+// inlineParser holds the state of one inline call. Every construct is found
+// in a single pass over the text, so that no input takes more than linear
+// time however it nests.
+type inlineParser struct {
+	s     string
+	nodes []*node
+	text  strings.Builder
+
+	brackets []bracket
+	// Brackets below linkBottom may no longer open a link, as links do not
+	// nest, and those below imageBottom may no longer open an image.
+	linkBottom, imageBottom int
+
+	// codeRuns lists where the runs of backticks of each length start, for
+	// code spans to find their closing run without searching.
+	codeRuns map[int][]int
+	// unclosedTitle remembers the title quotes a search found no closing
+	// quote for, so that later searches give up straight away.
+	unclosedTitle map[byte]bool
+}
+
+// inline renders the text of a paragraph, heading or table cell. Line
+// breaks are kept, as members expect from a forum.
+func inline(b *strings.Builder, s string) {
+	p := &inlineParser{s: s, unclosedTitle: map[byte]bool{}}
+	p.parse()
+	writeNodes(b, p.nodes)
+}
+
+func (p *inlineParser) flush() {
+	if p.text.Len() > 0 {
+		p.nodes = append(p.nodes, &node{html: p.text.String()})
+		p.text.Reset()
+	}
+}
+
+func (p *inlineParser) parse() {
+	s := p.s
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			p.text.WriteString("<br>")
+			i += 2
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			p.text.WriteString(escape(s[i+1 : i+2]))
+			i += 2
+		case c == '\n':
+			p.text.WriteString("<br>")
+			i++
+		case c == '`':
+			html, n := p.codeSpan(i)
+			p.text.WriteString(html)
+			i += n
+		case c == '*' || c == '_' || c == '~':
+			p.flush()
+			p.nodes = append(p.nodes, delimiterRun(s, i))
+			i += p.nodes[len(p.nodes)-1].length
+		case c == '!' && strings.HasPrefix(s[i:], "!["):
+			p.openBracket(i, true)
+			i += 2
+		case c == '[':
+			p.openBracket(i, false)
+			i++
+		case c == ']':
+			if end, ok := p.closeBracket(i); ok {
+				i = end
+			} else {
+				p.text.WriteByte(']')
+				i++
+			}
+		case c == '<':
+			html, n := autolink(s[i:])
+			p.text.WriteString(html)
+			i += n
+		case (c == 'h' || c == 'H' || c == 'w' || c == 'W') && (i == 0 || strings.IndexByte(" \n*_~(", s[i-1]) >= 0):
+			if html, n := bareLink(s[i:]); n > 0 {
+				p.text.WriteString(html)
+				i += n
+			} else {
+				p.text.WriteByte(c)
+				i++
+			}
+		default:
+			if c == '&' || c == '>' || c == '"' {
+				p.text.WriteString(escape(s[i : i+1]))
+			} else {
+				p.text.WriteByte(c)
+			}
+			i++
+		}
+	}
+	p.flush()
+}
+
+// writeNodes pairs the emphasis delimiters among nodes and writes them out.
+func writeNodes(b *strings.Builder, nodes []*node) {
+	pairDelimiters(nodes)
+	for _, n := range nodes {
+		if n.delim == 0 {
+			b.WriteString(n.html)
+			continue
+		}
+		b.WriteString(n.close)
+		b.WriteString(strings.Repeat(string(n.delim), n.count))
+		b.WriteString(n.open)
+	}
+}
+
+func isASCIIPunct(c byte) bool {
+	return c < utf8.RuneSelf && strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func isPunct(r rune) bool {
+	if r < utf8.RuneSelf {
+		return isASCIIPunct(byte(r))
+	}
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// delimiterRun reads the run of *, _ or ~ at s[i] and works out, from the
+// characters around it, whether it can open or close emphasis.
+func delimiterRun(s string, i int) *node {
+	c := s[i]
+	n := len(s[i:]) - len(strings.TrimLeft(s[i:], string(c)))
+
+	before, after := ' ', ' '
+	if i > 0 {
+		before, _ = utf8.DecodeLastRuneInString(s[:i])
+	}
+	if i+n < len(s) {
+		after, _ = utf8.DecodeRuneInString(s[i+n:])
+	}
+	leftFlanking := !unicode.IsSpace(after) && (!isPunct(after) || unicode.IsSpace(before) || isPunct(before))
+	rightFlanking := !unicode.IsSpace(before) && (!isPunct(before) || unicode.IsSpace(after) || isPunct(after))
+
+	run := &node{delim: c, count: n, length: n, canOpen: leftFlanking, canClose: rightFlanking}
+	if c == '_' {
+		// No emphasis inside words like snake_case_name.
+		run.canOpen = leftFlanking && (!rightFlanking || isPunct(before))
+		run.canClose = rightFlanking && (!leftFlanking || isPunct(after))
+	}
+	return run
+}
+
+// pairDelimiters matches closing delimiter runs with the nearest opening
+// run before them, as CommonMark does, and turns the pairs into em,
+// strong and del tags. Unpaired delimiters stay as text.
+//
+// Runs that may still open wait on a stack. Runs between a pair are dropped
+// from it, and once a closer finds no opener, closers of the same kind
+// never look below that point again, so every run is looked at a bounded
+// number of times.
+func pairDelimiters(nodes []*node) {
+	type kind struct {
+		delim   byte
+		canOpen bool
+		n       int
+	}
+	bottom := map[kind]int{}
+	var openers []int
+
+	for ci, closer := range nodes {
+		if closer.delim == 0 {
+			continue
+		}
+		// Only runs of one or two tildes pair up, with a run as long.
+		if closer.canClose && (closer.delim != '~' || closer.count <= 2) {
+			k := kind{closer.delim, closer.canOpen, closer.length % 3}
+			if closer.delim == '~' {
+				k.n = closer.count
+			}
+			for closer.count > 0 {
+				floor, ok := bottom[k]
+				if !ok {
+					floor = -1
+				}
+				oi := len(openers) - 1
+				for ; oi >= 0 && openers[oi] > floor; oi-- {
+					if closes(nodes[openers[oi]], closer) {
+						break
+					}
+				}
+				if oi < 0 || openers[oi] <= floor {
+					bottom[k] = ci - 1
+					break
+				}
+
+				opener := nodes[openers[oi]]
+				n, tag := 1, "em"
+				switch {
+				case opener.delim == '~':
+					n, tag = closer.count, "del"
+				case opener.count >= 2 && closer.count >= 2:
+					n, tag = 2, "strong"
+				}
+				opener.count -= n
+				closer.count -= n
+				opener.open = "<" + tag + ">" + opener.open
+				closer.close += "</" + tag + ">"
+				openers = openers[:oi+1]
+				if opener.count == 0 {
+					openers = openers[:oi]
+				}
+			}
+		}
+		if closer.canOpen && closer.count > 0 {
+			openers = append(openers, ci)
+		}
+	}
+}
+
+// closes reports whether closer may pair with opener.
+func closes(opener, closer *node) bool {
+	if opener.delim != closer.delim {
+		return false
+	}
+	if opener.delim == '~' {
+		return opener.count == closer.count
+	}
+	// The rule of three keeps *a**b* from pairing the wrong way.
+	return !((opener.canClose || closer.canOpen) && (opener.length+closer.length)%3 == 0 &&
+		(opener.length%3 != 0 || closer.length%3 != 0))
+}
+
+// codeSpan renders the code span at s[i], or the backticks as text when
+// nothing closes them.
+func (p *inlineParser) codeSpan(i int) (string, int) {
+	s := p.s
+	n := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+	if p.codeRuns == nil {
+		p.codeRuns = backtickRuns(s)
+	}
+	// Spans are read left to right, so runs before this one are of no
+	// further use.
+	runs := p.codeRuns[n]
+	for len(runs) > 0 && runs[0] < i+n {
+		runs = runs[1:]
+	}
+	p.codeRuns[n] = runs
+	if len(runs) == 0 {
+		return s[i : i+n], n
+	}
+
+	end := runs[0]
+	code := strings.ReplaceAll(s[i+n:end], "\n", " ")
+	if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+		code = code[1 : len(code)-1]
+	}
+	return "<code>" + escape(code) + "</code>", end + n - i
+}
+
+// backtickRuns maps the length of every run of backticks in s to where
+// such runs start, in order.
+func backtickRuns(s string) map[int][]int {
+	runs := map[int][]int{}
+	for i := 0; i < len(s); {
+		k := strings.IndexByte(s[i:], '`')
+		if k < 0 {
+			break
+		}
+		start := i + k
+		n := len(s[start:]) - len(strings.TrimLeft(s[start:], "`"))
+		runs[n] = append(runs[n], start)
+		i = start + n
+	}
+	return runs
+}
+
+func (p *inlineParser) openBracket(pos int, image bool) {
+	p.flush()
+	p.brackets = append(p.brackets, bracket{node: len(p.nodes), pos: pos, image: image})
+	if image {
+		p.nodes = append(p.nodes, &node{html: "!["})
+	} else {
+		p.nodes = append(p.nodes, &node{html: "["})
+	}
+}
+
+// closeBracket turns the nearest open bracket and everything after it into
+// [text](destination "title") or ![alt](source "title") when the ] at s[i]
+// is followed by a destination. It returns where the link ends.
+func (p *inlineParser) closeBracket(i int) (int, bool) {
+	top := len(p.brackets) - 1
+	if top < 0 {
+		return 0, false
+	}
+	open := p.brackets[top]
+	p.brackets = p.brackets[:top]
+	active := top >= p.linkBottom
+	if open.image {
+		active = top >= p.imageBottom
+	}
+	p.linkBottom = min(p.linkBottom, top)
+	p.imageBottom = min(p.imageBottom, top)
+	if !active {
+		return 0, false
+	}
+	destination, title, end, ok := p.linkTail(i + 1)
+	if !ok {
+		return 0, false
+	}
+
+	p.flush()
+	content := p.nodes[open.node+1:]
+	var b strings.Builder
+	switch {
+	case !safeURL(destination):
+		// Unsafe links keep their text and lose the link.
+		if open.image {
+			b.WriteString(escape(p.s[open.pos+2 : i]))
+		} else {
+			writeNodes(&b, content)
+		}
+	case open.image:
+		b.WriteString(`<img src="` + escape(destination) + `" alt="` + escape(unescape(p.s[open.pos+2:i])) + `"`)
+		if title != "" {
+			b.WriteString(` title="` + escape(title) + `"`)
+		}
+		b.WriteString(">")
+	default:
+		b.WriteString(`<a href="` + escape(destination) + `"`)
+		if title != "" {
+			b.WriteString(` title="` + escape(title) + `"`)
+		}
+		b.WriteString(">")
+		writeNodes(&b, content)
+		b.WriteString("</a>")
+	}
+	p.nodes = append(p.nodes[:open.node], &node{html: b.String()})
+
+	// The alt text of an image is its source, so an image in an image
+	// would only be thrown away.
+	if open.image {
+		p.imageBottom = len(p.brackets)
+	} else {
+		p.linkBottom = len(p.brackets)
+	}
+	return end, true
+}
+
+// linkTail reads (destination "title") at s[i], returning where it ends.
+func (p *inlineParser) linkTail(i int) (string, string, int, bool) {
+	s := p.s
+	if i >= len(s) || s[i] != '(' {
+		return "", "", 0, false
+	}
+	i = skipSpace(s, i+1)
+	destination, i, ok := linkDestination(s, i)
+	if !ok {
+		return "", "", 0, false
+	}
+	var title string
+	if j := skipSpace(s, i); j > i && j < len(s) && strings.IndexByte(`"'(`, s[j]) >= 0 {
+		if title, i, ok = p.linkTitle(j); !ok {
+			return "", "", 0, false
+		}
+	}
+	i = skipSpace(s, i)
+	if i >= len(s) || s[i] != ')' {
+		return "", "", 0, false
+	}
+	return unescape(destination), unescape(title), i + 1, true
+}
+
+func skipSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// maxLinkParens bounds the parentheses a link destination may nest, which
+// also bounds how far a destination that never closes is read.
+const maxLinkParens = 32
+
+func linkDestination(s string, i int) (string, int, bool) {
+	if i < len(s) && s[i] == '<' {
+		end := strings.IndexAny(s[i+1:], "<>\n")
+		if end < 0 || s[i+1+end] != '>' {
+			return "", 0, false
+		}
+		return s[i+1 : i+1+end], i + end + 2, true
+	}
+
+	start, depth := i, 0
+	for ; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) {
+			i++
+			continue
+		}
+		if c <= ' ' {
+			break
+		}
+		if c == '(' {
+			if depth++; depth > maxLinkParens {
+				return "", 0, false
+			}
+		}
+		if c == ')' {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+	}
+	return s[start:i], i, depth == 0
+}
+
+func (p *inlineParser) linkTitle(i int) (string, int, bool) {
+	s := p.s
+	closing := s[i]
+	if closing == '(' {
+		closing = ')'
+	}
+	if p.unclosedTitle[closing] {
+		return "", 0, false
+	}
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case closing:
+			return s[i+1 : j], j + 1, true
+		}
+	}
+	p.unclosedTitle[closing] = true
+	return "", 0, false
+}
+
+// unescape drops the backslash from backslash escapes.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// autolink renders <https://…> or <name@example.com> at the start of s,
+// or the < as text.
+func autolink(s string) (string, int) {
+	if m := uriAutolink.FindStringSubmatch(s); m != nil && safeURL(m[1]) {
+		return `<a href="` + escape(m[1]) + `">` + escape(m[1]) + "</a>", len(m[0])
+	}
+	if m := emailAutolink.FindStringSubmatch(s); m != nil {
+		return `<a href="mailto:` + escape(m[1]) + `">` + escape(m[1]) + "</a>", len(m[0])
+	}
+	return "&lt;", 1
+}
+
+// bareLink links a URL typed without brackets, leaving out punctuation
+// that more likely ends the sentence than the URL.
+func bareLink(s string) (string, int) {
+	url := bareURL.FindString(s)
+	opened, closed := strings.Count(url, "("), strings.Count(url, ")")
+	for url != "" {
+		last := url[len(url)-1]
+		if strings.IndexByte("?!.,:;*_~'\"", last) >= 0 || last == ')' && closed > opened {
+			if last == ')' {
+				closed--
+			}
+			url = url[:len(url)-1]
+			continue
+		}
+		break
+	}
+	scheme := strings.Index(url, "//") + 2
+	if strings.HasPrefix(strings.ToLower(url), "www.") {
+		scheme = 0
+	}
+	if len(url) <= scheme+1 || !strings.Contains(url[scheme:], ".") && scheme == 0 {
+		return "", 0
+	}
+
+	href := url
+	if scheme == 0 {
+		href = "http://" + url
+	}
+	return `<a href="` + escape(href) + `">` + escape(url) + "</a>", len(url)
+}
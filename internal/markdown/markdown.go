@@ -0,0 +1,455 @@
+// Package markdown turns what members write into HTML. It follows
+// GitHub's dialect as far as forum posts need it: headings, emphasis,
+// strikethrough, links and images, bare URLs, lists, block quotes, tables
+// and fenced code with highlighting. Raw HTML is shown as text, and the
+// result passes through Sanitize before anyone sees it.
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version changes whenever Render gives different output for the same
+// source, so that HTML cached from an older version is rendered again.
+const Version = 2
+
+// Render returns the sanitized HTML for src.
+func Render(src string) string {
+	src = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\x00", "�").Replace(src)
+	lines := strings.Split(src, "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+	var b strings.Builder
+	blocks(&b, lines, false, 0)
+	return Sanitize(b.String())
+}
+
+var (
+	atxHeading      = regexp.MustCompile(`^ {0,3}(#{1,6})(?: +(.*?))??(?: +#+)? *$`)
+	thematicBreak   = regexp.MustCompile(`^ {0,3}(?:(?:- *){3,}|(?:\* *){3,}|(?:_ *){3,})$`)
+	setextUnderline = regexp.MustCompile(`^ {0,3}(=+|-+) *$`)
+	tableDelimiter  = regexp.MustCompile(`^ *\|? *:?-+:? *(?:\| *:?-+:? *)*\|? *$`)
+	languageName    = regexp.MustCompile(`^[A-Za-z0-9_+#-]+$`)
+)
+
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	for _, r := range line {
+		if r == '\t' {
+			b.WriteString(strings.Repeat(" ", 4-b.Len()%4))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// maxNesting bounds how deeply lists and block quotes nest. Every level
+// reads its lines again, so deeper markers are left as text.
+const maxNesting = 16
+
+// blocks renders lines as a sequence of blocks. In a tight list item
+// paragraphs go without <p>. depth counts the lists and quotes around lines.
+func blocks(b *strings.Builder, lines []string, tight bool, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+		case isFenceStart(line):
+			i = fencedCode(b, lines, i)
+		case indentOf(line) >= 4:
+			i = indentedCode(b, lines, i)
+		case atxHeading.MatchString(line):
+			m := atxHeading.FindStringSubmatch(line)
+			heading(b, len(m[1]), m[2])
+			i++
+		case thematicBreak.MatchString(line):
+			b.WriteString("<hr>")
+			i++
+		case isQuote(line) && depth < maxNesting:
+			i = blockquote(b, lines, i, depth)
+		case isListItem(line) && depth < maxNesting:
+			i = list(b, lines, i, depth)
+		case isTableStart(lines, i):
+			i = table(b, lines, i)
+		default:
+			i = paragraph(b, lines, i, tight)
+		}
+	}
+}
+
+// startsBlock reports whether line ends a paragraph by starting another
+// block.
+func startsBlock(line string) bool {
+	if isFenceStart(line) || atxHeading.MatchString(line) || thematicBreak.MatchString(line) || isQuote(line) {
+		return true
+	}
+	// Only a list that starts at 1 and has content may interrupt a
+	// paragraph, so a sentence may wrap onto a line like "2024. was".
+	m, content, ok := parseListMarker(line)
+	return ok && !isBlank(content) && (!m.ordered || m.start == 1)
+}
+
+func heading(b *strings.Builder, level int, text string) {
+	tag := "h" + strconv.Itoa(level)
+	b.WriteString("<" + tag + ">")
+	inline(b, strings.TrimSpace(text))
+	b.WriteString("</" + tag + ">")
+}
+
+func paragraph(b *strings.Builder, lines []string, i int, tight bool) int {
+	para := []string{strings.TrimSpace(lines[i])}
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		if isBlank(line) {
+			break
+		}
+		if m := setextUnderline.FindStringSubmatch(line); m != nil {
+			level := 2
+			if m[1][0] == '=' {
+				level = 1
+			}
+			heading(b, level, strings.Join(para, "\n"))
+			return i + 1
+		}
+		if startsBlock(line) {
+			break
+		}
+		para = append(para, strings.TrimSpace(line))
+	}
+
+	if !tight {
+		b.WriteString("<p>")
+	}
+	inline(b, strings.Join(para, "\n"))
+	if !tight {
+		b.WriteString("</p>")
+	}
+	return i
+}
+
+// Code blocks
+
+type fence struct {
+	indent int
+	marker string
+	info   string
+}
+
+func parseFence(line string) (fence, bool) {
+	indent := indentOf(line)
+	rest := line[indent:]
+	if indent > 3 || rest == "" || rest[0] != '`' && rest[0] != '~' {
+		return fence{}, false
+	}
+	n := len(rest) - len(strings.TrimLeft(rest, rest[:1]))
+	info := strings.TrimSpace(rest[n:])
+	if n < 3 || rest[0] == '`' && strings.Contains(info, "`") {
+		return fence{}, false
+	}
+	return fence{indent: indent, marker: rest[:n], info: info}, true
+}
+
+func isFenceStart(line string) bool {
+	_, ok := parseFence(line)
+	return ok
+}
+
+func fencedCode(b *strings.Builder, lines []string, i int) int {
+	open, _ := parseFence(lines[i])
+	var code strings.Builder
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		if indentOf(line) <= 3 && strings.HasPrefix(trimmed, open.marker) && strings.Trim(trimmed, open.marker[:1]) == "" {
+			i++
+			break
+		}
+		// Content loses as much indentation as the opening fence had.
+		code.WriteString(line[min(open.indent, indentOf(line)):])
+		code.WriteByte('\n')
+	}
+
+	language, _, _ := strings.Cut(open.info, " ")
+	codeBlock(b, language, code.String())
+	return i
+}
+
+func indentedCode(b *strings.Builder, lines []string, i int) int {
+	var code []string
+	for ; i < len(lines) && (isBlank(lines[i]) || indentOf(lines[i]) >= 4); i++ {
+		code = append(code, lines[i][min(4, len(lines[i])):])
+	}
+	for len(code) > 0 && isBlank(code[len(code)-1]) {
+		code = code[:len(code)-1]
+	}
+	codeBlock(b, "", strings.Join(code, "\n")+"\n")
+	return i
+}
+
+func codeBlock(b *strings.Builder, language, code string) {
+	if language != "" && languageName.MatchString(language) {
+		b.WriteString(`<pre><code class="language-` + strings.ToLower(language) + `">`)
+	} else {
+		language = ""
+		b.WriteString("<pre><code>")
+	}
+	b.WriteString(highlight(language, code))
+	b.WriteString("</code></pre>")
+}
+
+// Block quotes
+
+func quoteContent(line string) (string, bool) {
+	indent := indentOf(line)
+	if indent > 3 || !strings.HasPrefix(line[indent:], ">") {
+		return "", false
+	}
+	content := line[indent+1:]
+	return strings.TrimPrefix(content, " "), true
+}
+
+func isQuote(line string) bool {
+	_, ok := quoteContent(line)
+	return ok
+}
+
+func blockquote(b *strings.Builder, lines []string, i, depth int) int {
+	var inner []string
+	for ; i < len(lines); i++ {
+		if content, ok := quoteContent(lines[i]); ok {
+			inner = append(inner, content)
+			continue
+		}
+		// A paragraph inside the quote may go on without the marker.
+		if isBlank(lines[i]) || isBlank(inner[len(inner)-1]) || startsBlock(lines[i]) {
+			break
+		}
+		inner = append(inner, lines[i])
+	}
+	b.WriteString("<blockquote>")
+	blocks(b, inner, false, depth+1)
+	b.WriteString("</blockquote>")
+	return i
+}
+
+// Lists
+
+type listMarker struct {
+	ordered bool
+	start   int
+	// char is the bullet, or the . or ) after the number.
+	char byte
+	// width is the indentation of the item's content.
+	width int
+}
+
+func parseListMarker(line string) (listMarker, string, bool) {
+	indent := indentOf(line)
+	rest := line[indent:]
+	if indent > 3 || rest == "" {
+		return listMarker{}, "", false
+	}
+
+	var m listMarker
+	n := 0
+	switch {
+	case rest[0] == '-' || rest[0] == '*' || rest[0] == '+':
+		m.char, n = rest[0], 1
+	default:
+		for n < len(rest) && n < 9 && rest[n] >= '0' && rest[n] <= '9' {
+			n++
+		}
+		if n == 0 || n == len(rest) || rest[n] != '.' && rest[n] != ')' {
+			return listMarker{}, "", false
+		}
+		m.ordered, m.char = true, rest[n]
+		m.start, _ = strconv.Atoi(rest[:n])
+		n++
+	}
+
+	after := rest[n:]
+	if isBlank(after) {
+		m.width = indent + n + 1
+		return m, "", true
+	}
+	if after[0] != ' ' {
+		return listMarker{}, "", false
+	}
+	// Content indented further than that is an indented code block.
+	spaces := indentOf(after)
+	if spaces > 4 {
+		spaces = 1
+	}
+	m.width = indent + n + spaces
+	return m, line[m.width:], true
+}
+
+func isListItem(line string) bool {
+	_, _, ok := parseListMarker(line)
+	return ok
+}
+
+func list(b *strings.Builder, lines []string, i, depth int) int {
+	first, content, _ := parseListMarker(lines[i])
+	marker := first
+	items := [][]string{{content}}
+	loose := false
+
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		item := &items[len(items)-1]
+		previousBlank := isBlank((*item)[len(*item)-1])
+		switch m, content, ok := parseListMarker(line); {
+		case isBlank(line):
+			*item = append(*item, "")
+		case indentOf(line) >= marker.width:
+			*item = append(*item, line[marker.width:])
+		case ok && m.ordered == first.ordered && m.char == first.char && !thematicBreak.MatchString(line):
+			if previousBlank {
+				loose = true
+			}
+			items = append(items, []string{content})
+			marker = m
+		case !previousBlank && !startsBlock(line):
+			*item = append(*item, strings.TrimSpace(line))
+		default:
+			return renderList(b, first, items, loose, i, depth)
+		}
+	}
+	return renderList(b, first, items, loose, i, depth)
+}
+
+func renderList(b *strings.Builder, first listMarker, items [][]string, loose bool, end, depth int) int {
+	for i, item := range items {
+		for len(item) > 0 && isBlank(item[len(item)-1]) {
+			item = item[:len(item)-1]
+		}
+		items[i] = item
+		loose = loose || hasInnerBlank(item)
+	}
+
+	tag := "ul"
+	if first.ordered {
+		tag = "ol"
+	}
+	b.WriteString("<" + tag)
+	if first.ordered && first.start != 1 {
+		b.WriteString(` start="` + strconv.Itoa(first.start) + `"`)
+	}
+	b.WriteString(">")
+	for _, item := range items {
+		b.WriteString("<li>")
+		blocks(b, item, !loose, depth+1)
+		b.WriteString("</li>")
+	}
+	b.WriteString("</" + tag + ">")
+	return end
+}
+
+// hasInnerBlank reports a blank line between two blocks of a list item,
+// which makes the list loose. Blank lines in fenced code do not count.
+func hasInnerBlank(item []string) bool {
+	var open *fence
+	for _, line := range item {
+		switch f, ok := parseFence(line); {
+		case open != nil:
+			if ok && strings.HasPrefix(f.marker, open.marker) && f.info == "" {
+				open = nil
+			}
+		case ok:
+			open = &f
+		case isBlank(line):
+			return true
+		}
+	}
+	return false
+}
+
+// Tables
+
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '|':
+			cells = append(cells, strings.TrimSpace(line[start:i]))
+			start = i + 1
+		}
+	}
+	return append(cells, strings.TrimSpace(line[start:]))
+}
+
+func isTableStart(lines []string, i int) bool {
+	return i+1 < len(lines) && strings.Contains(lines[i], "|") && tableDelimiter.MatchString(lines[i+1]) &&
+		len(splitRow(lines[i])) == len(splitRow(lines[i+1]))
+}
+
+func table(b *strings.Builder, lines []string, i int) int {
+	header := splitRow(lines[i])
+	aligns := make([]string, len(header))
+	for j, cell := range splitRow(lines[i+1]) {
+		switch left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":"); {
+		case left && right:
+			aligns[j] = "center"
+		case right:
+			aligns[j] = "right"
+		case left:
+			aligns[j] = "left"
+		}
+	}
+
+	row := func(tag string, cells []string) {
+		b.WriteString("<tr>")
+		for j := range header {
+			b.WriteString("<" + tag)
+			if aligns[j] != "" {
+				b.WriteString(` class="align-` + aligns[j] + `"`)
+			}
+			b.WriteString(">")
+			if j < len(cells) {
+				inline(b, cells[j])
+			}
+			b.WriteString("</" + tag + ">")
+		}
+		b.WriteString("</tr>")
+	}
+
+	b.WriteString("<table><thead>")
+	row("th", header)
+	b.WriteString("</thead>")
+	i += 2
+	if i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]) {
+		b.WriteString("<tbody>")
+		for ; i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]); i++ {
+			row("td", splitRow(lines[i]))
+		}
+		b.WriteString("</tbody>")
+	}
+	b.WriteString("</table>")
+	return i
+}
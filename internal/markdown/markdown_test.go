@@ -0,0 +1,88 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"paragraphs", "one\r\ntwo\n\nthree", "<p>one<br>two</p><p>three</p>"},
+		{"emphasis", "**bold** *it* ***both*** ~~gone~~ snake_case_name a\\*b", "<p><strong>bold</strong> <em>it</em> <em><strong>both</strong></em> <del>gone</del> snake_case_name a*b</p>"},
+		{"raw html", "<script>alert(1)</script> `<b>`", "<p>&lt;script&gt;alert(1)&lt;/script&gt; <code>&lt;b&gt;</code></p>"},
+		{"headings", "# One #\nTwo\n---", "<h1>One</h1><h2>Two</h2>"},
+		{"tight list", "- a\n- b\n  - c\n\n3. x\n4. y", "<ul><li>a</li><li>b<ul><li>c</li></ul></li></ul><ol start=\"3\"><li>x</li><li>y</li></ol>"},
+		{"loose list", "- a\n\n- b", "<ul><li><p>a</p></li><li><p>b</p></li></ul>"},
+		{"quote", "> quoted\nlazy\n\nafter", "<blockquote><p>quoted<br>lazy</p></blockquote><p>after</p>"},
+		{"fenced code", "```go\nreturn \"x\" // 1\n```", "<pre><code class=\"language-go\"><span class=\"hl-keyword\">return</span> <span class=\"hl-string\">&#34;x&#34;</span> <span class=\"hl-comment\">// 1</span>\n</code></pre>"},
+		{"unknown language", "~~~brainfuck\n<+>\n~~~", "<pre><code class=\"language-brainfuck\">&lt;+&gt;\n</code></pre>"},
+		{"indented code", "    a < b", "<pre><code>a &lt; b\n</code></pre>"},
+		{"table", "| a | b |\n|:-:|--|\n| 1 | 2 | 3 |", "<table><thead><tr><th class=\"align-center\">a</th><th>b</th></tr></thead><tbody><tr><td class=\"align-center\">1</td><td>2</td></tr></tbody></table>"},
+		{"links", "[x](https://x.org \"T\") ![i](/i.png)", "<p><a href=\"https://x.org\" title=\"T\" rel=\"nofollow noopener ugc\">x</a> <img src=\"/i.png\" alt=\"i\"></p>"},
+		{"autolinks", "see https://x.org/a_(b). www.go.dev <me@x.org>", "<p>see <a href=\"https://x.org/a_(b)\" rel=\"nofollow noopener ugc\">https://x.org/a_(b)</a>. <a href=\"http://www.go.dev\" rel=\"nofollow noopener ugc\">www.go.dev</a> <a href=\"mailto:me@x.org\" rel=\"nofollow noopener ugc\">me@x.org</a></p>"},
+		{"unsafe link", "[click](javascript:alert(1)) <javascript:alert(1)>", "<p>click &lt;javascript:alert(1)&gt;</p>"},
+		{"unsafe image", "![x](data:text/html,hi)", "<p>x</p>"},
+		{"link text", "[a *b* [c] `]`](u) *[d*](v)", "<p><a href=\"u\" rel=\"nofollow noopener ugc\">a <em>b</em> [c] <code>]</code></a> *<a href=\"v\" rel=\"nofollow noopener ugc\">d*</a></p>"},
+		{"nested links", "[[a](u)](v) [![i](s)](u)", "<p>[<a href=\"u\" rel=\"nofollow noopener ugc\">a</a>](v) <a href=\"u\" rel=\"nofollow noopener ugc\"><img src=\"s\" alt=\"i\"></a></p>"},
+		{"deep nesting", strings.Repeat("> ", maxNesting+1) + "x", strings.Repeat("<blockquote>", maxNesting) + "<p>&gt; x</p>" + strings.Repeat("</blockquote>", maxNesting)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Render(tt.src))
+		})
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{`<a href="javascript:alert(1)" onclick="x">x</a>`, `<a rel="nofollow noopener ugc">x</a>`},
+		{`<a href=" java&#x09;script:x">x</a>`, `<a rel="nofollow noopener ugc">x</a>`},
+		{`<a href="/t?a=1&amp;b=2" title='"hi"'>x</a>`, `<a href="/t?a=1&amp;b=2" title="&#34;hi&#34;" rel="nofollow noopener ugc">x</a>`},
+		{`<img src=x onerror=alert(1)><img src="mailto:x">`, `<img src="x"><img>`},
+		{`<script>alert(1)</script><style>*{}</style>ok`, `ok`},
+		{`<div><p><b>x</div>`, `<p>x</p>`},
+		{`<span class="hl-keyword">k</span><span class="evil">e</span>`, `<span class="hl-keyword">k</span><span>e</span>`},
+		{`a < b > c & d &amp; <!-- note -->e`, `a &lt; b &gt; c &amp; d &amp; e`},
+		{`<ul><li>open`, `<ul><li>open</li></ul>`},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Sanitize(tt.src), tt.src)
+	}
+}
+
+// TestRenderLinear feeds Render inputs that took quadratic time in earlier
+// versions. Each takes milliseconds now.
+func TestRenderLinear(t *testing.T) {
+	var lists, backticks strings.Builder
+	for i := 0; i < 2000; i++ {
+		lists.WriteString(strings.Repeat("  ", i) + "- a\n")
+	}
+	for i := 1; i < 1000; i++ {
+		backticks.WriteString(strings.Repeat("`", i) + "a")
+	}
+	tests := map[string]string{
+		"emphasis":     strings.Repeat("*a", 100000),
+		"brackets":     strings.Repeat("[", 100000),
+		"images":       strings.Repeat("![", 50000) + "a" + strings.Repeat("](b)", 50000),
+		"destinations": strings.Repeat("[a](x", 100000),
+		"titles":       strings.Repeat("[a](b '", 100000),
+		"backticks":    backticks.String(),
+		"lists":        lists.String(),
+		"markers":      strings.Repeat("- ", 100000) + "x",
+		"parentheses":  "http://x" + strings.Repeat(")", 100000),
+	}
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			Render(src)
+			assert.Less(t, time.Since(start), 5*time.Second)
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// allowed lists the elements Sanitize keeps and, for each, the attributes
+// they may carry. A nil value checks nothing beyond the name.
+var allowed = map[string]map[string]*regexp.Regexp{
+	"a":          {"href": nil, "title": nil},
+	"blockquote": {},
+	"br":         {},
+	"code":       {"class": regexp.MustCompile(`^language-[a-z0-9_+#-]+$`)},
+	"del":        {},
+	"em":         {},
+	"h1":         {},
+	"h2":         {},
+	"h3":         {},
+	"h4":         {},
+	"h5":         {},
+	"h6":         {},
+	"hr":         {},
+	"img":        {"src": nil, "alt": nil, "title": nil},
+	"li":         {},
+	"ol":         {"start": regexp.MustCompile(`^[0-9]{1,9}$`)},
+	"p":          {},
+	"pre":        {},
+	"span":       {"class": regexp.MustCompile(`^hl-(keyword|string|comment|number)$`)},
+	"strong":     {},
+	"table":      {},
+	"tbody":      {},
+	"td":         {"class": regexp.MustCompile(`^align-(left|center|right)$`)},
+	"th":         {"class": regexp.MustCompile(`^align-(left|center|right)$`)},
+	"thead":      {},
+	"tr":         {},
+	"ul":         {},
+}
+
+var voidElements = map[string]bool{"br": true, "hr": true, "img": true}
+
+// Elements whose content is dropped along with them.
+var rawTextElements = map[string]bool{
+	"script": true, "style": true, "textarea": true, "title": true, "xmp": true, "iframe": true,
+	"noembed": true, "noframes": true, "noscript": true, "template": true, "svg": true, "math": true,
+}
+
+var (
+	tagName   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*`)
+	attribute = regexp.MustCompile(`^[\s/]*([^\s"'>/=]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+)))?`)
+	entity    = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	urlScheme = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.-]*):`)
+)
+
+// Sanitize keeps the elements and attributes listed in allowed and drops
+// everything else, keeping the text of dropped elements except scripts
+// and the like. Links may only use http, https and mailto or be relative,
+// images only http and https, and links get rel="nofollow noopener ugc".
+// The result has every element closed.
+func Sanitize(s string) string {
+	var b strings.Builder
+	var open []string
+	for i := 0; i < len(s); {
+		if s[i] != '<' {
+			end := strings.IndexByte(s[i:], '<')
+			if end < 0 {
+				end = len(s) - i
+			}
+			writeText(&b, s[i:i+end])
+			i += end
+			continue
+		}
+
+		rest := s[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest[4:], "-->")
+			if end < 0 {
+				return closeAll(&b, open)
+			}
+			i += 4 + end + 3
+		case strings.HasPrefix(rest, "</"):
+			name := strings.ToLower(tagName.FindString(rest[2:]))
+			end := strings.IndexByte(rest, '>')
+			if name == "" || end < 0 {
+				b.WriteString("&lt;")
+				i++
+				continue
+			}
+			i += end + 1
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j] == name {
+					for k := len(open) - 1; k >= j; k-- {
+						b.WriteString("</" + open[k] + ">")
+					}
+					open = open[:j]
+					break
+				}
+			}
+		default:
+			name, attrs, n, ok := parseStartTag(rest)
+			if !ok {
+				b.WriteString("&lt;")
+				i++
+				continue
+			}
+			i += n
+			if rawTextElements[name] {
+				end := strings.Index(strings.ToLower(s[i:]), "</"+name)
+				if end < 0 {
+					return closeAll(&b, open)
+				}
+				i += end
+				continue
+			}
+			if _, ok := allowed[name]; !ok {
+				continue
+			}
+			b.WriteString("<" + name + attrs + ">")
+			if !voidElements[name] {
+				open = append(open, name)
+			}
+		}
+	}
+	return closeAll(&b, open)
+}
+
+// parseStartTag reads the tag at the start of s and returns its name, the
+// allowed attributes rendered, and its length.
+func parseStartTag(s string) (string, string, int, bool) {
+	name := tagName.FindString(s[1:])
+	if name == "" {
+		return "", "", 0, false
+	}
+	name = strings.ToLower(name)
+	permitted := allowed[name]
+
+	var attrs strings.Builder
+	i := 1 + len(name)
+	for {
+		rest := strings.TrimLeft(s[i:], " \t\n\r\f/")
+		i = len(s) - len(rest)
+		if rest == "" {
+			return "", "", 0, false
+		}
+		if rest[0] == '>' {
+			i++
+			break
+		}
+		m := attribute.FindStringSubmatch(rest)
+		if m == nil {
+			return "", "", 0, false
+		}
+		i += len(m[0])
+
+		key := strings.ToLower(m[1])
+		value := html.UnescapeString(m[2] + m[3] + m[4])
+		pattern, ok := permitted[key]
+		switch {
+		case !ok:
+			continue
+		case pattern != nil && !pattern.MatchString(value):
+			continue
+		case key == "href" && !safeURL(value):
+			continue
+		case key == "src" && !safeImageURL(value):
+			continue
+		}
+		attrs.WriteString(" " + key + `="` + html.EscapeString(value) + `"`)
+	}
+	if name == "a" {
+		attrs.WriteString(` rel="nofollow noopener ugc"`)
+	}
+	return name, attrs.String(), i, true
+}
+
+// writeText copies text, escaping what could be taken for markup but
+// keeping character references.
+func writeText(b *strings.Builder, text string) {
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '&' && entity.MatchString(text[i:]):
+			b.WriteByte('&')
+		case c == '&':
+			b.WriteString("&amp;")
+		case c == '>':
+			b.WriteString("&gt;")
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func closeAll(b *strings.Builder, open []string) string {
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return b.String()
+}
+
+// safeURL accepts relative URLs and those with the http, https or mailto
+// scheme.
+func safeURL(url string) bool {
+	// Browsers ignore control characters and spaces in a scheme.
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, url)
+	m := urlScheme.FindStringSubmatch(cleaned)
+	if m == nil {
+		return true
+	}
+	switch strings.ToLower(m[1]) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+func safeImageURL(url string) bool {
+	return safeURL(url) && !strings.HasPrefix(strings.ToLower(strings.TrimSpace(url)), "mailto:")
+}
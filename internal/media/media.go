@@ -0,0 +1,199 @@
+// Package media inspects uploaded files. It tells their type from their
+// content rather than from what the browser claims, and re-encodes
+// images, which drops EXIF and any other metadata, and makes thumbnails.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime"
+	"net/http"
+)
+
+// MaxPixels bounds the size of images that are decoded, so a small file
+// claiming huge dimensions cannot exhaust memory.
+const MaxPixels = 40_000_000
+
+var (
+	ErrNotImage      = errors.New("file is not a readable image")
+	ErrImageTooLarge = errors.New("image has too many pixels")
+)
+
+// ContentType sniffs the media type of data, without parameters such as
+// the charset.
+func ContentType(data []byte) string {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+// IsImage reports whether Process handles the media type.
+func IsImage(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Image is an uploaded image prepared for storage.
+type Image struct {
+	Data          []byte
+	Width, Height int
+	Thumbnail     []byte
+	// ThumbnailType is image/jpeg for photos and image/png otherwise,
+	// which keeps transparency.
+	ThumbnailType string
+}
+
+// Process re-encodes the JPEG, PNG or GIF image in data, turned upright
+// as its EXIF orientation says, and makes a thumbnail no wider or taller
+// than thumbnailSize.
+func Process(data []byte, contentType string, thumbnailSize int) (*Image, error) {
+	img, err := decode(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: 90})
+	case "image/png":
+		err = png.Encode(&out, img)
+	case "image/gif":
+		// Every frame is kept, so animations still play.
+		var animation *gif.GIF
+		animation, err = gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(animation.Image) == 0 {
+			return nil, ErrNotImage
+		}
+		if animation.Config.Width*animation.Config.Height*len(animation.Image) > MaxPixels {
+			return nil, ErrImageTooLarge
+		}
+		err = gif.EncodeAll(&out, &gif.GIF{
+			Image:           animation.Image,
+			Delay:           animation.Delay,
+			LoopCount:       animation.LoopCount,
+			Disposal:        animation.Disposal,
+			Config:          animation.Config,
+			BackgroundIndex: animation.BackgroundIndex,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Image{Data: out.Bytes(), Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+	var thumbnail bytes.Buffer
+	small := shrink(img, thumbnailSize)
+	if contentType == "image/jpeg" {
+		result.ThumbnailType = "image/jpeg"
+		err = jpeg.Encode(&thumbnail, small, &jpeg.Options{Quality: 85})
+	} else {
+		result.ThumbnailType = "image/png"
+		err = png.Encode(&thumbnail, small)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Thumbnail = thumbnail.Bytes()
+	return result, nil
+}
+
+// Avatar crops the middle square out of the JPEG, PNG or GIF image in
+// data and scales it down to size×size, as a PNG.
+func Avatar(data []byte, contentType string, size int) ([]byte, error) {
+	img, err := decode(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2), draw.Src)
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, shrink(square, size)); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// decode reads the JPEG, PNG or GIF image in data, turned upright as its
+// EXIF orientation says. Of a GIF only the first frame is read.
+func decode(data []byte, contentType string) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrNotImage
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	var img image.Image
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		if err == nil {
+			img = orient(img, jpegOrientation(data))
+		}
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		img, err = gif.Decode(bytes.NewReader(data))
+	default:
+		return nil, ErrNotImage
+	}
+	if err != nil {
+		return nil, ErrNotImage
+	}
+	return img, nil
+}
+
+// shrink scales img down to fit in a size×size square, averaging the
+// pixels each thumbnail pixel covers. Smaller images are returned as they
+// are.
+func shrink(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	tw, th = max(tw, 1), max(th, 1)
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, max((x+1)*w/tw, x*w/tw+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			for c := 0; c < 4; c++ {
+				dst.Pix[y*dst.Stride+x*4+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
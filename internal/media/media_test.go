@@ -0,0 +1,104 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 10), uint8(y * 10), 0, 255})
+		}
+	}
+	return img
+}
+
+// withOrientation inserts an EXIF segment with the given orientation
+// after the start of a JPEG.
+func withOrientation(jpg []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry[0:], 0x0112)
+	binary.BigEndian.PutUint16(entry[2:], 3)
+	binary.BigEndian.PutUint32(entry[4:], 1)
+	binary.BigEndian.PutUint16(entry[8:], orientation)
+	segment := append(append([]byte("Exif\x00\x00"), tiff...), append(entry, 0, 0, 0, 0)...)
+
+	header := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+	out := append([]byte{}, jpg[:2]...)
+	out = append(append(out, header...), segment...)
+	return append(out, jpg[2:]...)
+}
+
+func TestProcessJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, testImage(40, 20), nil))
+	data := withOrientation(buf.Bytes(), 6)
+	assert.Equal(t, 6, jpegOrientation(data))
+	assert.Equal(t, "image/jpeg", ContentType(data))
+
+	img, err := Process(data, "image/jpeg", 10)
+	require.NoError(t, err)
+	assert.Equal(t, [2]int{20, 40}, [2]int{img.Width, img.Height}, "turned upright")
+	assert.NotContains(t, string(img.Data), "Exif")
+	assert.Equal(t, 1, jpegOrientation(img.Data))
+
+	thumbnail, err := jpeg.Decode(bytes.NewReader(img.Thumbnail))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 5, 10), thumbnail.Bounds())
+	assert.Equal(t, "image/jpeg", img.ThumbnailType)
+}
+
+func TestProcessPNG(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, testImage(8, 4)))
+	img, err := Process(buf.Bytes(), "image/png", 320)
+	require.NoError(t, err)
+	assert.Equal(t, [2]int{8, 4}, [2]int{img.Width, img.Height})
+	assert.Equal(t, "image/png", img.ThumbnailType)
+
+	_, err = Process([]byte("not an image"), "image/gif", 320)
+	assert.ErrorIs(t, err, ErrNotImage)
+}
+
+func TestContentType(t *testing.T) {
+	assert.Equal(t, "text/plain", ContentType([]byte("just some notes")))
+	assert.Equal(t, "application/pdf", ContentType([]byte("%PDF-1.7\n")))
+	assert.Equal(t, "text/html", ContentType([]byte("<html><script>alert(1)</script>")))
+}
+
+func TestAvatar(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, testImage(30, 12)))
+	data, err := Avatar(buf.Bytes(), "image/png", 8)
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 8, 8), img.Bounds())
+}
+
+func TestIdenticon(t *testing.T) {
+	a, err := Identicon("1", 50)
+	require.NoError(t, err)
+	again, err := Identicon("1", 50)
+	require.NoError(t, err)
+	b, err := Identicon("2", 50)
+	require.NoError(t, err)
+	assert.Equal(t, a, again)
+	assert.NotEqual(t, a, b)
+
+	img, err := png.Decode(bytes.NewReader(a))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 50, 50), img.Bounds())
+}
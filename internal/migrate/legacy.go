@@ -0,0 +1,88 @@
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal/database"
+	"log"
+)
+
+type legacyColumn struct {
+	table      string
+	column     string
+	definition string
+	backfill   string
+}
+
+// legacyColumns are the columns that were added to existing databases by
+// ALTER TABLE before migrations existed. This list is frozen: new columns
+// belong in a migration.
+var legacyColumns = []legacyColumn{
+	{"users", "is_banned", "BOOLEAN DEFAULT FALSE", ""},
+	{"categories", "slug", "TEXT", "UPDATE categories SET slug = lower(replace(trim(name), ' ', '-')) WHERE slug IS NULL"},
+	{"categories", "description", "TEXT NOT NULL DEFAULT ''", ""},
+	{"categories", "sort_order", "INTEGER NOT NULL DEFAULT 0", "UPDATE categories SET sort_order = id"},
+	{"categories", "archived", "BOOLEAN NOT NULL DEFAULT FALSE", ""},
+	{"categories", "created", "DATETIME", "UPDATE categories SET created = CURRENT_TIMESTAMP WHERE created IS NULL"},
+	{"users", "role_id", "INTEGER REFERENCES roles(id)", ""},
+	{"posts", "updated", "DATETIME", ""},
+	{"posts", "deleted", "DATETIME", ""},
+	{"posts", "deleted_by", "INTEGER", ""},
+	{"comments", "parent_id", "INTEGER REFERENCES comments(id) ON DELETE CASCADE", ""},
+	{"comments", "depth", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"comments", "updated", "DATETIME", ""},
+	{"comments", "deleted", "DATETIME", ""},
+	{"comments", "removed_by", "INTEGER REFERENCES users(id)", ""},
+	{"comments", "removal_reason", "TEXT", ""},
+}
+
+// adoptLegacySchema adds the columns a SQLite database created by the old
+// init.sql may lack, so the initial migration's CREATE TABLE IF NOT EXISTS
+// statements leave it in the same shape as a fresh one.
+func adoptLegacySchema(db *database.DB) error {
+	for _, c := range legacyColumns {
+		exists, hasColumn, err := tableHasColumn(db, c.table, c.column)
+		if err != nil {
+			return err
+		}
+		if !exists || hasColumn {
+			continue
+		}
+
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition))
+		if err != nil {
+			return fmt.Errorf("failed to add %s.%s: %v", c.table, c.column, err)
+		}
+		if c.backfill != "" {
+			_, err = db.Exec(c.backfill)
+			if err != nil {
+				return fmt.Errorf("failed to backfill %s.%s: %v", c.table, c.column, err)
+			}
+		}
+		log.Printf("Added column %s.%s", c.table, c.column)
+	}
+	return nil
+}
+
+func tableHasColumn(db *database.DB, table, column string) (bool, bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, false, err
+	}
+	defer rows.Close()
+
+	exists, found := false, false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, false, err
+		}
+		exists = true
+		if name == column {
+			found = true
+		}
+	}
+	return exists, found, rows.Err()
+}
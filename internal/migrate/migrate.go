@@ -0,0 +1,416 @@
+// Package migrate applies the numbered SQL migrations in
+// internal/database/migrations and records them in schema_migrations.
+//
+// Every backend has its own directory of migrations, named after its
+// driver. Every migration is a pair of files, NNNN_name.up.sql and
+// NNNN_name.down.sql. A first line of "-- requires: <module>" marks a
+// migration that needs an optional SQLite module such as fts5, or a
+// PostgreSQL extension; when it is missing the migration is recorded as
+// skipped and applied by a later run that has it.
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"forum/internal/database"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSchemaNewer     = errors.New("database schema is newer than this build")
+	ErrChecksum        = errors.New("applied migration has been modified")
+	ErrUnknownVersion  = errors.New("unknown migration version")
+	ErrMissingDownFile = errors.New("migration has no down file")
+)
+
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+	Requires string
+}
+
+// Status describes one migration as seen by the database.
+type Status struct {
+	*Migration
+	Applied   bool
+	Skipped   bool
+	Changed   bool
+	AppliedAt time.Time
+}
+
+type Migrator struct {
+	DB *database.DB
+	FS fs.FS
+}
+
+type record struct {
+	version  int
+	name     string
+	checksum string
+	applied  time.Time
+	skipped  bool
+}
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Load reads the migrations in fsys ordered by version.
+func Load(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has files with different names: %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+			m.Requires = requiredModule(m.Up)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d (%s) has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func requiredModule(sql string) string {
+	firstLine, _, _ := strings.Cut(sql, "\n")
+	module, ok := strings.CutPrefix(strings.TrimSpace(firstLine), "-- requires:")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(module)
+}
+
+// Latest is the highest version the migration files define.
+func Latest(migrations []*Migration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+func (m *Migrator) moduleAvailable(name string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM pragma_module_list WHERE name = ?)`
+	if m.DB.Dialect == database.Postgres {
+		query = `SELECT EXISTS(SELECT 1 FROM pg_available_extensions WHERE name = ?)`
+	}
+	var exists bool
+	err := m.DB.QueryRow(query, name).Scan(&exists)
+	return exists, err
+}
+
+func (m *Migrator) records() (map[int]record, error) {
+	records := map[int]record{}
+	exists, err := m.DB.TableExists("schema_migrations")
+	if err != nil || !exists {
+		return records, err
+	}
+
+	rows, err := m.DB.Query(`SELECT version, name, checksum, applied, skipped FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.version, &r.name, &r.checksum, &r.applied, &r.skipped); err != nil {
+			return nil, err
+		}
+		records[r.version] = r
+	}
+	return records, rows.Err()
+}
+
+// load reads the migration files and the applied records, and refuses to
+// go on when the database holds a migration this build does not know or
+// one whose file changed after it was applied.
+func (m *Migrator) load() ([]*Migration, map[int]record, error) {
+	migrations, err := Load(m.FS)
+	if err != nil {
+		return nil, nil, err
+	}
+	records, err := m.records()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	known := map[int]*Migration{}
+	for _, migration := range migrations {
+		known[migration.Version] = migration
+	}
+	for version, r := range records {
+		migration, ok := known[version]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: version %d (%s) is applied but this build only knows migrations up to %d",
+				ErrSchemaNewer, version, r.name, Latest(migrations))
+		}
+		if migration.Checksum != r.checksum {
+			return nil, nil, fmt.Errorf("%w: %04d_%s", ErrChecksum, version, migration.Name)
+		}
+	}
+	return migrations, records, nil
+}
+
+// Check verifies the database against the migration files without
+// changing anything.
+func (m *Migrator) Check() error {
+	_, _, err := m.load()
+	return err
+}
+
+func (m *Migrator) Status() ([]Status, error) {
+	migrations, err := Load(m.FS)
+	if err != nil {
+		return nil, err
+	}
+	records, err := m.records()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(migrations))
+	for _, migration := range migrations {
+		s := Status{Migration: migration}
+		if r, ok := records[migration.Version]; ok {
+			s.Applied = !r.skipped
+			s.Skipped = r.skipped
+			s.Changed = r.checksum != migration.Checksum
+			s.AppliedAt = r.applied
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// Version is the highest applied or skipped migration, or 0.
+func (m *Migrator) Version() (int, error) {
+	records, err := m.records()
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for v := range records {
+		version = max(version, v)
+	}
+	return version, nil
+}
+
+// Up applies every pending migration, including skipped ones whose module
+// has become available.
+func (m *Migrator) Up() error {
+	migrations, _, err := m.load()
+	if err != nil {
+		return err
+	}
+	return m.To(Latest(migrations))
+}
+
+// Down rolls back the given number of migrations, newest first.
+func (m *Migrator) Down(steps int) error {
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+	migrations, _, err := m.load()
+	if err != nil {
+		return err
+	}
+
+	target := version
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		if migrations[i].Version > target {
+			continue
+		}
+		steps--
+		target = 0
+		if i > 0 {
+			target = migrations[i-1].Version
+		}
+	}
+	return m.To(target)
+}
+
+// To migrates up or down until version is the newest applied migration.
+// Version 0 rolls back everything.
+func (m *Migrator) To(version int) error {
+	migrations, records, err := m.load()
+	if err != nil {
+		return err
+	}
+	if version != 0 && !containsVersion(migrations, version) {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	if err := m.prepare(); err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if r, ok := records[migration.Version]; ok && migration.Version > version {
+			if err := m.revert(migration, r.skipped); err != nil {
+				return err
+			}
+		}
+	}
+	for _, migration := range migrations {
+		r, ok := records[migration.Version]
+		if migration.Version > version || (ok && !r.skipped) {
+			continue
+		}
+		if err := m.apply(migration, ok); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsVersion(migrations []*Migration, version int) bool {
+	for _, migration := range migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// prepare creates schema_migrations. A database made before migrations
+// existed has tables but no schema_migrations; it is first brought up to
+// the shape the initial migration expects.
+func (m *Migrator) prepare() error {
+	exists, err := m.DB.TableExists("schema_migrations")
+	if err != nil || exists {
+		return err
+	}
+	legacy, err := m.DB.TableExists("users")
+	if err != nil {
+		return err
+	}
+	if legacy && m.DB.Dialect == database.SQLite {
+		if err := adoptLegacySchema(m.DB); err != nil {
+			return fmt.Errorf("failed to adopt existing database: %w", err)
+		}
+	}
+
+	_, err = m.DB.Exec(`
+		CREATE TABLE schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied TIMESTAMP NOT NULL,
+			skipped BOOLEAN NOT NULL DEFAULT FALSE
+		)`)
+	return err
+}
+
+// apply runs one up migration in a transaction. retry is set when the
+// migration was skipped before and its record has to be replaced.
+func (m *Migrator) apply(migration *Migration, retry bool) error {
+	skip := false
+	if migration.Requires != "" {
+		available, err := m.moduleAvailable(migration.Requires)
+		if err != nil {
+			return err
+		}
+		if !available && retry {
+			return nil
+		}
+		skip = !available
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !skip {
+		if _, err := tx.Exec(migration.Up); err != nil {
+			return fmt.Errorf("migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+	}
+	_, err = tx.Exec(`
+		INSERT INTO schema_migrations (version, name, checksum, applied, skipped) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (version) DO UPDATE SET applied = excluded.applied, skipped = excluded.skipped`,
+		migration.Version, migration.Name, migration.Checksum, time.Now().UTC(), skip)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if skip {
+		log.Printf("Skipped migration %04d_%s: %s is not available", migration.Version, migration.Name, migration.Requires)
+	} else {
+		log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+	}
+	return nil
+}
+
+func (m *Migrator) revert(migration *Migration, skipped bool) error {
+	if migration.Down == "" && !skipped {
+		return fmt.Errorf("%w: %04d_%s", ErrMissingDownFile, migration.Version, migration.Name)
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !skipped {
+		if _, err := tx.Exec(migration.Down); err != nil {
+			return fmt.Errorf("rollback of %04d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, migration.Version); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("Rolled back migration %04d_%s", migration.Version, migration.Name)
+	return nil
+}
@@ -0,0 +1,148 @@
+package migrate
+
+import (
+	"database/sql"
+	"forum/internal/database"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.Open(database.SQLite, "file:"+t.Name()+"?mode=memory&cache=shared")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"0001_things.up.sql":     {Data: []byte("CREATE TABLE things (id INTEGER PRIMARY KEY);")},
+		"0001_things.down.sql":   {Data: []byte("DROP TABLE things;")},
+		"0002_names.up.sql":      {Data: []byte("ALTER TABLE things ADD COLUMN name TEXT;")},
+		"0002_names.down.sql":    {Data: []byte("ALTER TABLE things DROP COLUMN name;")},
+		"0003_optional.up.sql":   {Data: []byte("-- requires: nosuchmodule\nCREATE VIRTUAL TABLE v USING nosuchmodule(x);")},
+		"0003_optional.down.sql": {Data: []byte("DROP TABLE v;")},
+		"0004_more.up.sql":       {Data: []byte("CREATE TABLE more (id INTEGER PRIMARY KEY);")},
+		"0004_more.down.sql":     {Data: []byte("DROP TABLE more;")},
+		"README":                 {Data: []byte("not a migration")},
+	}
+}
+
+func TestUpDownAndTo(t *testing.T) {
+	db := openTestDB(t)
+	m := &Migrator{DB: db, FS: testMigrations()}
+
+	require.NoError(t, m.Up())
+	version, err := m.Version()
+	require.NoError(t, err)
+	assert.Equal(t, 4, version)
+
+	statuses, err := m.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 4)
+	assert.True(t, statuses[1].Applied)
+	assert.True(t, statuses[2].Skipped, "a migration needing a missing module is skipped")
+	assert.Equal(t, "nosuchmodule", statuses[2].Requires)
+
+	require.NoError(t, m.Down(2))
+	version, err = m.Version()
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+
+	require.NoError(t, m.To(1))
+	_, err = db.Exec(`INSERT INTO things (name) VALUES ('x')`)
+	assert.Error(t, err, "the name column is rolled back")
+
+	require.NoError(t, m.To(0))
+	_, err = db.Exec(`SELECT 1 FROM things`)
+	assert.Error(t, err)
+
+	assert.ErrorIs(t, m.To(7), ErrUnknownVersion)
+}
+
+func TestChangedMigrationIsRejected(t *testing.T) {
+	db := openTestDB(t)
+	files := testMigrations()
+	require.NoError(t, (&Migrator{DB: db, FS: files}).Up())
+
+	files["0002_names.up.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE things ADD COLUMN title TEXT;")}
+	m := &Migrator{DB: db, FS: files}
+	assert.ErrorIs(t, m.Up(), ErrChecksum)
+
+	statuses, err := m.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[1].Changed)
+}
+
+func TestNewerSchemaIsRejected(t *testing.T) {
+	db := openTestDB(t)
+	require.NoError(t, (&Migrator{DB: db, FS: testMigrations()}).Up())
+
+	older := testMigrations()
+	delete(older, "0004_more.up.sql")
+	delete(older, "0004_more.down.sql")
+	m := &Migrator{DB: db, FS: older}
+	assert.ErrorIs(t, m.Check(), ErrSchemaNewer)
+	assert.ErrorIs(t, m.Up(), ErrSchemaNewer)
+	assert.ErrorIs(t, m.Down(1), ErrSchemaNewer)
+}
+
+func TestForumMigrationsRoundTrip(t *testing.T) {
+	db := openTestDB(t)
+	m := &Migrator{DB: db, FS: os.DirFS("../database/migrations/sqlite3")}
+
+	require.NoError(t, m.Up())
+	require.NoError(t, m.To(0))
+
+	var tables int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence')`).Scan(&tables))
+	assert.Zero(t, tables, "rolling back to 0 drops every table")
+
+	require.NoError(t, m.Up())
+	_, err := db.Exec(`INSERT INTO users (username, email, password, is_banned) VALUES ('a', 'a@example.com', 'x', FALSE)`)
+	assert.NoError(t, err, "a fresh database has the is_banned column")
+}
+
+func TestLegacyDatabaseIsAdopted(t *testing.T) {
+	db := openTestDB(t)
+	_, err := db.Exec(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, username TEXT NOT NULL UNIQUE, email TEXT NOT NULL UNIQUE, password TEXT NOT NULL);
+		CREATE TABLE categories (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL);
+		INSERT INTO users (username, email, password) VALUES ('Admin', 'admin@gmail.com', 'x');
+		INSERT INTO categories (name) VALUES ('Game Dev');`)
+	require.NoError(t, err)
+
+	m := &Migrator{DB: db, FS: os.DirFS("../database/migrations/sqlite3")}
+	require.NoError(t, m.Up())
+
+	var banned bool
+	var roleID sql.NullInt64
+	err = db.QueryRow(`SELECT is_banned, role_id FROM users WHERE email = 'admin@gmail.com'`).Scan(&banned, &roleID)
+	require.NoError(t, err)
+	assert.False(t, banned)
+	assert.False(t, roleID.Valid, "no account is made an admin by migrating; see forum admin grant")
+
+	var slug string
+	require.NoError(t, db.QueryRow(`SELECT slug FROM categories WHERE name = 'Game Dev'`).Scan(&slug))
+	assert.Equal(t, "game-dev", slug)
+}
+
+func TestDialectsDefineSameVersions(t *testing.T) {
+	sqlite, err := Load(os.DirFS("../database/migrations/sqlite3"))
+	require.NoError(t, err)
+	postgres, err := Load(os.DirFS("../database/migrations/postgres"))
+	require.NoError(t, err)
+
+	require.Len(t, postgres, len(sqlite))
+	for i := range sqlite {
+		assert.Equal(t, sqlite[i].Version, postgres[i].Version)
+		assert.Equal(t, sqlite[i].Name, postgres[i].Name)
+		assert.NotEmpty(t, postgres[i].Down)
+	}
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"forum/internal/database"
+	"strconv"
+	"time"
+)
+
+// Attachment is a file uploaded with a post. Its bytes live in blob
+// storage under StorageKey. Images also have a thumbnail under
+// ThumbnailKey; other files leave it empty.
+type Attachment struct {
+	ID           int       `json:"id"`
+	PostID       int       `json:"post_id"`
+	UserID       int       `json:"user_id"`
+	Filename     string    `json:"filename"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	StorageKey   string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+func (a *Attachment) IsImage() bool {
+	return a.ThumbnailKey != ""
+}
+
+// URL is where the attachment is served.
+func (a *Attachment) URL() string {
+	return "/attachments/" + strconv.Itoa(a.ID)
+}
+
+// ThumbnailType is the media type of the thumbnail: JPEG for photos, PNG
+// for everything else so transparency survives.
+func (a *Attachment) ThumbnailType() string {
+	if a.ContentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+type AttachmentModel struct {
+	DB *database.DB
+}
+
+const attachmentColumns = `id, post_id, user_id, filename, content_type, size, storage_key, thumbnail_key, width, height, created`
+
+func scanAttachment(scanner interface{ Scan(...any) error }) (*Attachment, error) {
+	a := &Attachment{}
+	err := scanner.Scan(&a.ID, &a.PostID, &a.UserID, &a.Filename, &a.ContentType, &a.Size, &a.StorageKey, &a.ThumbnailKey,
+		&a.Width, &a.Height, &a.Created)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (m *AttachmentModel) Insert(a *Attachment) (int, error) {
+	a.Created = time.Now().In(Timezone)
+	stmt := `INSERT INTO attachments (post_id, user_id, filename, content_type, size, storage_key, thumbnail_key, width, height, created)
+             VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`
+	err := m.DB.QueryRow(stmt, a.PostID, a.UserID, a.Filename, a.ContentType, a.Size, a.StorageKey, a.ThumbnailKey,
+		a.Width, a.Height, a.Created).Scan(&a.ID)
+	return a.ID, err
+}
+
+func (m *AttachmentModel) Get(id int) (*Attachment, error) {
+	return scanAttachment(m.DB.QueryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE id = ?`, id))
+}
+
+// ByPostID returns a post's attachments in upload order.
+func (m *AttachmentModel) ByPostID(postID int) ([]*Attachment, error) {
+	rows, err := m.DB.Query(`SELECT `+attachmentColumns+` FROM attachments WHERE post_id = ? ORDER BY id`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []*Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
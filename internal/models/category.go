@@ -0,0 +1,180 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"forum/internal/database"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Category struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Slug        string    `json:"slug"`
+	Description string    `json:"description"`
+	SortOrder   int       `json:"sort_order"`
+	Archived    bool      `json:"archived"`
+	Created     time.Time `json:"created"`
+}
+
+type CategoryModel struct {
+	DB *database.DB
+}
+
+var (
+	ErrInvalidSlug   = errors.New("invalid category slug")
+	ErrDuplicateSlug = errors.New("category slug already exists")
+
+	slugPattern      = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+	nonSlugCharsExpr = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+func Slugify(name string) string {
+	slug := nonSlugCharsExpr.ReplaceAllString(strings.ToLower(name), "-")
+	return strings.Trim(slug, "-")
+}
+
+func ValidSlug(slug string) bool {
+	return len(slug) <= 64 && slugPattern.MatchString(slug)
+}
+
+const categoryColumns = `id, name, slug, description, sort_order, archived, created`
+
+func scanCategory(scanner interface{ Scan(...any) error }) (*Category, error) {
+	c := &Category{}
+	err := scanner.Scan(&c.ID, &c.Name, &c.Slug, &c.Description, &c.SortOrder, &c.Archived, &c.Created)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (m *CategoryModel) list(stmt string, args ...any) ([]*Category, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []*Category
+	for rows.Next() {
+		c, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+func (m *CategoryModel) Active() ([]*Category, error) {
+	return m.list(`SELECT ` + categoryColumns + ` FROM categories WHERE NOT archived ORDER BY sort_order, name`)
+}
+
+func (m *CategoryModel) All() ([]*Category, error) {
+	return m.list(`SELECT ` + categoryColumns + ` FROM categories ORDER BY archived, sort_order, name`)
+}
+
+func (m *CategoryModel) Get(id int) (*Category, error) {
+	return scanCategory(m.DB.QueryRow(`SELECT `+categoryColumns+` FROM categories WHERE id = ?`, id))
+}
+
+func (m *CategoryModel) GetBySlug(slug string) (*Category, error) {
+	return scanCategory(m.DB.QueryRow(`SELECT `+categoryColumns+` FROM categories WHERE slug = ?`, slug))
+}
+
+func (m *CategoryModel) slugTaken(slug string, exceptID int) (bool, error) {
+	var exists bool
+	err := m.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM categories WHERE slug = ? AND id != ?)`, slug, exceptID).Scan(&exists)
+	return exists, err
+}
+
+func (m *CategoryModel) Insert(name, slug, description string, sortOrder int) (int, error) {
+	if !ValidSlug(slug) {
+		return 0, ErrInvalidSlug
+	}
+	taken, err := m.slugTaken(slug, 0)
+	if err != nil {
+		return 0, err
+	}
+	if taken {
+		return 0, ErrDuplicateSlug
+	}
+
+	stmt := `INSERT INTO categories (name, slug, description, sort_order, archived, created) VALUES (?, ?, ?, ?, FALSE, ?) RETURNING id`
+	var id int
+	err = m.DB.QueryRow(stmt, name, slug, description, sortOrder, time.Now().In(Timezone)).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (m *CategoryModel) Update(id int, name, slug, description string, sortOrder int) error {
+	if !ValidSlug(slug) {
+		return ErrInvalidSlug
+	}
+	taken, err := m.slugTaken(slug, id)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrDuplicateSlug
+	}
+
+	stmt := `UPDATE categories SET name = ?, slug = ?, description = ?, sort_order = ? WHERE id = ?`
+	result, err := m.DB.Exec(stmt, name, slug, description, sortOrder, id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+func (m *CategoryModel) SetArchived(id int, archived bool) error {
+	result, err := m.DB.Exec(`UPDATE categories SET archived = ? WHERE id = ?`, archived, id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// FilterActive keeps only the IDs that belong to existing, non-archived
+// categories, so a forged form cannot attach posts to retired categories.
+func (m *CategoryModel) FilterActive(ids []int) ([]int, error) {
+	active, err := m.Active()
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[int]bool, len(active))
+	for _, c := range active {
+		allowed[c.ID] = true
+	}
+
+	var filtered []int
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if allowed[id] && !seen[id] {
+			seen[id] = true
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered, nil
+}
+
+func requireAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -2,37 +2,212 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"forum/internal/database"
+	"forum/internal/live"
+	"forum/internal/markdown"
+	"html/template"
 	"time"
 )
 
 type Comment struct {
-	ID       int       `json:"id"`
-	PostID   int       `json:"post_id"`
-	UserID   int       `json:"user_id"`
-	Created  time.Time `json:"created"`
-	Content  string    `json:"content"`
-	Username string    `json:"username"`
-	Likes    int       `json:"likes"`
-	Dislikes int       `json:"dislikes"`
-	UserVote int       `json:"user_vote"`
+	ID          int           `json:"id"`
+	PostID      int           `json:"post_id"`
+	ParentID    int           `json:"parent_id,omitempty"`
+	Depth       int           `json:"depth"`
+	UserID      int           `json:"user_id"`
+	Created     time.Time     `json:"created"`
+	Content     string        `json:"content"`
+	ContentHTML template.HTML `json:"content_html"`
+	Username    string        `json:"username"`
+	Likes       int           `json:"likes"`
+	Dislikes    int           `json:"dislikes"`
+	UserVote    int           `json:"user_vote"`
+	Updated     time.Time     `json:"updated"`
+	Edited      bool          `json:"edited"`
+	Deleted     bool          `json:"deleted"`
+	Removed     bool          `json:"removed"`
+	CanReply    bool          `json:"-"`
+	CanEdit     bool          `json:"-"`
+	CanDelete   bool          `json:"-"`
+	CanRemove   bool          `json:"-"`
+	Replies     []*Comment    `json:"replies,omitempty"`
+	// PostTitle is only filled where comments are listed away from their
+	// post.
+	PostTitle string `json:"post_title,omitempty"`
+
+	// RemovalReason is only meant for the author and moderators, so it is
+	// never serialized.
+	RemovalReason string `json:"-"`
 }
 
 type CommentModel struct {
-	DB *sql.DB
+	DB *database.DB
+	// Events tells open pages about new comments and votes.
+	Events *live.Hub
 }
 
-func (m *CommentModel) Insert(postID, userID int, content string) error {
-	stmt := `INSERT INTO comments (post_id, user_id, content, created) VALUES (?, ?, ?, ?)`
-	_, err := m.DB.Exec(stmt, postID, userID, content, time.Now().In(gmtPlus5))
-	return err
+// MaxCommentDepth is the deepest nesting level a reply may have; top-level
+// comments have depth 0.
+const MaxCommentDepth = 5
+
+// CommentEditWindow is how long after posting an author may still edit a
+// comment.
+var CommentEditWindow = 15 * time.Minute
+
+var (
+	ErrParentNotFound   = errors.New("parent comment not found on this post")
+	ErrCommentTooDeep   = errors.New("comment thread is nested too deeply")
+	ErrCommentGone      = errors.New("comment has been deleted or removed")
+	ErrEditWindowClosed = errors.New("comment edit window has closed")
+)
+
+func (m *CommentModel) Insert(postID, userID int, content string) (int, error) {
+	return m.insert(postID, 0, 0, userID, content)
 }
 
-func (m *CommentModel) GetByPostID(postID int, userID int) ([]*Comment, error) {
-	stmt := `SELECT c.id, c.post_id, c.user_id, c.created, c.content, u.username
+func (m *CommentModel) InsertReply(postID, parentID, userID int, content string) (int, error) {
+	var parentDepth int
+	var parentDeleted sql.NullTime
+	err := m.DB.QueryRow(`SELECT depth, deleted FROM comments WHERE id = ? AND post_id = ?`, parentID, postID).Scan(&parentDepth, &parentDeleted)
+	if err == sql.ErrNoRows {
+		return 0, ErrParentNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	if parentDeleted.Valid {
+		return 0, ErrCommentGone
+	}
+	if parentDepth+1 > MaxCommentDepth {
+		return 0, ErrCommentTooDeep
+	}
+
+	return m.insert(postID, parentID, parentDepth+1, userID, content)
+}
+
+// insert adds a comment, a reply when parentID is set, and notifies the
+// members it concerns.
+func (m *CommentModel) insert(postID, parentID, depth, userID int, content string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var parent any
+	if parentID != 0 {
+		parent = parentID
+	}
+	c := &Comment{
+		PostID:      postID,
+		ParentID:    parentID,
+		Depth:       depth,
+		UserID:      userID,
+		Created:     time.Now().In(Timezone),
+		Content:     content,
+		ContentHTML: template.HTML(markdown.Render(content)),
+	}
+	stmt := `INSERT INTO comments (post_id, parent_id, depth, user_id, content, content_html, html_version, created)
+             VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`
+	err = tx.QueryRow(stmt, postID, parent, depth, userID, content, string(c.ContentHTML), markdown.Version, c.Created).Scan(&c.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err := notifyNewComment(tx, c.ID, postID, parentID, userID, content); err != nil {
+		return 0, err
+	}
+
+	if err := tx.QueryRow(`SELECT username FROM users WHERE id = ?`, userID).Scan(&c.Username); err != nil {
+		return 0, err
+	}
+	count := CommentCountEvent{PostID: postID}
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM comments WHERE post_id = ? AND deleted IS NULL`, postID).Scan(&count.Comments); err != nil {
+		return 0, err
+	}
+	topics, err := listTopics(tx, postID)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	m.Events.Publish(EventComment, c, PostTopic(postID))
+	m.Events.Publish(EventCommentCount, count, topics...)
+	return c.ID, nil
+}
+
+// GetThreadByPostID returns the top-level comments of a post with their
+// replies nested under Replies.
+func (m *CommentModel) GetThreadByPostID(postID int, userID int) ([]*Comment, error) {
+	comments, err := m.GetByPostID(postID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return BuildCommentTree(comments), nil
+}
+
+// BuildCommentTree links a flat, chronologically ordered comment list into
+// a forest. Replies whose parent is missing are promoted to the top level so
+// they are never silently dropped.
+func BuildCommentTree(comments []*Comment) []*Comment {
+	byID := make(map[int]*Comment, len(comments))
+	for _, c := range comments {
+		c.Replies = nil
+		byID[c.ID] = c
+	}
+
+	var roots []*Comment
+	for _, c := range comments {
+		parent, ok := byID[c.ParentID]
+		if c.ParentID == 0 || !ok {
+			roots = append(roots, c)
+			continue
+		}
+		parent.Replies = append(parent.Replies, c)
+	}
+	return roots
+}
+
+const commentColumns = `c.id, c.post_id, COALESCE(c.parent_id, 0), c.depth, c.user_id, c.created, c.content, c.content_html, c.html_version,
+             u.username, c.updated, c.deleted, c.removed_by, COALESCE(c.removal_reason, '')`
+
+func scanComment(scanner interface{ Scan(...any) error }) (*Comment, error) {
+	c := &Comment{}
+	var cached string
+	var version int
+	var updated, deleted sql.NullTime
+	var removedBy sql.NullInt64
+	err := scanner.Scan(&c.ID, &c.PostID, &c.ParentID, &c.Depth, &c.UserID, &c.Created, &c.Content, &cached, &version,
+		&c.Username, &updated, &deleted, &removedBy, &c.RemovalReason)
+	if err != nil {
+		return nil, err
+	}
+	c.ContentHTML = contentHTML(c.Content, cached, version)
+	c.Edited, c.Updated = updated.Valid, updated.Time
+	c.Removed = removedBy.Valid
+	c.Deleted = deleted.Valid && !c.Removed
+	return c, nil
+}
+
+func (m *CommentModel) Get(commentID int) (*Comment, error) {
+	stmt := `SELECT ` + commentColumns + `
              FROM comments c
              JOIN users u ON c.user_id = u.id
-             WHERE c.post_id = ? ORDER BY c.created ASC`
-	rows, err := m.DB.Query(stmt, postID)
+             WHERE c.id = ?`
+	return scanComment(m.DB.QueryRow(stmt, commentID))
+}
+
+// RecentByUserID returns a member's newest visible comments on posts that
+// still exist, with the titles of those posts.
+func (m *CommentModel) RecentByUserID(userID, limit int) ([]*Comment, error) {
+	stmt := `SELECT ` + commentColumns + `, p.title
+             FROM comments c
+             JOIN users u ON c.user_id = u.id
+             JOIN posts p ON c.post_id = p.id
+             WHERE c.user_id = ? AND c.deleted IS NULL AND c.removed_by IS NULL AND p.deleted IS NULL
+             ORDER BY c.created DESC, c.id DESC
+             LIMIT ?`
+	rows, err := m.DB.Query(stmt, userID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -40,29 +215,112 @@ func (m *CommentModel) GetByPostID(postID int, userID int) ([]*Comment, error) {
 
 	var comments []*Comment
 	for rows.Next() {
-		c := &Comment{}
-		err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Created, &c.Content, &c.Username)
+		var title sql.NullString
+		c, err := scanComment(scanFunc(func(dest ...any) error {
+			return rows.Scan(append(dest, &title)...)
+		}))
 		if err != nil {
 			return nil, err
 		}
+		c.PostTitle = title.String
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// scanFunc lets a query that selects more than commentColumns use
+// scanComment.
+type scanFunc func(dest ...any) error
+
+func (f scanFunc) Scan(dest ...any) error {
+	return f(dest...)
+}
+
+func (c *Comment) Gone() bool {
+	return c.Deleted || c.Removed
+}
+
+// Editable reports whether the author is still inside the edit window.
+func (c *Comment) Editable(now time.Time) bool {
+	return !c.Gone() && now.Sub(c.Created) <= CommentEditWindow
+}
+
+func (m *CommentModel) Update(commentID int, content string) error {
+	c, err := m.Get(commentID)
+	if err != nil {
+		return err
+	}
+	if c.Gone() {
+		return ErrCommentGone
+	}
+	if !c.Editable(time.Now()) {
+		return ErrEditWindowClosed
+	}
 
-		c.Likes, c.Dislikes, err = m.GetLikesAndDislikes(c.ID)
+	_, err = m.DB.Exec(`UPDATE comments SET content = ?, content_html = ?, html_version = ?, updated = ? WHERE id = ?`,
+		content, markdown.Render(content), markdown.Version, time.Now().In(Timezone), commentID)
+	return err
+}
+
+// Delete blanks a comment on behalf of its author. The row stays so replies
+// keep their place in the thread.
+func (m *CommentModel) Delete(commentID int) error {
+	result, err := m.DB.Exec(`UPDATE comments SET content = '', content_html = '', deleted = ? WHERE id = ? AND deleted IS NULL`,
+		time.Now().In(Timezone), commentID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// Remove hides a comment on behalf of a moderator. The content is kept for
+// the author, who is shown the reason instead of the placeholder.
+func (m *CommentModel) Remove(commentID, moderatorID int, reason string) error {
+	result, err := m.DB.Exec(`UPDATE comments SET deleted = ?, removed_by = ?, removal_reason = ? WHERE id = ? AND deleted IS NULL`,
+		time.Now().In(Timezone), moderatorID, reason, commentID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// GetByPostID returns every comment on a post in the order they were made.
+// Vote tallies and the viewer's own vote come from correlated subqueries, as
+// in postListColumns, so a thread costs one round trip however long it is.
+func (m *CommentModel) GetByPostID(postID int, userID int) ([]*Comment, error) {
+	stmt := `SELECT ` + commentColumns + `,
+             (SELECT COUNT(*) FROM comment_votes cv WHERE cv.comment_id = c.id AND cv.vote_type = 1),
+             (SELECT COUNT(*) FROM comment_votes cv WHERE cv.comment_id = c.id AND cv.vote_type = -1),
+             COALESCE((SELECT cv.vote_type FROM comment_votes cv WHERE cv.comment_id = c.id AND cv.user_id = ?), 0)
+             FROM comments c
+             JOIN users u ON c.user_id = u.id
+             WHERE c.post_id = ? ORDER BY c.created ASC, c.id ASC`
+	rows, err := m.DB.Query(stmt, userID, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*Comment
+	for rows.Next() {
+		var likes, dislikes, vote int
+		c, err := scanComment(scanFunc(func(dest ...any) error {
+			return rows.Scan(append(dest, &likes, &dislikes, &vote)...)
+		}))
 		if err != nil {
 			return nil, err
 		}
-
-		if userID > 0 {
-			c.UserVote, _ = m.GetUserVote(c.ID, userID)
-		}
-
+		c.Likes, c.Dislikes, c.UserVote = likes, dislikes, vote
 		comments = append(comments, c)
 	}
-	return comments, nil
+	return comments, rows.Err()
 }
 
+// CountByPostID counts the comments on a post that are still shown. Removed
+// comments are marked deleted too, so they are left out as well.
 func (m *CommentModel) CountByPostID(postID int) (int, error) {
 	var count int
-	err := m.DB.QueryRow(`SELECT COUNT(*) FROM comments WHERE post_id = ?`, postID).Scan(&count)
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM comments WHERE post_id = ? AND deleted IS NULL`, postID).Scan(&count)
 	return count, err
 }
 
@@ -72,19 +330,53 @@ func (m *CommentModel) HasUserCommented(postID, userID int) (bool, error) {
 	return exists, err
 }
 
+// ToggleVote records a vote on a comment, or takes it back when the same
+// vote is cast again. A new like notifies the comment's author.
 func (m *CommentModel) ToggleVote(commentID, userID, voteType int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	var existingVote int
-	err := m.DB.QueryRow("SELECT vote_type FROM comment_votes WHERE comment_id = ? AND user_id = ?", commentID, userID).Scan(&existingVote)
-	if err == nil && existingVote == voteType {
-		_, err = m.DB.Exec("DELETE FROM comment_votes WHERE comment_id = ? AND user_id = ?", commentID, userID)
+	err = tx.QueryRow("SELECT vote_type FROM comment_votes WHERE comment_id = ? AND user_id = ?", commentID, userID).Scan(&existingVote)
+	switch {
+	case err == nil && existingVote == voteType:
+		_, err = tx.Exec("DELETE FROM comment_votes WHERE comment_id = ? AND user_id = ?", commentID, userID)
+	case err == nil:
+		_, err = tx.Exec("UPDATE comment_votes SET vote_type = ? WHERE comment_id = ? AND user_id = ?", voteType, commentID, userID)
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec("INSERT INTO comment_votes (comment_id, user_id, vote_type) VALUES (?, ?, ?)", commentID, userID, voteType)
+	}
+	if err != nil {
 		return err
 	}
-	if err == nil && existingVote != voteType {
-		_, err = m.DB.Exec("UPDATE comment_votes SET vote_type = ? WHERE comment_id = ? AND user_id = ?", voteType, commentID, userID)
+
+	if voteType == 1 && existingVote != 1 {
+		var postID, authorID int
+		err := tx.QueryRow("SELECT post_id, user_id FROM comments WHERE id = ?", commentID).Scan(&postID, &authorID)
+		if err != nil {
+			return err
+		}
+		if err := notifyLike(tx, authorID, userID, postID, commentID); err != nil {
+			return err
+		}
+	}
+
+	event := VotesEvent{CommentID: commentID}
+	if err := tx.QueryRow("SELECT post_id FROM comments WHERE id = ?", commentID).Scan(&event.PostID); err != nil {
 		return err
 	}
-	_, err = m.DB.Exec("INSERT INTO comment_votes (comment_id, user_id, vote_type) VALUES (?, ?, ?)", commentID, userID, voteType)
-	return err
+	event.Likes, event.Dislikes, err = countVotes(tx, "comment_votes", "comment_id", commentID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	m.Events.Publish(EventCommentVotes, event, PostTopic(event.PostID))
+	return nil
 }
 
 func (m *CommentModel) GetLikesAndDislikes(commentID int) (int, int, error) {
@@ -110,3 +402,8 @@ func (m *CommentModel) GetUserVote(commentID, userID int) (int, error) {
 	}
 	return voteType, nil
 }
+
+// RefreshHTML renders again the comments whose cached HTML is out of date.
+func (m *CommentModel) RefreshHTML() (int64, error) {
+	return refreshHTML(m.DB, "comments")
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"forum/internal/database"
+	"forum/internal/markdown"
+	"html/template"
+)
+
+// contentHTML returns the HTML cached for a post or comment, rendering
+// content again when the cache comes from an older markdown.Version.
+func contentHTML(content, cached string, version int) template.HTML {
+	if version != markdown.Version {
+		cached = markdown.Render(content)
+	}
+	return template.HTML(cached)
+}
+
+// refreshHTML renders again the cached HTML of every row in table, posts or
+// comments, that an older markdown.Version produced, and returns how many
+// rows it updated.
+func refreshHTML(db *database.DB, table string) (int64, error) {
+	rows, err := db.Query(`SELECT id, content FROM `+table+` WHERE html_version <> ?`, markdown.Version)
+	if err != nil {
+		return 0, err
+	}
+	stale := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stale[id] = content
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for id, content := range stale {
+		// The version check skips rows edited since they were read.
+		result, err := db.Exec(`UPDATE `+table+` SET content_html = ?, html_version = ? WHERE id = ? AND html_version <> ?`,
+			markdown.Render(content), markdown.Version, id, markdown.Version)
+		if err != nil {
+			return n, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return n, err
+		}
+		n += affected
+	}
+	return n, nil
+}
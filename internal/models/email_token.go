@@ -0,0 +1,131 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"forum/internal/database"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmailTokenPurpose says what a link sent by email does. A token made for
+// one purpose is refused for any other.
+type EmailTokenPurpose string
+
+const (
+	TokenResetPassword EmailTokenPurpose = "reset"
+	TokenVerifyEmail   EmailTokenPurpose = "verify"
+	TokenChangeEmail   EmailTokenPurpose = "email"
+)
+
+var (
+	// EmailTokenSecret signs the tokens. Changing it ends every link sent
+	// so far.
+	EmailTokenSecret []byte
+	// ResetTokenLifetime bounds password reset links; VerifyTokenLifetime
+	// bounds links confirming an address.
+	ResetTokenLifetime  = time.Hour
+	VerifyTokenLifetime = 48 * time.Hour
+)
+
+var (
+	ErrInvalidLink = errors.New("invalid or already used link")
+	ErrLinkExpired = errors.New("link expired")
+)
+
+// EmailToken is what a checked token vouches for. Email is the address
+// the link was sent to.
+type EmailToken struct {
+	Purpose EmailTokenPurpose
+	UserID  int
+	Email   string
+	Expiry  time.Time
+}
+
+func (t EmailToken) lifetime() time.Duration {
+	if t.Purpose == TokenResetPassword {
+		return ResetTokenLifetime
+	}
+	return VerifyTokenLifetime
+}
+
+// EmailTokenModel issues the tokens in password reset and confirmation
+// links. Nothing is stored: a token carries its purpose, user, address and
+// expiry, signed together with the account's current email, password hash
+// and verification. Using a link changes one of those, so each link works
+// only once, and a password or email change ends every older link.
+type EmailTokenModel struct {
+	DB *database.DB
+}
+
+func (m *EmailTokenModel) New(purpose EmailTokenPurpose, userID int, email string) (string, error) {
+	token := EmailToken{Purpose: purpose, UserID: userID, Email: email}
+	token.Expiry = time.Now().Add(token.lifetime()).Truncate(time.Second)
+
+	payload := strings.Join([]string{string(purpose), strconv.Itoa(userID), strconv.FormatInt(token.Expiry.Unix(), 10), email}, "\n")
+	signature, err := m.sign(payload, userID)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Check returns what token vouches for when it was made for purpose,
+// has not expired and has not been used.
+func (m *EmailTokenModel) Check(purpose EmailTokenPurpose, token string) (*EmailToken, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if !ok || err != nil {
+		return nil, ErrInvalidLink
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	fields := strings.SplitN(string(payload), "\n", 4)
+	if len(fields) != 4 || fields[0] != string(purpose) {
+		return nil, ErrInvalidLink
+	}
+	userID, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	expiry, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+
+	expected, err := m.sign(string(payload), userID)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidLink
+	} else if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(signature, expected) {
+		return nil, ErrInvalidLink
+	}
+	if time.Now().Unix() > expiry {
+		return nil, ErrLinkExpired
+	}
+	return &EmailToken{Purpose: purpose, UserID: userID, Email: fields[3], Expiry: time.Unix(expiry, 0).In(Timezone)}, nil
+}
+
+// sign binds payload to the current state of the user's account.
+func (m *EmailTokenModel) sign(payload string, userID int) ([]byte, error) {
+	if len(EmailTokenSecret) == 0 {
+		return nil, errors.New("email token secret is not set")
+	}
+	var email, password string
+	var verified bool
+	err := m.DB.QueryRow(`SELECT email, password, email_verified FROM users WHERE id = ?`, userID).Scan(&email, &password, &verified)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, EmailTokenSecret)
+	mac.Write([]byte(payload + "\x00" + email + "\x00" + password + "\x00" + strconv.FormatBool(verified)))
+	return mac.Sum(nil), nil
+}
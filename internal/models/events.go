@@ -0,0 +1,82 @@
+package models
+
+import (
+	"forum/internal/database"
+	"strconv"
+)
+
+// Events that open pages receive when posts, comments and votes change.
+const (
+	EventPost         = "post"
+	EventComment      = "comment"
+	EventCommentCount = "comments"
+	EventVotes        = "votes"
+	EventCommentVotes = "comment_votes"
+)
+
+const (
+	FeedTopic           = "feed"
+	postTopicPrefix     = "post:"
+	categoryTopicPrefix = "category:"
+)
+
+// PostTopic carries the events shown on a post's page.
+func PostTopic(postID int) string {
+	return postTopicPrefix + strconv.Itoa(postID)
+}
+
+// CategoryTopic carries the events shown on a category's post list, as
+// FeedTopic does for the list of all posts.
+func CategoryTopic(categoryID int) string {
+	return categoryTopicPrefix + strconv.Itoa(categoryID)
+}
+
+type NewPostEvent struct {
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	Username string `json:"username"`
+}
+
+// VotesEvent has the counts after a vote on a post, or on a comment when
+// CommentID is set.
+type VotesEvent struct {
+	PostID    int `json:"post_id"`
+	CommentID int `json:"comment_id,omitempty"`
+	Likes     int `json:"likes"`
+	Dislikes  int `json:"dislikes"`
+}
+
+type CommentCountEvent struct {
+	PostID   int `json:"post_id"`
+	Comments int `json:"comments"`
+}
+
+// listTopics returns the topics of the lists a post appears in: the feed
+// and its categories.
+func listTopics(tx *database.Tx, postID int) ([]string, error) {
+	rows, err := tx.Query(`SELECT DISTINCT category_id FROM post_categories WHERE post_id = ?`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	topics := []string{FeedTopic}
+	for rows.Next() {
+		var categoryID int
+		if err := rows.Scan(&categoryID); err != nil {
+			return nil, err
+		}
+		topics = append(topics, CategoryTopic(categoryID))
+	}
+	return topics, rows.Err()
+}
+
+// countVotes counts the likes and dislikes in table, post_votes or
+// comment_votes, for the row whose column is id.
+func countVotes(tx *database.Tx, table, column string, id int) (likes, dislikes int, err error) {
+	err = tx.QueryRow(`SELECT
+		COALESCE(SUM(CASE WHEN vote_type = 1 THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN vote_type = -1 THEN 1 ELSE 0 END), 0)
+		FROM `+table+` WHERE `+column+` = ?`, id).Scan(&likes, &dislikes)
+	return likes, dislikes, err
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"database/sql"
+	"forum/internal/database"
+	"time"
+)
+
+var (
+	// MaxLoginFailures failed logins in a row with one email lock it,
+	// whether or not an account uses it.
+	MaxLoginFailures = 5
+	// MaxIPLoginFailures failed logins from one address within MaxLockout
+	// hold that address back.
+	MaxIPLoginFailures = 20
+	// LockoutBase is the first lockout. Each further failure doubles it,
+	// up to MaxLockout.
+	LockoutBase = time.Minute
+	MaxLockout  = time.Hour
+)
+
+// lockoutFor is how long to hold back after failures, the limit'th of
+// which starts the first lockout.
+func lockoutFor(failures, limit int) time.Duration {
+	if failures < limit {
+		return 0
+	}
+	d := LockoutBase
+	for i := limit; i < failures && d < MaxLockout; i++ {
+		d *= 2
+	}
+	return min(d, MaxLockout)
+}
+
+type LoginFailure struct {
+	ID        int
+	Email     string
+	IP        string
+	UserAgent string
+	Reason    string
+	Created   time.Time
+}
+
+type LoginFailureModel struct {
+	DB *database.DB
+}
+
+func (m *LoginFailureModel) Record(email, ip, userAgent, reason string) error {
+	_, err := m.DB.Exec(`INSERT INTO login_failures (email, ip, user_agent, reason, created) VALUES (?, ?, ?, ?, ?)`,
+		email, ip, userAgent, reason, time.Now().In(Timezone))
+	return err
+}
+
+// Recent lists the latest failures, newest first.
+func (m *LoginFailureModel) Recent(limit int) ([]*LoginFailure, error) {
+	rows, err := m.DB.Query(`
+		SELECT id, email, ip, user_agent, reason, created
+		FROM login_failures
+		ORDER BY created DESC, id DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var failures []*LoginFailure
+	for rows.Next() {
+		f := &LoginFailure{}
+		if err := rows.Scan(&f.ID, &f.Email, &f.IP, &f.UserAgent, &f.Reason, &f.Created); err != nil {
+			return nil, err
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
+
+// IPLockedUntil tells until when logins from ip are refused. The zero
+// time means they are not.
+func (m *LoginFailureModel) IPLockedUntil(ip string) (time.Time, error) {
+	return lockedUntil(m.DB, `ip = ?`, ip, MaxIPLoginFailures)
+}
+
+// EmailLockedUntil tells until when logins with email are refused. Failures
+// count whether or not an account uses the email, and a successful login
+// clears them.
+func (m *LoginFailureModel) EmailLockedUntil(email string) (time.Time, error) {
+	return lockedUntil(m.DB, `email = ? AND NOT cleared`, email, MaxLoginFailures)
+}
+
+// lockedUntil tells until when the failures matching condition within
+// MaxLockout hold logins back, the limit'th of them starting the first
+// lockout.
+func lockedUntil(db *database.DB, condition string, arg any, limit int) (time.Time, error) {
+	since := time.Now().In(Timezone).Add(-MaxLockout)
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM login_failures WHERE `+condition+` AND created > ?`, arg, since).Scan(&count)
+	if err != nil || count < limit {
+		return time.Time{}, err
+	}
+
+	var last time.Time
+	err = db.QueryRow(`SELECT created FROM login_failures WHERE `+condition+` ORDER BY created DESC LIMIT 1`, arg).Scan(&last)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return last.Add(lockoutFor(count, limit)), nil
+}
+
+// emailLockouts returns the emails whose failed logins currently hold them
+// back, with when each lockout ends.
+func emailLockouts(db *database.DB) (map[string]time.Time, error) {
+	rows, err := db.Query(`SELECT email, created FROM login_failures WHERE NOT cleared AND created > ? ORDER BY created`,
+		time.Now().In(Timezone).Add(-MaxLockout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	last := map[string]time.Time{}
+	for rows.Next() {
+		var email string
+		var created time.Time
+		if err := rows.Scan(&email, &created); err != nil {
+			return nil, err
+		}
+		counts[email]++
+		last[email] = created
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lockouts := map[string]time.Time{}
+	for email, count := range counts {
+		if until := last[email].Add(lockoutFor(count, MaxLoginFailures)); count >= MaxLoginFailures && time.Now().Before(until) {
+			lockouts[email] = until
+		}
+	}
+	return lockouts, nil
+}
+
+// Clear stops the failed logins so far with email counting towards a
+// lockout. They stay in the audit.
+func (m *LoginFailureModel) Clear(email string) error {
+	_, err := m.DB.Exec(`UPDATE login_failures SET cleared = ? WHERE email = ? AND NOT cleared`, true, email)
+	return err
+}
+
+// DeleteBefore removes failures older than t and returns how many there
+// were.
+func (m *LoginFailureModel) DeleteBefore(t time.Time) (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM login_failures WHERE created < ?`, t.In(Timezone))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"database/sql"
+	"forum/internal/database"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+type NotificationType string
+
+const (
+	NotifyComment NotificationType = "comment"
+	NotifyReply   NotificationType = "reply"
+	NotifyMention NotificationType = "mention"
+	NotifyLike    NotificationType = "like"
+)
+
+type NotificationKind struct {
+	Type        NotificationType
+	Description string
+}
+
+// NotificationKinds lists the types members can turn off, in the order the
+// profile page shows them.
+var NotificationKinds = []NotificationKind{
+	{NotifyComment, "Comments on my posts"},
+	{NotifyReply, "Replies to my comments"},
+	{NotifyMention, "Mentions of @me"},
+	{NotifyLike, "Likes of my posts and comments"},
+}
+
+func IsKnownNotificationType(t NotificationType) bool {
+	for _, kind := range NotificationKinds {
+		if kind.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+type Notification struct {
+	ID        int              `json:"id"`
+	Type      NotificationType `json:"type"`
+	ActorName string           `json:"actor"`
+	// Actors counts the members behind a batch of likes.
+	Actors    int       `json:"actors"`
+	PostID    int       `json:"post_id"`
+	PostTitle string    `json:"post_title"`
+	CommentID int       `json:"comment_id,omitempty"`
+	Created   time.Time `json:"created"`
+	Read      bool      `json:"read"`
+}
+
+// Message says what happened, to be followed by the post's title.
+func (n *Notification) Message() string {
+	switch n.Type {
+	case NotifyComment:
+		return n.ActorName + " commented on your post"
+	case NotifyReply:
+		return n.ActorName + " replied to your comment on"
+	case NotifyMention:
+		return n.ActorName + " mentioned you on"
+	case NotifyLike:
+		target := "your post"
+		if n.CommentID != 0 {
+			target = "your comment on"
+		}
+		if n.Actors > 1 {
+			return strconv.Itoa(n.Actors) + " people liked " + target
+		}
+		return n.ActorName + " liked " + target
+	}
+	return n.ActorName + " did something on"
+}
+
+// URL points at the comment the notification is about, or at the post.
+func (n *Notification) URL() string {
+	url := "/post/" + strconv.Itoa(n.PostID)
+	if n.CommentID != 0 {
+		url += "#comment-" + strconv.Itoa(n.CommentID)
+	}
+	return url
+}
+
+type NotificationModel struct {
+	DB *database.DB
+}
+
+// visibleNotifications leaves out notifications about deleted posts and
+// about comments that were deleted or removed.
+const visibleNotifications = `
+	FROM notifications n
+	JOIN users u ON u.id = n.actor_id
+	JOIN posts p ON p.id = n.post_id
+	LEFT JOIN comments c ON c.id = n.comment_id
+	WHERE n.user_id = ? AND p.deleted IS NULL AND (n.comment_id = 0 OR (c.deleted IS NULL AND c.removed_by IS NULL))`
+
+// ByUserID returns a member's newest notifications, read or not.
+func (m *NotificationModel) ByUserID(userID, limit int) ([]*Notification, error) {
+	rows, err := m.DB.Query(`
+		SELECT n.id, n.type, u.username, n.actors, n.post_id, p.title, n.comment_id, n.created, n.read_at IS NOT NULL
+		`+visibleNotifications+`
+		ORDER BY n.created DESC, n.id DESC
+		LIMIT ?`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []*Notification
+	for rows.Next() {
+		n := &Notification{}
+		var title sql.NullString
+		err := rows.Scan(&n.ID, &n.Type, &n.ActorName, &n.Actors, &n.PostID, &title, &n.CommentID, &n.Created, &n.Read)
+		if err != nil {
+			return nil, err
+		}
+		n.PostTitle = title.String
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (m *NotificationModel) UnreadCount(userID int) (int, error) {
+	var count int
+	err := m.DB.QueryRow(`SELECT COUNT(*) `+visibleNotifications+` AND n.read_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+// MarkRead marks one of the member's notifications read. It returns
+// sql.ErrNoRows for a notification that is not theirs.
+func (m *NotificationModel) MarkRead(userID, id int) error {
+	result, err := m.DB.Exec(`UPDATE notifications SET read_at = COALESCE(read_at, ?) WHERE id = ? AND user_id = ?`,
+		time.Now().In(Timezone), id, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+func (m *NotificationModel) MarkAllRead(userID int) error {
+	_, err := m.DB.Exec(`UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL`, time.Now().In(Timezone), userID)
+	return err
+}
+
+// Muted returns the types of notification the member turned off.
+func (m *NotificationModel) Muted(userID int) (map[NotificationType]bool, error) {
+	rows, err := m.DB.Query(`SELECT type FROM notification_mutes WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	muted := map[NotificationType]bool{}
+	for rows.Next() {
+		var t NotificationType
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		muted[t] = true
+	}
+	return muted, rows.Err()
+}
+
+// SetMuted replaces the types of notification the member turned off.
+func (m *NotificationModel) SetMuted(userID int, types []NotificationType) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM notification_mutes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, t := range types {
+		if _, err := tx.Exec(`INSERT INTO notification_mutes (user_id, type) VALUES (?, ?) ON CONFLICT DO NOTHING`, userID, t); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// maxMentions bounds how many members one comment can notify by name.
+const maxMentions = 10
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_-]+)`)
+
+// mentions returns the distinct names written as @name in content.
+func mentions(content string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		if name := match[1]; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+			if len(names) == maxMentions {
+				break
+			}
+		}
+	}
+	return names
+}
+
+// notifyNewComment tells the post's author, the author of the comment
+// replied to, and every member mentioned about a new comment. Nobody is
+// told twice, and nobody about their own comment.
+func notifyNewComment(tx *database.Tx, commentID, postID, parentID, actorID int, content string) error {
+	told := map[int]bool{actorID: true}
+	tell := func(userID int, t NotificationType) error {
+		if told[userID] {
+			return nil
+		}
+		told[userID] = true
+		return notify(tx, userID, t, actorID, postID, commentID)
+	}
+
+	if parentID != 0 {
+		var parentAuthor int
+		if err := tx.QueryRow(`SELECT user_id FROM comments WHERE id = ?`, parentID).Scan(&parentAuthor); err != nil {
+			return err
+		}
+		if err := tell(parentAuthor, NotifyReply); err != nil {
+			return err
+		}
+	}
+	var postAuthor int
+	if err := tx.QueryRow(`SELECT user_id FROM posts WHERE id = ?`, postID).Scan(&postAuthor); err != nil {
+		return err
+	}
+	if err := tell(postAuthor, NotifyComment); err != nil {
+		return err
+	}
+
+	for _, name := range mentions(content) {
+		var userID int
+		err := tx.QueryRow(`SELECT id FROM users WHERE username = ?`, name).Scan(&userID)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := tell(userID, NotifyMention); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyLike tells owner that actor liked their post, or their comment
+// when commentID is set. Likes join the owner's unread notification about
+// the same post or comment if there is one.
+func notifyLike(tx *database.Tx, ownerID, actorID, postID, commentID int) error {
+	if ownerID == actorID {
+		return nil
+	}
+	var id int
+	err := tx.QueryRow(`SELECT id FROM notifications
+		WHERE user_id = ? AND type = ? AND post_id = ? AND comment_id = ? AND read_at IS NULL`,
+		ownerID, NotifyLike, postID, commentID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return notify(tx, ownerID, NotifyLike, actorID, postID, commentID)
+	} else if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`INSERT INTO notification_actors (notification_id, user_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, id, actorID)
+	if err != nil {
+		return err
+	}
+	if added, err := result.RowsAffected(); err != nil || added == 0 {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE notifications SET actors = actors + 1, actor_id = ?, created = ? WHERE id = ?`,
+		actorID, time.Now().In(Timezone), id)
+	return err
+}
+
+// notify adds a notification unless the member turned its type off.
+func notify(tx *database.Tx, userID int, t NotificationType, actorID, postID, commentID int) error {
+	var muted bool
+	err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM notification_mutes WHERE user_id = ? AND type = ?)`, userID, t).Scan(&muted)
+	if err != nil || muted {
+		return err
+	}
+
+	var id int
+	err = tx.QueryRow(`INSERT INTO notifications (user_id, type, actor_id, post_id, comment_id, created)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id`, userID, t, actorID, postID, commentID, time.Now().In(Timezone)).Scan(&id)
+	if err != nil {
+		return err
+	}
+	if t == NotifyLike {
+		_, err = tx.Exec(`INSERT INTO notification_actors (notification_id, user_id) VALUES (?, ?)`, id, actorID)
+	}
+	return err
+}
@@ -0,0 +1,242 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultPageSize = 10
+	MaxPageSize     = 50
+)
+
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// PageRequest selects one page of a post listing. After continues towards
+// older posts and Before goes back towards newer ones; both are cursors
+// taken from a previous PostPage. At most one of them should be set.
+type PageRequest struct {
+	After  string
+	Before string
+	Limit  int
+}
+
+type PostPage struct {
+	Posts      []*Post
+	NextCursor string
+	PrevCursor string
+}
+
+// cursor is the keyset position of a post in a listing ordered by
+// (created DESC, id DESC). Created keeps the value exactly as stored so the
+// comparison in SQL matches the ordering.
+type cursor struct {
+	Created string
+	ID      int
+}
+
+func (c cursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(c.ID) + "|" + c.Created))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	idStr, created, ok := strings.Cut(string(raw), "|")
+	if !ok || created == "" {
+		return cursor{}, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id < 1 || strconv.Itoa(id) != idStr {
+		return cursor{}, ErrInvalidCursor
+	}
+	// A timestamp the database cannot compare with would fail the query
+	// rather than the cursor.
+	if parseStoredTime(created).IsZero() {
+		return cursor{}, ErrInvalidCursor
+	}
+	return cursor{Created: created, ID: id}, nil
+}
+
+func (p PageRequest) limit() int {
+	if p.Limit < 1 {
+		return DefaultPageSize
+	}
+	if p.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return p.Limit
+}
+
+// postListColumns is the column list every paginated listing selects. Vote
+// tallies, the count of comments still shown and the viewer's own activity
+// come from correlated subqueries so a page costs one round trip however
+// many posts it holds. The two placeholders take the viewer's user ID, and
+// the text copy of created feeds the cursors.
+const postListColumns = `posts.id, posts.title, posts.content, posts.content_html, posts.html_version, posts.created, CAST(posts.created AS TEXT), posts.user_id, users.username,
+	(SELECT COUNT(*) FROM post_votes pv WHERE pv.post_id = posts.id AND pv.vote_type = 1),
+	(SELECT COUNT(*) FROM post_votes pv WHERE pv.post_id = posts.id AND pv.vote_type = -1),
+	(SELECT COUNT(*) FROM comments c WHERE c.post_id = posts.id AND c.deleted IS NULL),
+	COALESCE((SELECT pv.vote_type FROM post_votes pv WHERE pv.post_id = posts.id AND pv.user_id = ?), 0),
+	EXISTS (SELECT 1 FROM comments c WHERE c.post_id = posts.id AND c.user_id = ?)`
+
+// queryPostPage runs one page of a listing filtered by where, which is
+// written against the posts table, and fills in each post's categories.
+func (m *PostModel) queryPostPage(where string, args []any, viewerID int, page PageRequest) (*PostPage, error) {
+	limit := page.limit()
+	backwards := page.Before != ""
+
+	stmt := `SELECT ` + postListColumns + ` FROM posts JOIN users ON posts.user_id = users.id WHERE ` + where + ` AND posts.deleted IS NULL`
+	args = append([]any{viewerID, viewerID}, args...)
+	switch {
+	case backwards:
+		c, err := decodeCursor(page.Before)
+		if err != nil {
+			return nil, err
+		}
+		stmt += ` AND (posts.created > ? OR (posts.created = ? AND posts.id > ?))
+		          ORDER BY posts.created ASC, posts.id ASC`
+		args = append(args, c.Created, c.Created, c.ID)
+	case page.After != "":
+		c, err := decodeCursor(page.After)
+		if err != nil {
+			return nil, err
+		}
+		stmt += ` AND (posts.created < ? OR (posts.created = ? AND posts.id < ?))
+		          ORDER BY posts.created DESC, posts.id DESC`
+		args = append(args, c.Created, c.Created, c.ID)
+	default:
+		stmt += ` ORDER BY posts.created DESC, posts.id DESC`
+	}
+	stmt += ` LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []*Post
+	var keys []cursor
+	for rows.Next() {
+		post := &Post{}
+		var key cursor
+		var cached string
+		var version int
+		err := rows.Scan(&post.ID, &post.Title, &post.Content, &cached, &version, &post.Created, &key.Created, &post.UserID, &post.Username,
+			&post.Likes, &post.Dislikes, &post.CommentCount, &post.UserVote, &post.UserCommented)
+		if err != nil {
+			return nil, err
+		}
+		post.ContentHTML = contentHTML(post.Content, cached, version)
+		key.ID = post.ID
+		posts = append(posts, post)
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	hasMore := len(posts) > limit
+	if hasMore {
+		posts, keys = posts[:limit], keys[:limit]
+	}
+	if backwards {
+		for i, j := 0, len(posts)-1; i < j; i, j = i+1, j-1 {
+			posts[i], posts[j] = posts[j], posts[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	result := &PostPage{Posts: posts}
+	if len(posts) == 0 {
+		return result, nil
+	}
+	if err := m.loadCategories(posts); err != nil {
+		return nil, err
+	}
+	if hasMore || backwards {
+		result.NextCursor = keys[len(keys)-1].encode()
+	}
+	if (backwards && hasMore) || page.After != "" {
+		result.PrevCursor = keys[0].encode()
+	}
+	return result, nil
+}
+
+// postIDPlaceholders returns "?, ?, ..." and the matching arguments for an
+// IN clause over the given posts.
+func postIDPlaceholders(posts []*Post) (string, []any) {
+	marks := make([]string, len(posts))
+	args := make([]any, len(posts))
+	for i, post := range posts {
+		marks[i] = "?"
+		args[i] = post.ID
+	}
+	return strings.Join(marks, ", "), args
+}
+
+func (m *PostModel) loadCategories(posts []*Post) error {
+	marks, args := postIDPlaceholders(posts)
+	rows, err := m.DB.Query(`
+		SELECT pc.post_id, c.name
+		FROM post_categories pc
+		JOIN categories c ON c.id = pc.category_id
+		WHERE pc.post_id IN (`+marks+`)
+		ORDER BY c.sort_order, c.id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byID := make(map[int]*Post, len(posts))
+	for _, post := range posts {
+		byID[post.ID] = post
+	}
+	for rows.Next() {
+		var postID int
+		var name string
+		if err := rows.Scan(&postID, &name); err != nil {
+			return err
+		}
+		byID[postID].Categories = append(byID[postID].Categories, name)
+	}
+	return rows.Err()
+}
+
+func (m *PostModel) loadUserComments(posts []*Post, userID int) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	marks, args := postIDPlaceholders(posts)
+	rows, err := m.DB.Query(`
+		SELECT id, post_id, user_id, created, content, content_html, html_version
+		FROM comments
+		WHERE post_id IN (`+marks+`) AND user_id = ? AND deleted IS NULL
+		ORDER BY created ASC`, append(args, userID)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byID := make(map[int]*Post, len(posts))
+	for _, post := range posts {
+		byID[post.ID] = post
+	}
+	for rows.Next() {
+		comment := &Comment{}
+		var cached string
+		var version int
+		if err := rows.Scan(&comment.ID, &comment.PostID, &comment.UserID, &comment.Created, &comment.Content, &cached, &version); err != nil {
+			return err
+		}
+		comment.ContentHTML = contentHTML(comment.Content, cached, version)
+		byID[comment.PostID].UserComments = append(byID[comment.PostID].UserComments, comment)
+	}
+	return rows.Err()
+}
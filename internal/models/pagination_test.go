@@ -0,0 +1,97 @@
+package models
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor(t *testing.T) {
+	for _, created := range []string{
+		"2026-10-18 16:44:57.127865243+05:00",
+		"2024-11-26 03:54:06.72953+05",
+		"2024-10-30 05:06:20",
+		"2024-10-30T05:06:20Z",
+	} {
+		c := cursor{Created: created, ID: 42}
+		decoded, err := decodeCursor(c.encode())
+		require.NoError(t, err, created)
+		assert.Equal(t, c, decoded)
+	}
+
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, tampered := range []string{
+		"",
+		"not base64!",
+		raw("42"),
+		raw("42|"),
+		raw("|2024-10-30 05:06:20"),
+		raw("0|2024-10-30 05:06:20"),
+		raw("-3|2024-10-30 05:06:20"),
+		raw("+42|2024-10-30 05:06:20"),
+		raw("forty|2024-10-30 05:06:20"),
+		raw("42|yesterday"),
+		raw("42|2024-10-30 05:06:20' OR 1=1 --"),
+	} {
+		_, err := decodeCursor(tampered)
+		assert.ErrorIs(t, err, ErrInvalidCursor, tampered)
+	}
+}
+
+func TestStorePagination(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *Store) {
+		author := createUser(t, store, "Author")
+		var ids []int
+		for _, title := range []string{"One", "Two", "Three", "Four", "Five"} {
+			id, err := store.Posts.InsertWithUserIDAndCategories(title, "Text", author, []int{1})
+			require.NoError(t, err)
+			ids = append(ids, id)
+		}
+		// Posts created at the same moment are ordered by ID.
+		_, err := store.Posts.(*PostModel).DB.Exec(`UPDATE posts SET created = (SELECT created FROM posts WHERE id = ?) WHERE id IN (?, ?)`,
+			ids[1], ids[2], ids[3])
+		require.NoError(t, err)
+
+		titles := func(page *PostPage) []string {
+			var titles []string
+			for _, post := range page.Posts {
+				titles = append(titles, post.Title)
+			}
+			return titles
+		}
+
+		first, err := store.Posts.Latest(author, PageRequest{Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Five", "Four"}, titles(first))
+		assert.Empty(t, first.PrevCursor)
+		require.NotEmpty(t, first.NextCursor)
+
+		second, err := store.Posts.Latest(author, PageRequest{After: first.NextCursor, Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Three", "Two"}, titles(second))
+		require.NotEmpty(t, second.PrevCursor)
+
+		last, err := store.Posts.Latest(author, PageRequest{After: second.NextCursor, Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"One"}, titles(last))
+		assert.Empty(t, last.NextCursor)
+
+		// Going back returns the same pages.
+		back, err := store.Posts.Latest(author, PageRequest{Before: last.PrevCursor, Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, titles(second), titles(back))
+		back, err = store.Posts.Latest(author, PageRequest{Before: back.PrevCursor, Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, titles(first), titles(back))
+		assert.Empty(t, back.PrevCursor)
+		assert.Equal(t, first.NextCursor, back.NextCursor)
+
+		tampered := base64.RawURLEncoding.EncodeToString([]byte("3|not a time"))
+		_, err = store.Posts.Latest(author, PageRequest{After: tampered})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+		_, err = store.Posts.GetByCategoryID(1, author, PageRequest{Before: "%%%"})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	})
+}
@@ -3,48 +3,72 @@ package models
 import (
 	"database/sql"
 	"errors"
+	"forum/internal/database"
+	"forum/internal/live"
+	"forum/internal/markdown"
+	"html/template"
 	"time"
+	"unicode/utf8"
 )
 
 type Post struct {
-	ID            int
-	Title         string
-	Content       string
-	Created       time.Time
-	UserID        int
-	Username      string
-	Likes         int
-	Dislikes      int
-	UserVote      int
-	Categories    []string
-	UserCommented bool
-	CommentCount  int
-	UserComments  []*Comment
+	ID            int           `json:"id"`
+	Title         string        `json:"title"`
+	Content       string        `json:"content"`
+	ContentHTML   template.HTML `json:"content_html"`
+	Created       time.Time     `json:"created"`
+	UserID        int           `json:"user_id"`
+	Username      string        `json:"username"`
+	Likes         int           `json:"likes"`
+	Dislikes      int           `json:"dislikes"`
+	UserVote      int           `json:"user_vote"`
+	Categories    []string      `json:"categories"`
+	UserCommented bool          `json:"user_commented"`
+	CommentCount  int           `json:"comment_count"`
+	UserComments  []*Comment    `json:"user_comments,omitempty"`
+	Attachments   []*Attachment `json:"attachments,omitempty"`
+	Updated       time.Time     `json:"updated"`
+	Edited        bool          `json:"edited"`
+	Deleted       bool          `json:"deleted"`
 }
 
 type PostModel struct {
-	DB *sql.DB
+	DB *database.DB
+	// Events tells open pages about new posts and votes.
+	Events *live.Hub
 }
 
-var gmtPlus5 = time.FixedZone("GMT+5", 5*60*60)
+// Timezone is the zone timestamps are stored in. main sets it from the
+// configuration.
+var Timezone = time.FixedZone("GMT+5", 5*60*60)
 
-func (m *PostModel) InsertWithUserIDAndCategories(title string, content string, userID int, categoryIDs []int) (int, error) {
-	if len(title) > 25 {
-		title = title[:25]
-	}
-	tx, err := m.DB.Begin()
-	if err != nil {
-		return 0, err
+var ErrPostDeleted = errors.New("post has been deleted")
+
+// MaxTitleLength is the longest title a post keeps; longer titles are cut.
+var MaxTitleLength = 25
+
+// MaxContentLength is the most characters a post or comment may hold.
+// Longer ones are refused rather than cut.
+var MaxContentLength = 50000
+
+// cutTitle shortens title to MaxTitleLength characters.
+func cutTitle(title string) string {
+	if utf8.RuneCountInString(title) > MaxTitleLength {
+		return string([]rune(title)[:MaxTitleLength])
 	}
+	return title
+}
 
-	stmt := `INSERT INTO posts (title, content, user_id, created) VALUES (?, ?, ?, ?)`
-	result, err := tx.Exec(stmt, title, content, userID, time.Now().In(gmtPlus5))
+func (m *PostModel) InsertWithUserIDAndCategories(title string, content string, userID int, categoryIDs []int) (int, error) {
+	title = cutTitle(title)
+	tx, err := m.DB.Begin()
 	if err != nil {
-		tx.Rollback()
 		return 0, err
 	}
 
-	postID, err := result.LastInsertId()
+	stmt := `INSERT INTO posts (title, content, content_html, html_version, user_id, created) VALUES (?, ?, ?, ?, ?, ?) RETURNING id`
+	var postID int
+	err = tx.QueryRow(stmt, title, content, markdown.Render(content), markdown.Version, userID, time.Now().In(Timezone)).Scan(&postID)
 	if err != nil {
 		tx.Rollback()
 		return 0, err
@@ -58,30 +82,99 @@ func (m *PostModel) InsertWithUserIDAndCategories(title string, content string,
 		}
 	}
 
+	event := NewPostEvent{ID: postID, Title: title}
+	err = tx.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&event.Username)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	topics, err := listTopics(tx, postID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return 0, err
 	}
 
-	return int(postID), nil
+	m.Events.Publish(EventPost, event, topics...)
+	return postID, nil
 }
 
 func (m *PostModel) Get(id int) (*Post, error) {
-	query := `SELECT id, title, content, user_id, created FROM posts WHERE id = ?`
+	query := `SELECT id, title, content, content_html, html_version, user_id, created, updated, deleted FROM posts WHERE id = ?`
 	row := m.DB.QueryRow(query, id)
 
 	post := &Post{}
-	err := row.Scan(&post.ID, &post.Title, &post.Content, &post.UserID, &post.Created)
+	var cached string
+	var version int
+	var updated, deleted sql.NullTime
+	err := row.Scan(&post.ID, &post.Title, &post.Content, &cached, &version, &post.UserID, &post.Created, &updated, &deleted)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, sql.ErrNoRows
 		}
 		return nil, err
 	}
+	post.ContentHTML = contentHTML(post.Content, cached, version)
+	post.Edited, post.Updated = updated.Valid, updated.Time
+	post.Deleted = deleted.Valid
 
 	return post, nil
 }
 
+// Update stores the current title and content as a revision before
+// replacing them, so every earlier version of a post stays recoverable.
+func (m *PostModel) Update(postID, editorID int, title, content string) error {
+	title = cutTitle(title)
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	var oldTitle, oldContent sql.NullString
+	var deleted sql.NullTime
+	err = tx.QueryRow(`SELECT title, content, deleted FROM posts WHERE id = ?`, postID).Scan(&oldTitle, &oldContent, &deleted)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if deleted.Valid {
+		tx.Rollback()
+		return ErrPostDeleted
+	}
+
+	now := time.Now().In(Timezone)
+	_, err = tx.Exec(`INSERT INTO post_revisions (post_id, editor_id, title, content, created) VALUES (?, ?, ?, ?, ?)`,
+		postID, editorID, oldTitle, oldContent, now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE posts SET title = ?, content = ?, content_html = ?, html_version = ?, updated = ? WHERE id = ?`,
+		title, content, markdown.Render(content), markdown.Version, now, postID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete hides a post behind a tombstone. The row, its revisions and its
+// comments are kept so threads that reference it stay intact.
+func (m *PostModel) Delete(postID, userID int) error {
+	result, err := m.DB.Exec(`UPDATE posts SET deleted = ?, deleted_by = ? WHERE id = ? AND deleted IS NULL`,
+		time.Now().In(Timezone), userID, postID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
 func (m *PostModel) GetCategories(postID int) ([]string, error) {
 	stmt := `SELECT categories.name FROM categories
              JOIN post_categories ON categories.id = post_categories.category_id
@@ -106,169 +199,65 @@ func (m *PostModel) GetCategories(postID int) ([]string, error) {
 	return categories, nil
 }
 
-func (m *PostModel) Latest(userID int) ([]*Post, error) {
-	stmt := `
-        SELECT posts.id, posts.title, posts.content, posts.created, users.username
-        FROM posts
-        JOIN users ON posts.user_id = users.id
-        ORDER BY posts.created DESC LIMIT 10
-    `
-
-	rows, err := m.DB.Query(stmt)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var posts []*Post
-	for rows.Next() {
-		post := &Post{}
-		err = rows.Scan(&post.ID, &post.Title, &post.Content, &post.Created, &post.Username)
-		if err != nil {
-			return nil, err
-		}
-
-		post.Categories, err = m.GetCategories(post.ID)
-		if err != nil {
-			return nil, err
-		}
-
-		post.Likes, post.Dislikes, err = m.GetLikesAndDislikes(post.ID)
-		if err != nil {
-			return nil, err
-		}
-
-		err = m.DB.QueryRow("SELECT vote_type FROM post_votes WHERE post_id = ? AND user_id = ?", post.ID, userID).Scan(&post.UserVote)
-		if err == sql.ErrNoRows {
-			post.UserVote = 0
-		} else if err != nil {
-			return nil, err
-		}
-
-		posts = append(posts, post)
-	}
+func (m *PostModel) Latest(userID int, page PageRequest) (*PostPage, error) {
+	return m.queryPostPage(`1 = 1`, nil, userID, page)
+}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
-	}
+func (m *PostModel) GetByCategoryID(categoryID, userID int, page PageRequest) (*PostPage, error) {
+	return m.queryPostPage(`posts.id IN (SELECT post_id FROM post_categories WHERE category_id = ?)`, []any{categoryID}, userID, page)
+}
 
-	return posts, nil
+func (m *PostModel) GetByUserID(authorID, viewerID int, page PageRequest) (*PostPage, error) {
+	return m.queryPostPage(`posts.user_id = ?`, []any{authorID}, viewerID, page)
 }
 
-func (m *PostModel) GetByCategoryID(categoryID, userID int) ([]*Post, error) {
-	stmt := `
-        SELECT posts.id, posts.title, posts.content, posts.created, users.username
-        FROM posts
-        JOIN users ON posts.user_id = users.id
-        JOIN post_categories ON posts.id = post_categories.post_id
-        WHERE post_categories.category_id = ?
-        ORDER BY posts.created DESC
-    `
-
-	rows, err := m.DB.Query(stmt, categoryID)
+// ToggleVote records a vote on a post, or takes it back when the same vote
+// is cast again. A new like notifies the post's author.
+func (m *PostModel) ToggleVote(postID, userID, voteType int) error {
+	tx, err := m.DB.Begin()
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var posts []*Post
-	for rows.Next() {
-		post := &Post{}
-		err = rows.Scan(&post.ID, &post.Title, &post.Content, &post.Created, &post.Username)
-		if err != nil {
-			return nil, err
-		}
-
-		post.Categories, err = m.GetCategories(post.ID)
-		if err != nil {
-			return nil, err
-		}
-
-		post.Likes, post.Dislikes, err = m.GetLikesAndDislikes(post.ID)
-		if err != nil {
-			return nil, err
-		}
-
-		err = m.DB.QueryRow("SELECT vote_type FROM post_votes WHERE post_id = ? AND user_id = ?", post.ID, userID).Scan(&post.UserVote)
-		if err == sql.ErrNoRows {
-			post.UserVote = 0
-		} else if err != nil {
-			return nil, err
-		}
-
-		posts = append(posts, post)
+		return err
 	}
+	defer tx.Rollback()
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+	var existingVote int
+	err = tx.QueryRow("SELECT vote_type FROM post_votes WHERE post_id = ? AND user_id = ?", postID, userID).Scan(&existingVote)
+	switch {
+	case err == nil && existingVote == voteType:
+		_, err = tx.Exec("DELETE FROM post_votes WHERE post_id = ? AND user_id = ?", postID, userID)
+	case err == nil:
+		_, err = tx.Exec("UPDATE post_votes SET vote_type = ? WHERE post_id = ? AND user_id = ?", voteType, postID, userID)
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec("INSERT INTO post_votes (post_id, user_id, vote_type) VALUES (?, ?, ?)", postID, userID, voteType)
 	}
-
-	return posts, nil
-}
-
-func (m *PostModel) GetByUserID(userID int) ([]*Post, error) {
-	stmt := `
-        SELECT posts.id, posts.title, posts.content, posts.created, users.username
-        FROM posts
-        JOIN users ON posts.user_id = users.id
-        WHERE posts.user_id = ?
-        ORDER BY posts.created DESC
-    `
-
-	rows, err := m.DB.Query(stmt, userID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
-
-	var posts []*Post
-	for rows.Next() {
-		post := &Post{}
-		err = rows.Scan(&post.ID, &post.Title, &post.Content, &post.Created, &post.Username)
-		if err != nil {
-			return nil, err
-		}
-
-		post.Categories, err = m.GetCategories(post.ID)
-		if err != nil {
-			return nil, err
-		}
 
-		post.Likes, post.Dislikes, err = m.GetLikesAndDislikes(post.ID)
-		if err != nil {
-			return nil, err
+	if voteType == 1 && existingVote != 1 {
+		var authorID int
+		if err := tx.QueryRow("SELECT user_id FROM posts WHERE id = ?", postID).Scan(&authorID); err != nil {
+			return err
 		}
-
-		err = m.DB.QueryRow("SELECT vote_type FROM post_votes WHERE post_id = ? AND user_id = ?", post.ID, userID).Scan(&post.UserVote)
-		if err == sql.ErrNoRows {
-			post.UserVote = 0
-		} else if err != nil {
-			return nil, err
+		if err := notifyLike(tx, authorID, userID, postID, 0); err != nil {
+			return err
 		}
-
-		posts = append(posts, post)
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+	event := VotesEvent{PostID: postID}
+	event.Likes, event.Dislikes, err = countVotes(tx, "post_votes", "post_id", postID)
+	if err != nil {
+		return err
 	}
-
-	return posts, nil
-}
-
-func (m *PostModel) ToggleVote(postID, userID, voteType int) error {
-	var existingVote int
-	err := m.DB.QueryRow("SELECT vote_type FROM post_votes WHERE post_id = ? AND user_id = ?", postID, userID).Scan(&existingVote)
-	if err == nil && existingVote == voteType {
-		_, err = m.DB.Exec("DELETE FROM post_votes WHERE post_id = ? AND user_id = ?", postID, userID)
+	topics, err := listTopics(tx, postID)
+	if err != nil {
 		return err
 	}
-	if err == nil && existingVote != voteType {
-		_, err = m.DB.Exec("UPDATE post_votes SET vote_type = ? WHERE post_id = ? AND user_id = ?", voteType, postID, userID)
+	if err := tx.Commit(); err != nil {
 		return err
 	}
-	_, err = m.DB.Exec("INSERT INTO post_votes (post_id, user_id, vote_type) VALUES (?, ?, ?)", postID, userID, voteType)
-	return err
+	m.Events.Publish(EventVotes, event, append(topics, PostTopic(postID))...)
+	return nil
 }
 
 func (m *PostModel) GetLikesAndDislikes(postID int) (int, int, error) {
@@ -284,46 +273,8 @@ func (m *PostModel) GetLikesAndDislikes(postID int) (int, int, error) {
 	return likes, dislikes, nil
 }
 
-func (m *PostModel) GetLikedPostsByUserID(userID int) ([]*Post, error) {
-	stmt := `
-        SELECT posts.id, posts.title, posts.content, posts.created, users.username
-        FROM posts
-        JOIN users ON posts.user_id = users.id
-        JOIN post_votes ON posts.id = post_votes.post_id
-        WHERE post_votes.user_id = ? AND post_votes.vote_type = 1
-        ORDER BY posts.created DESC
-    `
-
-	rows, err := m.DB.Query(stmt, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var posts []*Post
-	for rows.Next() {
-		post := &Post{}
-		err = rows.Scan(&post.ID, &post.Title, &post.Content, &post.Created, &post.Username)
-		if err != nil {
-			return nil, err
-		}
-		post.UserVote = 1
-		post.Categories, err = m.GetCategories(post.ID)
-		if err != nil {
-			return nil, err
-		}
-		post.Likes, post.Dislikes, err = m.GetLikesAndDislikes(post.ID)
-		if err != nil {
-			return nil, err
-		}
-		posts = append(posts, post)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, err
-	}
-
-	return posts, nil
+func (m *PostModel) GetLikedPostsByUserID(userID int, page PageRequest) (*PostPage, error) {
+	return m.queryPostPage(`EXISTS (SELECT 1 FROM post_votes WHERE post_votes.post_id = posts.id AND post_votes.user_id = ? AND post_votes.vote_type = 1)`, []any{userID}, userID, page)
 }
 
 func (m *PostModel) GetUserVote(postID, userID int) (int, error) {
@@ -350,61 +301,23 @@ func (m *PostModel) GetUsername(userID int) (string, error) {
 	return username, nil
 }
 
-func (m *PostModel) GetPostsWithUserComments(userID int) ([]*Post, error) {
-	stmt := `
-		SELECT DISTINCT posts.id, posts.title, posts.content, posts.created, users.username
-		FROM posts
-		JOIN comments ON posts.id = comments.post_id
-		JOIN users ON posts.user_id = users.id
-		WHERE comments.user_id = ?
-		ORDER BY posts.created DESC
-	`
-	rows, err := m.DB.Query(stmt, userID)
+func (m *PostModel) GetPostsWithUserComments(userID int, page PageRequest) (*PostPage, error) {
+	result, err := m.queryPostPage(`EXISTS (SELECT 1 FROM comments WHERE comments.post_id = posts.id AND comments.user_id = ? AND comments.deleted IS NULL)`, []any{userID}, userID, page)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var posts []*Post
-	for rows.Next() {
-		post := &Post{}
-		err = rows.Scan(&post.ID, &post.Title, &post.Content, &post.Created, &post.Username)
-		if err != nil {
-			return nil, err
-		}
-
-		post.UserComments, err = m.GetCommentsByUserIDForPost(post.ID, userID)
-		if err != nil {
-			return nil, err
-		}
-
-		post.Likes, post.Dislikes, err = m.GetLikesAndDislikes(post.ID)
-		if err != nil {
-			return nil, err
-		}
-
-		err = m.DB.QueryRow("SELECT vote_type FROM post_votes WHERE post_id = ? AND user_id = ?", post.ID, userID).Scan(&post.UserVote)
-		if err == sql.ErrNoRows {
-			post.UserVote = 0
-		} else if err != nil {
-			return nil, err
-		}
-
-		posts = append(posts, post)
-	}
-
-	if err = rows.Err(); err != nil {
+	if err := m.loadUserComments(result.Posts, userID); err != nil {
 		return nil, err
 	}
-
-	return posts, nil
+	return result, nil
 }
 
 func (m *PostModel) GetCommentsByUserIDForPost(postID, userID int) ([]*Comment, error) {
 	stmt := `
-		SELECT comments.id, comments.post_id, comments.user_id, comments.created, comments.content
+		SELECT comments.id, comments.post_id, comments.user_id, comments.created, comments.content,
+		       comments.content_html, comments.html_version
 		FROM comments
-		WHERE comments.post_id = ? AND comments.user_id = ?
+		WHERE comments.post_id = ? AND comments.user_id = ? AND comments.deleted IS NULL
 		ORDER BY comments.created ASC
 	`
 	rows, err := m.DB.Query(stmt, postID, userID)
@@ -416,12 +329,20 @@ func (m *PostModel) GetCommentsByUserIDForPost(postID, userID int) ([]*Comment,
 	var comments []*Comment
 	for rows.Next() {
 		comment := &Comment{}
-		err := rows.Scan(&comment.ID, &comment.PostID, &comment.UserID, &comment.Created, &comment.Content)
+		var cached string
+		var version int
+		err := rows.Scan(&comment.ID, &comment.PostID, &comment.UserID, &comment.Created, &comment.Content, &cached, &version)
 		if err != nil {
 			return nil, err
 		}
+		comment.ContentHTML = contentHTML(comment.Content, cached, version)
 		comments = append(comments, comment)
 	}
 
 	return comments, nil
 }
+
+// RefreshHTML renders again the posts whose cached HTML is out of date.
+func (m *PostModel) RefreshHTML() (int64, error) {
+	return refreshHTML(m.DB, "posts")
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"forum/internal/database"
+	"forum/internal/migrate"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingDriver wraps the sqlite3 driver and counts every statement sent
+// to it. Its connections implement Prepare and ExecContext only, so
+// database/sql routes every query through one of the two.
+type countingDriver struct {
+	queries atomic.Int64
+}
+
+type countingConn struct {
+	driver.Conn
+	counter *atomic.Int64
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, counter: &d.queries}, nil
+}
+
+func (c *countingConn) Prepare(query string) (driver.Stmt, error) {
+	c.counter.Add(1)
+	return c.Conn.Prepare(query)
+}
+
+func (c *countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.counter.Add(1)
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+var counter = &countingDriver{}
+
+func init() {
+	sql.Register("sqlite3_counting", counter)
+}
+
+func openListingDB(tb testing.TB, posts int) *database.DB {
+	tb.Helper()
+
+	conn, err := sql.Open("sqlite3_counting", "file:"+tb.Name()+"?mode=memory&cache=shared")
+	require.NoError(tb, err)
+	conn.SetMaxOpenConns(1)
+	tb.Cleanup(func() { conn.Close() })
+	db := &database.DB{DB: conn, Dialect: database.SQLite}
+
+	migrator := &migrate.Migrator{DB: db, FS: os.DirFS("../database/migrations/sqlite3")}
+	require.NoError(tb, migrator.Up())
+
+	for u := 1; u <= 3; u++ {
+		_, err := db.Exec(`INSERT INTO users (username, email, password) VALUES (?, ?, 'x')`,
+			"user"+strconv.Itoa(u), "user"+strconv.Itoa(u)+"@example.com")
+		require.NoError(tb, err)
+	}
+
+	postModel := &PostModel{DB: db}
+	commentModel := &CommentModel{DB: db}
+	for i := 0; i < posts; i++ {
+		postID, err := postModel.InsertWithUserIDAndCategories("post "+strconv.Itoa(i), "content", 1+i%3, []int{1 + i%5, 1 + (i+1)%5})
+		require.NoError(tb, err)
+		require.NoError(tb, postModel.ToggleVote(postID, 2, 1))
+		require.NoError(tb, postModel.ToggleVote(postID, 3, -1))
+		_, err = commentModel.Insert(postID, 2, "comment")
+		require.NoError(tb, err)
+	}
+	return db
+}
+
+func countQueries(tb testing.TB, fn func() error) int64 {
+	tb.Helper()
+	before := counter.queries.Load()
+	require.NoError(tb, fn())
+	return counter.queries.Load() - before
+}
+
+func TestListingQueryCountIsConstant(t *testing.T) {
+	db := openListingDB(t, 40)
+	postModel := &PostModel{DB: db}
+
+	listings := map[string]func(limit int) error{
+		"latest": func(limit int) error {
+			_, err := postModel.Latest(2, PageRequest{Limit: limit})
+			return err
+		},
+		"category": func(limit int) error {
+			_, err := postModel.GetByCategoryID(1, 2, PageRequest{Limit: limit})
+			return err
+		},
+		"liked": func(limit int) error {
+			_, err := postModel.GetLikedPostsByUserID(2, PageRequest{Limit: limit})
+			return err
+		},
+		"commented": func(limit int) error {
+			_, err := postModel.GetPostsWithUserComments(2, PageRequest{Limit: limit})
+			return err
+		},
+	}
+
+	for name, list := range listings {
+		small := countQueries(t, func() error { return list(2) })
+		large := countQueries(t, func() error { return list(MaxPageSize) })
+		assert.Equal(t, small, large, "%s: query count grew with page size", name)
+		assert.LessOrEqual(t, large, int64(3), "%s: too many queries per page", name)
+	}
+}
+
+func TestLatestFillsAggregates(t *testing.T) {
+	db := openListingDB(t, 3)
+	postModel := &PostModel{DB: db}
+
+	page, err := postModel.Latest(2, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, page.Posts, 3)
+
+	for _, post := range page.Posts {
+		assert.Equal(t, 1, post.Likes)
+		assert.Equal(t, 1, post.Dislikes)
+		assert.Equal(t, 1, post.CommentCount)
+		assert.Equal(t, 1, post.UserVote)
+		assert.True(t, post.UserCommented)
+		assert.Len(t, post.Categories, 2)
+	}
+}
+
+func BenchmarkLatest(b *testing.B) {
+	for _, size := range []int{10, 50} {
+		b.Run("page="+strconv.Itoa(size), func(b *testing.B) {
+			db := openListingDB(b, MaxPageSize)
+			postModel := &PostModel{DB: db}
+
+			start := counter.queries.Load()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := postModel.Latest(2, PageRequest{Limit: size}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(counter.queries.Load()-start)/float64(b.N), "queries/op")
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"forum/internal/database"
+	"time"
+)
+
+type PostRevision struct {
+	ID       int
+	PostID   int
+	EditorID int
+	Editor   string
+	Title    string
+	Content  string
+	Created  time.Time
+}
+
+type RevisionModel struct {
+	DB *database.DB
+}
+
+// ByPostID returns the stored revisions of a post, newest first. Each
+// revision holds the version that was replaced at Created.
+func (m *RevisionModel) ByPostID(postID int) ([]*PostRevision, error) {
+	stmt := `SELECT r.id, r.post_id, r.editor_id, COALESCE(u.username, ''), r.title, r.content, r.created
+             FROM post_revisions r
+             LEFT JOIN users u ON r.editor_id = u.id
+             WHERE r.post_id = ?
+             ORDER BY r.id DESC`
+	rows, err := m.DB.Query(stmt, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*PostRevision
+	for rows.Next() {
+		rev := &PostRevision{}
+		err := rows.Scan(&rev.ID, &rev.PostID, &rev.EditorID, &rev.Editor, &rev.Title, &rev.Content, &rev.Created)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (m *RevisionModel) Get(postID, revisionID int) (*PostRevision, error) {
+	rev := &PostRevision{}
+	err := m.DB.QueryRow(`SELECT id, post_id, editor_id, title, content, created
+                          FROM post_revisions WHERE id = ? AND post_id = ?`, revisionID, postID).
+		Scan(&rev.ID, &rev.PostID, &rev.EditorID, &rev.Title, &rev.Content, &rev.Created)
+	if err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
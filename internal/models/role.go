@@ -0,0 +1,321 @@
+package models
+
+import (
+	"errors"
+	"forum/internal/database"
+	"strings"
+)
+
+const (
+	PermViewUsers        = "users.view"
+	PermBanUsers         = "users.ban"
+	PermManageRoles      = "roles.manage"
+	PermManageCategories = "categories.manage"
+	PermEditPosts        = "posts.edit"
+	PermDeletePosts      = "posts.delete"
+	PermDeleteComments   = "comments.delete"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+type Permission struct {
+	Name        string
+	Description string
+}
+
+var AllPermissions = []Permission{
+	{PermViewUsers, "View the member list in the admin panel"},
+	{PermBanUsers, "Ban and unban members"},
+	{PermManageRoles, "Create roles and assign them to members"},
+	{PermManageCategories, "Create, edit and archive categories"},
+	{PermEditPosts, "Restore any revision of a post"},
+	{PermDeletePosts, "Delete any post"},
+	{PermDeleteComments, "Delete any comment"},
+}
+
+func IsKnownPermission(name string) bool {
+	for _, p := range AllPermissions {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+type PermissionSet map[string]bool
+
+func (s PermissionSet) Has(permission string) bool {
+	return s[permission]
+}
+
+type Role struct {
+	ID          int
+	Name        string
+	Description string
+	IsSystem    bool
+	Permissions PermissionSet
+	MemberCount int
+}
+
+type RoleModel struct {
+	DB *database.DB
+}
+
+var (
+	ErrDuplicateRole     = errors.New("role name already exists")
+	ErrSystemRole        = errors.New("system roles cannot be changed this way")
+	ErrInvalidRole       = errors.New("invalid role name")
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+// userRoleExpr resolves a user's role, treating accounts created before
+// roles existed (role_id IS NULL) as regular users.
+const userRoleExpr = `COALESCE(u.role_id, (SELECT id FROM roles WHERE name = 'user'))`
+
+func (m *RoleModel) All() ([]*Role, error) {
+	rows, err := m.DB.Query(`
+		SELECT r.id, r.name, r.description, r.is_system,
+		       (SELECT COUNT(*) FROM users u WHERE ` + userRoleExpr + ` = r.id)
+		FROM roles r
+		ORDER BY r.is_system DESC, r.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*Role
+	byID := make(map[int]*Role)
+	for rows.Next() {
+		role := &Role{Permissions: PermissionSet{}}
+		err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.IsSystem, &role.MemberCount)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+		byID[role.ID] = role
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	permRows, err := m.DB.Query(`SELECT role_id, permission FROM role_permissions`)
+	if err != nil {
+		return nil, err
+	}
+	defer permRows.Close()
+
+	for permRows.Next() {
+		var roleID int
+		var permission string
+		if err := permRows.Scan(&roleID, &permission); err != nil {
+			return nil, err
+		}
+		if role, ok := byID[roleID]; ok {
+			role.Permissions[permission] = true
+		}
+	}
+
+	return roles, permRows.Err()
+}
+
+func (m *RoleModel) Get(id int) (*Role, error) {
+	role := &Role{}
+	err := m.DB.QueryRow(`SELECT id, name, description, is_system FROM roles WHERE id = ?`, id).
+		Scan(&role.ID, &role.Name, &role.Description, &role.IsSystem)
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (m *RoleModel) Insert(name, description string, permissions []string) (int, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !ValidSlug(name) {
+		return 0, ErrInvalidRole
+	}
+	for _, p := range permissions {
+		if !IsKnownPermission(p) {
+			return 0, ErrUnknownPermission
+		}
+	}
+
+	var exists bool
+	err := m.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM roles WHERE name = ?)`, name).Scan(&exists)
+	if err != nil {
+		return 0, err
+	}
+	if exists {
+		return 0, ErrDuplicateRole
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var roleID int
+	err = tx.QueryRow(`INSERT INTO roles (name, description, is_system) VALUES (?, ?, FALSE) RETURNING id`, name, description).Scan(&roleID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	for _, p := range permissions {
+		_, err := tx.Exec(`INSERT INTO role_permissions (role_id, permission) VALUES (?, ?) ON CONFLICT DO NOTHING`, roleID, p)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return roleID, nil
+}
+
+// SetPermissions replaces the permission set of a role. The admin role always
+// holds every permission and cannot be edited.
+func (m *RoleModel) SetPermissions(roleID int, permissions []string) error {
+	role, err := m.Get(roleID)
+	if err != nil {
+		return err
+	}
+	if role.Name == RoleAdmin {
+		return ErrSystemRole
+	}
+	for _, p := range permissions {
+		if !IsKnownPermission(p) {
+			return ErrUnknownPermission
+		}
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM role_permissions WHERE role_id = ?`, roleID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, p := range permissions {
+		_, err := tx.Exec(`INSERT INTO role_permissions (role_id, permission) VALUES (?, ?) ON CONFLICT DO NOTHING`, roleID, p)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a custom role and moves its members back to the user role.
+func (m *RoleModel) Delete(roleID int) error {
+	role, err := m.Get(roleID)
+	if err != nil {
+		return err
+	}
+	if role.IsSystem {
+		return ErrSystemRole
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE users SET role_id = NULL WHERE role_id = ?`, roleID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM role_permissions WHERE role_id = ?`, roleID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM roles WHERE id = ?`, roleID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *RoleModel) AssignToUser(userID, roleID int) error {
+	if _, err := m.Get(roleID); err != nil {
+		return err
+	}
+	result, err := m.DB.Exec(`UPDATE users SET role_id = ? WHERE id = ?`, roleID, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// GrantAdmin gives the account that uses email the admin role. It is how
+// the first admin is made, as no account starts out with it.
+func (m *RoleModel) GrantAdmin(email string) error {
+	result, err := m.DB.Exec(`UPDATE users SET role_id = (SELECT id FROM roles WHERE name = ?) WHERE email = ?`, RoleAdmin, email)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+func (m *RoleModel) UserRole(userID int) (*Role, error) {
+	role := &Role{}
+	err := m.DB.QueryRow(`
+		SELECT r.id, r.name, r.description, r.is_system
+		FROM users u
+		JOIN roles r ON r.id = `+userRoleExpr+`
+		WHERE u.id = ?`, userID).Scan(&role.ID, &role.Name, &role.Description, &role.IsSystem)
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (m *RoleModel) UserPermissions(userID int) (PermissionSet, error) {
+	rows, err := m.DB.Query(`
+		SELECT rp.permission
+		FROM users u
+		JOIN role_permissions rp ON rp.role_id = `+userRoleExpr+`
+		WHERE u.id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := PermissionSet{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions[permission] = true
+	}
+	return permissions, rows.Err()
+}
+
+func (m *RoleModel) HasPermission(userID int, permission string) (bool, error) {
+	var allowed bool
+	err := m.DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1
+			FROM users u
+			JOIN role_permissions rp ON rp.role_id = `+userRoleExpr+`
+			WHERE u.id = ? AND rp.permission = ?
+		)`, userID, permission).Scan(&allowed)
+	return allowed, err
+}
@@ -0,0 +1,375 @@
+package models
+
+import (
+	"errors"
+	"forum/internal/database"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Snippets mark matched terms with these control characters. They never
+// appear in user input that passed validation, so handlers can escape the
+// snippet and then swap the markers for highlight tags.
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+const (
+	SortRelevance = "relevance"
+	SortRecent    = "recent"
+)
+
+const searchDateLayout = "2006-01-02"
+
+var (
+	ErrEmptySearch   = errors.New("search query is empty")
+	ErrInvalidSearch = errors.New("invalid search query")
+)
+
+// SearchQuery is a parsed search string. Terms holds single words and
+// quoted phrases; the remaining fields come from author:, category:,
+// from: and to: filters.
+type SearchQuery struct {
+	Terms    []string
+	Author   string
+	Category string
+	From     time.Time
+	To       time.Time
+	Sort     string
+}
+
+type SearchResult struct {
+	PostID    int
+	CommentID int
+	Title     string
+	Username  string
+	Created   time.Time
+	Snippet   string
+}
+
+type SearchModel struct {
+	DB *database.DB
+}
+
+// ParseSearchQuery splits raw into terms and filters. Quoted text is kept
+// as one phrase; from: and to: take dates as YYYY-MM-DD and are inclusive.
+func ParseSearchQuery(raw string) (SearchQuery, error) {
+	var q SearchQuery
+	for _, token := range tokenizeSearch(raw) {
+		if token.phrase {
+			q.Terms = append(q.Terms, token.text)
+			continue
+		}
+
+		key, value, ok := strings.Cut(token.text, ":")
+		if !ok || value == "" {
+			q.Terms = append(q.Terms, token.text)
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "author":
+			q.Author = value
+		case "category":
+			q.Category = strings.ToLower(value)
+		case "from", "to":
+			date, err := time.Parse(searchDateLayout, value)
+			if err != nil {
+				return q, ErrInvalidSearch
+			}
+			if strings.ToLower(key) == "from" {
+				q.From = date
+			} else {
+				q.To = date
+			}
+		default:
+			q.Terms = append(q.Terms, token.text)
+		}
+	}
+
+	if len(q.Terms) == 0 && q.Author == "" && q.Category == "" && q.From.IsZero() && q.To.IsZero() {
+		return q, ErrEmptySearch
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return q, ErrInvalidSearch
+	}
+	return q, nil
+}
+
+type searchToken struct {
+	text   string
+	phrase bool
+}
+
+func tokenizeSearch(raw string) []searchToken {
+	var tokens []searchToken
+	var current strings.Builder
+	inQuote := false
+
+	flush := func(phrase bool) {
+		text := strings.TrimSpace(current.String())
+		current.Reset()
+		if text != "" {
+			tokens = append(tokens, searchToken{text: text, phrase: phrase})
+		}
+	}
+
+	for _, r := range raw {
+		switch {
+		case r == '"':
+			flush(inQuote)
+			inQuote = !inQuote
+		case unicode.IsSpace(r) && !inQuote:
+			flush(false)
+		case unicode.IsControl(r):
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush(inQuote)
+	return tokens
+}
+
+// hasFullTextIndex reports whether the FTS5 index was created. SQLite
+// builds without FTS5 support and PostgreSQL fall back to substring
+// matching.
+func (m *SearchModel) hasFullTextIndex() (bool, error) {
+	if m.DB.Dialect != database.SQLite {
+		return false, nil
+	}
+	return m.DB.TableExists("search_index")
+}
+
+// Search returns one page of matches and whether another page follows.
+func (m *SearchModel) Search(q SearchQuery, limit, offset int) ([]*SearchResult, bool, error) {
+	if len(q.Terms) == 0 {
+		return m.run(q, searchPostsStmt, "posts.created", nil, limit, offset)
+	}
+
+	fullText, err := m.hasFullTextIndex()
+	if err != nil {
+		return nil, false, err
+	}
+	if !fullText {
+		return m.searchSubstring(q, limit, offset)
+	}
+
+	return m.run(q, searchFullTextStmt, "COALESCE(c.created, posts.created)", []any{ftsMatchExpr(q.Terms)}, limit, offset)
+}
+
+// ftsMatchExpr quotes every term so user input is never read as FTS5
+// syntax. A trailing * on a word becomes a prefix search.
+func ftsMatchExpr(terms []string) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		prefix := strings.HasSuffix(term, "*") && !strings.Contains(term, " ")
+		term = strings.TrimRight(term, "*")
+		if term == "" {
+			continue
+		}
+		part := `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix {
+			part += "*"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// Every search statement selects post ID, comment ID (0 for posts), post
+// title, author, created (as item_created) and snippet, and names the
+// matched item's author u and the post posts. Filters repeat the created
+// expression because PostgreSQL does not allow output names in WHERE.
+const searchFullTextStmt = `
+	SELECT posts.id, COALESCE(c.id, 0), posts.title, u.username, COALESCE(c.created, posts.created) AS item_created,
+	       snippet(search_index, -1, '` + HighlightStart + `', '` + HighlightEnd + `', '…', 16), bm25(search_index, 10.0, 1.0) AS score
+	FROM search_index
+	JOIN posts ON posts.id = search_index.post_id
+	LEFT JOIN comments c ON c.id = search_index.comment_id
+	JOIN users u ON u.id = COALESCE(c.user_id, posts.user_id)
+	WHERE search_index MATCH ? AND (c.id IS NULL OR c.deleted IS NULL)`
+
+const searchPostsStmt = `
+	SELECT posts.id, 0, posts.title, u.username, posts.created AS item_created, posts.content, 0 AS score
+	FROM posts
+	JOIN users u ON u.id = posts.user_id
+	WHERE 1 = 1`
+
+const searchSubstringStmt = `
+	SELECT posts.id, COALESCE(c.id, 0), posts.title, u.username, item.created AS item_created, item.content, 0 AS score
+	FROM (
+		SELECT id AS post_id, NULL AS comment_id, user_id, created, title, content FROM posts
+		UNION ALL
+		SELECT post_id, id, user_id, created, '', content FROM comments WHERE deleted IS NULL
+	) item
+	JOIN posts ON posts.id = item.post_id
+	LEFT JOIN comments c ON c.id = item.comment_id
+	JOIN users u ON u.id = item.user_id
+	WHERE 1 = 1`
+
+func (m *SearchModel) searchSubstring(q SearchQuery, limit, offset int) ([]*SearchResult, bool, error) {
+	stmt := searchSubstringStmt
+	like := m.DB.Dialect.CaseInsensitiveLike()
+	var args []any
+	for _, term := range q.Terms {
+		pattern := "%" + escapeLike(strings.TrimRight(term, "*")) + "%"
+		stmt += ` AND (item.title ` + like + ` ? ESCAPE '\' OR item.content ` + like + ` ? ESCAPE '\')`
+		args = append(args, pattern, pattern)
+	}
+
+	results, more, err := m.run(q, stmt, "item.created", args, limit, offset)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, result := range results {
+		result.Snippet = highlightSnippet(result.Snippet, q.Terms)
+	}
+	return results, more, nil
+}
+
+func (m *SearchModel) run(q SearchQuery, stmt, createdExpr string, args []any, limit, offset int) ([]*SearchResult, bool, error) {
+	stmt += ` AND posts.deleted IS NULL`
+	if q.Author != "" {
+		stmt += ` AND lower(u.username) = lower(?)`
+		args = append(args, q.Author)
+	}
+	if q.Category != "" {
+		stmt += ` AND posts.id IN (
+			SELECT pc.post_id FROM post_categories pc
+			JOIN categories cat ON cat.id = pc.category_id
+			WHERE cat.slug = ?)`
+		args = append(args, q.Category)
+	}
+	if !q.From.IsZero() {
+		stmt += ` AND ` + createdExpr + ` >= ?`
+		args = append(args, q.From.Format(searchDateLayout))
+	}
+	if !q.To.IsZero() {
+		stmt += ` AND ` + createdExpr + ` < ?`
+		args = append(args, q.To.AddDate(0, 0, 1).Format(searchDateLayout))
+	}
+
+	if q.Sort == SortRecent {
+		stmt += ` ORDER BY item_created DESC`
+	} else {
+		stmt += ` ORDER BY score, item_created DESC`
+	}
+	stmt += ` LIMIT ? OFFSET ?`
+	args = append(args, limit+1, offset)
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var results []*SearchResult
+	for rows.Next() {
+		result := &SearchResult{}
+		var score float64
+		var created string
+		err := rows.Scan(&result.PostID, &result.CommentID, &result.Title, &result.Username, &created, &result.Snippet, &score)
+		if err != nil {
+			return nil, false, err
+		}
+		result.Created = parseStoredTime(created)
+		results = append(results, result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	more := len(results) > limit
+	if more {
+		results = results[:limit]
+	}
+	if len(q.Terms) == 0 {
+		for _, result := range results {
+			result.Snippet = highlightSnippet(result.Snippet, nil)
+		}
+	}
+	return results, more, nil
+}
+
+// parseStoredTime reads a timestamp in any of the layouts SQLite columns in
+// this database hold, or in PostgreSQL's text form. COALESCE drops the
+// column type, so the driver hands these back as text.
+func parseStoredTime(value string) time.Time {
+	layouts := []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05.999999999-07:00",
+		"2006-01-02 15:04:05.999999999-07",
+		"2006-01-02T15:04:05.999999999-07:00",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02T15:04:05Z",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+const snippetRadius = 80
+
+// highlightSnippet cuts a window of text around the first matched term and
+// wraps every match in the highlight markers. It is the substring-search
+// counterpart of FTS5's snippet().
+func highlightSnippet(text string, terms []string) string {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	hit := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		needle := []rune(strings.ToLower(strings.TrimRight(term, "*")))
+		if len(needle) == 0 {
+			continue
+		}
+		for i := 0; i+len(needle) <= len(lower); i++ {
+			if string(lower[i:i+len(needle)]) != string(needle) {
+				continue
+			}
+			for k := i; k < i+len(needle); k++ {
+				hit[k] = true
+			}
+			if first == -1 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start := 0
+	if first > 0 {
+		start = max(0, first-snippetRadius/2)
+	}
+	end := min(len(runes), start+2*snippetRadius)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; i++ {
+		if hit[i] && (i == start || !hit[i-1]) {
+			b.WriteString(HighlightStart)
+		}
+		b.WriteRune(runes[i])
+		if hit[i] && (i+1 == end || !hit[i+1]) {
+			b.WriteString(HighlightEnd)
+		}
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
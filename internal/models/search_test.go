@@ -0,0 +1,51 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse(searchDateLayout, s)
+		require.NoError(t, err)
+		return d
+	}
+	tests := []struct {
+		raw  string
+		want SearchQuery
+		err  error
+	}{
+		{raw: "go channels", want: SearchQuery{Terms: []string{"go", "channels"}}},
+		{raw: `"worker pool" go`, want: SearchQuery{Terms: []string{"worker pool", "go"}}},
+		{raw: `go "unterminated phrase`, want: SearchQuery{Terms: []string{"go", "unterminated phrase"}}},
+		{raw: `"author:nurik"`, want: SearchQuery{Terms: []string{"author:nurik"}}},
+		{raw: "author:Nurik tips", want: SearchQuery{Terms: []string{"tips"}, Author: "Nurik"}},
+		{raw: "Category:Technology", want: SearchQuery{Category: "technology"}},
+		{raw: "from:2024-10-01 to:2024-10-31", want: SearchQuery{From: date("2024-10-01"), To: date("2024-10-31")}},
+		{raw: "author: http://example.com", want: SearchQuery{Terms: []string{"author:", "http://example.com"}}},
+		{raw: "lang:go", want: SearchQuery{Terms: []string{"lang:go"}}},
+		{raw: "from:yesterday", err: ErrInvalidSearch},
+		{raw: "from:2024-10-31 to:2024-10-01", err: ErrInvalidSearch},
+		{raw: `  "" `, err: ErrEmptySearch},
+		{raw: "", err: ErrEmptySearch},
+	}
+	for _, tt := range tests {
+		q, err := ParseSearchQuery(tt.raw)
+		if tt.err != nil {
+			assert.ErrorIs(t, err, tt.err, tt.raw)
+			continue
+		}
+		require.NoError(t, err, tt.raw)
+		assert.Equal(t, tt.want, q, tt.raw)
+	}
+}
+
+func TestFTSMatchExpr(t *testing.T) {
+	assert.Equal(t, `"worker pool" AND "go"*`, ftsMatchExpr([]string{"worker pool", "go*"}))
+	assert.Equal(t, `"say ""hi"""`, ftsMatchExpr([]string{`say "hi"`}))
+	assert.Equal(t, `"NEAR" AND "a OR b"`, ftsMatchExpr([]string{"NEAR", "a OR b", "*"}))
+}
@@ -0,0 +1,201 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"forum/internal/database"
+	"time"
+)
+
+var (
+	// SessionLifetime is how long a login lasts without use. Every use
+	// pushes the expiry out again.
+	SessionLifetime = 24 * time.Hour
+	// RememberLifetime replaces SessionLifetime for "remember me" logins.
+	RememberLifetime = 30 * 24 * time.Hour
+)
+
+// sessionTouchInterval limits how often a session in use is written back.
+const sessionTouchInterval = time.Minute
+
+var ErrNoSession = errors.New("session not found or expired")
+
+type Session struct {
+	ID        int
+	UserID    int
+	Created   time.Time
+	LastSeen  time.Time
+	Expiry    time.Time
+	Remember  bool
+	IP        string
+	UserAgent string
+}
+
+// SessionModel stores sessions by the SHA-256 hash of their token, so the
+// table alone cannot be used to sign in.
+type SessionModel struct {
+	DB *database.DB
+}
+
+func sessionLifetime(remember bool) time.Duration {
+	if remember {
+		return RememberLifetime
+	}
+	return SessionLifetime
+}
+
+func generateSessionToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// Create starts a session and returns its token.
+func (m *SessionModel) Create(userID int, remember bool, ip, userAgent string) (string, error) {
+	token, err := generateSessionToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().In(Timezone)
+	_, err = m.DB.Exec(`
+		INSERT INTO sessions (token_hash, user_id, created, last_seen, expiry, remember, ip, user_agent)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		hashToken(token), userID, now, now, now.Add(sessionLifetime(remember)), remember, ip, userAgent)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Get returns the live session for a token.
+func (m *SessionModel) Get(token string) (*Session, error) {
+	s := &Session{}
+	err := m.DB.QueryRow(`
+		SELECT id, user_id, created, last_seen, expiry, remember, ip, user_agent
+		FROM sessions
+		WHERE token_hash = ?`, hashToken(token)).
+		Scan(&s.ID, &s.UserID, &s.Created, &s.LastSeen, &s.Expiry, &s.Remember, &s.IP, &s.UserAgent)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoSession
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Now().After(s.Expiry) {
+		return nil, ErrNoSession
+	}
+	return s, nil
+}
+
+func (m *SessionModel) UserID(token string) (int, error) {
+	s, err := m.Get(token)
+	if err != nil {
+		return 0, err
+	}
+	return s.UserID, nil
+}
+
+// Touch records that a session was used and slides its expiry. To spare
+// the database it only writes when the last write is a while ago; the
+// returned flag tells whether it did.
+func (m *SessionModel) Touch(token, ip, userAgent string) (*Session, bool, error) {
+	s, err := m.Get(token)
+	if err != nil {
+		return nil, false, err
+	}
+	now := time.Now().In(Timezone)
+	if now.Sub(s.LastSeen) < sessionTouchInterval {
+		return s, false, nil
+	}
+
+	s.LastSeen, s.Expiry, s.IP, s.UserAgent = now, now.Add(sessionLifetime(s.Remember)), ip, userAgent
+	_, err = m.DB.Exec(`UPDATE sessions SET last_seen = ?, expiry = ?, ip = ?, user_agent = ? WHERE id = ?`,
+		s.LastSeen, s.Expiry, s.IP, s.UserAgent, s.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	return s, true, nil
+}
+
+// Rotate gives a session a new token and returns it. The old token stops
+// working at once.
+func (m *SessionModel) Rotate(token string) (string, error) {
+	newToken, err := generateSessionToken()
+	if err != nil {
+		return "", err
+	}
+	result, err := m.DB.Exec(`UPDATE sessions SET token_hash = ? WHERE token_hash = ?`,
+		hashToken(newToken), hashToken(token))
+	if err != nil {
+		return "", err
+	}
+	if err := requireAffected(result); err != nil {
+		return "", ErrNoSession
+	}
+	return newToken, nil
+}
+
+func (m *SessionModel) Delete(token string) error {
+	_, err := m.DB.Exec(`DELETE FROM sessions WHERE token_hash = ?`, hashToken(token))
+	return err
+}
+
+// ByUserID lists a user's live sessions, most recently used first.
+func (m *SessionModel) ByUserID(userID int) ([]*Session, error) {
+	rows, err := m.DB.Query(`
+		SELECT id, user_id, created, last_seen, expiry, remember, ip, user_agent
+		FROM sessions
+		WHERE user_id = ? AND expiry > ?
+		ORDER BY last_seen DESC, id DESC`, userID, time.Now().In(Timezone))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		s := &Session{}
+		err := rows.Scan(&s.ID, &s.UserID, &s.Created, &s.LastSeen, &s.Expiry, &s.Remember, &s.IP, &s.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke ends one of the user's sessions. Sessions of other users are
+// reported as missing.
+func (m *SessionModel) Revoke(userID, sessionID int) error {
+	result, err := m.DB.Exec(`DELETE FROM sessions WHERE id = ? AND user_id = ?`, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// RevokeOthers ends every session of the user except the one holding
+// token.
+func (m *SessionModel) RevokeOthers(userID int, token string) error {
+	_, err := m.DB.Exec(`DELETE FROM sessions WHERE user_id = ? AND token_hash <> ?`, userID, hashToken(token))
+	return err
+}
+
+// RevokeAll ends every session of the user.
+func (m *SessionModel) RevokeAll(userID int) error {
+	_, err := m.DB.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID)
+	return err
+}
+
+// DeleteExpired removes sessions past their expiry and returns how many
+// there were.
+func (m *SessionModel) DeleteExpired() (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM sessions WHERE expiry <= ?`, time.Now().In(Timezone))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
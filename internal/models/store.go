@@ -0,0 +1,207 @@
+package models
+
+import (
+	"forum/internal/database"
+	"forum/internal/live"
+	"net/http"
+	"time"
+)
+
+// The repositories below are what handlers depend on. The models in this
+// package implement them for SQLite and PostgreSQL alike; the dialect of
+// the database they are given decides how queries are sent.
+
+type PostRepository interface {
+	InsertWithUserIDAndCategories(title, content string, userID int, categoryIDs []int) (int, error)
+	Get(id int) (*Post, error)
+	Update(postID, editorID int, title, content string) error
+	Delete(postID, userID int) error
+	GetCategories(postID int) ([]string, error)
+	Latest(userID int, page PageRequest) (*PostPage, error)
+	GetByCategoryID(categoryID, userID int, page PageRequest) (*PostPage, error)
+	GetByUserID(authorID, viewerID int, page PageRequest) (*PostPage, error)
+	GetLikedPostsByUserID(userID int, page PageRequest) (*PostPage, error)
+	GetPostsWithUserComments(userID int, page PageRequest) (*PostPage, error)
+	GetCommentsByUserIDForPost(postID, userID int) ([]*Comment, error)
+	ToggleVote(postID, userID, voteType int) error
+	GetLikesAndDislikes(postID int) (int, int, error)
+	GetUserVote(postID, userID int) (int, error)
+	GetUsername(userID int) (string, error)
+	RefreshHTML() (int64, error)
+}
+
+type CommentRepository interface {
+	Insert(postID, userID int, content string) (int, error)
+	InsertReply(postID, parentID, userID int, content string) (int, error)
+	Get(commentID int) (*Comment, error)
+	GetThreadByPostID(postID, userID int) ([]*Comment, error)
+	GetByPostID(postID, userID int) ([]*Comment, error)
+	RecentByUserID(userID, limit int) ([]*Comment, error)
+	Update(commentID int, content string) error
+	Delete(commentID int) error
+	Remove(commentID, moderatorID int, reason string) error
+	CountByPostID(postID int) (int, error)
+	HasUserCommented(postID, userID int) (bool, error)
+	ToggleVote(commentID, userID, voteType int) error
+	GetLikesAndDislikes(commentID int) (int, int, error)
+	GetUserVote(commentID, userID int) (int, error)
+	RefreshHTML() (int64, error)
+}
+
+type UserRepository interface {
+	Get(id int) (*User, error)
+	Create(username, email, password string) error
+	Authenticate(email, password string) (int, error)
+	EmailExists(email string) (bool, error)
+	GetByEmail(email string) (*User, error)
+	GetByUsername(username string) (*User, error)
+	VerifyEmail(userID int, email string) error
+	SetEmail(userID int, email string) error
+	CheckPassword(userID int, password string) error
+	SetPassword(userID int, password string) error
+	SetUsername(userID int, username string) error
+	SetBio(userID int, bio string) error
+	SetAvatar(userID int, key string) error
+	ToggleBan(userID int) (bool, error)
+	Stats(userID int) (*UserStats, error)
+	Members() ([]*Member, error)
+	GetSessionUserIDFromRequest(r *http.Request) (int, error)
+}
+
+type SessionRepository interface {
+	Create(userID int, remember bool, ip, userAgent string) (string, error)
+	Get(token string) (*Session, error)
+	UserID(token string) (int, error)
+	Touch(token, ip, userAgent string) (*Session, bool, error)
+	Rotate(token string) (string, error)
+	Delete(token string) error
+	ByUserID(userID int) ([]*Session, error)
+	Revoke(userID, sessionID int) error
+	RevokeOthers(userID int, token string) error
+	RevokeAll(userID int) error
+	DeleteExpired() (int64, error)
+}
+
+type LoginFailureRepository interface {
+	Record(email, ip, userAgent, reason string) error
+	Recent(limit int) ([]*LoginFailure, error)
+	IPLockedUntil(ip string) (time.Time, error)
+	EmailLockedUntil(email string) (time.Time, error)
+	Clear(email string) error
+	DeleteBefore(t time.Time) (int64, error)
+}
+
+type TwoFactorRepository interface {
+	Enabled(userID int) (bool, error)
+	Enable(userID int, secret, code string) ([]string, error)
+	Disable(userID int) error
+	Verify(userID int, code string) error
+	NewRecoveryCodes(userID int) ([]string, error)
+	RecoveryCodesLeft(userID int) (int, error)
+	StartLogin(userID int, remember bool) (string, error)
+	PendingLogin(token string) (*PendingLogin, error)
+	FailLogin(token string) error
+	FinishLogin(token string) error
+	DeleteExpiredLogins() (int64, error)
+}
+
+type EmailTokenRepository interface {
+	New(purpose EmailTokenPurpose, userID int, email string) (string, error)
+	Check(purpose EmailTokenPurpose, token string) (*EmailToken, error)
+}
+
+type NotificationRepository interface {
+	ByUserID(userID, limit int) ([]*Notification, error)
+	UnreadCount(userID int) (int, error)
+	MarkRead(userID, id int) error
+	MarkAllRead(userID int) error
+	Muted(userID int) (map[NotificationType]bool, error)
+	SetMuted(userID int, types []NotificationType) error
+}
+
+type AttachmentRepository interface {
+	Insert(a *Attachment) (int, error)
+	Get(id int) (*Attachment, error)
+	ByPostID(postID int) ([]*Attachment, error)
+}
+
+type CategoryRepository interface {
+	Active() ([]*Category, error)
+	All() ([]*Category, error)
+	Get(id int) (*Category, error)
+	GetBySlug(slug string) (*Category, error)
+	Insert(name, slug, description string, sortOrder int) (int, error)
+	Update(id int, name, slug, description string, sortOrder int) error
+	SetArchived(id int, archived bool) error
+	FilterActive(ids []int) ([]int, error)
+}
+
+type RoleRepository interface {
+	All() ([]*Role, error)
+	Get(id int) (*Role, error)
+	Insert(name, description string, permissions []string) (int, error)
+	SetPermissions(roleID int, permissions []string) error
+	Delete(roleID int) error
+	AssignToUser(userID, roleID int) error
+	GrantAdmin(email string) error
+	UserRole(userID int) (*Role, error)
+	UserPermissions(userID int) (PermissionSet, error)
+	HasPermission(userID int, permission string) (bool, error)
+}
+
+type RevisionRepository interface {
+	ByPostID(postID int) ([]*PostRevision, error)
+	Get(postID, revisionID int) (*PostRevision, error)
+}
+
+type SearchRepository interface {
+	Search(q SearchQuery, limit, offset int) ([]*SearchResult, bool, error)
+}
+
+type APITokenRepository interface {
+	Create(userID int, name string, scopes []string) (string, error)
+	ByUserID(userID int) ([]*APIToken, error)
+	Revoke(userID, tokenID int) error
+	Authenticate(token string) (int, PermissionSet, error)
+}
+
+// Store bundles the repositories a request may need.
+type Store struct {
+	Posts         PostRepository
+	Comments      CommentRepository
+	Attachments   AttachmentRepository
+	Notifications NotificationRepository
+	Users         UserRepository
+	Sessions      SessionRepository
+	LoginFailures LoginFailureRepository
+	TwoFactor     TwoFactorRepository
+	EmailTokens   EmailTokenRepository
+	Categories    CategoryRepository
+	Roles         RoleRepository
+	Revisions     RevisionRepository
+	Search        SearchRepository
+	Tokens        APITokenRepository
+	// Events passes changes to the pages open in browsers.
+	Events *live.Hub
+}
+
+func NewStore(db *database.DB) *Store {
+	events := live.NewHub()
+	return &Store{
+		Posts:         &PostModel{DB: db, Events: events},
+		Comments:      &CommentModel{DB: db, Events: events},
+		Attachments:   &AttachmentModel{DB: db},
+		Notifications: &NotificationModel{DB: db},
+		Users:         &UserModel{DB: db},
+		Sessions:      &SessionModel{DB: db},
+		LoginFailures: &LoginFailureModel{DB: db},
+		TwoFactor:     &TwoFactorModel{DB: db},
+		EmailTokens:   &EmailTokenModel{DB: db},
+		Categories:    &CategoryModel{DB: db},
+		Roles:         &RoleModel{DB: db},
+		Revisions:     &RevisionModel{DB: db},
+		Search:        &SearchModel{DB: db},
+		Tokens:        &APITokenModel{DB: db},
+		Events:        events,
+	}
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"forum/internal/database"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeVote  = "vote"
+)
+
+var AllScopes = []Permission{
+	{ScopeRead, "Read posts, comments, categories and profiles"},
+	{ScopeWrite, "Create posts and comments"},
+	{ScopeVote, "Like and dislike posts and comments"},
+}
+
+// tokenPrefix marks forum API tokens so they are easy to spot in scripts
+// and secret scanners.
+const tokenPrefix = "fat_"
+
+// MaxTokensPerUser caps how many live tokens one account may hold.
+const MaxTokensPerUser = 20
+
+var (
+	ErrInvalidToken  = errors.New("invalid or revoked API token")
+	ErrUnknownScope  = errors.New("unknown token scope")
+	ErrNoScopes      = errors.New("a token needs at least one scope")
+	ErrTooManyTokens = errors.New("too many API tokens")
+)
+
+type APIToken struct {
+	ID       int
+	UserID   int
+	Name     string
+	Hint     string
+	Scopes   PermissionSet
+	Created  time.Time
+	LastUsed time.Time
+	Used     bool
+}
+
+type APITokenModel struct {
+	DB *database.DB
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func isKnownScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s.Name == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Create stores a new token and returns its plaintext. Only the SHA-256
+// hash is kept, so the plaintext cannot be shown again later.
+func (m *APITokenModel) Create(userID int, name string, scopes []string) (string, error) {
+	if len(scopes) == 0 {
+		return "", ErrNoScopes
+	}
+	for _, scope := range scopes {
+		if !isKnownScope(scope) {
+			return "", ErrUnknownScope
+		}
+	}
+
+	var count int
+	if err := m.DB.QueryRow(`SELECT COUNT(*) FROM api_tokens WHERE user_id = ?`, userID).Scan(&count); err != nil {
+		return "", err
+	}
+	if count >= MaxTokensPerUser {
+		return "", ErrTooManyTokens
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := tokenPrefix + hex.EncodeToString(raw)
+
+	_, err := m.DB.Exec(`INSERT INTO api_tokens (user_id, name, token_hash, hint, scopes, created) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, name, hashToken(token), token[len(token)-4:], strings.Join(scopes, ","), time.Now().In(Timezone))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (m *APITokenModel) ByUserID(userID int) ([]*APIToken, error) {
+	rows, err := m.DB.Query(`
+		SELECT id, user_id, name, hint, scopes, created, last_used
+		FROM api_tokens
+		WHERE user_id = ?
+		ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*APIToken
+	for rows.Next() {
+		token := &APIToken{}
+		var scopes string
+		var lastUsed sql.NullTime
+		err := rows.Scan(&token.ID, &token.UserID, &token.Name, &token.Hint, &scopes, &token.Created, &lastUsed)
+		if err != nil {
+			return nil, err
+		}
+		token.Scopes = parseScopes(scopes)
+		token.Used, token.LastUsed = lastUsed.Valid, lastUsed.Time
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// Revoke deletes one of the user's tokens. Tokens of other users are
+// reported as missing.
+func (m *APITokenModel) Revoke(userID, tokenID int) error {
+	result, err := m.DB.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// Authenticate resolves a plaintext token to its owner and scopes and
+// records when it was used. Tokens of banned users are rejected.
+func (m *APITokenModel) Authenticate(token string) (int, PermissionSet, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return 0, nil, ErrInvalidToken
+	}
+
+	var id, userID int
+	var scopes string
+	err := m.DB.QueryRow(`
+		SELECT t.id, t.user_id, t.scopes
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ? AND NOT u.is_banned`, hashToken(token)).Scan(&id, &userID, &scopes)
+	if err == sql.ErrNoRows {
+		return 0, nil, ErrInvalidToken
+	} else if err != nil {
+		return 0, nil, err
+	}
+
+	_, err = m.DB.Exec(`UPDATE api_tokens SET last_used = ? WHERE id = ?`, time.Now().In(Timezone), id)
+	if err != nil {
+		return 0, nil, err
+	}
+	return userID, parseScopes(scopes), nil
+}
+
+func parseScopes(s string) PermissionSet {
+	scopes := PermissionSet{}
+	for _, scope := range strings.Split(s, ",") {
+		if scope != "" {
+			scopes[scope] = true
+		}
+	}
+	return scopes
+}
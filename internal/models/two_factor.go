@@ -0,0 +1,301 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"forum/internal/database"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238) every authenticator app supports.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew also accepts codes one step either side of now, for
+	// phones whose clocks are slightly off.
+	totpSkew = 1
+)
+
+const (
+	recoveryCodeCount = 10
+	// maxTwoFactorAttempts wrong codes end a pending login, so guessing
+	// needs the password again.
+	maxTwoFactorAttempts = 5
+	pendingLoginLifetime = 5 * time.Minute
+)
+
+var (
+	ErrInvalidCode    = errors.New("incorrect two-factor code")
+	ErrNoPendingLogin = errors.New("pending login not found or expired")
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random 160-bit secret in base32, the form
+// authenticator apps expect.
+func NewTOTPSecret() (string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(raw), nil
+}
+
+// TOTPURI is the otpauth:// URI authenticator apps read from a QR code.
+func TOTPURI(issuer, account, secret string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {strconv.Itoa(totpDigits)},
+		"period":    {strconv.Itoa(totpPeriod)},
+	}
+	return "otpauth://totp/" + url.PathEscape(issuer) + ":" + url.PathEscape(account) + "?" + query.Encode()
+}
+
+// totpCode is the HOTP value (RFC 4226) of key for a time step.
+func totpCode(key []byte, step int64) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0F
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7FFFFFFF
+
+	code := strconv.Itoa(int(value % 1_000_000))
+	return strings.Repeat("0", totpDigits-len(code)) + code
+}
+
+// matchTOTP returns the time step code is valid for.
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// normalizeCode strips what people type around codes: spaces, dashes and
+// capitals.
+func normalizeCode(code string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
+
+func newRecoveryCode() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32NoPadding.EncodeToString(raw))
+	return code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:], nil
+}
+
+type PendingLogin struct {
+	UserID   int
+	Remember bool
+}
+
+type TwoFactorModel struct {
+	DB *database.DB
+}
+
+func (m *TwoFactorModel) Enabled(userID int) (bool, error) {
+	var enabled bool
+	err := m.DB.QueryRow(`SELECT totp_secret IS NOT NULL FROM users WHERE id = ?`, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// Enable turns on two-factor authentication once code shows the user's
+// app holds secret. It returns fresh recovery codes, which are stored
+// only as hashes.
+func (m *TwoFactorModel) Enable(userID int, secret, code string) ([]string, error) {
+	step, ok := matchTOTP(secret, normalizeCode(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE users SET totp_secret = ?, totp_last_step = ? WHERE id = ?`, secret, step, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireAffected(result); err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *database.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hashToken(normalizeCode(code)))
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+	return codes, nil
+}
+
+// Disable turns off two-factor authentication and drops the recovery
+// codes. Admins use it for members who lost both.
+func (m *TwoFactorModel) Disable(userID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE users SET totp_secret = NULL, totp_last_step = 0 WHERE id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Verify checks a code from the user's app or one of their recovery
+// codes. Either works only once.
+func (m *TwoFactorModel) Verify(userID int, code string) error {
+	code = normalizeCode(code)
+	if len(code) != totpDigits {
+		result, err := m.DB.Exec(`UPDATE recovery_codes SET used = ? WHERE user_id = ? AND code_hash = ? AND used IS NULL`,
+			time.Now().In(Timezone), userID, hashToken(code))
+		if err != nil {
+			return err
+		}
+		if requireAffected(result) != nil {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+
+	var secret sql.NullString
+	var lastStep int64
+	err := m.DB.QueryRow(`SELECT totp_secret, totp_last_step FROM users WHERE id = ?`, userID).Scan(&secret, &lastStep)
+	if err != nil {
+		return err
+	}
+	step, ok := matchTOTP(secret.String, code, time.Now())
+	if !secret.Valid || !ok || step <= lastStep {
+		return ErrInvalidCode
+	}
+	// The condition makes a code sent twice at once count only once.
+	result, err := m.DB.Exec(`UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, userID, step)
+	if err != nil {
+		return err
+	}
+	if requireAffected(result) != nil {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// NewRecoveryCodes replaces the user's recovery codes.
+func (m *TwoFactorModel) NewRecoveryCodes(userID int) ([]string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+func (m *TwoFactorModel) RecoveryCodesLeft(userID int) (int, error) {
+	var n int
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+// StartLogin records that userID passed the password check and returns
+// the token that lets them finish it with a code.
+func (m *TwoFactorModel) StartLogin(userID int, remember bool) (string, error) {
+	token, err := generateSessionToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = m.DB.Exec(`INSERT INTO pending_logins (token_hash, user_id, remember, expiry) VALUES (?, ?, ?, ?)`,
+		hashToken(token), userID, remember, time.Now().In(Timezone).Add(pendingLoginLifetime))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (m *TwoFactorModel) PendingLogin(token string) (*PendingLogin, error) {
+	login := &PendingLogin{}
+	var expiry time.Time
+	err := m.DB.QueryRow(`SELECT user_id, remember, expiry FROM pending_logins WHERE token_hash = ?`, hashToken(token)).
+		Scan(&login.UserID, &login.Remember, &expiry)
+	if err == sql.ErrNoRows || err == nil && time.Now().After(expiry) {
+		return nil, ErrNoPendingLogin
+	} else if err != nil {
+		return nil, err
+	}
+	return login, nil
+}
+
+// FailLogin counts a wrong code against a pending login and ends it after
+// maxTwoFactorAttempts.
+func (m *TwoFactorModel) FailLogin(token string) error {
+	hash := hashToken(token)
+	if _, err := m.DB.Exec(`UPDATE pending_logins SET attempts = attempts + 1 WHERE token_hash = ?`, hash); err != nil {
+		return err
+	}
+	_, err := m.DB.Exec(`DELETE FROM pending_logins WHERE token_hash = ? AND attempts >= ?`, hash, maxTwoFactorAttempts)
+	return err
+}
+
+func (m *TwoFactorModel) FinishLogin(token string) error {
+	_, err := m.DB.Exec(`DELETE FROM pending_logins WHERE token_hash = ?`, hashToken(token))
+	return err
+}
+
+// DeleteExpiredLogins removes pending logins nobody finished.
+func (m *TwoFactorModel) DeleteExpiredLogins() (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM pending_logins WHERE expiry <= ?`, time.Now().In(Timezone))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -1,17 +1,65 @@
 package models
 
 import (
-	"crypto/rand"
 	"database/sql"
-	"encoding/hex"
 	"errors"
+	"forum/internal/database"
 	"golang.org/x/crypto/bcrypt"
 	"net/http"
 	"time"
 )
 
+type User struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+	Role     string `json:"role"`
+	IsBanned bool   `json:"is_banned"`
+	// EmailVerified is false until the user opens the link sent to their
+	// address; until then they cannot post.
+	EmailVerified bool   `json:"email_verified"`
+	Bio           string `json:"bio"`
+	// AvatarKey is where an uploaded avatar is stored; without one the
+	// member is shown an identicon.
+	AvatarKey string `json:"-"`
+	// Created is nil for members who joined before join dates were kept
+	// and never posted.
+	Created *time.Time `json:"created,omitempty"`
+}
+
 type UserModel struct {
-	DB *sql.DB
+	DB *database.DB
+}
+
+func (m *UserModel) Get(id int) (*User, error) {
+	return m.getBy(`u.id = ?`, id)
+}
+
+func (m *UserModel) GetByEmail(email string) (*User, error) {
+	return m.getBy(`u.email = ?`, email)
+}
+
+// GetByUsername finds a member by their exact username.
+func (m *UserModel) GetByUsername(username string) (*User, error) {
+	return m.getBy(`u.username = ?`, username)
+}
+
+func (m *UserModel) getBy(condition string, arg any) (*User, error) {
+	user := &User{}
+	var created sql.NullTime
+	err := m.DB.QueryRow(`
+		SELECT u.id, u.username, u.email, r.name, u.is_banned, u.email_verified, u.bio, u.avatar_key, u.created
+		FROM users u
+		JOIN roles r ON r.id = `+userRoleExpr+`
+		WHERE `+condition, arg).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.IsBanned, &user.EmailVerified,
+		&user.Bio, &user.AvatarKey, &created)
+	if err != nil {
+		return nil, err
+	}
+	if created.Valid {
+		user.Created = &created.Time
+	}
+	return user, nil
 }
 
 func (m *UserModel) Create(username, email, password string) error {
@@ -19,85 +67,223 @@ func (m *UserModel) Create(username, email, password string) error {
 	if err != nil {
 		return err
 	}
-	stmt := `INSERT INTO users (username, email, password) VALUES (?, ?, ?)`
-	_, err = m.DB.Exec(stmt, username, email, string(hashedPassword))
+	stmt := `INSERT INTO users (username, email, password, role_id, created) VALUES (?, ?, ?, (SELECT id FROM roles WHERE name = ?), ?)`
+	_, err = m.DB.Exec(stmt, username, email, string(hashedPassword), RoleUser, time.Now().In(Timezone))
 	return err
 }
 
+var (
+	ErrUnknownEmail  = errors.New("no account uses this email")
+	ErrUserBanned    = errors.New("account is banned")
+	ErrWrongPassword = errors.New("incorrect password")
+)
+
+// dummyHash is compared against when no account uses an email, so that a
+// login takes as long whether or not one does.
+var dummyHash = []byte("$2a$10$8WCCHHQkJiDIyGHb8mdsi.aTH.Flv2fYIcbVe4iQ5mFNPtSvkB4jm")
+
+// Authenticate checks an email and password and returns the account's ID.
+// Whether an account is banned is only revealed to someone who knows its
+// password. Lockouts are up to the caller; see
+// LoginFailureModel.EmailLockedUntil.
 func (m *UserModel) Authenticate(email, password string) (int, error) {
 	var id int
 	var hashedPassword string
-	stmt := `SELECT id, password FROM users WHERE email = ?`
-	row := m.DB.QueryRow(stmt, email)
-	err := row.Scan(&id, &hashedPassword)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return 0, errors.New("invalid credentials")
-		}
+	var isBanned bool
+	err := m.DB.QueryRow(`SELECT id, password, is_banned FROM users WHERE email = ?`, email).Scan(&id, &hashedPassword, &isBanned)
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return 0, ErrUnknownEmail
+	} else if err != nil {
 		return 0, err
 	}
+
 	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	if err != nil {
-		if err == bcrypt.ErrMismatchedHashAndPassword {
-			return 0, errors.New("invalid credentials")
-		}
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, ErrWrongPassword
+	} else if err != nil {
 		return 0, err
 	}
+	if isBanned {
+		return 0, ErrUserBanned
+	}
 	return id, nil
 }
 
-func (m *UserModel) CreateSession(userID int) (string, error) {
-	sessionID, err := generateSessionID()
+func (m *UserModel) EmailExists(email string) (bool, error) {
+	var exists bool
+	err := m.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)`, email).Scan(&exists)
+	return exists, err
+}
+
+// CheckPassword reports ErrWrongPassword when password is not the user's
+// current one.
+func (m *UserModel) CheckPassword(userID int, password string) error {
+	var hashedPassword string
+	err := m.DB.QueryRow(`SELECT password FROM users WHERE id = ?`, userID).Scan(&hashedPassword)
 	if err != nil {
-		return "", err
+		return err
 	}
-	expiry := time.Now().Add(24 * time.Hour)
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrWrongPassword
+	}
+	return err
+}
 
-	stmt := `REPLACE INTO sessions (session_id, user_id, expiry) VALUES (?, ?, ?)`
-	_, err = m.DB.Exec(stmt, sessionID, userID, expiry)
+func (m *UserModel) SetPassword(userID int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return "", err
+		return err
 	}
-	return sessionID, nil
+	result, err := m.DB.Exec(`UPDATE users SET password = ? WHERE id = ?`, string(hashedPassword), userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
 }
 
-func (m *UserModel) DeleteSession(sessionID string) error {
-	stmt := `DELETE FROM sessions WHERE session_id = ?`
-	_, err := m.DB.Exec(stmt, sessionID)
-	return err
+// VerifyEmail marks email as confirmed, provided it is still the user's
+// address.
+func (m *UserModel) VerifyEmail(userID int, email string) error {
+	result, err := m.DB.Exec(`UPDATE users SET email_verified = ? WHERE id = ? AND email = ?`, true, userID, email)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
 }
 
-func (m *UserModel) GetSessionUserID(sessionID string) (int, error) {
-	var userID int
-	var expiry time.Time
-	stmt := `SELECT user_id, expiry FROM sessions WHERE session_id = ?`
-	err := m.DB.QueryRow(stmt, sessionID).Scan(&userID, &expiry)
+// SetEmail changes the user's address to one they have confirmed.
+func (m *UserModel) SetEmail(userID int, email string) error {
+	result, err := m.DB.Exec(`UPDATE users SET email = ?, email_verified = ? WHERE id = ?`, email, true, userID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return 0, errors.New("session not found")
-		}
-		return 0, err
+		return err
 	}
-	if time.Now().After(expiry) {
-		_ = m.DeleteSession(sessionID)
-		return 0, errors.New("session expired")
+	return requireAffected(result)
+}
+
+func (m *UserModel) SetUsername(userID int, username string) error {
+	result, err := m.DB.Exec(`UPDATE users SET username = ? WHERE id = ?`, username, userID)
+	if err != nil {
+		return err
 	}
-	return userID, nil
+	return requireAffected(result)
 }
 
-func generateSessionID() (string, error) {
-	randomBytes := make([]byte, 16)
-	_, err := rand.Read(randomBytes)
+func (m *UserModel) SetBio(userID int, bio string) error {
+	result, err := m.DB.Exec(`UPDATE users SET bio = ? WHERE id = ?`, bio, userID)
 	if err != nil {
-		return "", err
+		return err
+	}
+	return requireAffected(result)
+}
+
+// SetAvatar records where the member's avatar is stored; an empty key goes
+// back to the identicon.
+func (m *UserModel) SetAvatar(userID int, key string) error {
+	result, err := m.DB.Exec(`UPDATE users SET avatar_key = ? WHERE id = ?`, key, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// ToggleBan flips a user's ban and returns the new state.
+func (m *UserModel) ToggleBan(userID int) (bool, error) {
+	var banned bool
+	err := m.DB.QueryRow(`UPDATE users SET is_banned = NOT is_banned WHERE id = ? RETURNING is_banned`, userID).Scan(&banned)
+	return banned, err
+}
+
+// UserStats sums up a member's activity for the profile and admin pages.
+type UserStats struct {
+	PostCount             int
+	CommentCount          int
+	LikedPosts            int
+	DislikedPosts         int
+	LikeDislikeRatioPosts float64
+}
+
+// userStatsColumns selects a UserStats for the user aliased u.
+const userStatsColumns = `
+	(SELECT COUNT(*) FROM posts WHERE user_id = u.id AND deleted IS NULL),
+	(SELECT COUNT(*) FROM comments WHERE user_id = u.id AND deleted IS NULL),
+	(SELECT COUNT(*) FROM post_votes WHERE user_id = u.id AND vote_type = 1),
+	(SELECT COUNT(*) FROM post_votes WHERE user_id = u.id AND vote_type = -1),
+	COALESCE((SELECT AVG(vote_type) FROM post_votes WHERE user_id = u.id), 0)`
+
+func (s *UserStats) scanArgs() []any {
+	return []any{&s.PostCount, &s.CommentCount, &s.LikedPosts, &s.DislikedPosts, &s.LikeDislikeRatioPosts}
+}
+
+func (m *UserModel) Stats(userID int) (*UserStats, error) {
+	stats := &UserStats{}
+	err := m.DB.QueryRow(`SELECT `+userStatsColumns+` FROM users u WHERE u.id = ?`, userID).Scan(stats.scanArgs()...)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Member is a row of the admin member list. Protected members hold a role
+// that can manage roles and so cannot be banned. Staff members hold a role
+// with any permission, and only those who manage roles reset their
+// two-factor authentication.
+type Member struct {
+	UserStats
+	ID        int
+	Username  string
+	Email     string
+	IsBanned  bool
+	RoleID    int
+	RoleName  string
+	Protected bool
+	Staff     bool
+	// Locked is set while too many failed logins lock the account.
+	Locked      bool
+	LockedUntil time.Time
+	TwoFactor   bool
+}
+
+func (m *UserModel) Members() ([]*Member, error) {
+	lockouts, err := emailLockouts(m.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.Query(`
+		SELECT u.id, u.username, u.email, u.is_banned, u.totp_secret IS NOT NULL, r.id, r.name,
+			EXISTS(SELECT 1 FROM role_permissions WHERE role_id = r.id AND permission = ?),
+			EXISTS(SELECT 1 FROM role_permissions WHERE role_id = r.id),`+userStatsColumns+`
+		FROM users u
+		JOIN roles r ON r.id = `+userRoleExpr+`
+		ORDER BY u.id`, PermManageRoles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*Member
+	for rows.Next() {
+		member := &Member{}
+		dest := append([]any{&member.ID, &member.Username, &member.Email, &member.IsBanned, &member.TwoFactor,
+			&member.RoleID, &member.RoleName, &member.Protected, &member.Staff}, member.scanArgs()...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		member.LockedUntil, member.Locked = lockouts[member.Email]
+		members = append(members, member)
 	}
-	return hex.EncodeToString(randomBytes), nil
+	return members, rows.Err()
 }
 
+// GetSessionUserIDFromRequest returns the user signed in with the
+// request's session cookie.
 func (m *UserModel) GetSessionUserIDFromRequest(r *http.Request) (int, error) {
 	cookie, err := r.Cookie("session_id")
 	if err != nil {
 		return 0, err
 	}
-	return m.GetSessionUserID(cookie.Value)
+	sessions := &SessionModel{DB: m.DB}
+	return sessions.UserID(cookie.Value)
 }
@@ -0,0 +1,109 @@
+package qrcode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at version 1-M, from the worked example most QR
+	// tutorials use.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	assert.Equal(t, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}, reedSolomon(data, 10))
+}
+
+func TestEncode(t *testing.T) {
+	uri := "otpauth://totp/Forum:alice%40example.com?secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP&issuer=Forum"
+	code, err := Encode(uri)
+	require.NoError(t, err)
+	assert.Equal(t, 41, code.Size, "version 6")
+
+	// Both copies of the format information agree.
+	var first, second int
+	for i, p := range [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}} {
+		if code.Dark(p[0], p[1]) {
+			first |= 1 << i
+		}
+	}
+	for i := 0; i < 15; i++ {
+		x, y := code.Size-1-i, 8
+		if i >= 8 {
+			x, y = 8, code.Size-15+i
+		}
+		if code.Dark(x, y) {
+			second |= 1 << i
+		}
+	}
+	assert.Equal(t, first, second)
+	assert.Zero(t, (first^0x5412)>>13, "level M")
+
+	assert.Equal(t, uri, decode(t, code, (first^0x5412)>>10&7))
+
+	_, err = Encode(strings.Repeat("x", 214))
+	assert.ErrorIs(t, err, ErrTooLong)
+}
+
+// decode reads a version 6 code back, checking every block against its
+// error correction codewords.
+func decode(t *testing.T, code *Code, mask int) string {
+	layout := newCode(6)
+	bits := readBits(layout, code.Dark)
+	layout.applyMask(mask)
+	maskBits := readBits(layout, func(x, y int) bool { return layout.modules[y][x] })
+
+	raw := make([]byte, len(bits)/8)
+	for i := range raw {
+		for j := 0; j < 8; j++ {
+			if bits[8*i+j] != maskBits[8*i+j] {
+				raw[i] |= 0x80 >> j
+			}
+		}
+	}
+
+	// Version 6-M: four blocks of 27 data and 16 error correction
+	// codewords, interleaved.
+	blocks := make([][]byte, 4)
+	for i := 0; i < 43*4; i++ {
+		blocks[i%4] = append(blocks[i%4], raw[i])
+	}
+	var data []byte
+	for _, block := range blocks {
+		require.Equal(t, block[27:], reedSolomon(block[:27], 16))
+		data = append(data, block[:27]...)
+	}
+	length := int(data[0]&0x0F)<<4 | int(data[1]>>4)
+	out := make([]byte, length)
+	for i := range out {
+		out[i] = data[1+i]<<4 | data[2+i]>>4
+	}
+	return string(out)
+}
+
+// readBits reads the data modules in placement order: pairs of columns
+// from the right, the first pair upwards, the next downwards and so on,
+// stepping over the timing column.
+func readBits(layout *matrix, dark func(x, y int) bool) []bool {
+	var bits []bool
+	pair := 0
+	for right := layout.size - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for i := 0; i < layout.size; i++ {
+			y := i
+			if pair%2 == 0 {
+				y = layout.size - 1 - i
+			}
+			for _, x := range []int{right, right - 1} {
+				if !layout.function[y][x] {
+					bits = append(bits, dark(x, y))
+				}
+			}
+		}
+		pair++
+	}
+	return bits
+}
@@ -1,104 +1,245 @@
 package internal
 
 import (
-	"database/sql"
 	"forum/internal/handlers"
 	"forum/internal/models"
 	"net/http"
 	"strings"
 )
 
-func Router(db *sql.DB) *http.ServeMux {
+func Router(store *models.Store) *http.ServeMux {
 	mux := http.NewServeMux()
 
-	postModel := &models.PostModel{DB: db}
-	commentModel := &models.CommentModel{DB: db}
-
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
 			handlers.RenderError(w, http.StatusNotFound, "The page you are looking for does not exist.")
 			return
 		}
-		handlers.Home(w, r, postModel, commentModel, db)
+		handlers.Home(w, r, store)
 	})
 
-	mux.HandleFunc("/forum/technology", func(w http.ResponseWriter, r *http.Request) {
-		r.URL.RawQuery = "categoryID=1"
-		handlers.Home(w, r, postModel, commentModel, db)
-	})
-	mux.HandleFunc("/forum/entertainment", func(w http.ResponseWriter, r *http.Request) {
-		r.URL.RawQuery = "categoryID=2"
-		handlers.Home(w, r, postModel, commentModel, db)
-	})
-	mux.HandleFunc("/forum/sports", func(w http.ResponseWriter, r *http.Request) {
-		r.URL.RawQuery = "categoryID=3"
-		handlers.Home(w, r, postModel, commentModel, db)
-	})
-	mux.HandleFunc("/forum/education", func(w http.ResponseWriter, r *http.Request) {
-		r.URL.RawQuery = "categoryID=4"
-		handlers.Home(w, r, postModel, commentModel, db)
-	})
-	mux.HandleFunc("/forum/health", func(w http.ResponseWriter, r *http.Request) {
-		r.URL.RawQuery = "categoryID=5"
-		handlers.Home(w, r, postModel, commentModel, db)
+	mux.HandleFunc("/forum/c/", func(w http.ResponseWriter, r *http.Request) {
+		handlers.CategoryPosts(w, r, store)
 	})
 
-	mux.HandleFunc("/forum/posted", handlers.AuthorizeAndHandle(db, func(w http.ResponseWriter, r *http.Request, userID int) {
+	mux.HandleFunc("/forum/posted", handlers.AuthorizeAndHandle(store, func(w http.ResponseWriter, r *http.Request, userID int) {
 		r.URL.RawQuery = "myPosts=1"
-		handlers.Home(w, r, postModel, commentModel, db)
+		handlers.Home(w, r, store)
 	}))
 
-	mux.HandleFunc("/forum/liked", handlers.AuthorizeAndHandle(db, func(w http.ResponseWriter, r *http.Request, userID int) {
+	mux.HandleFunc("/forum/liked", handlers.AuthorizeAndHandle(store, func(w http.ResponseWriter, r *http.Request, userID int) {
 		r.URL.RawQuery = "likedPosts=1"
-		handlers.Home(w, r, postModel, commentModel, db)
+		handlers.Home(w, r, store)
 	}))
 
-	mux.HandleFunc("/forum/commented", handlers.AuthorizeAndHandle(db, func(w http.ResponseWriter, r *http.Request, userID int) {
+	mux.HandleFunc("/forum/commented", handlers.AuthorizeAndHandle(store, func(w http.ResponseWriter, r *http.Request, userID int) {
 		r.URL.RawQuery = "commentedPosts=1"
-		handlers.Home(w, r, postModel, commentModel, db)
+		handlers.Home(w, r, store)
 	}))
 
 	mux.HandleFunc("/post/", func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasSuffix(r.URL.Path, "/comment") {
-			handlers.AddComment(w, r, db)
-		} else {
-			handlers.PostView(w, r, db)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/comment"):
+			handlers.AddComment(w, r, store)
+		case strings.HasSuffix(r.URL.Path, "/reply"):
+			handlers.AddReply(w, r, store)
+		case strings.HasSuffix(r.URL.Path, "/edit"):
+			handlers.PostEdit(w, r, store)
+		case strings.HasSuffix(r.URL.Path, "/delete"):
+			handlers.PostDelete(w, r, store)
+		case strings.HasSuffix(r.URL.Path, "/revisions"):
+			handlers.PostRevisions(w, r, store)
+		case strings.HasSuffix(r.URL.Path, "/restore"):
+			handlers.RequirePermission(store, models.PermEditPosts, func(w http.ResponseWriter, r *http.Request, userID int) {
+				handlers.PostRestoreRevision(w, r, store, userID)
+			})(w, r)
+		default:
+			handlers.PostView(w, r, store)
+		}
+	})
+
+	mux.HandleFunc("/comment/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/edit"):
+			handlers.EditComment(w, r, store)
+		case strings.HasSuffix(r.URL.Path, "/delete"):
+			handlers.DeleteComment(w, r, store)
+		case strings.HasSuffix(r.URL.Path, "/remove"):
+			handlers.RemoveComment(w, r, store)
+		default:
+			handlers.RenderError(w, http.StatusNotFound, "The page you are looking for does not exist.")
 		}
 	})
 
 	mux.HandleFunc("/forum/profile", func(w http.ResponseWriter, r *http.Request) {
-		handlers.UserProfile(w, r, db)
+		handlers.UserProfile(w, r, store)
 	})
-	mux.HandleFunc("/forum/login", handlers.Login(db))
+	mux.HandleFunc("/forum/login", handlers.Login(store))
+	mux.HandleFunc("/forum/login/2fa", handlers.LoginTwoFactor(store))
 	mux.HandleFunc("/forum/signup", func(w http.ResponseWriter, r *http.Request) {
-		handlers.SignUp(w, r, db)
+		handlers.SignUp(w, r, store)
+	})
+	mux.HandleFunc("/forum/forgot-password", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ForgotPassword(w, r, store)
+	})
+	mux.HandleFunc("/forum/reset-password", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ResetPassword(w, r, store)
+	})
+	mux.HandleFunc("/forum/verify-email", func(w http.ResponseWriter, r *http.Request) {
+		handlers.VerifyEmail(w, r, store)
+	})
+	mux.HandleFunc("/forum/confirm-email", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ConfirmEmail(w, r, store)
 	})
 	mux.HandleFunc("/forum/logout", func(w http.ResponseWriter, r *http.Request) {
-		handlers.Logout(w, r, db)
+		handlers.Logout(w, r, store)
+	})
+	mux.HandleFunc("/forum/search", func(w http.ResponseWriter, r *http.Request) {
+		handlers.Search(w, r, store)
 	})
+
 	mux.HandleFunc("/forum/create", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
-			handlers.PostCreateForm(w, r, db)
+			handlers.PostCreateForm(w, r, store)
 		} else {
-			handlers.PostCreate(w, r, db)
+			handlers.PostCreate(w, r, store)
 		}
 	})
+	mux.HandleFunc("/forum/preview", func(w http.ResponseWriter, r *http.Request) {
+		handlers.Preview(w, r, store)
+	})
+	mux.HandleFunc("/forum/u/", func(w http.ResponseWriter, r *http.Request) {
+		handlers.PublicProfile(w, r, store)
+	})
+	mux.HandleFunc("/avatars/", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ServeAvatar(w, r, store)
+	})
+	mux.HandleFunc("/attachments/", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ServeAttachment(w, r, store)
+	})
 	mux.HandleFunc("/toggle-vote", func(w http.ResponseWriter, r *http.Request) {
-		handlers.ToggleVote(w, r, db)
+		handlers.ToggleVote(w, r, store)
 	})
 
 	mux.HandleFunc("/toggle-comment-vote", func(w http.ResponseWriter, r *http.Request) {
-		handlers.ToggleCommentVote(w, r, db)
+		handlers.ToggleCommentVote(w, r, store)
 	})
 
-	mux.HandleFunc("/forum/toggle-ban", func(w http.ResponseWriter, r *http.Request) {
-		handlers.ToggleBanStatus(w, r, db)
-	})
+	mux.HandleFunc("/forum/toggle-ban", handlers.RequirePermission(store, models.PermBanUsers, func(w http.ResponseWriter, r *http.Request, userID int) {
+		handlers.ToggleBanStatus(w, r, store, userID)
+	}))
+	mux.HandleFunc("/forum/admin/categories/create", handlers.RequirePermission(store, models.PermManageCategories, func(w http.ResponseWriter, r *http.Request, userID int) {
+		handlers.CreateCategory(w, r, store)
+	}))
+	mux.HandleFunc("/forum/admin/categories/update", handlers.RequirePermission(store, models.PermManageCategories, func(w http.ResponseWriter, r *http.Request, userID int) {
+		handlers.UpdateCategory(w, r, store)
+	}))
+	mux.HandleFunc("/forum/admin/categories/archive", handlers.RequirePermission(store, models.PermManageCategories, func(w http.ResponseWriter, r *http.Request, userID int) {
+		handlers.ToggleCategoryArchive(w, r, store)
+	}))
+	mux.HandleFunc("/forum/admin/roles/create", handlers.RequirePermission(store, models.PermManageRoles, func(w http.ResponseWriter, r *http.Request, userID int) {
+		handlers.CreateRole(w, r, store)
+	}))
+	mux.HandleFunc("/forum/admin/roles/update", handlers.RequirePermission(store, models.PermManageRoles, func(w http.ResponseWriter, r *http.Request, userID int) {
+		handlers.UpdateRolePermissions(w, r, store)
+	}))
+	mux.HandleFunc("/forum/admin/roles/delete", handlers.RequirePermission(store, models.PermManageRoles, func(w http.ResponseWriter, r *http.Request, userID int) {
+		handlers.DeleteRole(w, r, store)
+	}))
+	mux.HandleFunc("/forum/admin/users/unlock", handlers.RequirePermission(store, models.PermBanUsers, func(w http.ResponseWriter, r *http.Request, userID int) {
+		handlers.UnlockUser(w, r, store, userID)
+	}))
+	mux.HandleFunc("/forum/admin/users/reset-2fa", handlers.RequirePermission(store, models.PermBanUsers, func(w http.ResponseWriter, r *http.Request, userID int) {
+		handlers.ResetTwoFactor(w, r, store, userID)
+	}))
+	mux.HandleFunc("/forum/admin/users/role", handlers.RequirePermission(store, models.PermManageRoles, func(w http.ResponseWriter, r *http.Request, userID int) {
+		handlers.AssignUserRole(w, r, store, userID)
+	}))
 	mux.HandleFunc("/forum/profile/change-password", func(w http.ResponseWriter, r *http.Request) {
-		handlers.ChangePassword(db).ServeHTTP(w, r)
+		handlers.ChangePassword(store).ServeHTTP(w, r)
 	})
 	mux.HandleFunc("/forum/profile/change-name", func(w http.ResponseWriter, r *http.Request) {
-		handlers.ChangeName(db).ServeHTTP(w, r)
+		handlers.ChangeName(store).ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/forum/profile/change-email", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ChangeEmail(w, r, store)
+	})
+	mux.HandleFunc("/forum/profile/avatar", func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateAvatar(w, r, store)
+	})
+	mux.HandleFunc("/forum/profile/bio", func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateBio(w, r, store)
+	})
+	mux.HandleFunc("/forum/profile/notifications", func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateNotificationPreferences(w, r, store)
+	})
+	mux.HandleFunc("/forum/notifications", func(w http.ResponseWriter, r *http.Request) {
+		handlers.Notifications(w, r, store)
+	})
+	mux.HandleFunc("/forum/notifications/read", func(w http.ResponseWriter, r *http.Request) {
+		handlers.MarkNotificationRead(w, r, store)
+	})
+	mux.HandleFunc("/forum/notifications/read-all", func(w http.ResponseWriter, r *http.Request) {
+		handlers.MarkAllNotificationsRead(w, r, store)
+	})
+	mux.HandleFunc("/forum/profile/verify-email/resend", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ResendVerification(w, r, store)
+	})
+	mux.HandleFunc("/forum/profile/tokens/create", func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreateAPIToken(w, r, store)
+	})
+	mux.HandleFunc("/forum/profile/tokens/revoke", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RevokeAPIToken(w, r, store)
+	})
+	mux.HandleFunc("/forum/profile/sessions/revoke", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RevokeSession(w, r, store)
+	})
+	mux.HandleFunc("/forum/profile/sessions/revoke-all", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RevokeAllSessions(w, r, store)
+	})
+	mux.HandleFunc("/forum/profile/2fa/setup", func(w http.ResponseWriter, r *http.Request) {
+		handlers.TwoFactorSetup(w, r, store)
+	})
+	mux.HandleFunc("/forum/profile/2fa/disable", func(w http.ResponseWriter, r *http.Request) {
+		handlers.DisableTwoFactor(w, r, store)
+	})
+	mux.HandleFunc("/forum/profile/2fa/recovery-codes", func(w http.ResponseWriter, r *http.Request) {
+		handlers.NewRecoveryCodes(w, r, store)
+	})
+
+	mux.HandleFunc("/api/", handlers.APINotFound)
+	mux.HandleFunc("/api/v1/posts", func(w http.ResponseWriter, r *http.Request) {
+		handlers.APIPosts(w, r, store)
+	})
+	mux.HandleFunc("/api/v1/posts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.APIPost(w, r, store)
+	})
+	mux.HandleFunc("/api/v1/posts/{id}/comments", func(w http.ResponseWriter, r *http.Request) {
+		handlers.APIPostComments(w, r, store)
+	})
+	mux.HandleFunc("/api/v1/posts/{id}/vote", func(w http.ResponseWriter, r *http.Request) {
+		handlers.APIPostVote(w, r, store)
+	})
+	mux.HandleFunc("/api/v1/comments/{id}/vote", func(w http.ResponseWriter, r *http.Request) {
+		handlers.APICommentVote(w, r, store)
+	})
+	mux.HandleFunc("/events/post/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.PostEvents(w, r, store)
+	})
+	mux.HandleFunc("/events/feed", func(w http.ResponseWriter, r *http.Request) {
+		handlers.FeedEvents(w, r, store)
+	})
+	mux.HandleFunc("/events/c/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.FeedEvents(w, r, store)
+	})
+	mux.HandleFunc("/api/v1/categories", func(w http.ResponseWriter, r *http.Request) {
+		handlers.APICategories(w, r, store)
+	})
+	mux.HandleFunc("/api/v1/me", func(w http.ResponseWriter, r *http.Request) {
+		handlers.APIMe(w, r, store)
+	})
+	mux.HandleFunc("/api/v1/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.APIUser(w, r, store)
 	})
 
 	return mux
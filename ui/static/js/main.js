@@ -17,6 +17,13 @@ if (titleInput) {
     });
 }
 
+// csrfToken reads the token the server keeps in the csrf_token cookie.
+// Every POST made with fetch sends it back in the X-CSRF-Token header.
+function csrfToken() {
+    const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+    return match ? decodeURIComponent(match[1]) : "";
+}
+
 function showAlert(message) {
     if (document.querySelector(".alert-box")) return;
 
@@ -36,6 +43,7 @@ function toggleVote(postID, voteType) {
         method: "POST",
         headers: {
             "Content-Type": "application/x-www-form-urlencoded",
+            "X-CSRF-Token": csrfToken(),
         },
         body: `postID=${postID}&voteType=${voteType}`
     })
@@ -43,7 +51,10 @@ function toggleVote(postID, voteType) {
             if (response.status === 401) {
                 window.location.href = "/forum/login";
             } else if (response.ok) {
-                window.location.reload();
+                return response.json().then(result => {
+                    document.querySelectorAll(`.post-votes[data-post-id="${postID}"]`)
+                        .forEach(votes => showVotes(votes, result));
+                });
             } else {
                 alert("An error occurred while attempting to vote.");
             }
@@ -53,6 +64,20 @@ function toggleVote(postID, voteType) {
         });
 }
 
+// showVotes puts new counts on a pair of like and dislike buttons. The
+// user's own vote is only known after they voted, so it is left alone
+// when result has none.
+function showVotes(votes, result) {
+    const like = votes.querySelector(":scope > .like-button");
+    const dislike = votes.querySelector(":scope > .dislike-button");
+    like.querySelector(".vote-count").textContent = result.likes;
+    dislike.querySelector(".vote-count").textContent = result.dislikes;
+    if ("user_vote" in result) {
+        like.classList.toggle("active", result.user_vote === 1);
+        dislike.classList.toggle("active", result.user_vote === -1);
+    }
+}
+
 const form = document.querySelector("form[action='/forum/create']");
 if (form) {
     form.addEventListener("submit", function(event) {
@@ -66,26 +91,12 @@ if (form) {
     });
 }
 
-function filterByCategory(category) {
-    switch (category) {
-        case 1:
-            window.location.href = "/forum/technology";
-            break;
-        case 2:
-            window.location.href = "/forum/entertainment";
-            break;
-        case 3:
-            window.location.href = "/forum/sports";
-            break;
-        case 4:
-            window.location.href = "/forum/education";
-            break;
-        case 5:
-            window.location.href = "/forum/health";
-            break;
-        default:
-            window.location.href = "/";
+function filterByCategory(slug) {
+    if (!slug) {
+        window.location.href = "/";
+        return;
     }
+    window.location.href = `/forum/c/${encodeURIComponent(slug)}`;
 }
 
 function filterLikedPosts() {
@@ -131,7 +142,7 @@ function toggleBan(userID, button) {
     const action = isBanned ? "unban" : "ban";
 
     if (confirm(`Are you sure you want to ${action} this user?`)) {
-        fetch(`/forum/toggle-ban?userID=${userID}`, { method: 'POST' })
+        fetch(`/forum/toggle-ban?userID=${userID}`, { method: 'POST', headers: { "X-CSRF-Token": csrfToken() } })
             .then(response => {
                 if (response.ok) {
                     alert(`User has been ${action}ned successfully.`);
@@ -156,7 +167,7 @@ document.addEventListener("DOMContentLoaded", () => {
                 : "Are you sure you want to unban this user?";
 
             showAlertWithConfirmationBan(confirmMessage, () => {
-                fetch(`/forum/toggle-ban?userID=${userID}`, { method: "POST" })
+                fetch(`/forum/toggle-ban?userID=${userID}`, { method: "POST", headers: { "X-CSRF-Token": csrfToken() } })
                     .then((response) => {
                         if (response.ok) {
                             button.textContent = action === "Ban" ? "Unban" : "Ban";
@@ -244,6 +255,7 @@ function toggleCommentVote(commentID, voteType) {
         method: "POST",
         headers: {
             "Content-Type": "application/x-www-form-urlencoded",
+            "X-CSRF-Token": csrfToken(),
         },
         body: `commentID=${commentID}&voteType=${voteType}`
     })
@@ -251,7 +263,10 @@ function toggleCommentVote(commentID, voteType) {
             if (response.status === 401) {
                 window.location.href = "/forum/login";
             } else if (response.ok) {
-                window.location.reload();
+                return response.json().then(result => {
+                    const votes = document.querySelector(`.comment-votes[data-comment-id="${commentID}"]`);
+                    if (votes) showVotes(votes, result);
+                });
             } else {
                 alert("An error occurred while attempting to vote.");
             }
@@ -260,3 +275,231 @@ function toggleCommentVote(commentID, voteType) {
             alert("An error occurred while attempting to vote.");
         });
 }
+
+function openEditCategory(button) {
+    document.getElementById('edit-category-id').value = button.dataset.categoryId;
+    document.getElementById('edit-category-name').value = button.dataset.categoryName;
+    document.getElementById('edit-category-slug').value = button.dataset.categorySlug;
+    document.getElementById('edit-category-description').value = button.dataset.categoryDescription;
+    document.getElementById('edit-category-sort-order').value = button.dataset.categorySortOrder;
+
+    openModal('edit-category-modal');
+}
+
+function toggleReplyForm(commentID) {
+    toggleCommentForm("reply", commentID);
+}
+
+function toggleCommentForm(kind, commentID) {
+    const form = document.getElementById(`${kind}-form-${commentID}`);
+    if (!form) return;
+
+    form.hidden = !form.hidden;
+    if (!form.hidden) {
+        form.querySelector("textarea, input[type=text]").focus();
+    }
+}
+
+function toggleThread(commentID, button) {
+    const replies = document.getElementById(`replies-${commentID}`);
+    if (!replies) return;
+
+    const collapsed = !replies.hidden;
+    replies.hidden = collapsed;
+    button.setAttribute("aria-expanded", String(!collapsed));
+    button.textContent = collapsed ? `[+${replies.querySelectorAll(".comment").length}]` : "[\u2212]";
+}
+
+// togglePreview shows the rendered Markdown of the form's text below it and
+// keeps it up to date while the author types.
+function togglePreview(button) {
+    const form = button.closest("form");
+    const textarea = form.querySelector("textarea[name=content]");
+    const pane = form.querySelector(".preview-pane");
+
+    pane.hidden = !pane.hidden;
+    button.textContent = pane.hidden ? "Preview" : "Hide Preview";
+    if (pane.hidden) return;
+
+    if (!textarea.dataset.previewBound) {
+        textarea.dataset.previewBound = "true";
+        let timer;
+        textarea.addEventListener("input", () => {
+            clearTimeout(timer);
+            timer = setTimeout(() => updatePreview(textarea, pane), 300);
+        });
+    }
+    updatePreview(textarea, pane);
+}
+
+function updatePreview(textarea, pane) {
+    if (pane.hidden) return;
+
+    fetch("/forum/preview", {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/x-www-form-urlencoded",
+            "X-CSRF-Token": csrfToken(),
+        },
+        body: new URLSearchParams({ content: textarea.value }),
+    })
+        .then(response => {
+            if (response.status === 401) {
+                window.location.href = "/forum/login";
+                return;
+            }
+            if (!response.ok) throw new Error(response.statusText);
+            return response.text().then(html => { pane.innerHTML = html; });
+        })
+        .catch(() => {
+            pane.textContent = "The preview could not be loaded.";
+        });
+}
+
+// Images pasted into the content of a new post, such as screenshots, are
+// added to its attachments.
+document.addEventListener("DOMContentLoaded", () => {
+    const input = document.getElementById("attachments");
+    const textarea = document.querySelector(".create-post-form textarea[name=content]");
+    if (!input || !textarea) return;
+
+    textarea.addEventListener("paste", (event) => {
+        const pasted = Array.from(event.clipboardData.files).filter(file => file.type.startsWith("image/"));
+        if (pasted.length === 0) return;
+        event.preventDefault();
+
+        const files = new DataTransfer();
+        Array.from(input.files).forEach(file => files.items.add(file));
+        const room = Number(input.dataset.maxFiles) - files.files.length;
+        if (room <= 0) {
+            alert(`A post can have at most ${input.dataset.maxFiles} attachments.`);
+            return;
+        }
+        pasted.slice(0, room).forEach(file => files.items.add(file));
+        input.files = files.files;
+    });
+});
+
+// Pages with a data-events attribute follow the server's event stream:
+// vote and comment counts are kept current, new comments are added to an
+// open post and lists offer to show new posts. EventSource reconnects on
+// its own when the stream drops.
+document.addEventListener("DOMContentLoaded", () => {
+    const page = document.querySelector("[data-events]");
+    if (!page || !window.EventSource) return;
+
+    const events = new EventSource(page.dataset.events);
+    window.addEventListener("pagehide", () => events.close());
+
+    events.addEventListener("votes", (event) => {
+        const result = JSON.parse(event.data);
+        delete result.user_vote;
+        document.querySelectorAll(`.post-votes[data-post-id="${result.post_id}"]`)
+            .forEach(votes => showVotes(votes, result));
+    });
+
+    events.addEventListener("comment_votes", (event) => {
+        const result = JSON.parse(event.data);
+        const votes = document.querySelector(`.comment-votes[data-comment-id="${result.comment_id}"]`);
+        if (votes) showVotes(votes, result);
+    });
+
+    events.addEventListener("comments", (event) => {
+        const result = JSON.parse(event.data);
+        const count = document.querySelector(`.post-votes[data-post-id="${result.post_id}"] .comment-count`);
+        if (count) count.textContent = result.comments;
+    });
+
+    events.addEventListener("comment", (event) => addComment(JSON.parse(event.data)));
+
+    let newPosts = 0;
+    events.addEventListener("post", () => {
+        if (!("newPosts" in page.dataset)) return;
+        const notice = page.querySelector(".new-posts-notice");
+        newPosts++;
+        notice.textContent = newPosts === 1 ? "1 new post \u2014 show" : `${newPosts} new posts \u2014 show`;
+        notice.hidden = false;
+    });
+});
+
+const months = ["Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"];
+
+// formatCreated writes a server timestamp the way the templates do, in the
+// forum's time zone rather than the browser's.
+function formatCreated(created) {
+    const m = created.match(/^(\d{4})-(\d{2})-(\d{2})T(\d{2}):(\d{2})/);
+    if (!m) return created;
+    return `${m[3]} ${months[Number(m[2]) - 1]} ${m[1]} at ${m[4]}:${m[5]}`;
+}
+
+// addComment shows a comment someone else just posted. It has no reply or
+// edit controls; they appear after a reload.
+function addComment(comment) {
+    if (document.getElementById(`comment-${comment.id}`)) return;
+
+    const list = document.querySelector(".comments-list");
+    let container = list;
+    if (comment.parent_id) {
+        const parent = document.getElementById(`comment-${comment.parent_id}`);
+        if (!parent) return;
+        container = document.getElementById(`replies-${comment.parent_id}`);
+        if (!container) {
+            container = document.createElement("div");
+            container.className = "comment-replies";
+            container.id = `replies-${comment.parent_id}`;
+            parent.appendChild(container);
+        }
+    } else {
+        list.querySelector("h4").hidden = false;
+        const empty = list.querySelector(".no-comments");
+        if (empty) empty.remove();
+    }
+
+    const element = document.createElement("div");
+    element.className = "comment new-comment";
+    element.id = `comment-${comment.id}`;
+
+    const meta = document.createElement("div");
+    meta.className = "comment-meta";
+    const author = document.createElement("span");
+    const avatar = document.createElement("img");
+    avatar.src = `/avatars/${comment.user_id}`;
+    avatar.alt = "";
+    avatar.className = "avatar-small";
+    const link = document.createElement("a");
+    link.href = `/forum/u/${encodeURIComponent(comment.username)}`;
+    link.className = "user-link";
+    link.textContent = comment.username;
+    author.append(avatar, " Posted by ", link);
+    const date = document.createElement("span");
+    date.style.float = "right";
+    date.textContent = `on ${formatCreated(comment.created)}`;
+    meta.append(author, date);
+
+    // content_html was sanitized by the server before it was stored.
+    const body = document.createElement("div");
+    body.className = "markdown-body";
+    body.id = `comment-content-${comment.id}`;
+    body.innerHTML = comment.content_html;
+
+    const votes = document.createElement("div");
+    votes.className = "comment-votes";
+    votes.dataset.commentId = comment.id;
+    const images = document.querySelectorAll(".post-votes img");
+    [[1, "like-button", "Like"], [-1, "dislike-button", "Dislike"]].forEach(([vote, className, label], i) => {
+        const button = document.createElement("button");
+        button.className = `vote-button-comment ${className}`;
+        button.addEventListener("click", () => toggleCommentVote(comment.id, vote));
+        const image = document.createElement("img");
+        image.src = images[i] ? images[i].src : "";
+        image.alt = label;
+        const count = document.createElement("span");
+        count.className = "vote-count";
+        count.textContent = "0";
+        button.append(image, " ", count);
+        votes.appendChild(button);
+    });
+
+    element.append(meta, body, votes);
+    container.appendChild(element);
+}
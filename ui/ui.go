@@ -0,0 +1,29 @@
+// Package ui embeds the templates and static files, so the server runs
+// from any working directory without them next to it.
+package ui
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed static templates
+var files embed.FS
+
+// Static holds what the server publishes under /static/.
+func Static() fs.FS {
+	return sub("static")
+}
+
+// Templates holds the HTML templates.
+func Templates() fs.FS {
+	return sub("templates")
+}
+
+func sub(dir string) fs.FS {
+	fsys, err := fs.Sub(files, dir)
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}